@@ -0,0 +1,296 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+)
+
+// GET  /admin/memory/ : READ approximate memory used by the graph, route cache and trash
+func (rs *routeServer) memoryHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Reporting memory at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.MemoryReport())
+}
+
+// POST /admin/compact/ : UPDATE rebuild internal structures, empty the route cache and drop expired trash
+func (rs *routeServer) compactHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Compacting at %s\n", req.URL.Path)
+
+	report, err := rs.store.Compact()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, report)
+}
+
+// GET  /admin/cache/stats/ : READ route cache hit ratio, evictions and size
+func (rs *routeServer) cacheStatsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Reporting cache stats at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.CacheStats())
+}
+
+// GET  /admin/cache/ : READ every route cache entry with its size and hits, and the cache's statistics
+func (rs *routeServer) inspectCacheHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Inspecting cache at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.InspectCache())
+}
+
+// DELETE /admin/cache/ (?from=<pattern>&to=<pattern> optional) : DELETE the cached routes between matching locations, or every one
+func (rs *routeServer) flushCacheHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Flushing cache at %s\n", req.URL.Path)
+
+	query := req.URL.Query()
+	flushed, err := rs.store.FlushCache(query.Get("from"), query.Get("to"))
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, struct {
+		Flushed int `json:"flushed"`
+	}{flushed})
+}
+
+// GET  /admin/hot/ : READ the locations whose shortest paths are maintained incrementally
+func (rs *routeServer) getHotSourcesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting hot sources at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.GetHotSources())
+}
+
+// PUT  /admin/hot/<location> : UPDATE maintain shortest paths from <location> incrementally
+func (rs *routeServer) addHotSourceHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Adding hot source at %s\n", req.URL.Path)
+
+	loc := mux.Vars(req)["location"]
+
+	if err := rs.store.AddHotSource(loc); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /admin/hot/<location> : DELETE stop maintaining shortest paths from <location>
+func (rs *routeServer) removeHotSourceHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Removing hot source at %s\n", req.URL.Path)
+
+	loc := mux.Vars(req)["location"]
+
+	if err := rs.store.RemoveHotSource(loc); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /admin/landmarks/ : READ the landmarks used by the landmark A* heuristic and whether they are current
+func (rs *routeServer) landmarksHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting landmarks at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.LandmarkStatus())
+}
+
+// POST /admin/landmarks/ : UPDATE recompute the landmarks now if the graph has changed
+func (rs *routeServer) refreshLandmarksHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Refreshing landmarks at %s\n", req.URL.Path)
+
+	rs.store.RefreshLandmarks()
+	renderJSON(w, rs.store.LandmarkStatus())
+}
+
+// GET  /admin/resync/ : READ when the last full resync from Redis ran and the drift it found
+func (rs *routeServer) lastResyncHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting the last resync at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.LastResync())
+}
+
+// POST /admin/resync/ : UPDATE reconcile the whole graph with Redis now, fixing and reporting any drift
+func (rs *routeServer) resyncHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Resyncing at %s\n", req.URL.Path)
+
+	report, err := rs.store.ResyncAll()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, report)
+}
+
+// POST /admin/reload-graph/ : UPDATE read the whole graph from Redis again and swap it in at once, for bulk changes made by offline tools, reporting what changed
+func (rs *routeServer) reloadGraphHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Reloading the graph at %s\n", req.URL.Path)
+
+	report, err := rs.store.ReloadGraph()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, report)
+}
+
+// GET  /admin/bundle/ : READ the whole state of the store, for ImportBundle on another server
+func (rs *routeServer) exportBundleHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Exporting bundle at %s\n", req.URL.Path)
+
+	bundle, err := rs.store.ExportBundle()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, bundle)
+}
+
+// POST /admin/bundle/ (with JSON bundle) : CREATE the whole state of an exported store in this empty one
+func (rs *routeServer) importBundleHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Importing bundle at %s\n", req.URL.Path)
+
+	var bundle routes.Bundle
+	if !decodeJSON(w, req, &bundle) {
+		return
+	}
+
+	if err := rs.store.ImportBundle(bundle); err == routes.ErrStoreNotEmpty {
+		httpError(w, req, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// POST /admin/generate/ (with JSON locations: int, density: number, min_weight: number, max_weight: number, prefix: string, seed: int optional) : CREATE a random graph for load testing and demos
+func (rs *routeServer) generateHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Generating a graph at %s\n", req.URL.Path)
+
+	var opts routes.GeneratorOptions
+	if !decodeJSON(w, req, &opts) {
+		return
+	}
+
+	report, err := rs.store.Generate(opts)
+	if err == routes.ErrImportConflict {
+		w.Header().Set("X-Error-Code", errorCode(err.Error(), http.StatusConflict))
+		renderJSONStatus(w, http.StatusConflict, report)
+		return
+	}
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, report)
+}
+
+// GET  /admin/costs/ : READ every named cost function, in name order
+func (rs *routeServer) costFunctionsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting cost functions at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.CostFunctions())
+}
+
+// PUT  /admin/costs/<name> (with JSON expression: string) : UPDATE define or replace the cost function <name>
+func (rs *routeServer) defineCostFunctionHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Defining cost function at %s\n", req.URL.Path)
+
+	var body struct {
+		Expression string `json:"expression"`
+	}
+	if !decodeJSON(w, req, &body) {
+		return
+	}
+
+	if err := rs.store.DefineCostFunction(mux.Vars(req)["name"], body.Expression); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /admin/timezone/ : READ the map's time zone, which arrival times are given in
+func (rs *routeServer) timezoneHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting the time zone at %s\n", req.URL.Path)
+
+	renderJSON(w, map[string]string{"timezone": rs.store.Timezone()})
+}
+
+// PUT  /admin/timezone/ (with JSON timezone: string) : UPDATE set the map's time zone by IANA name, such as Europe/London; empty for UTC
+func (rs *routeServer) setTimezoneHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting the time zone at %s\n", req.URL.Path)
+
+	var body struct {
+		Timezone string `json:"timezone"`
+	}
+	if !decodeJSON(w, req, &body) {
+		return
+	}
+
+	if err := rs.store.SetTimezone(body.Timezone); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /admin/symmetric/ : READ whether edges added to the map go both ways unless bidirectional says otherwise
+func (rs *routeServer) symmetricHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting the symmetry policy at %s\n", req.URL.Path)
+
+	renderJSON(w, map[string]bool{"symmetric": rs.store.Symmetric()})
+}
+
+// PUT  /admin/symmetric/ (with JSON symmetric: bool) : UPDATE set whether edges added to the map go both ways unless bidirectional says otherwise
+func (rs *routeServer) setSymmetricHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting the symmetry policy at %s\n", req.URL.Path)
+
+	var body struct {
+		Symmetric bool `json:"symmetric"`
+	}
+	if !decodeJSON(w, req, &body) {
+		return
+	}
+
+	if err := rs.store.SetSymmetric(body.Symmetric); err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// GET  /admin/default-weight/ : READ the weight of edges added with a null weight, or null if they need one
+func (rs *routeServer) defaultWeightHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting the default weight at %s\n", req.URL.Path)
+
+	renderJSON(w, map[string]*float64{"default_weight": rs.store.DefaultWeight()})
+}
+
+// PUT  /admin/default-weight/ (with JSON default_weight: number or null) : UPDATE set the weight of edges added with a null weight, or require one again with null
+func (rs *routeServer) setDefaultWeightHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting the default weight at %s\n", req.URL.Path)
+
+	var body struct {
+		DefaultWeight *float64 `json:"default_weight"`
+	}
+	if !decodeJSON(w, req, &body) {
+		return
+	}
+
+	if err := rs.store.SetDefaultWeight(body.DefaultWeight); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /admin/costs/<name> : DELETE the cost function <name>
+func (rs *routeServer) removeCostFunctionHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Removing cost function at %s\n", req.URL.Path)
+
+	if err := rs.store.RemoveCostFunction(mux.Vars(req)["name"]); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
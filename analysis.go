@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// intParam reads an optional integer query parameter
+func intParam(req *http.Request, name string, def int) (int, error) {
+	s := req.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer, not %q", name, s)
+	}
+	return n, nil
+}
+
+// floatParam reads an optional floating point query parameter
+func floatParam(req *http.Request, name string, def float64) (float64, error) {
+	s := req.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number, not %q", name, s)
+	}
+	return f, nil
+}
+
+// GET  /maps/analysis/weights/ (?buckets=10&top=5 optional) : READ a histogram, percentiles and extremes of the edge weights
+func (rs *routeServer) analyseWeightsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Analysing weights at %s\n", req.URL.Path)
+
+	buckets, err := intParam(req, "buckets", 10)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	top, err := intParam(req, "top", 5)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	analysis, err := rs.store.AnalyseWeights(buckets, top)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, analysis)
+}
+
+// GET  /maps/analysis/duplicates/ (?threshold=2&km=0.5 optional) : READ pairs of locations that are probably the same place
+func (rs *routeServer) findDuplicatesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding duplicates at %s\n", req.URL.Path)
+
+	threshold, err := intParam(req, "threshold", 2)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	km, err := floatParam(req, "km", 0.5)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	duplicates, err := rs.store.FindDuplicates(threshold, km)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, duplicates)
+}
+
+// GET  /maps/analysis/lint/ (?tolerance=0.1&fence=3&threshold=2&km=0.5 optional) : READ likely data problems, and the percentage of locations clear of them
+func (rs *routeServer) lintHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Linting at %s\n", req.URL.Path)
+
+	var opts routes.LintOptions
+	var err error
+	if opts.Tolerance, err = floatParam(req, "tolerance", 0.1); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Fence, err = floatParam(req, "fence", 3); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Threshold, err = intParam(req, "threshold", 2); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Km, err = floatParam(req, "km", 0.5); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := rs.store.Lint(opts)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, report)
+}
+
+// GET  /maps/analysis/critical/ : READ the articulation points and bridges, whose removal would cut some locations off from others
+func (rs *routeServer) criticalElementsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding articulation points and bridges at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.CriticalElements())
+}
+
+// GET  /maps/analysis/suggest-edges/ (?n=20 optional) : READ the pairs of locations most likely to be missing an edge, with confidence scores
+func (rs *routeServer) suggestEdgesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Suggesting edges at %s\n", req.URL.Path)
+
+	n, err := intParam(req, "n", 20)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	suggestions, err := rs.store.SuggestEdges(n)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, suggestions)
+}
+
+// GET  /maps/analysis/communities/ (?algorithm=louvain&resolution=1 optional) : READ the locations grouped into densely linked communities, largest first
+func (rs *routeServer) communitiesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding communities at %s\n", req.URL.Path)
+
+	resolution, err := floatParam(req, "resolution", 1)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	communities, err := rs.store.Communities(req.URL.Query().Get("algorithm"), resolution)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, communities)
+}
+
+// GET  /maps/components/ : READ the strongly connected components, largest first, each in name order
+func (rs *routeServer) componentsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding components at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.Components())
+}
+
+// GET  /maps/cycles/ (?max=100 optional) : READ whether the graph is acyclic, and up to max of its simple cycles
+func (rs *routeServer) cyclesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding cycles at %s\n", req.URL.Path)
+
+	max, err := intParam(req, "max", 100)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := rs.store.Cycles(max)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, report)
+}
+
+// GET  /maps/analytics/centrality/ (Cache-Control: no-cache|only-if-cached optional) : READ degree, betweenness and PageRank of every location
+func (rs *routeServer) centralityHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Computing centrality at %s\n", req.URL.Path)
+
+	centrality, err := rs.store.Centrality(requestCacheMode(req))
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, centrality)
+}
+
+// GET  /maps/layout/ (?algorithm=force|spectral optional; Cache-Control: no-cache|only-if-cached optional) : READ a position in the unit square for every location
+func (rs *routeServer) layoutHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Computing layout at %s\n", req.URL.Path)
+
+	layout, err := rs.store.Layout(req.URL.Query().Get("algorithm"), requestCacheMode(req))
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, layout)
+}
+
+// GET  /maps/stats/ (Cache-Control: no-cache|only-if-cached optional) : READ location and edge counts, average degree, diameter and connectivity
+func (rs *routeServer) statsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Computing graph stats at %s\n", req.URL.Path)
+
+	stats, err := rs.store.Stats(requestCacheMode(req))
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, stats)
+}
+
+// POST /maps/analysis/traffic/ (with JSON [{from, to, demand}]) : READ the flow each edge would carry with every demand on its shortest route, and which exceed their capacity
+func (rs *routeServer) assignTrafficHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Assigning traffic at %s\n", req.URL.Path)
+
+	var demands []routes.Demand
+	if !decodeJSON(w, req, &demands) {
+		return
+	}
+
+	assignment, err := rs.store.AssignTraffic(demands)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, assignment)
+}
+
+// POST /maps/route/capacity/ (with JSON from, to, demand, split: bool optional) : READ a route for demand from <from> to <to> that takes no edge past its capacity attribute, or split across routes, with the edges it saturates, or refused with the edges that stop it
+func (rs *routeServer) routeWithinCapacityHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Routing within capacity at %s\n", req.URL.Path)
+
+	var cr routes.CapacityRequest
+	if !decodeJSON(w, req, &cr) {
+		return
+	}
+
+	routing, err := rs.store.RouteWithinCapacity(cr)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, routing)
+}
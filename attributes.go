@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// GET  /maps/<from>/edge/<to>/attributes : READ the numeric attributes of the edge from <from> to <to>
+func (rs *routeServer) edgeAttributesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting edge attributes at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	attributes, err := rs.store.EdgeAttributes(vars["from"], vars["to"])
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, attributes)
+}
+
+// PUT  /maps/<from>/edge/<to>/attributes (with JSON map[string]number) : UPDATE replace the attributes of the edge from <from> to <to>
+func (rs *routeServer) setEdgeAttributesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting edge attributes at %s\n", req.URL.Path)
+
+	var attributes map[string]float64
+	if !decodeJSON(w, req, &attributes) {
+		return
+	}
+
+	vars := mux.Vars(req)
+	if err := rs.store.SetEdgeAttributes(vars["from"], vars["to"], attributes); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/patterson-a/rest_project/routes"
+	"net/http"
+	"strings"
+)
+
+// Cache-Control values for each class of endpoint
+type cachePolicy struct {
+	// GET requests for the graph and routes through it
+	Listings string
+	// Anything that can change the graph
+	Mutations string
+	// /admin/ and /metrics, which should always be current
+	Operational string
+}
+
+var defaultCachePolicy = cachePolicy{
+	Listings:    "max-age=5",
+	Mutations:   "no-store",
+	Operational: "no-store",
+}
+
+// middleware sets Cache-Control on every response by endpoint class; handlers may still override it
+func (policy cachePolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var value string
+		_, path := splitMapPath(req.URL.Path)
+		switch {
+		case strings.HasPrefix(path, "/admin/") || path == "/metrics":
+			value = policy.Operational
+		case req.Method == http.MethodGet || req.Method == http.MethodHead:
+			value = policy.Listings
+		default:
+			value = policy.Mutations
+		}
+		if value != "" {
+			w.Header().Set("Cache-Control", value)
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// requestCacheMode reads how a route query may use the server's route cache from its Cache-Control header:
+// no-cache always searches afresh, and only-if-cached answers 504 rather than search
+func requestCacheMode(req *http.Request) routes.CacheMode {
+	mode := routes.CacheDefault
+	for _, header := range req.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(header, ",") {
+			name := strings.SplitN(directive, "=", 2)[0]
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "no-cache":
+				mode = routes.CacheBypass
+			case "only-if-cached":
+				// Searching is what only-if-cached rules out, so it wins over no-cache
+				return routes.CacheOnly
+			}
+		}
+	}
+	return mode
+}
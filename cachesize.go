@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"github.com/patterson-a/rest_project/routes"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The route cache may use this fraction of the memory the OS reports as available
+const cacheMemoryFraction = 10
+
+// availableMemory reads MemAvailable from /proc/meminfo, so only works on Linux
+func availableMemory() (int64, bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, false
+			}
+			return kb * 1024, true
+		}
+	}
+	return 0, false
+}
+
+// cacheBudget is a share of available memory, never above maxBytes
+func cacheBudget(maxBytes int64) int64 {
+	if available, ok := availableMemory(); ok && available/cacheMemoryFraction < maxBytes {
+		return available / cacheMemoryFraction
+	}
+	return maxBytes
+}
+
+// adaptCacheSize periodically re-sizes the route cache as available memory changes
+func adaptCacheSize(store *routes.RouteStore, maxBytes int64, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		store.SetCacheMaxBytes(cacheBudget(maxBytes))
+	}
+}
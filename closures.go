@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+)
+
+// GET  /maps/close/ : READ every closed edge, with its weight and when it was closed
+func (rs *routeServer) closuresHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting closed edges at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.Closures())
+}
+
+// PUT  /maps/close/<from>/<to> : UPDATE close the edge from <from> to <to> without deleting it, so routes go around it until it is opened, or 404 if there is none
+func (rs *routeServer) closeEdgeHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Closing an edge at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	err := rs.store.CloseEdge(vars["from"], vars["to"])
+	if errors.Is(err, routes.ErrNoEdge) {
+		httpError(w, req, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// PUT  /maps/open/<from>/<to> : UPDATE open the closed edge from <from> to <to> again
+func (rs *routeServer) openEdgeHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Opening an edge at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	if err := rs.store.OpenEdge(vars["from"], vars["to"]); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+)
+
+// POST /maps/compare/ (with JSON before: scenario, after: scenario, pairs: [{from, to}]) : READ the best route between each pair in both scenarios, and the change in weight
+func (rs *routeServer) compareScenariosHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Comparing scenarios at %s\n", req.URL.Path)
+
+	var body struct {
+		Before routes.Scenario `json:"before"`
+		After  routes.Scenario `json:"after"`
+		Pairs  []routes.Pair   `json:"pairs"`
+	}
+	if !decodeJSON(w, req, &body) {
+		return
+	}
+
+	comparisons, err := rs.store.CompareScenarios(body.Before, body.After, body.Pairs)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, comparisons)
+}
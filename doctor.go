@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/patterson-a/rest_project/routes"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The key the doctor's probe writes, reads back and deletes; it expires on its own should the probe stop part way
+const doctorProbeKey = "rest_project:doctor_probe"
+
+// Checks of each setting the server reads from the environment, each returning why the value would stop it starting
+var settingChecks = []struct {
+	name  string
+	check func(string) error
+}{
+	{"STARTUP_MODE", func(s string) error { _, err := parseStartupMode(s); return err }},
+	{"STARTUP_DEADLINE", checkDuration},
+	{"EVICTION_CHECK", func(s string) error { _, err := parseEvictionCheck(s); return err }},
+	{"LOST_KEYS_INTERVAL", checkDuration},
+	{"LOST_KEYS_REWRITE", checkBool},
+	{"LANDMARKS", checkInt},
+	{"LANDMARK_REFRESH", checkDuration},
+	{"FAILURE_WINDOW", checkDuration},
+	{"USAGE_RETENTION_DAYS", checkInt},
+	{"LENIENT_JSON", checkBool},
+	{"SNAPSHOT_READS", checkBool},
+	{"SNAPSHOT_HISTORY", checkInt},
+	{"RESYNC_INTERVAL", checkDuration},
+	{"REDIS_NOTIFICATIONS", checkBool},
+	{"REDIS_NOTIFICATIONS_DEBOUNCE", checkDuration},
+	{"CACHE_MAX_BYTES", func(s string) error { _, err := strconv.ParseInt(s, 10, 64); return err }},
+	{"WARM_ROUTES", func(s string) error {
+		for _, pair := range strings.Split(s, ",") {
+			if _, err := routes.ParsePair(strings.TrimSpace(pair)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"WARM_TOP_N", checkInt},
+	{"REPLICA_OF", func(s string) error {
+		u, err := url.Parse(s)
+		if err == nil && (u.Scheme == "" || u.Host == "") {
+			err = fmt.Errorf("%q is not an absolute URL, such as http://primary:1337", s)
+		}
+		return err
+	}},
+	{"REPLICA_MAX_STALENESS", checkDuration},
+	{"SERVERPORT", func(s string) error {
+		if port, err := strconv.Atoi(s); err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("%q is not a port number", s)
+		}
+		return nil
+	}},
+	// Read by configureStore, for every map
+	{"TRASH_RETENTION", checkDuration},
+	{"DEFAULT_ALGORITHM", func(s string) error { _, err := routes.ParseAlgorithm(s); return err }},
+	{"INTEGER_WEIGHTS", checkBool},
+	{"DISTANCE_WEIGHTS", checkBool},
+	{"WEIGHT_PRECISION", checkInt},
+	{"COST_FUNCTIONS", func(s string) error {
+		for _, definition := range strings.Split(s, ";") {
+			parts := strings.SplitN(definition, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("bad cost function %q, expected <name>=<expression>", definition)
+			}
+			if _, err := routes.ParseCostFunction(strings.TrimSpace(parts[0]), parts[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"ASTAR_HEURISTIC", func(s string) error { _, err := routes.ParseHeuristic(s); return err }},
+	{"HEURISTIC_SCALE", func(s string) error { _, err := strconv.ParseFloat(s, 64); return err }},
+	{"LOCK_LOG_THRESHOLD", checkDuration},
+	{"REDIS_POOL_SIZE", checkInt},
+	{"EDGE_HISTORY_LENGTH", checkInt},
+}
+
+func checkDuration(s string) error {
+	_, err := time.ParseDuration(s)
+	return err
+}
+
+func checkBool(s string) error {
+	_, err := strconv.ParseBool(s)
+	return err
+}
+
+func checkInt(s string) error {
+	_, err := strconv.Atoi(s)
+	return err
+}
+
+// A doctor writes one line per check, and remembers whether any failed
+type doctor struct {
+	out    io.Writer
+	failed bool
+}
+
+func (d *doctor) ok(subject, detail string) {
+	fmt.Fprintf(d.out, "ok    %s: %s\n", subject, detail)
+}
+
+func (d *doctor) warn(subject, detail, fix string) {
+	fmt.Fprintf(d.out, "warn  %s: %s\n      %s\n", subject, detail, fix)
+}
+
+func (d *doctor) fail(subject, detail, fix string) {
+	d.failed = true
+	fmt.Fprintf(d.out, "FAIL  %s: %s\n      %s\n", subject, detail, fix)
+}
+
+// runDoctor checks the configuration, the Redis dial reaches and every map's keys as the server would find them at startup, writing
+// what it finds and how to fix it to out, and returns the exit status: 1 if anything would stop the server starting
+// or serving, else 0. Restoring each map writes its edges back as they are, as startup does, but nothing else is
+// changed beyond the probe key.
+func runDoctor(out io.Writer, dial func() (redis.Conn, error)) int {
+	d := &doctor{out: out}
+
+	for _, setting := range settingChecks {
+		value, ok := os.LookupEnv(setting.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := setting.check(value); err != nil {
+			d.fail(setting.name, err.Error(), fmt.Sprintf("Fix or unset %s; the server would not start with it.", setting.name))
+		} else {
+			d.ok(setting.name, value)
+		}
+	}
+
+	conn, err := dial()
+	if err != nil {
+		d.fail("redis", err.Error(), "Check that Redis is running and reachable, and its password; or start with STARTUP_MODE=wait or serve-empty to ride out its absence.")
+		return 1
+	}
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		d.fail("redis", err.Error(), "Redis accepted the connection but not commands; check its password and that it is not still loading its data.")
+		return 1
+	}
+	d.ok("redis", "connected")
+
+	if os.Getenv("DUAL_WRITE_TO") != "" {
+		if secondary, err := dialSecondary(); err != nil {
+			d.fail("DUAL_WRITE_TO", err.Error(), "Check that the new backend is reachable at DUAL_WRITE_TO, with DUAL_WRITE_PASSWORD, or unset DUAL_WRITE_TO.")
+		} else {
+			if _, err := secondary.Do("PING"); err != nil {
+				d.fail("DUAL_WRITE_TO", err.Error(), "The new backend accepted the connection but not commands; check DUAL_WRITE_PASSWORD.")
+			} else {
+				d.ok("DUAL_WRITE_TO", "connected")
+			}
+			secondary.Close()
+		}
+	}
+
+	d.probe(conn)
+
+	store, err := routes.Restore(conn)
+	if err != nil {
+		d.fail("default map", "restoring it failed: "+err.Error(), "A key the store reads has been changed by something else; find it with redis-cli and fix or delete it.")
+		return 1
+	}
+	d.ok("default map", fmt.Sprintf("restored %d locations", len(store.GetLocations())))
+	d.checkEviction(store)
+
+	registry, err := routes.NewRegistry(store, dial, nil)
+	if err != nil {
+		d.fail("named maps", err.Error(), "Check that Redis accepts more connections.")
+		return 1
+	}
+	names, err := registry.Maps()
+	if err != nil {
+		d.fail("named maps", err.Error(), "rest_project:maps should be a set of map names; fix or delete it with redis-cli.")
+		return 1
+	}
+	for _, name := range names {
+		subject := "map " + name
+		if store, err := registry.Get(name); err != nil {
+			d.fail(subject, "restoring it failed: "+err.Error(), fmt.Sprintf("A key under its prefix has been changed by something else; fix it, or delete the map with DELETE /namespaces/%s/.", name))
+		} else {
+			d.ok(subject, fmt.Sprintf("restored %d locations", len(store.GetLocations())))
+		}
+	}
+
+	if d.failed {
+		return 1
+	}
+	return 0
+}
+
+// probe writes a key, reads it back and deletes it, as the server's writes would
+func (d *doctor) probe(conn redis.Conn) {
+	value := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if _, err := conn.Do("SET", doctorProbeKey, value, "EX", 60); err != nil {
+		d.fail("read/write probe", "writing failed: "+err.Error(), "Redis refuses writes; check it is not a read-only replica, is not out of memory under noeviction, and that the user may write.")
+		return
+	}
+	read, err := redis.String(conn.Do("GET", doctorProbeKey))
+	if err != nil || read != value {
+		detail := fmt.Sprintf("read back %q, not %q", read, value)
+		if err != nil {
+			detail = "reading failed: " + err.Error()
+		}
+		d.fail("read/write probe", detail, "Redis did not keep what was written; check nothing else writes "+doctorProbeKey+" and that it is not evicting keys straight away.")
+		return
+	}
+	if _, err := conn.Do("DEL", doctorProbeKey); err != nil {
+		d.warn("read/write probe", "deleting the probe failed: "+err.Error(), doctorProbeKey+" will expire within a minute.")
+		return
+	}
+	d.ok("read/write probe", "wrote, read back and deleted "+doctorProbeKey)
+}
+
+// checkEviction warns if Redis could evict the store's keys to free memory
+func (d *doctor) checkEviction(store *routes.RouteStore) {
+	report, err := store.CheckEviction()
+	if err != nil {
+		d.warn("eviction", "checking failed: "+err.Error(), "Redis may refuse CONFIG GET; allow it, or start with EVICTION_CHECK=off.")
+	} else if report.Unsafe {
+		d.warn("eviction", fmt.Sprintf("maxmemory-policy %s can evict the graph's keys once Redis reaches its maxmemory of %d bytes", report.Policy, report.MaxMemory),
+			"Set maxmemory-policy to noeviction or a volatile policy; EVICTION_CHECK=refuse would not start.")
+	} else {
+		d.ok("eviction", fmt.Sprintf("maxmemory-policy %s keeps the graph's keys", report.Policy))
+	}
+}
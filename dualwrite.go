@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Writes mirrored to the new backend while migrating, for GET /admin/dual-write/; nil when dual writing is off
+var dualWrites *routes.DualWriteStats
+
+// dialPrimary connects to the Redis the store reads and writes
+func dialPrimary() (redis.Conn, error) {
+	return redis.Dial("tcp", "localhost:6379",
+		redis.DialPassword("bad-password"))
+}
+
+// dialSecondary connects to the new backend at DUAL_WRITE_TO, with DUAL_WRITE_PASSWORD
+func dialSecondary() (redis.Conn, error) {
+	return redis.Dial("tcp", os.Getenv("DUAL_WRITE_TO"),
+		redis.DialPassword(os.Getenv("DUAL_WRITE_PASSWORD")))
+}
+
+// GET  /admin/dual-write/ : READ how many writes were mirrored to the new backend, how many it failed or answered differently, and the most recent of those, across every map
+func dualWriteHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Reporting dual writes at %s\n", req.URL.Path)
+
+	if dualWrites == nil {
+		httpError(w, req, "dual writing is off on this server", http.StatusNotFound)
+		return
+	}
+
+	renderJSON(w, dualWrites.Report())
+}
+
+// GET  /admin/dual-write/verify/ (?limit=100 optional) : READ compare every key of the old backend with the new, listing up to <limit> of each of the keys the new lacks, has with other contents, or has that the old does not
+func verifyDualWriteHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Verifying dual writes at %s\n", req.URL.Path)
+
+	if dualWrites == nil {
+		httpError(w, req, "dual writing is off on this server", http.StatusNotFound)
+		return
+	}
+	limit, err := intParam(req, "limit", 100)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Connections of its own, so that the walk holds up no map
+	primary, err := dialPrimary()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer primary.Close()
+	secondary, err := dialSecondary()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer secondary.Close()
+
+	verification, err := routes.VerifyDualWrite(primary, secondary, limit)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+	renderJSON(w, verification)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/patterson-a/rest_project/routes"
+	"math"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// Response buffers, reused so that busy endpoints do not allocate one per request
+var bufferPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// Buffers grown past this by an unusually large response are dropped rather than kept in the pool
+const maxPooledBuffer = 1 << 20
+
+// appendJSON appends the JSON encoding of v to out, byte for byte what json.Marshal produces.
+// Location lists and routes, the bulk of read traffic, are written directly without reflection;
+// anything else goes through encoding/json.
+func appendJSON(out []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []string:
+		return appendStrings(out, v), nil
+	case []routes.Route:
+		if ret, ok := appendRoutes(out, v); ok {
+			return ret, nil
+		}
+	}
+
+	buf := bytes.NewBuffer(out)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return out, err
+	}
+	// Encode ends with a newline that Marshal does not
+	return buf.Bytes()[:buf.Len()-1], nil
+}
+
+func appendStrings(out []byte, ss []string) []byte {
+	if ss == nil {
+		return append(out, "null"...)
+	}
+	out = append(out, '[')
+	for i, s := range ss {
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = appendString(out, s)
+	}
+	return append(out, ']')
+}
+
+// appendRoutes fails on weights JSON cannot represent, leaving encoding/json to report the error, and
+// leaves routes with arrival times, which are rarer, to encoding/json as well
+func appendRoutes(out []byte, rs []routes.Route) ([]byte, bool) {
+	if rs == nil {
+		return append(out, "null"...), true
+	}
+	out = append(out, '[')
+	for i, r := range rs {
+		if math.IsInf(r.Weight, 0) || math.IsNaN(r.Weight) || r.Arrivals != nil {
+			return out, false
+		}
+		if i > 0 {
+			out = append(out, ',')
+		}
+		out = append(out, `{"route":`...)
+		out = appendStrings(out, r.Route)
+		out = append(out, `,"weight":`...)
+		out = appendFloat(out, r.Weight)
+		out = append(out, `,"token":`...)
+		out = appendString(out, r.Token)
+		if len(r.Modes) > 0 {
+			out = append(out, `,"modes":`...)
+			out = appendStrings(out, r.Modes)
+		}
+		out = append(out, '}')
+	}
+	return append(out, ']'), true
+}
+
+// appendFloat formats f as encoding/json does: like ES6, %f unless very large or small
+func appendFloat(out []byte, f float64) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	out = strconv.AppendFloat(out, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(out)
+		if n >= 4 && out[n-4] == 'e' && out[n-3] == '-' && out[n-2] == '0' {
+			out[n-2] = out[n-1]
+			out = out[:n-1]
+		}
+	}
+	return out
+}
+
+const hex = "0123456789abcdef"
+
+// appendString quotes s as encoding/json does, including its escaping of HTML characters,
+// U+2028 and U+2029, and its replacement of invalid UTF-8 with U+FFFD
+func appendString(out []byte, s string) []byte {
+	out = append(out, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			out = append(out, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				out = append(out, '\\', b)
+			case '\n':
+				out = append(out, '\\', 'n')
+			case '\r':
+				out = append(out, '\\', 'r')
+			case '\t':
+				out = append(out, '\\', 't')
+			case '\b':
+				out = append(out, '\\', 'b')
+			case '\f':
+				out = append(out, '\\', 'f')
+			default:
+				out = append(out, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 {
+			out = append(out, s[start:i]...)
+			out = append(out, string(utf8.RuneError)...)
+			i += size
+			start = i
+			continue
+		}
+		if c == '\u2028' || c == '\u2029' {
+			out = append(out, s[start:i]...)
+			out = append(out, '\\', 'u', '2', '0', '2', hex[c&0xf])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	out = append(out, s[start:]...)
+	return append(out, '"')
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/patterson-a/rest_project/routes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Strings with everything appendString escapes or replaces
+var awkwardStrings = []string{
+	"",
+	"plain",
+	"quote \" and backslash \\",
+	"\n\r\t\b\f",
+	"\x00\x01\x07\x0b\x0e\x1f\x7f",
+	"<script>&amp;</script>",
+	"line\u2028separator\u2029paragraph",
+	"\u2027\u202a",
+	"caf\u00e9 \u65e5\u672c \U0001f600",
+	"\xff",
+	"invalid \xc3\x28 and \xe2\x82 truncated \xf0\x9f",
+	"\xed\xa0\x80 surrogate",
+	"\xef\xbf\xbd already replaced",
+}
+
+func checkAppendJSON(t *testing.T, v interface{}) {
+	t.Helper()
+	want, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := appendJSON([]byte("prefix"), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "prefix"+string(want) {
+		t.Fatalf("encoding %#v:\n got %s\nwant prefix%s", v, got, want)
+	}
+}
+
+func TestAppendJSONMatchesMarshalForStrings(t *testing.T) {
+	checkAppendJSON(t, []string(nil))
+	checkAppendJSON(t, []string{})
+	checkAppendJSON(t, awkwardStrings)
+	for _, s := range awkwardStrings {
+		checkAppendJSON(t, []string{s})
+	}
+	// Every single byte, and random bytes, most of them not UTF-8
+	for b := 0; b < 256; b++ {
+		checkAppendJSON(t, []string{string([]byte{byte(b)}), "a" + string([]byte{byte(b)}) + "z"})
+	}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		b := make([]byte, r.Intn(16))
+		r.Read(b)
+		checkAppendJSON(t, []string{string(b)})
+	}
+}
+
+func TestAppendJSONMatchesMarshalForRoutes(t *testing.T) {
+	weights := []float64{0, math.Copysign(0, -1), 1, -2.5, 123.456, 1e-6, 1e-7, 9.99e-7, 1e20, 1e21, 1.5e300, 5e-324, -1e-9, 1.0 / 3}
+	var rs []routes.Route
+	for i, w := range weights {
+		rs = append(rs, routes.Route{Route: []string{"a", awkwardStrings[i%len(awkwardStrings)]}, Weight: w, Token: awkwardStrings[(i+3)%len(awkwardStrings)]})
+	}
+	checkAppendJSON(t, []routes.Route(nil))
+	checkAppendJSON(t, []routes.Route{})
+	checkAppendJSON(t, rs)
+	checkAppendJSON(t, []routes.Route{{}})
+	checkAppendJSON(t, []routes.Route{{Route: []string{}, Modes: []string{}}})
+	checkAppendJSON(t, []routes.Route{{Route: []string{"a", "b"}, Weight: 1, Modes: []string{"walk\u2028", "\x01"}}})
+	// Left to encoding/json
+	checkAppendJSON(t, []routes.Route{{Route: []string{"a", "b"}, Weight: 1, Arrivals: []routes.LegArrival{}}})
+}
+
+// Anything else goes through encoding/json, without the newline its Encoder adds
+func TestAppendJSONMatchesMarshalForOtherResponses(t *testing.T) {
+	checkAppendJSON(t, map[string]interface{}{"html": "<&>", "n": 1.5, "list": awkwardStrings})
+	checkAppendJSON(t, routes.MaxFlow{From: "a\u2029", To: "\xff", MinCut: []routes.Edge{{From: "a", To: "b", Weight: 1e-7}}})
+	checkAppendJSON(t, nil)
+	checkAppendJSON(t, "\b\f")
+
+	if _, err := appendJSON(nil, []routes.Route{{Weight: math.Inf(1)}}); err == nil {
+		t.Fatal("an infinite weight should fail as json.Marshal does")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"github.com/patterson-a/rest_project/routes"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Stable codes for errors, by the English format that produces them, so clients need not parse messages.
+// Errors not listed here get a code from their HTTP status, such as BAD_REQUEST.
+var errorCodes = map[string]string{
+	"%s does not exist":                                 "LOCATION_NOT_FOUND",
+	"coordinates given for unknown location %s":         "LOCATION_NOT_FOUND",
+	"%s already exists":                                 "LOCATION_EXISTS",
+	"location names cannot be empty":                    "INVALID_NAME",
+	"location name %q cannot contain '/'":               "INVALID_NAME",
+	"region %s does not exist":                          "REGION_NOT_FOUND",
+	"region names cannot be empty":                      "INVALID_NAME",
+	"region name %q cannot contain '/'":                 "INVALID_NAME",
+	"region %s cannot be inside %s, which is inside it": "REGION_CYCLE",
+	"%s is not directly in region %s":                   "NOT_IN_REGION",
+	"%s is not in region %s":                            "NOT_IN_REGION",
+	"%s and %s cannot both be renamed to %s":            "RENAME_COLLISION",
+	"there is no such map: %s":                          "MAP_NOT_FOUND",
+	"map %s already exists":                             "MAP_EXISTS",
+	"map name %q must be letters, digits, '-' and '_'":  "INVALID_NAME",
+
+	"%s cannot have an edge to itself":                                       "SELF_EDGE",
+	"there is no edge from %s to %s":                                         "EDGE_NOT_FOUND",
+	"the edge from %s to %s has no usable weight":                            "INVALID_WEIGHT",
+	"the edge from %s to %s weighs %g, which is not a whole number up to %d": "INVALID_WEIGHT",
+	"import conflicts with existing edges":                                   "EDGE_EXISTS",
+	"tags cannot be empty":                                                   "INVALID_TAG",
+	"tag %q cannot contain ','":                                              "INVALID_TAG",
+	"attribute name %q must be letters, digits and '_', not starting with a digit, and not weight": "INVALID_ATTRIBUTE",
+	"attribute %s must be a non-negative number, not %g":                                           "INVALID_ATTRIBUTE",
+
+	"cannot find a tour through every location, none of the rest can be reached from %s":                      "NO_TOUR",
+	"cannot find a tour through every location, %s cannot be reached from %s":                                 "NO_TOUR",
+	"no tour meets every time window: leaving %s at %g, %s cannot be reached before its window closes at %g":  "INFEASIBLE_TIME_WINDOWS",
+	"no tour meets every time window: the tour is back at %s after its window closes at %g":                   "INFEASIBLE_TIME_WINDOWS",
+	"no tour meets every time window: %s closes at %g, but cannot be reached before %g even straight from %s": "INFEASIBLE_TIME_WINDOWS",
+
+	"negative cycle detected":   "NEGATIVE_CYCLE",
+	"the routes are not cached": "NOT_CACHED",
+	"the revision is not kept":  "REVISION_NOT_KEPT",
+	"no route within budget":    "NO_ROUTE_WITHIN_BUDGET",
+	"%s cannot be used while there are negative edge weights, use %s":                  "NEGATIVE_WEIGHTS",
+	"unknown algorithm %q, expected one of %s, %s, %s or %s":                           "UNKNOWN_ALGORITHM",
+	"unknown heuristic %q, expected one of %v":                                         "UNKNOWN_HEURISTIC",
+	"unknown disjointness %q, expected %s or %s":                                       "INVALID_PARAMETER",
+	"unknown community algorithm %q, expected %s":                                      "UNKNOWN_ALGORITHM",
+	"the edge from %s to %s has no weight":                                             "MISSING_WEIGHT",
+	"the edge from %s to %s has no weight, and %s has no coordinates to find one":      "MISSING_COORDINATES",
+	"the default weight must be a finite number, not %g":                               "INVALID_WEIGHT",
+	"lat and lon must be given together":                                               "INVALID_COORDINATES",
+	"unknown layout algorithm %q, expected %s or %s":                                   "UNKNOWN_ALGORITHM",
+	"the graph has %d locations, more than the %d a spectral layout allows":            "GRAPH_TOO_LARGE",
+	"resolution must be a positive number, not %g":                                     "INVALID_PARAMETER",
+	"tolerance must not be negative, not %g":                                           "INVALID_PARAMETER",
+	"fence must not be negative, not %g":                                               "INVALID_PARAMETER",
+	"n must be between 1 and %d":                                                       "INVALID_PARAMETER",
+	"locations must be between 1 and %d":                                               "INVALID_PARAMETER",
+	"density must be between 0 and 1, not %g":                                          "INVALID_PARAMETER",
+	"the graph would have about %d edges, more than %d":                                "INVALID_PARAMETER",
+	"weights must be finite numbers, not %g":                                           "INVALID_PARAMETER",
+	"min_weight %g is more than max_weight %g":                                         "INVALID_PARAMETER",
+	"malformed route token":                                                            "INVALID_TOKEN",
+	"only dijkstra can stream routes":                                                  "STREAMING_UNSUPPORTED",
+	"only dijkstra can find the k cheapest routes":                                     "K_SHORTEST_UNSUPPORTED",
+	"%s cannot be combined with k or stream":                                           "INVALID_PARAMETER",
+	"k shortest routes cannot be found while there are negative edge weights":          "NEGATIVE_WEIGHTS",
+	"%s cannot limit max_hops, use %s or %s":                                           "UNKNOWN_ALGORITHM",
+	"%s cannot route by modes":                                                         "UNKNOWN_ALGORITHM",
+	"mode name %q must be letters, digits and '_', not starting with a digit":          "INVALID_MODE",
+	"mode %s must weigh a non-negative number, not %g":                                 "INVALID_MODE",
+	"%s cannot use a cost function":                                                    "UNKNOWN_ALGORITHM",
+	"cost function name %q must be letters, digits and '_', not starting with a digit": "INVALID_COST_FUNCTION",
+	"unknown cost function %q":                                                         "UNKNOWN_COST_FUNCTION",
+	"bad cost function term %q, expected <number>*<attribute>":                         "INVALID_COST_FUNCTION",
+	"bad cost function term %q, numbers must not be negative":                          "INVALID_COST_FUNCTION",
+	"cost and metric cannot be combined":                                               "INVALID_METRIC",
+	"metric %q must be an attribute name or weight":                                    "INVALID_METRIC",
+	"metric %s must have a non-negative coefficient, not %g":                           "INVALID_METRIC",
+	"unknown profile %q, expected one of %s":                                           "UNKNOWN_PROFILE",
+	"profile cannot be combined with cost or metric":                                   "INVALID_METRIC",
+
+	"a bundle can only be imported into an empty store":           "STORE_NOT_EMPTY",
+	"unsupported bundle version %d, expected %d":                  "INVALID_BUNDLE",
+	"the edges between %s and %s are two-way but weigh %g and %g": "INVALID_WEIGHT",
+
+	"the flow from %s to itself is unbounded":                     "INVALID_PARAMETER",
+	"flows cannot be found while there are negative edge weights": "NEGATIVE_WEIGHTS",
+
+	"the demand from %s to itself needs no route":                                 "INVALID_PARAMETER",
+	"capacity-aware routes cannot be found while there are negative edge weights": "NEGATIVE_WEIGHTS",
+
+	"this server is a read-only replica of %s": "READ_ONLY_REPLICA",
+
+	"latitude %g is not between -90 and 90":    "INVALID_COORDINATES",
+	"longitude %g is not between -180 and 180": "INVALID_COORDINATES",
+	"bad coordinates %q: %s":                   "INVALID_COORDINATES",
+
+	"%s is not in the trash":     "NOT_IN_TRASH",
+	"%s is not watched":          "NOT_WATCHED",
+	"%s is already watched":      "ALREADY_WATCHED",
+	"%s is not critical":         "NOT_CRITICAL",
+	"%s is already critical":     "ALREADY_CRITICAL",
+	"%s is not a hot source":     "NOT_HOT_SOURCE",
+	"%s is already a hot source": "ALREADY_HOT_SOURCE",
+
+	"%s is archived":     "LOCATION_ARCHIVED",
+	"%s is not archived": "NOT_ARCHIVED",
+	"%s is a hot source, which cannot be archived": "HOT_SOURCE",
+
+	"unknown conflict strategy %q, expected one of skip, overwrite, error, min or max": "UNKNOWN_CONFLICT_STRATEGY",
+	"at least one origin and one destination are needed":                               "INVALID_PARAMETER",
+	"a route needs at least 2 waypoints":                                               "INVALID_PARAMETER",
+	"there is no route from %s to %s":                                                  "NO_ROUTE",
+	"cannot avoid %s, where the route starts or ends":                                  "INVALID_PARAMETER",
+	"cannot avoid a location with no name":                                             "INVALID_PARAMETER",
+	"%q is not of the form <from>/<to>":                                                "INVALID_PARAMETER",
+	"%s must be an integer, not %q":                                                    "INVALID_PARAMETER",
+	"%s must be a number, not %q":                                                      "INVALID_PARAMETER",
+	"buckets must be at least 1, not %d":                                               "INVALID_PARAMETER",
+	"top must not be negative, not %d":                                                 "INVALID_PARAMETER",
+	"max_hops must not be negative, not %d":                                            "INVALID_PARAMETER",
+	"max_weight must be a number, not %g":                                              "INVALID_PARAMETER",
+	"threshold must not be negative, not %d":                                           "INVALID_PARAMETER",
+	"km must not be negative, not %g":                                                  "INVALID_PARAMETER",
+	"pairs must number between 1 and %d":                                               "INVALID_PARAMETER",
+	"demands must number between 1 and %d":                                             "INVALID_PARAMETER",
+	"demand from %s to %s must be a non-negative number, not %g":                       "INVALID_PARAMETER",
+	"limit must be between 1 and %d":                                                   "INVALID_PARAMETER",
+	"k must be between 1 and %d":                                                       "INVALID_PARAMETER",
+	"max must be between 1 and %d":                                                     "INVALID_PARAMETER",
+	"k must be at least 1, not %d":                                                     "INVALID_PARAMETER",
+	"total must be true or false, not %q":                                              "INVALID_PARAMETER",
+	"soft must be true or false, not %q":                                               "INVALID_PARAMETER",
+	"bad pattern %q: %s":                                                               "INVALID_PARAMETER",
+	"vehicles must be between 1 and %d":                                                "INVALID_PARAMETER",
+	"capacity must be a positive number, not %g":                                       "INVALID_PARAMETER",
+	"stops must number between 1 and %d":                                               "INVALID_PARAMETER",
+	"demand at %s must be a non-negative number, not %g":                               "INVALID_PARAMETER",
+	"%s is the depot, so cannot be a stop":                                             "INVALID_PARAMETER",
+	"%s is a stop more than once":                                                      "INVALID_PARAMETER",
+	"%s is in a pickup and delivery pair, so must be a stop":                           "INVALID_PARAMETER",
+	"%s is in more than one pickup and delivery pair":                                  "INVALID_PARAMETER",
+	"delivery %s must have no demand of its own, it unloads what %s loaded":            "INVALID_PARAMETER",
+	"locations must number between 1 and %d":                                           "INVALID_PARAMETER",
+	"%s is listed more than once":                                                      "INVALID_PARAMETER",
+	"departure must be a number, not %g":                                               "INVALID_PARAMETER",
+	"weight is required":                                                               "INVALID_PARAMETER",
+	"weight must be a finite number, not %g":                                           "INVALID_PARAMETER",
+	"%s has a time window but is not on the tour":                                      "INVALID_PARAMETER",
+	"%s starts an open tour, so cannot have a time window":                             "INVALID_PARAMETER",
+	"the time window of %s must be numbers":                                            "INVALID_PARAMETER",
+	"the time window of %s closes before it opens":                                     "INVALID_PARAMETER",
+	"malformed cursor":                                                                 "INVALID_CURSOR",
+	"requires application/json Content-Type":                                           "UNSUPPORTED_MEDIA_TYPE",
+
+	"depart_at must be a time such as 2006-01-02T15:04:05, with or without an offset, not %q": "INVALID_PARAMETER",
+	"the edge from %s to %s has no %s attribute, so arrival times cannot be estimated":        "MISSING_ATTRIBUTE",
+	"unknown time zone %q":                                       "UNKNOWN_TIMEZONE",
+	"unknown format %q, expected json or ics":                    "INVALID_PARAMETER",
+	"format=ics needs depart_at, to put the stops in a calendar": "INVALID_PARAMETER",
+
+	"unknown field %q":                 "INVALID_PARAMETER",
+	"%s must be true or false, not %q": "INVALID_PARAMETER",
+	"%d weights given for %d locations, expected one for each or none": "INVALID_PARAMETER",
+
+	"a route must be a weight, null, or an object of weight and both: %s": "INVALID_WEIGHT",
+
+	"%s must be a day such as 2006-01-02, not %q":      "INVALID_PARAMETER",
+	"from %s is after to %s":                           "INVALID_PARAMETER",
+	"a usage report can cover at most %d days, not %d": "INVALID_PARAMETER",
+	"usage is not counted on this server":              "USAGE_DISABLED",
+
+	"samples must be between 1 and %d, not %d": "INVALID_PARAMETER",
+
+	"limit must not be negative, not %d": "INVALID_PARAMETER",
+	"dual writing is off on this server": "DUAL_WRITE_DISABLED",
+
+	"Redis cannot be reached yet, so the graph is empty and cannot be changed": "REDIS_UNAVAILABLE",
+
+	"the edge from %s to %s is not closed": "EDGE_NOT_CLOSED",
+
+	"the visit cost of %s must be a non-negative number, not %g": "INVALID_VISIT_COST",
+	"cost is required": "INVALID_PARAMETER",
+}
+
+// The body of an error response, for clients that accept JSON
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorCode is the code for msg, or one made from status, such as NOT_FOUND, if msg has none
+func errorCode(msg string, status int) string {
+	if format, _ := matchFormat(msg); format != "" {
+		if code, ok := errorCodes[format]; ok {
+			return code
+		}
+	}
+	return strings.ToUpper(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// routeErrorStatus is the status for an error from a route search: 422 for a negative cycle, which the
+// request cannot avoid, since no route has a least weight until the graph changes, 504 when only cached
+// routes were asked for and there were none, as for only-if-cached in HTTP caches, 404 when no route is
+// within a max_weight budget, 422 too when no tour keeps to the time windows asked for, and otherwise 400
+func routeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, routes.ErrNegativeCycle):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, routes.ErrNotCached):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, routes.ErrNoRouteWithinBudget), errors.Is(err, routes.ErrRevisionNotKept):
+		return http.StatusNotFound
+	case errors.Is(err, routes.ErrInfeasible):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errRedisUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// acceptsJSON is whether the request's Accept header names application/json
+func acceptsJSON(req *http.Request) bool {
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mediatype, _, err := mime.ParseMediaType(accepted); err == nil && mediatype == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// Routing failures, which never reach a handler, reported like every other error
+func notFoundHandler(w http.ResponseWriter, req *http.Request) {
+	httpError(w, req, "404 page not found", http.StatusNotFound)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, req *http.Request) {
+	httpError(w, req, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
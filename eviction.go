@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// What to do at startup when Redis could evict the graph's keys
+type evictionCheck string
+
+const (
+	evictionWarn   evictionCheck = "warn"
+	evictionRefuse evictionCheck = "refuse"
+	evictionOff    evictionCheck = "off"
+)
+
+func parseEvictionCheck(s string) (evictionCheck, error) {
+	switch check := evictionCheck(s); check {
+	case evictionWarn, evictionRefuse, evictionOff:
+		return check, nil
+	}
+	return "", fmt.Errorf("unknown eviction check %q, expected one of %s, %s or %s", s, evictionWarn, evictionRefuse, evictionOff)
+}
+
+// checkEvictionPolicy logs, or with evictionRefuse fails, if Redis's eviction policy could drop the graph's keys,
+// and logs any graph keys it has already lost
+func checkEvictionPolicy(store *routes.RouteStore, check evictionCheck) error {
+	if check == evictionOff {
+		return nil
+	}
+	report, err := store.CheckEviction()
+	if err != nil {
+		return err
+	}
+	if report.Unsafe {
+		err := fmt.Errorf("Redis's maxmemory-policy %s can evict the graph's keys once it reaches its maxmemory of %d bytes; use noeviction or a volatile policy", report.Policy, report.MaxMemory)
+		if check == evictionRefuse {
+			return err
+		}
+		log.Printf("Warning: %s\n", err.Error())
+	}
+	if len(report.Lost) > 0 {
+		log.Printf("Warning: Redis has lost %d graph keys: %s\n", len(report.Lost), strings.Join(report.Lost, ", "))
+	}
+	return nil
+}
+
+// watchLostKeys checks for graph keys Redis has lost every interval, forever, logging them, and with rewrite
+// writing them again from memory
+func watchLostKeys(store *routes.RouteStore, interval time.Duration, rewrite bool) {
+	for range time.Tick(interval) {
+		var report routes.EvictionReport
+		var err error
+		if rewrite {
+			report, err = store.RewriteLostKeys()
+		} else {
+			report, err = store.CheckEviction()
+		}
+		if err != nil {
+			log.Printf("Checking for lost Redis keys failed: %s\n", err.Error())
+		} else if report.Rewritten {
+			log.Printf("Rewrote %d graph keys Redis had lost: %s\n", len(report.Lost), strings.Join(report.Lost, ", "))
+		} else if len(report.Lost) > 0 {
+			log.Printf("Warning: Redis has lost %d graph keys: %s\n", len(report.Lost), strings.Join(report.Lost, ", "))
+		}
+	}
+}
+
+// GET  /admin/eviction/ : READ Redis's eviction policy, whether it could evict the graph's keys, and which graph keys it has lost
+func (rs *routeServer) evictionHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Checking eviction at %s\n", req.URL.Path)
+
+	report, err := rs.store.CheckEviction()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, report)
+}
+
+// POST /admin/eviction/ : UPDATE write the graph keys Redis has lost again from memory
+func (rs *routeServer) rewriteLostKeysHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Rewriting lost keys at %s\n", req.URL.Path)
+
+	report, err := rs.store.RewriteLostKeys()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, report)
+}
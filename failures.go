@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How many slices the failure window is kept in; counts leave the window a slice at a time
+const failureSlices = 60
+
+// Distinct patterns kept per slice, past which failures are counted against client "other", so that a flood of
+// clients cannot grow the log without bound
+const maxFailurePatterns = 1000
+
+// What a failing request was: the endpoint, as its method and route template, the error code and the client
+type failurePattern struct {
+	Endpoint string `json:"endpoint"`
+	Code     string `json:"code"`
+	Client   string `json:"client"`
+}
+
+type failureCount struct {
+	failurePattern
+	Count int `json:"count"`
+}
+
+// The most frequent failures over the window, most frequent first
+type failureReport struct {
+	WindowSeconds float64        `json:"window_seconds"`
+	Total         int            `json:"total"`
+	Top           []failureCount `json:"top"`
+}
+
+type failureSlice struct {
+	start  time.Time
+	counts map[failurePattern]int
+}
+
+// failureLog counts every error response by pattern over a rolling window
+type failureLog struct {
+	mu     sync.Mutex
+	window time.Duration
+	slices [failureSlices]failureSlice
+}
+
+// Every error response httpError writes, over FAILURE_WINDOW
+var failures = newFailureLog(time.Hour)
+
+func newFailureLog(window time.Duration) *failureLog {
+	return &failureLog{window: window}
+}
+
+func (fl *failureLog) sliceLength() time.Duration {
+	if length := fl.window / failureSlices; length > 0 {
+		return length
+	}
+	return 1
+}
+
+func (fl *failureLog) record(p failurePattern, now time.Time) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	length := fl.sliceLength()
+	start := now.Truncate(length)
+	slice := &fl.slices[(now.UnixNano()/int64(length))%failureSlices]
+	if !slice.start.Equal(start) {
+		*slice = failureSlice{start: start, counts: make(map[failurePattern]int)}
+	}
+	if _, ok := slice.counts[p]; !ok && len(slice.counts) >= maxFailurePatterns {
+		p.Client = "other"
+	}
+	slice.counts[p]++
+}
+
+// report returns the top most frequent patterns of the slices still in the window
+func (fl *failureLog) report(top int, now time.Time) failureReport {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	ret := failureReport{WindowSeconds: fl.window.Seconds(), Top: []failureCount{}}
+	oldest := now.Truncate(fl.sliceLength()).Add(-fl.sliceLength() * (failureSlices - 1))
+	counts := make(map[failurePattern]int)
+	for _, slice := range fl.slices {
+		if slice.start.Before(oldest) {
+			continue
+		}
+		for p, n := range slice.counts {
+			counts[p] += n
+			ret.Total += n
+		}
+	}
+	for p, n := range counts {
+		ret.Top = append(ret.Top, failureCount{failurePattern: p, Count: n})
+	}
+	sort.Slice(ret.Top, func(i, j int) bool {
+		a, b := ret.Top[i], ret.Top[j]
+		switch {
+		case a.Count != b.Count:
+			return a.Count > b.Count
+		case a.Endpoint != b.Endpoint:
+			return a.Endpoint < b.Endpoint
+		case a.Code != b.Code:
+			return a.Code < b.Code
+		}
+		return a.Client < b.Client
+	})
+	if top < len(ret.Top) {
+		ret.Top = ret.Top[:top]
+	}
+	return ret
+}
+
+// routeTemplate is the template of the route req matched, so that requests at different locations count
+// together, or "unmatched"
+func routeTemplate(req *http.Request) string {
+	if route := mux.CurrentRoute(req); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return "unmatched"
+}
+
+// failureOf is the pattern of a failing request, by its route template rather than its path
+func failureOf(req *http.Request, code string) failurePattern {
+	client := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		client = host
+	}
+	return failurePattern{Endpoint: req.Method + " " + routeTemplate(req), Code: code, Client: client}
+}
+
+// GET  /admin/failures/ (?top=10 optional) : READ the most frequent failing requests over FAILURE_WINDOW, by endpoint, error code and client, across every map
+func failuresHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Reporting failures at %s\n", req.URL.Path)
+
+	top, err := intParam(req, "top", 10)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if top < 0 {
+		httpError(w, req, fmt.Sprintf("top must not be negative, not %d", top), http.StatusBadRequest)
+		return
+	}
+	renderJSON(w, failures.report(top, time.Now()))
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// GET  /maps/<from>/<to>/flow : READ the maximum flow from <from> to <to> treating weights as capacities, with a minimum cut
+func (rs *routeServer) maxFlowHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding max flow at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+
+	flow, err := rs.store.MaxFlow(vars["from"], vars["to"])
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, flow)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"github.com/patterson-a/rest_project/routes"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// The basic mutations also take their fields as a form, for curl and clients that cannot easily send JSON: as an
+// application/x-www-form-urlencoded body or, with no body, as query parameters. Edges are given as to=<location>
+// and weight=<weight> pairs, repeated for several.
+
+const formMediaType = "application/x-www-form-urlencoded"
+
+// isForm is whether req gives its fields as a form rather than JSON: a form body, or no body and a query string
+func isForm(req *http.Request) bool {
+	contentType := req.Header.Get("Content-Type")
+	if mediatype, _, err := mime.ParseMediaType(contentType); err == nil && mediatype == formMediaType {
+		return true
+	}
+	return contentType == "" && req.ContentLength == 0 && req.URL.RawQuery != ""
+}
+
+// parseForm reads req's form, rejecting any field but those allowed, as JSON bodies reject unknown fields
+// unless lenientJSON is set
+func parseForm(req *http.Request, allowed ...string) (url.Values, error) {
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+	if lenientJSON {
+		return req.Form, nil
+	}
+	known := make(map[string]bool, len(allowed))
+	for _, name := range allowed {
+		known[name] = true
+	}
+	var unknown []string
+	for name := range req.Form {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown field %q", unknown[0])
+	}
+	return req.Form, nil
+}
+
+// formRoutes reads the edges a form gives as to and weight pairs. Weights may be left out altogether, or one left
+// empty, for an edge with a null weight.
+func formRoutes(form url.Values) (map[string]routes.RouteSpec, error) {
+	to, weights := form["to"], form["weight"]
+	if len(weights) > 0 && len(weights) != len(to) {
+		return nil, fmt.Errorf("%d weights given for %d locations, expected one for each or none", len(weights), len(to))
+	}
+	ret := make(map[string]routes.RouteSpec, len(to))
+	for i, name := range to {
+		ret[name] = routes.RouteSpec{}
+		if len(weights) == 0 || weights[i] == "" {
+			continue
+		}
+		weight, err := strconv.ParseFloat(weights[i], 64)
+		if err != nil {
+			return nil, fmt.Errorf("weight must be a number, not %q", weights[i])
+		}
+		ret[name] = routes.RouteSpec{Weight: &weight}
+	}
+	return ret, nil
+}
+
+// formBool reads an optional true or false field of a form, nil if it is not given
+func formBool(form url.Values, name string) (*bool, error) {
+	s := form.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false, not %q", name, s)
+	}
+	return &b, nil
+}
+
+// formFloat reads an optional number field of a form, nil if it is not given
+func formFloat(form url.Values, name string) (*float64, error) {
+	s := form.Get(name)
+	if s == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number, not %q", name, s)
+	}
+	return &f, nil
+}
@@ -1,10 +1,14 @@
 module github.com/patterson-a/rest_project
 
-go 1.16
+go 1.23.0
 
 require (
 	github.com/gomodule/redigo v1.8.4
 	github.com/gorilla/mux v1.8.0
-	github.com/jung-kurt/gofpdf v1.0.3-0.20190309125859-24315acbbda5 // indirect
-	gonum.org/v1/gonum v0.9.0
+	gonum.org/v1/gonum v0.16.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/stretchr/testify v1.7.0 // indirect
 )
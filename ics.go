@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"github.com/patterson-a/rest_project/routes"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The layout of an iCalendar UTC date-time
+const icsTime = "20060102T150405Z"
+
+// icsText escapes s for an iCalendar TEXT value
+func icsText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// writeICSLine writes one content line, folded so that no line is longer than 75 octets, as RFC 5545 requires
+func writeICSLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		cut := 75
+		// Not in the middle of a UTF-8 sequence
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// renderICS writes a tour as an iCalendar with an event for each stop after the start, from when it arrives until
+// it leaves, so a dispatcher can add a day's tour to a calendar
+func renderICS(w http.ResponseWriter, tour routes.Tour) {
+	var b strings.Builder
+	stamp := time.Now().UTC().Format(icsTime)
+	// Identifies the tour, so the same tour added twice updates the events rather than duplicating them
+	var departure string
+	if len(tour.Schedule) > 0 && tour.Schedule[0].At != nil {
+		departure = tour.Schedule[0].At.UTC().Format(icsTime)
+	}
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//rest_project//tour//EN")
+	for i, arrival := range tour.Schedule {
+		if i == 0 || arrival.At == nil {
+			continue
+		}
+		start := arrival.At.UTC()
+		summary := arrival.Location
+		if i == len(tour.Schedule)-1 && arrival.Location == tour.Schedule[0].Location {
+			summary = "Back at " + summary
+		}
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, fmt.Sprintf("UID:%s-%d-%s@rest_project", departure, i, icsText(arrival.Location)))
+		writeICSLine(&b, "DTSTAMP:"+stamp)
+		writeICSLine(&b, "DTSTART:"+start.Format(icsTime))
+		// Without DTEND an event ends when it starts, as a stop with no wait does
+		if arrival.Wait > 0 {
+			end := start.Add(time.Duration(arrival.Wait * float64(time.Second)))
+			writeICSLine(&b, "DTEND:"+end.Format(icsTime))
+		}
+		writeICSLine(&b, "SUMMARY:"+icsText(summary))
+		writeICSLine(&b, fmt.Sprintf("DESCRIPTION:Stop %d of %d", i, len(tour.Schedule)-1))
+		writeICSLine(&b, "END:VEVENT")
+	}
+	writeICSLine(&b, "END:VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
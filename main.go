@@ -1,18 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/mux"
 	"github.com/patterson-a/rest_project/routes"
-	"github.com/gomodule/redigo/redis"
+	"io"
 	"log"
 	"mime"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type routeServer struct {
 	store *routes.RouteStore
+	// Serve location listings from a snapshot of the graph rather than under the store lock
+	snapshotReads bool
 }
 
 func NewRouteServer(conn redis.Conn) *routeServer {
@@ -24,32 +33,427 @@ func NewRouteServer(conn redis.Conn) *routeServer {
 }
 
 //// API:
-// POST /maps/ (with JSON name: string, routes_to: map[string]weight optional) : CREATE a location, optionally with routes
-// GET  /maps/ : READ a list of all known locations
-// GET  /maps/<location> : READ list of places <location> has direct connections to
-// GET  /maps/<from>/<to> : READ list of shortest routes from <from> to <to>
-// PUT  /maps/add/<location> (with JSON to: map[string]weight) : UPDATE add the given connections to <location>
-// PUT  /maps/delete/<location> (with JSON from: []string) : UPDATE remove the given connections from <location>
-// DELETE /maps/<location> : DELETE the given location (and all edges from/to it) (and error if no such location)
+// POST /maps/ (with JSON name: string, routes_to: map[string]weight|{weight, both}, bidirectional: bool, lat: float, lon: float optional, or a form or query of name, to and weight repeated, bidirectional, lat, lon) : CREATE a location, optionally with routes, both ways if bidirectional or, without it, if the map is symmetric, unless a route's own both says otherwise, and coordinates; a null weight is the distance, with DISTANCE_WEIGHTS, or else the map's default weight
+// GET  /maps/ (?region=<region>&tag=<tag>&limit=&cursor=&total=true optional) : READ a list of all known locations, or those in <region> and its subregions, or those tagged <tag>, or both
+// GET  /maps/<location> (?limit=&cursor=&total=true optional) : READ list of places <location> has direct connections to
+// GET  /maps/<location>/incoming (?limit=&cursor=&total=true optional) : READ list of places with direct connections to <location>
+// GET  /maps/<location>/within/ (?weight=<budget>) : READ every location the cheapest route from <location> reaches within the budget, with its weight, cheapest first
+// GET  /maps/<from>/<to> (?algorithm|algo=dijkstra|astar|bellman-ford|bidirectional, heuristic=<name>, exclude_tags=<tag>,..., require_tags=<tag>,..., exclude_location_tags=<tag>,..., require_location_tags=<tag>,..., within=<region>, modes=<mode>,..., max_hops=<n>, max_weight=<weight>, cost=<name>, metric=<attribute>, profile=distance|duration|cost, depart_at=<time>, disjoint=edges|nodes, stream=ndjson, k=<n> optional; Cache-Control: no-cache|only-if-cached optional) : READ list of shortest routes from <from> to <to>, or the k cheapest, or routes sharing no edges or locations, or by the given modes of transport only, or weighing edges by a profile's attribute where they have one, or passing only through locations with or without the given tags, or staying within <region> and its subregions
+// GET  /maps/<from>/<to>/edge : READ the edge from <from> to <to>, open or closed, with its weight, tags, attributes, modes, whether it is closed and since when, and when it was created and last changed, or 404 if there is none
+// GET  /maps/<from>/<to>/connected : READ whether there is any route from <from> to <to>, and the fewest edges on one, without finding routes
+// GET  /maps/<a>/similar/<b> : READ the neighbours <a> and <b> share, with their Jaccard coefficient and Adamic-Adar index
+// GET  /maps/<from>/<to>/flow : READ the maximum flow from <from> to <to> treating weights as capacities, with a minimum cut
+// PUT  /maps/add/<location> (with JSON to: map[string]weight|{weight, both}, bidirectional: bool optional, or a form or query of to and weight repeated, bidirectional) : UPDATE add the given connections to <location>, both ways if bidirectional or, without it, if the map is symmetric, unless a route's own both says otherwise; a null weight is the distance, with DISTANCE_WEIGHTS, or else the map's default weight
+// PUT  /maps/delete/<location> (with JSON from: []string, or a form or query of to repeated) : UPDATE remove the given connections from <location>
+// GET  /maps/close/ : READ every closed edge, with its weight and when it was closed
+// PUT  /maps/close/<from>/<to> : UPDATE close the edge from <from> to <to> without deleting it, so routes go around it until it is opened, or 404 if there is none
+// PUT  /maps/open/<from>/<to> : UPDATE open the closed edge from <from> to <to> again
+// DELETE /maps/<location> (?soft=true optional) : DELETE the given location (and all edges from/to it) (and error if no such location), or with soft archive it, keeping everything Redis has on it until it is restored
+// POST /maps/<location>/impact : READ which watched and critical routes would change or break if <location> were deleted, without deleting it
+// GET  /maps/<from>/edge/<to>/history (?limit=&cursor=&total=true optional) : READ the recorded weight changes of the edge from <from> to <to>
+// GET  /maps/<from>/edge/<to>/tags : READ the tags of the edge from <from> to <to>
+// PUT  /maps/<from>/edge/<to>/tags (with JSON []string) : UPDATE replace the tags of the edge from <from> to <to>
+// GET  /maps/<from>/edge/<to>/attributes : READ the numeric attributes of the edge from <from> to <to>
+// PUT  /maps/<from>/edge/<to>/attributes (with JSON map[string]number) : UPDATE replace the attributes of the edge from <from> to <to>
+// GET  /maps/<from>/edge/<to>/modes : READ the weight of each mode of transport, such as train or bus, of the edge from <from> to <to>
+// PUT  /maps/<from>/edge/<to>/modes (with JSON map[string]weight) : UPDATE replace the modes of the edge from <from> to <to>, parallel edges that ?modes= routes by
+// POST /maps/<from>/edge/<to>/impact : READ which watched and critical routes would change or break if the edge from <from> to <to> were removed, without removing it
+// GET  /maps/regions/ : READ every region with its parent, subregions and how many locations it holds
+// GET  /maps/regions/<region> : READ a region, with the locations directly in it
+// PUT  /maps/regions/<region> (with JSON parent: string optional) : UPDATE create <region>, or move it inside another region or to the top
+// DELETE /maps/regions/<region> : DELETE <region>; its locations and subregions move to the region it was in, if any
+// PUT  /maps/regions/<region>/locations (with JSON []string) : UPDATE put the given locations in <region>, taking them out of any other
+// DELETE /maps/regions/<region>/locations/<location> : DELETE take <location> out of <region>
+// GET  /maps/regions/<from>/routes/<to> : READ the shortest routes from any location in region <from> to any location in region <to>
+// GET  /maps/nearest/ (?origins=<name>,...&destinations=<name>,...) : READ the shortest routes from any origin to any destination
+// POST /maps/nearest/ (with JSON origins: []string, destinations: []string) : READ as GET /maps/nearest/, for long lists
+// GET  /maps/via/ (?points=<name>,...) : READ the shortest route visiting every waypoint in order, with each leg
+// POST /maps/route/ (with JSON from: string, to: string, avoid: []string, algorithm: string, metric: map[string]number, profile: string, depart_at: string, disjoint: edges|nodes, modes: []string optional) : READ shortest routes from <from> to <to> that use none of the avoided locations, or edges given as <from>/<to>, weighing edges by the metric's attributes or the profile's if given
+// POST /maps/compare/ (with JSON before: scenario, after: scenario, pairs: [{from, to}]) : READ the best route between each pair in both scenarios, and the change in weight
+// POST /maps/routes/validate/ (with JSON token: string) : READ whether a previously returned route is still valid and optimal
+// POST /maps/rename-batch/ (with JSON map[old]new) : UPDATE rename many locations at once, rewriting every edge
+// GET  /maps/offline/ : READ every location's edges with a version of each, to edit offline and submit later
+// POST /maps/offline/ (with JSON base: bundle, edited: map[location]map[string]weight) : UPDATE apply offline edits by three-way merge, reporting conflicts
+// GET  /maps/export/ (?nodes=prefix:<text>|<name>,...&min_weight=&max_weight=&component_of=<location> optional) : READ the graph or a slice of it
+// POST /maps/import/ (with JSON locations, edges, coordinates; ?conflict=skip|overwrite|error|min|max optional) : CREATE locations and edges in bulk
+// GET  /maps/matrix/ (?origins=<name>,...&destinations=<name>,... optional) : READ the shortest route weights between every pair of locations, up to 500 origins and 250,000 pairs
+// POST /maps/matrix/ (with JSON origins: []string, destinations: []string optional) : READ as GET /maps/matrix/, for long lists
+// GET  /maps/analysis/weights/ (?buckets=10&top=5 optional) : READ a histogram, percentiles and extremes of the edge weights
+// GET  /maps/analysis/duplicates/ (?threshold=2&km=0.5 optional) : READ pairs of locations that are probably the same place
+// POST /maps/analysis/traffic/ (with JSON [{from, to, demand}]) : READ the flow each edge would carry with every demand on its shortest route, and which exceed their capacity attribute
+// POST /maps/route/capacity/ (with JSON from, to, demand, split: bool optional) : READ a route for demand from <from> to <to> that takes no edge past its capacity attribute, or split across routes, with the edges it saturates, or refused with the edges that stop it
+// GET  /maps/analysis/lint/ (?tolerance=0.1&fence=3&threshold=2&km=0.5 optional) : READ likely data problems, such as asymmetric or outlying weights and duplicate locations, with a quality score
+// GET  /maps/analysis/critical/ : READ the articulation points and bridges, whose removal would cut some locations off from others
+// GET  /maps/analysis/communities/ (?algorithm=louvain&resolution=1 optional) : READ the locations grouped into densely linked communities, largest first
+// GET  /maps/analysis/suggest-edges/ (?n=20 optional) : READ the pairs of locations most likely to be missing an edge, with confidence scores, as a data quality aid
+// GET  /maps/components/ : READ the strongly connected components, largest first, each in name order
+// GET  /maps/cycles/ (?max=100 optional) : READ whether the graph is acyclic, and up to max of its simple cycles
+// GET  /maps/stats/ (Cache-Control: no-cache|only-if-cached optional) : READ location and edge counts, average degree, diameter and connectivity, kept until the graph changes
+// POST /maps/optimize/ (?format=json|ics optional; with JSON locations: []string, start: string, open: bool, departure: number, windows: map[location]{earliest, latest}, depart_at: string optional) : READ a short tour from start, or the first location, through every location and back, or not if open, with a schedule that keeps to the windows, or as calendar events given depart_at
+// POST /maps/optimize/vrp/ (with JSON depot: string, vehicles: int, capacity: number, stops: [{location, demand}], pairs: [{pickup, delivery}] optional) : READ tours for the vehicles from the depot through every stop and back, within their capacity, each pair's pickup before its delivery on the same tour
+// GET  /maps/analytics/centrality/ (Cache-Control: no-cache|only-if-cached optional) : READ degree, betweenness and PageRank of every location, kept until the graph changes
+// GET  /maps/layout/ (?algorithm=force|spectral optional; Cache-Control: no-cache|only-if-cached optional) : READ a position in the unit square for every location, for drawing the graph, kept until the graph changes
+// GET  /maps/watched/ : READ every watched pair's best route and whether it changed since the last check
+// POST /maps/watched/check/ : UPDATE as GET /maps/watched/, then clear the changed flags
+// PUT  /maps/watched/<from>/<to> : UPDATE start watching the best route from <from> to <to>
+// DELETE /maps/watched/<from>/<to> : DELETE stop watching the route from <from> to <to>
+// GET  /maps/critical/ : READ every critical pair and whether it has a route
+// GET  /maps/critical/disconnected/ : READ the critical pairs that have no route, and since when
+// PUT  /maps/critical/<from>/<to> : UPDATE mark the pair from <from> to <to> as critical, so losing every route between them is reported
+// DELETE /maps/critical/<from>/<to> : DELETE stop treating the pair from <from> to <to> as critical
+// GET  /maps/coordinates/ : READ the coordinates of every location that has them
+// PUT  /maps/coordinates/<location> (with JSON lat: float, lon: float) : UPDATE set the coordinates of <location>
+// GET  /maps/location-tags/ : READ the tags of every tagged location
+// GET  /maps/location-tags/<location> : READ the tags of <location>
+// PUT  /maps/location-tags/<location> (with JSON []string) : UPDATE replace the tags of <location>, such as warehouse or customer
+// GET  /maps/visit-costs/ : READ the visit cost of every location that has one
+// GET  /maps/visit-costs/<location> : READ the visit cost of <location>, 0 if it has none
+// PUT  /maps/visit-costs/<location> (with JSON cost: number) : UPDATE set the cost added to routes passing through <location>, such as a transfer penalty at a hub; 0 removes it
+// GET  /maps/trash/ : READ a list of deleted locations that can still be restored
+// PUT  /maps/trash/restore/<location> : UPDATE restore a deleted location and its coordinates, with its edges to and from locations that still exist, one-way and without tags, attributes or modes
+// DELETE /maps/trash/<location> : DELETE a deleted location permanently
+// DELETE /maps/trash/ : DELETE every deleted location permanently
+// GET  /maps/archive/ : READ the archived locations, in name order
+// PUT  /maps/archive/restore/<location> : UPDATE bring an archived location back into routing with everything kept for it
+// GET  /maps/revisions/ : READ the revisions the graph can be queried as of, oldest first and the current one last, with SNAPSHOT_HISTORY
+// GET  /maps/revisions/<revision> (?limit=&cursor=&total=true optional) : READ list of locations as the graph was at <revision>
+// GET  /maps/revisions/<revision>/<from>/<to> : READ list of shortest routes from <from> to <to> as the graph was at <revision>
+// GET  /metrics : READ server metrics for Prometheus
+// GET  /admin/memory/ : READ approximate memory used by the graph, route cache and trash
+// POST /admin/compact/ : UPDATE rebuild internal structures, empty the route cache and drop expired trash
+// GET  /admin/cache/stats/ : READ route cache hit ratio, evictions and size
+// GET  /admin/cache/ : READ every route cache entry with its size and hits, and the cache's statistics
+// DELETE /admin/cache/ (?from=<pattern>&to=<pattern> optional) : DELETE the cached routes between matching locations, or every one
+// GET  /admin/hot/ : READ the locations whose shortest paths are maintained incrementally
+// PUT  /admin/hot/<location> : UPDATE maintain shortest paths from <location> incrementally
+// DELETE /admin/hot/<location> : DELETE stop maintaining shortest paths from <location>
+// GET  /admin/landmarks/ : READ the landmarks used by the landmark A* heuristic and whether they are current
+// POST /admin/landmarks/ : UPDATE recompute the landmarks now if the graph has changed
+// GET  /admin/resync/ : READ when the last full resync from Redis ran and the drift it found
+// POST /admin/resync/ : UPDATE reconcile the whole graph with Redis now, fixing and reporting any drift
+// POST /admin/reload-graph/ : UPDATE read the whole graph from Redis again and swap it in at once, for bulk changes made by offline tools, reporting what changed
+// GET  /admin/eviction/ : READ Redis's eviction policy, whether it could evict the graph's keys, and which graph keys it has lost
+// POST /admin/eviction/ : UPDATE write the graph keys Redis has lost again from memory: the locations set and the edges of each location
+// GET  /admin/bundle/ : READ the whole state of the store, for ImportBundle on another server
+// POST /admin/bundle/ (with JSON bundle) : CREATE the whole state of an exported store in this empty one
+// POST /admin/generate/ (with JSON locations: int, density: number, min_weight: number, max_weight: number, prefix: string, seed: int optional) : CREATE a random graph for load testing and demos
+// GET  /admin/replica/ : READ the primary this replica copied and how stale it is, with 503 once past REPLICA_MAX_STALENESS
+// GET  /admin/failures/ (?top=10 optional) : READ the most frequent failing requests over FAILURE_WINDOW, by endpoint, error code and client, across every map
+// GET  /admin/redis-memory/ (?samples=100 optional) : READ approximately how much Redis memory each map takes, the default map as the empty name, sampling up to <samples> keys of each with MEMORY USAGE, and each map's share of Redis's maxmemory
+// GET  /admin/usage/ (?from=<day>&to=<day>&key=<key>&top=5 optional) : READ requests, bytes in and out and the top endpoints of each API key, as given in X-API-Key, or just <key>, for each day from <from> to <to>, as YYYY-MM-DD in UTC, by default the last seven days, across every map
+// GET  /admin/dual-write/ : READ how many writes were mirrored to the new backend, how many it failed or answered differently, and the most recent of those, across every map
+// GET  /admin/dual-write/verify/ (?limit=100 optional) : READ compare every key of the old backend with the new, listing up to <limit> of each of the keys the new lacks, has with other contents, or has that the old does not
+// GET  /admin/startup/ : READ the startup mode and when the store was restored from Redis, with 503 while serving an empty graph until Redis is reached
+// GET  /admin/costs/ : READ every named cost function, in name order
+// PUT  /admin/costs/<name> (with JSON expression: string) : UPDATE define or replace the cost function <name>
+// DELETE /admin/costs/<name> : DELETE the cost function <name>
+// GET  /admin/timezone/ : READ the map's time zone, which arrival times are given in
+// PUT  /admin/timezone/ (with JSON timezone: string) : UPDATE set the map's time zone by IANA name, such as Europe/London; empty for UTC
+// GET  /admin/symmetric/ : READ whether edges added to the map go both ways unless bidirectional says otherwise
+// PUT  /admin/symmetric/ (with JSON symmetric: bool) : UPDATE set whether edges added to the map go both ways unless bidirectional says otherwise
+// GET  /admin/default-weight/ : READ the weight of edges added with a null weight, or null if they need one
+// PUT  /admin/default-weight/ (with JSON default_weight: number or null) : UPDATE set the weight of edges added with a null weight, or require one again with null
+// GET  /namespaces/ : READ the names of every named map, in name order
+// PUT  /namespaces/<map> : CREATE an empty named map, with its own graph and Redis keys, served under /namespaces/<map>/
+// DELETE /namespaces/<map> : DELETE a named map, with everything in it
+// ANY  /namespaces/<map>/maps/..., /namespaces/<map>/admin/... : as /maps/... and /admin/..., for the named map <map> instead of the default one
+
+// dialRedis connects to Redis; serving empty, a connection that cannot be dialled yet is a standbyConn
+func dialRedis() (redis.Conn, error) {
+	conn, err := dialConn()
+	if err != nil && startup.mode() == startupServeEmpty {
+		standby := newStandbyConn()
+		standby.redial(dialConn)
+		return standby, nil
+	}
+	return conn, err
+}
+
+// dialConn connects to Redis, mirroring writes to the new backend too while dual writing
+func dialConn() (redis.Conn, error) {
+	conn, err := dialPrimary()
+	if err != nil || dualWrites == nil {
+		return conn, err
+	}
+	secondary, err := dialSecondary()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return routes.NewDualWriteConn(conn, secondary, dualWrites), nil
+}
 
 func main() {
-	conn, err := redis.Dial("tcp", "localhost:6379",
-		redis.DialPassword("bad-password"))
+	// "doctor" checks the configuration, Redis and every map's keys, printing what is wrong and how to fix it, instead of serving
+	if len(os.Args) > 1 {
+		if os.Args[1] != "doctor" {
+			log.Fatalf("unknown command %q, expected doctor or none to start the server\n", os.Args[1])
+		}
+		os.Exit(runDoctor(os.Stdout, dialPrimary))
+	}
+
+	// DUAL_WRITE_TO is the address of a new backend speaking the Redis protocol, with DUAL_WRITE_PASSWORD, which every write
+	// is mirrored to while migrating to it; reads stay with Redis. Every connection is dialled after this, so all mirror.
+	if os.Getenv("DUAL_WRITE_TO") != "" {
+		dualWrites = routes.NewDualWriteStats()
+	}
+
+	// STARTUP_MODE=fail-fast|wait|serve-empty exits, keeps trying for up to STARTUP_DEADLINE, 30s by default, or serves an
+	// empty graph that refuses changes when Redis cannot be reached at startup; fail-fast by default. Serving empty, the
+	// graph is restored, configured and checked for eviction once Redis is reached, as GET /admin/startup/ reports.
+	if envVar := os.Getenv("STARTUP_MODE"); envVar != "" {
+		mode, err := parseStartupMode(envVar)
+		if err != nil {
+			log.Fatal(err)
+		}
+		startup.status.Mode = mode
+	}
+	deadline := 30 * time.Second
+	if envVar := os.Getenv("STARTUP_DEADLINE"); envVar != "" {
+		var err error
+		if deadline, err = time.ParseDuration(envVar); err != nil {
+			log.Fatal(err)
+		}
+	}
+	conn, err := connectRedis(startup.mode(), deadline)
 	if err != nil {
-		panic(err)
+		log.Fatalf("Could not reach Redis: %s\n", err.Error())
+	}
+
+	// EVICTION_CHECK=warn|refuse|off logs, refuses to start, or does nothing when Redis's eviction policy could drop the
+	// graph's keys; warn by default. LOST_KEYS_INTERVAL checks that often for graph keys Redis has lost, and
+	// LOST_KEYS_REWRITE=true writes them again from memory rather than only logging them.
+	check := evictionWarn
+	if envVar := os.Getenv("EVICTION_CHECK"); envVar != "" {
+		if check, err = parseEvictionCheck(envVar); err != nil {
+			panic(err)
+		}
+	}
+
+	router := newRouter()
+	var server *routeServer
+	if standby, ok := conn.(*standbyConn); ok {
+		server = &routeServer{store: routes.New(standby)}
+		go reconcileWhenReached(server.store, standby, check)
+	} else {
+		server = NewRouteServer(conn)
+		startup.restored(nil, nil)
+		if err := configureStore(server.store); err != nil {
+			panic(err)
+		}
+		if err := checkEvictionPolicy(server.store, check); err != nil {
+			panic(err)
+		}
+	}
+	if envVar := os.Getenv("LOST_KEYS_INTERVAL"); envVar != "" {
+		interval, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		var rewrite bool
+		if rewriteVar := os.Getenv("LOST_KEYS_REWRITE"); rewriteVar != "" {
+			if rewrite, err = strconv.ParseBool(rewriteVar); err != nil {
+				panic(err)
+			}
+		}
+		go watchLostKeys(server.store, interval, rewrite)
+	}
+
+	// LANDMARKS is how many landmarks to precompute for the landmark heuristic, LANDMARK_REFRESH how often to recompute them
+	if envVar := os.Getenv("LANDMARKS"); envVar != "" {
+		count, err := strconv.Atoi(envVar)
+		if err != nil {
+			panic(err)
+		}
+		refresh := time.Minute
+		if refreshVar := os.Getenv("LANDMARK_REFRESH"); refreshVar != "" {
+			if refresh, err = time.ParseDuration(refreshVar); err != nil {
+				panic(err)
+			}
+		}
+		server.store.SetLandmarkCount(count)
+		go func() {
+			for {
+				server.store.RefreshLandmarks()
+				time.Sleep(refresh)
+			}
+		}()
+	}
+
+	// FAILURE_WINDOW is how far back GET /admin/failures/ counts failing requests
+	if envVar := os.Getenv("FAILURE_WINDOW"); envVar != "" {
+		window, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		failures = newFailureLog(window)
+	}
+
+	// USAGE_RETENTION_DAYS is how long each day's usage by API key is kept in Redis, 0 to count none
+	usageRetention := 90
+	if envVar := os.Getenv("USAGE_RETENTION_DAYS"); envVar != "" {
+		if usageRetention, err = strconv.Atoi(envVar); err != nil {
+			panic(err)
+		}
+	}
+	if usageRetention > 0 {
+		usageConn, err := dialRedis()
+		if err != nil {
+			panic(err)
+		}
+		usage = routes.NewUsage(usageConn, time.Duration(usageRetention)*24*time.Hour)
+		go flushUsage(usageFlushInterval)
+	}
+
+	// LENIENT_JSON accepts request bodies with unknown fields or trailing data, which are otherwise rejected
+	if envVar := os.Getenv("LENIENT_JSON"); envVar != "" {
+		if lenientJSON, err = strconv.ParseBool(envVar); err != nil {
+			panic(err)
+		}
+		routes.LenientJSON = lenientJSON
+	}
+
+	// SNAPSHOT_READS serves location listings from an immutable copy of the graph, made once per change
+	if envVar := os.Getenv("SNAPSHOT_READS"); envVar != "" {
+		if server.snapshotReads, err = strconv.ParseBool(envVar); err != nil {
+			panic(err)
+		}
+	}
+
+	go server.store.MonitorWatched()
+	go server.store.MonitorQueryCounts()
+	go server.store.MonitorTrash()
+
+	// RESYNC_INTERVAL reconciles the whole graph with Redis that often, logging any drift it fixes
+	if envVar := os.Getenv("RESYNC_INTERVAL"); envVar != "" {
+		interval, err := time.ParseDuration(envVar)
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			for range time.Tick(interval) {
+				report, err := server.store.ResyncAll()
+				if err != nil {
+					log.Printf("Resyncing from Redis failed: %s\n", err.Error())
+				} else if report.Changed() {
+					log.Printf("Resyncing from Redis fixed drift: %s\n", report.String())
+				}
+			}
+		}()
+	}
+
+	// REDIS_NOTIFICATIONS=true follows keyspace notifications so that edits made directly in Redis reach the
+	// graph, gathering those within REDIS_NOTIFICATIONS_DEBOUNCE of each other
+	if envVar := os.Getenv("REDIS_NOTIFICATIONS"); envVar != "" {
+		on, err := strconv.ParseBool(envVar)
+		if err != nil {
+			panic(err)
+		}
+		debounce := 100 * time.Millisecond
+		if debounceVar := os.Getenv("REDIS_NOTIFICATIONS_DEBOUNCE"); debounceVar != "" {
+			if debounce, err = time.ParseDuration(debounceVar); err != nil {
+				panic(err)
+			}
+		}
+		if on {
+			subscriber, err := dialRedis()
+			if err != nil {
+				panic(err)
+			}
+			go func() {
+				waitForRedis(subscriber)
+				err := server.store.FollowRedis(subscriber, 0, debounce)
+				log.Printf("Following Redis keyspace notifications stopped: %s\n", err.Error())
+			}()
+		}
+	}
+
+	cacheMaxBytes := int64(routes.DefaultCacheBytes)
+	if envVar := os.Getenv("CACHE_MAX_BYTES"); envVar != "" {
+		if cacheMaxBytes, err = strconv.ParseInt(envVar, 10, 64); err != nil {
+			panic(err)
+		}
+	}
+	server.store.SetCacheMaxBytes(cacheBudget(cacheMaxBytes))
+	go adaptCacheSize(server.store, cacheMaxBytes, time.Minute)
+
+	// WARM_ROUTES is a comma separated list of <from>/<to> pairs, WARM_TOP_N how many of the most queried pairs to add
+	var warm []routes.Pair
+	if envVar := os.Getenv("WARM_ROUTES"); envVar != "" {
+		for _, s := range strings.Split(envVar, ",") {
+			pair, err := routes.ParsePair(strings.TrimSpace(s))
+			if err != nil {
+				panic(err)
+			}
+			warm = append(warm, pair)
+		}
+	}
+	var topN int
+	if envVar := os.Getenv("WARM_TOP_N"); envVar != "" {
+		if topN, err = strconv.Atoi(envVar); err != nil {
+			panic(err)
+		}
+	}
+	if warmed, err := server.store.Warm(warm, topN); err != nil {
+		log.Printf("Warming the route cache failed: %s\n", err.Error())
+	} else if warmed > 0 {
+		log.Printf("Warmed the route cache with %d routes\n", warmed)
 	}
 
-	router := mux.NewRouter()
-	router.StrictSlash(true)
-	server := NewRouteServer(conn)
+	// CACHE_CONTROL_LISTINGS and CACHE_CONTROL_MUTATIONS replace the Cache-Control header for those endpoints; empty omits it
+	policy := defaultCachePolicy
+	if envVar, ok := os.LookupEnv("CACHE_CONTROL_LISTINGS"); ok {
+		policy.Listings = envVar
+	}
+	if envVar, ok := os.LookupEnv("CACHE_CONTROL_MUTATIONS"); ok {
+		policy.Mutations = envVar
+	}
+	router.Use(policy.middleware)
 
-	router.HandleFunc("/maps/", server.addLocationHandler).Methods("POST")
-	router.HandleFunc("/maps/", server.getLocationsHandler).Methods("GET")
-	router.HandleFunc("/maps/{location}/", server.routesFromHandler).Methods("GET")
-	router.HandleFunc("/maps/{from}/{to}/", server.routesBetweenHandler).Methods("GET")
-	router.HandleFunc("/maps/add/{location}/", server.addRoutesHandler).Methods("PUT")
-	router.HandleFunc("/maps/delete/{location}/", server.removeRoutesHandler).Methods("PUT")
-	router.HandleFunc("/maps/{location}/", server.deleteLocationHandler).Methods("DELETE")
+	// REPLICA_OF makes this a read-only replica of the primary at that URL, copying it into an empty store at startup;
+	// REPLICA_MAX_STALENESS is how old the copy may get before GET /admin/replica/ reports it stale
+	if envVar := os.Getenv("REPLICA_OF"); envVar != "" {
+		replica := &replica{primary: envVar}
+		if stalenessVar := os.Getenv("REPLICA_MAX_STALENESS"); stalenessVar != "" {
+			if replica.maxStaleness, err = time.ParseDuration(stalenessVar); err != nil {
+				panic(err)
+			}
+		}
+		if err := replica.bootstrap(server.store); err != nil {
+			panic(err)
+		}
+		router.Use(replica.middleware)
+		router.HandleFunc("/admin/replica/", replica.statusHandler).Methods("GET")
+	}
+
+	// Named maps are restored when first used, with the same settings and their own MonitorWatched, MonitorQueryCounts
+	// and MonitorTrash
+	registry, err := routes.NewRegistry(server.store, dialRedis, func(store *routes.RouteStore) error {
+		if err := configureStore(store); err != nil {
+			return err
+		}
+		go store.MonitorWatched()
+		go store.MonitorQueryCounts()
+		go store.MonitorTrash()
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	maps := &namespaces{registry: registry, snapshotReads: server.snapshotReads, servers: make(map[string]*namespace)}
+	router.HandleFunc("/namespaces/", maps.listHandler).Methods("GET")
+	router.HandleFunc("/namespaces/{map}/", maps.createHandler).Methods("PUT")
+	router.HandleFunc("/namespaces/{map}/", maps.deleteHandler).Methods("DELETE")
+	router.PathPrefix("/namespaces/{map}/").HandlerFunc(maps.serve)
+	router.HandleFunc("/admin/redis-memory/", maps.redisMemoryHandler).Methods("GET")
+
+	server.routes(router)
+	router.HandleFunc("/metrics", server.metricsHandler).Methods("GET")
+	router.HandleFunc("/admin/failures/", failuresHandler).Methods("GET")
+	router.HandleFunc("/admin/usage/", usageHandler).Methods("GET")
+	router.HandleFunc("/admin/dual-write/", dualWriteHandler).Methods("GET")
+	router.HandleFunc("/admin/dual-write/verify/", verifyDualWriteHandler).Methods("GET")
+	router.HandleFunc("/admin/startup/", startupHandler).Methods("GET")
 
 	var port string
 	if envVar := os.Getenv("SERVERPORT"); envVar != "" {
@@ -62,155 +466,909 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+port, router))
 }
 
-// POST /maps/ (with JSON name: string, routes_to: map[string]weight optional) : CREATE a location, optionally with routes
+// routes registers the handlers of one map's endpoints, which the default map serves at the root and each named map
+// under /namespaces/<map>/
+func (rs *routeServer) routes(router *mux.Router) {
+	router.HandleFunc("/maps/nearest/", rs.nearestRoutesHandler).Methods("GET")
+	router.HandleFunc("/maps/nearest/", rs.postNearestRoutesHandler).Methods("POST")
+	router.HandleFunc("/maps/via/", rs.routeViaHandler).Methods("GET")
+	router.HandleFunc("/maps/route/", rs.routeAvoidingHandler).Methods("POST")
+	router.HandleFunc("/maps/regions/", rs.getRegionsHandler).Methods("GET")
+	router.HandleFunc("/maps/regions/{region}/", rs.getRegionHandler).Methods("GET")
+	router.HandleFunc("/maps/regions/{region}/", rs.setRegionHandler).Methods("PUT")
+	router.HandleFunc("/maps/regions/{region}/", rs.removeRegionHandler).Methods("DELETE")
+	router.HandleFunc("/maps/regions/{region}/locations/", rs.addToRegionHandler).Methods("PUT")
+	router.HandleFunc("/maps/regions/{region}/locations/{location}/", rs.removeFromRegionHandler).Methods("DELETE")
+	router.HandleFunc("/maps/regions/{from}/routes/{to}/", rs.regionRoutesHandler).Methods("GET")
+	router.HandleFunc("/maps/compare/", rs.compareScenariosHandler).Methods("POST")
+	router.HandleFunc("/maps/routes/validate/", rs.validateRouteHandler).Methods("POST")
+	router.HandleFunc("/maps/rename-batch/", rs.renameLocationsHandler).Methods("POST")
+	router.HandleFunc("/maps/offline/", rs.offlineBundleHandler).Methods("GET")
+	router.HandleFunc("/maps/offline/", rs.mergeOfflineHandler).Methods("POST")
+	router.HandleFunc("/maps/export/", rs.exportHandler).Methods("GET")
+	router.HandleFunc("/maps/import/", rs.importHandler).Methods("POST")
+	router.HandleFunc("/maps/matrix/", rs.distanceMatrixHandler).Methods("GET")
+	router.HandleFunc("/maps/matrix/", rs.postDistanceMatrixHandler).Methods("POST")
+	router.HandleFunc("/maps/analysis/weights/", rs.analyseWeightsHandler).Methods("GET")
+	router.HandleFunc("/maps/analysis/duplicates/", rs.findDuplicatesHandler).Methods("GET")
+	router.HandleFunc("/maps/analysis/traffic/", rs.assignTrafficHandler).Methods("POST")
+	router.HandleFunc("/maps/route/capacity/", rs.routeWithinCapacityHandler).Methods("POST")
+	router.HandleFunc("/maps/analysis/lint/", rs.lintHandler).Methods("GET")
+	router.HandleFunc("/maps/analysis/critical/", rs.criticalElementsHandler).Methods("GET")
+	router.HandleFunc("/maps/analysis/communities/", rs.communitiesHandler).Methods("GET")
+	router.HandleFunc("/maps/analysis/suggest-edges/", rs.suggestEdgesHandler).Methods("GET")
+	router.HandleFunc("/maps/components/", rs.componentsHandler).Methods("GET")
+	router.HandleFunc("/maps/cycles/", rs.cyclesHandler).Methods("GET")
+	router.HandleFunc("/maps/stats/", rs.statsHandler).Methods("GET")
+	router.HandleFunc("/maps/optimize/", rs.optimizeTourHandler).Methods("POST")
+	router.HandleFunc("/maps/optimize/vrp/", rs.solveVRPHandler).Methods("POST")
+	router.HandleFunc("/maps/analytics/centrality/", rs.centralityHandler).Methods("GET")
+	router.HandleFunc("/maps/layout/", rs.layoutHandler).Methods("GET")
+	router.HandleFunc("/maps/watched/", rs.getWatchedHandler).Methods("GET")
+	router.HandleFunc("/maps/watched/check/", rs.checkWatchedHandler).Methods("POST")
+	router.HandleFunc("/maps/watched/{from}/{to}/", rs.watchHandler).Methods("PUT")
+	router.HandleFunc("/maps/watched/{from}/{to}/", rs.unwatchHandler).Methods("DELETE")
+	router.HandleFunc("/maps/critical/", rs.getCriticalHandler).Methods("GET")
+	router.HandleFunc("/maps/critical/disconnected/", rs.disconnectedCriticalHandler).Methods("GET")
+	router.HandleFunc("/maps/critical/{from}/{to}/", rs.markCriticalHandler).Methods("PUT")
+	router.HandleFunc("/maps/critical/{from}/{to}/", rs.unmarkCriticalHandler).Methods("DELETE")
+	router.HandleFunc("/maps/coordinates/", rs.getCoordinatesHandler).Methods("GET")
+	router.HandleFunc("/maps/coordinates/{location}/", rs.setCoordinatesHandler).Methods("PUT")
+	router.HandleFunc("/maps/location-tags/", rs.allLocationTagsHandler).Methods("GET")
+	router.HandleFunc("/maps/close/", rs.closuresHandler).Methods("GET")
+	router.HandleFunc("/maps/location-tags/{location}/", rs.locationTagsHandler).Methods("GET")
+	router.HandleFunc("/maps/location-tags/{location}/", rs.setLocationTagsHandler).Methods("PUT")
+	router.HandleFunc("/maps/visit-costs/", rs.visitCostsHandler).Methods("GET")
+	router.HandleFunc("/maps/visit-costs/{location}/", rs.visitCostHandler).Methods("GET")
+	router.HandleFunc("/maps/visit-costs/{location}/", rs.setVisitCostHandler).Methods("PUT")
+	router.HandleFunc("/maps/trash/", rs.getTrashHandler).Methods("GET")
+	router.HandleFunc("/maps/trash/restore/{location}/", rs.restoreLocationHandler).Methods("PUT")
+	router.HandleFunc("/maps/trash/{location}/", rs.purgeLocationHandler).Methods("DELETE")
+	router.HandleFunc("/maps/trash/", rs.emptyTrashHandler).Methods("DELETE")
+	router.HandleFunc("/maps/archive/", rs.getArchivedHandler).Methods("GET")
+	router.HandleFunc("/maps/archive/restore/{location}/", rs.unarchiveLocationHandler).Methods("PUT")
+	router.HandleFunc("/maps/revisions/", rs.revisionsHandler).Methods("GET")
+	router.HandleFunc("/maps/revisions/{revision}/", rs.locationsAtHandler).Methods("GET")
+	router.HandleFunc("/maps/revisions/{revision}/{from}/{to}/", rs.routesAtHandler).Methods("GET")
+
+	router.HandleFunc("/admin/memory/", rs.memoryHandler).Methods("GET")
+	router.HandleFunc("/admin/compact/", rs.compactHandler).Methods("POST")
+	router.HandleFunc("/admin/cache/stats/", rs.cacheStatsHandler).Methods("GET")
+	router.HandleFunc("/admin/cache/", rs.inspectCacheHandler).Methods("GET")
+	router.HandleFunc("/admin/cache/", rs.flushCacheHandler).Methods("DELETE")
+	router.HandleFunc("/admin/hot/", rs.getHotSourcesHandler).Methods("GET")
+	router.HandleFunc("/admin/hot/{location}/", rs.addHotSourceHandler).Methods("PUT")
+	router.HandleFunc("/admin/hot/{location}/", rs.removeHotSourceHandler).Methods("DELETE")
+	router.HandleFunc("/admin/landmarks/", rs.landmarksHandler).Methods("GET")
+	router.HandleFunc("/admin/landmarks/", rs.refreshLandmarksHandler).Methods("POST")
+	router.HandleFunc("/admin/resync/", rs.lastResyncHandler).Methods("GET")
+	router.HandleFunc("/admin/resync/", rs.resyncHandler).Methods("POST")
+	router.HandleFunc("/admin/reload-graph/", rs.reloadGraphHandler).Methods("POST")
+	router.HandleFunc("/admin/eviction/", rs.evictionHandler).Methods("GET")
+	router.HandleFunc("/admin/eviction/", rs.rewriteLostKeysHandler).Methods("POST")
+	router.HandleFunc("/admin/bundle/", rs.exportBundleHandler).Methods("GET")
+	router.HandleFunc("/admin/bundle/", rs.importBundleHandler).Methods("POST")
+	router.HandleFunc("/admin/generate/", rs.generateHandler).Methods("POST")
+	router.HandleFunc("/admin/costs/", rs.costFunctionsHandler).Methods("GET")
+	router.HandleFunc("/admin/costs/{name}/", rs.defineCostFunctionHandler).Methods("PUT")
+	router.HandleFunc("/admin/costs/{name}/", rs.removeCostFunctionHandler).Methods("DELETE")
+	router.HandleFunc("/admin/timezone/", rs.timezoneHandler).Methods("GET")
+	router.HandleFunc("/admin/timezone/", rs.setTimezoneHandler).Methods("PUT")
+	router.HandleFunc("/admin/symmetric/", rs.symmetricHandler).Methods("GET")
+	router.HandleFunc("/admin/symmetric/", rs.setSymmetricHandler).Methods("PUT")
+	router.HandleFunc("/admin/default-weight/", rs.defaultWeightHandler).Methods("GET")
+	router.HandleFunc("/admin/default-weight/", rs.setDefaultWeightHandler).Methods("PUT")
+
+	router.HandleFunc("/maps/", rs.addLocationHandler).Methods("POST")
+	router.HandleFunc("/maps/", rs.getLocationsHandler).Methods("GET")
+	router.HandleFunc("/maps/{location}/", rs.routesFromHandler).Methods("GET")
+	router.HandleFunc("/maps/{location}/within/", rs.withinHandler).Methods("GET")
+	router.HandleFunc("/maps/{location}/incoming/", rs.routesToHandler).Methods("GET")
+	router.HandleFunc("/maps/{from}/{to}/", rs.routesBetweenHandler).Methods("GET")
+	router.HandleFunc("/maps/{from}/{to}/edge/", rs.edgeInfoHandler).Methods("GET")
+	router.HandleFunc("/maps/{from}/{to}/flow/", rs.maxFlowHandler).Methods("GET")
+	router.HandleFunc("/maps/{from}/{to}/connected/", rs.connectedHandler).Methods("GET")
+	router.HandleFunc("/maps/{a}/similar/{b}/", rs.similarityHandler).Methods("GET")
+	router.HandleFunc("/maps/add/{location}/", rs.addRoutesHandler).Methods("PUT")
+	router.HandleFunc("/maps/delete/{location}/", rs.removeRoutesHandler).Methods("PUT")
+	router.HandleFunc("/maps/close/{from}/{to}/", rs.closeEdgeHandler).Methods("PUT")
+	router.HandleFunc("/maps/open/{from}/{to}/", rs.openEdgeHandler).Methods("PUT")
+	router.HandleFunc("/maps/{location}/", rs.deleteLocationHandler).Methods("DELETE")
+	router.HandleFunc("/maps/{location}/impact/", rs.locationImpactHandler).Methods("POST")
+	router.HandleFunc("/maps/{from}/edge/{to}/history/", rs.edgeHistoryHandler).Methods("GET")
+	router.HandleFunc("/maps/{from}/edge/{to}/tags/", rs.edgeTagsHandler).Methods("GET")
+	router.HandleFunc("/maps/{from}/edge/{to}/tags/", rs.setEdgeTagsHandler).Methods("PUT")
+	router.HandleFunc("/maps/{from}/edge/{to}/attributes/", rs.edgeAttributesHandler).Methods("GET")
+	router.HandleFunc("/maps/{from}/edge/{to}/attributes/", rs.setEdgeAttributesHandler).Methods("PUT")
+	router.HandleFunc("/maps/{from}/edge/{to}/modes/", rs.edgeModesHandler).Methods("GET")
+	router.HandleFunc("/maps/{from}/edge/{to}/modes/", rs.setEdgeModesHandler).Methods("PUT")
+	router.HandleFunc("/maps/{from}/edge/{to}/impact/", rs.edgeImpactHandler).Methods("POST")
+}
+
+// POST /maps/ (with JSON name: string, routes_to: map[string]weight|{weight, both}, bidirectional: bool, lat: float, lon: float optional, or a form or query of name, to and weight repeated, bidirectional, lat, lon) : CREATE a location, optionally with routes, both ways if bidirectional or, without it, if the map is symmetric, unless a route's own both says otherwise, and coordinates; a null weight is the distance, with DISTANCE_WEIGHTS, or else the map's default weight
 func (rs *routeServer) addLocationHandler(w http.ResponseWriter, req *http.Request) {
 	log.Printf("Creating a location from %s\n", req.URL.Path)
 
 	type locationRequest struct {
-		Name     string             `json:"name"`
-		RoutesTo map[string]float64 `json:"routes_to"`
+		Name          string                      `json:"name"`
+		RoutesTo      map[string]routes.RouteSpec `json:"routes_to"`
+		Bidirectional *bool                       `json:"bidirectional"`
+		Lat           *float64                    `json:"lat"`
+		Lon           *float64                    `json:"lon"`
 	}
 
-	mediatype, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var lr locationRequest
+	if isForm(req) {
+		form, err := parseForm(req, "name", "to", "weight", "bidirectional", "lat", "lon")
+		if err == nil {
+			lr.Name = form.Get("name")
+			lr.RoutesTo, err = formRoutes(form)
+		}
+		if err == nil {
+			lr.Bidirectional, err = formBool(form, "bidirectional")
+		}
+		if err == nil {
+			lr.Lat, err = formFloat(form, "lat")
+		}
+		if err == nil {
+			lr.Lon, err = formFloat(form, "lon")
+		}
+		if err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if !decodeJSON(w, req, &lr) {
 		return
 	}
-	if mediatype != "application/json" {
-		http.Error(w, "requires application/json Content-Type", http.StatusUnsupportedMediaType)
+
+	var at *routes.Coordinates
+	if (lr.Lat == nil) != (lr.Lon == nil) {
+		httpError(w, req, "lat and lon must be given together", http.StatusBadRequest)
 		return
+	} else if lr.Lat != nil {
+		at = &routes.Coordinates{Lat: *lr.Lat, Lon: *lr.Lon}
 	}
 
-	dec := json.NewDecoder(req.Body)
-	dec.DisallowUnknownFields()
-	var lr locationRequest
-	if err := dec.Decode(&lr); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := rs.store.AddLocation(lr.Name, at, lr.RoutesTo, lr.Bidirectional); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
 		return
 	}
+}
 
-	if err := rs.store.AddLocation(lr.Name, lr.RoutesTo); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+// Accept unknown fields and anything after the JSON document in request bodies, for clients written against
+// older versions; see LENIENT_JSON
+var lenientJSON bool
+
+// newDecoder is a JSON decoder that rejects unknown fields unless lenientJSON is set
+func newDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	if !lenientJSON {
+		dec.DisallowUnknownFields()
+	}
+	return dec
+}
+
+// checkEOF rejects anything but whitespace after the JSON document dec has read, unless lenientJSON is set
+func checkEOF(dec *json.Decoder) error {
+	if lenientJSON {
+		return nil
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after the JSON document")
 	}
+	return nil
+}
+
+// decodeJSON reads a JSON request body into v, writing an error response and returning false if it cannot
+func decodeJSON(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	mediatype, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if mediatype != "application/json" {
+		httpError(w, req, "requires application/json Content-Type", http.StatusUnsupportedMediaType)
+		return false
+	}
+
+	dec := newDecoder(req.Body)
+	if err = dec.Decode(v); err == nil {
+		err = checkEOF(dec)
+	}
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
 }
 
 func renderJSON(w http.ResponseWriter, v interface{}) {
-	js, err := json.Marshal(v)
+	renderJSONStatus(w, http.StatusOK, v)
+}
+
+func renderJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	buf := bufferPool.Get().(*[]byte)
+	js, err := appendJSON((*buf)[:0], v)
+	defer func() {
+		if cap(js) <= maxPooledBuffer {
+			*buf = js
+			bufferPool.Put(buf)
+		}
+	}()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		log.Printf("JSON Marshalling failure: %s", err.Error())
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(js)
 }
 
-// GET  /maps/ : READ a list of all known locations
+// GET  /maps/ (?region=<region>&tag=<tag>&limit=&cursor=&total=true optional) : READ a list of all known locations, or those in <region> and its subregions, or those tagged <tag>, or both
 func (rs *routeServer) getLocationsHandler(w http.ResponseWriter, req *http.Request) {
 	log.Printf("Getting locations at %s\n", req.URL.Path)
 
-	locations := rs.store.GetLocations()
-	renderJSON(w, locations)
+	var locations []string
+	if region := req.URL.Query().Get("region"); region != "" {
+		var err error
+		if locations, err = rs.store.RegionLocations(region); err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if rs.snapshotReads {
+		locations = rs.store.Snapshot().Locations()
+	} else {
+		locations = rs.store.GetLocations()
+	}
+	if tag := req.URL.Query().Get("tag"); tag != "" {
+		tagged, err := rs.store.TaggedLocations(tag)
+		if err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+		isTagged := make(map[string]bool, len(tagged))
+		for _, name := range tagged {
+			isTagged[name] = true
+		}
+		var filtered []string
+		for _, name := range locations {
+			if isTagged[name] {
+				filtered = append(filtered, name)
+			}
+		}
+		locations = filtered
+	}
+	renderList(w, req, locations, func(i int) string { return locations[i] })
 }
 
-// GET  /maps/<location> : READ list of places <location> has direct connections to
+// GET  /maps/<location> (?limit=&cursor=&total=true optional) : READ list of places <location> has direct connections to
 func (rs *routeServer) routesFromHandler(w http.ResponseWriter, req *http.Request) {
 	log.Printf("Getting locations from a location at %s\n", req.URL.Path)
 
 	loc := mux.Vars(req)["location"]
 
-	locations, err := rs.store.RoutesFrom(loc)
+	var locations []string
+	var err error
+	if rs.snapshotReads {
+		locations, err = rs.store.Snapshot().RoutesFrom(loc)
+	} else {
+		locations, err = rs.store.RoutesFrom(loc)
+	}
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderList(w, req, locations, func(i int) string { return locations[i] })
+}
+
+// GET  /maps/<location>/incoming (?limit=&cursor=&total=true optional) : READ list of places with direct connections to <location>
+func (rs *routeServer) routesToHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting locations to a location at %s\n", req.URL.Path)
+
+	loc := mux.Vars(req)["location"]
+
+	var locations []string
+	var err error
+	if rs.snapshotReads {
+		locations, err = rs.store.Snapshot().RoutesTo(loc)
+	} else {
+		locations, err = rs.store.RoutesTo(loc)
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, req, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	renderJSON(w, locations)
+	renderList(w, req, locations, func(i int) string { return locations[i] })
 }
 
-// GET  /maps/<from>/<to> : READ list of shortest routes from <from> to <to>
+// GET  /maps/<location>/within/ (?weight=<budget>) : READ every location the cheapest route from <location> reaches within the budget, with its weight, cheapest first
+func (rs *routeServer) withinHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding locations within a weight at %s\n", req.URL.Path)
+
+	if req.URL.Query().Get("weight") == "" {
+		httpError(w, req, "weight is required", http.StatusBadRequest)
+		return
+	}
+	budget, err := floatParam(req, "weight", 0)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reachable, err := rs.store.Within(mux.Vars(req)["location"], budget)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, reachable)
+}
+
+// GET  /maps/<from>/<to> (?algorithm|algo=dijkstra|astar|bellman-ford|bidirectional, heuristic=<name>, exclude_tags=<tag>,..., require_tags=<tag>,..., exclude_location_tags=<tag>,..., require_location_tags=<tag>,..., within=<region>, modes=<mode>,..., max_hops=<n>, max_weight=<weight>, cost=<name>, metric=<attribute>, profile=distance|duration|cost, depart_at=<time>, disjoint=edges|nodes, stream=ndjson, k=<n> optional; Cache-Control: no-cache|only-if-cached optional) : READ list of shortest routes from <from> to <to>, or the k cheapest, or routes sharing no edges or locations, or by the given modes of transport only, or weighing edges by a profile's attribute where they have one, or passing only through locations with or without the given tags, or staying within <region> and its subregions
 func (rs *routeServer) routesBetweenHandler(w http.ResponseWriter, req *http.Request) {
 	log.Printf("Finding routes at %s\n", req.URL.Path)
 
 	vars := mux.Vars(req)
 	from, to := vars["from"], vars["to"]
 
-	routes, err := rs.store.RoutesBetween(from, to)
+	algorithm := req.URL.Query().Get("algorithm")
+	if algorithm == "" {
+		algorithm = req.URL.Query().Get("algo")
+	}
+	alg, err := routes.ParseAlgorithm(algorithm)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	heuristic, err := routes.ParseHeuristic(req.URL.Query().Get("heuristic"))
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := routes.RouteOptions{Algorithm: alg, Heuristic: heuristic}
+	for name, tags := range map[string]*[]string{"exclude_tags": &opts.ExcludeTags, "require_tags": &opts.RequireTags, "exclude_location_tags": &opts.ExcludeLocationTags, "require_location_tags": &opts.RequireLocationTags} {
+		if *tags, err = routes.ParseTags(req.URL.Query().Get(name)); err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if opts.Modes, err = routes.ParseModes(req.URL.Query().Get("modes")); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.MaxHops, err = intParam(req, "max_hops", 0); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.URL.Query().Get("max_weight") != "" {
+		maxWeight, err := floatParam(req, "max_weight", 0)
+		if err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.MaxWeight = &maxWeight
+	}
+	opts.Within = req.URL.Query().Get("within")
+	opts.Cost = req.URL.Query().Get("cost")
+	if metric := req.URL.Query().Get("metric"); metric != "" {
+		opts.Metric = map[string]float64{metric: 1}
+	}
+	if opts.Profile, err = routes.ParseProfile(req.URL.Query().Get("profile")); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.DepartAt = req.URL.Query().Get("depart_at")
+	if opts.Disjoint, err = routes.ParseDisjointness(req.URL.Query().Get("disjoint")); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.Cache = requestCacheMode(req)
+	if req.URL.Query().Get("k") != "" || wantsNDJSON(req) {
+		for _, name := range []string{"exclude_tags", "require_tags", "exclude_location_tags", "require_location_tags", "within", "modes", "max_hops", "max_weight", "cost", "metric", "profile", "depart_at", "disjoint"} {
+			if req.URL.Query().Get(name) != "" {
+				httpError(w, req, fmt.Sprintf("%s cannot be combined with k or stream", name), http.StatusBadRequest)
+				return
+			}
+		}
+		// Neither is ever cached
+		if opts.Cache == routes.CacheOnly {
+			httpError(w, req, routes.ErrNotCached.Error(), routeErrorStatus(routes.ErrNotCached))
+			return
+		}
+	}
+
+	if req.URL.Query().Get("k") != "" {
+		rs.kShortestRoutes(w, req, from, to, alg)
+		return
+	}
+	if wantsNDJSON(req) {
+		rs.streamRoutes(w, req, from, to, alg)
+		return
+	}
+
+	routes, err := rs.store.RoutesBetween(from, to, opts)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, req, err.Error(), routeErrorStatus(err))
 		return
 	}
 
 	renderJSON(w, routes)
 }
 
-// PUT  /maps/add/<location> (with JSON to: map[string]weight) : UPDATE add the given connections to <location>
+// Larger k is refused, since each further route costs a shortest path search per node of the one before
+const maxK = 100
+
+// kShortestRoutes answers ?k= with Yen's algorithm, which is built on Dijkstra's, so other algorithms are refused
+func (rs *routeServer) kShortestRoutes(w http.ResponseWriter, req *http.Request, from, to string, alg routes.Algorithm) {
+	k, err := intParam(req, "k", 1)
+	if err == nil && (k < 1 || k > maxK) {
+		err = fmt.Errorf("k must be between 1 and %d", maxK)
+	}
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if alg != "" && alg != routes.Dijkstra {
+		httpError(w, req, "only dijkstra can find the k cheapest routes", http.StatusBadRequest)
+		return
+	}
+
+	routes, err := rs.store.KShortestRoutes(from, to, k)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, routes)
+}
+
+// PUT  /maps/add/<location> (with JSON to: map[string]weight|{weight, both}, bidirectional: bool optional, or a form or query of to and weight repeated, bidirectional) : UPDATE add the given connections to <location>, both ways if bidirectional or, without it, if the map is symmetric, unless a route's own both says otherwise; a null weight is the distance, with DISTANCE_WEIGHTS, or else the map's default weight
 func (rs *routeServer) addRoutesHandler(w http.ResponseWriter, req *http.Request) {
 	log.Printf("Adding routes at %s\n", req.URL.Path)
 
 	loc := mux.Vars(req)["location"]
 
-	mediatype, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if isForm(req) {
+		form, err := parseForm(req, "to", "weight", "bidirectional")
+		var to map[string]routes.RouteSpec
+		var bidirectional *bool
+		if err == nil {
+			to, err = formRoutes(form)
+		}
+		if err == nil {
+			bidirectional, err = formBool(form, "bidirectional")
+		}
+		if err == nil {
+			err = rs.store.AddRoutes(loc, to, bidirectional)
+		}
+		if err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+		}
 		return
 	}
-	if mediatype != "application/json" {
-		http.Error(w, "requires application/json Content-Type", http.StatusUnsupportedMediaType)
+
+	// Either {"to": {...}, "bidirectional": true} or, as before, the bare map of weights, which may now route to a
+	// location called to with a {"weight": 3, "both": true} object, so is tried if the first does not fit
+	var body json.RawMessage
+	if !decodeJSON(w, req, &body) {
 		return
 	}
-
-	dec := json.NewDecoder(req.Body)
-	var routes map[string]float64
-	if err := dec.Decode(&routes); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var ar struct {
+		To            map[string]routes.RouteSpec `json:"to"`
+		Bidirectional *bool                       `json:"bidirectional"`
+	}
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(body, &fields)
+	if err == nil {
+		if to, ok := fields["to"]; ok && bytes.HasPrefix(to, []byte("{")) {
+			err = newDecoder(bytes.NewReader(body)).Decode(&ar)
+			if err != nil {
+				var bare map[string]routes.RouteSpec
+				if json.Unmarshal(body, &bare) == nil {
+					ar.To, ar.Bidirectional, err = bare, nil, nil
+				}
+			}
+		} else {
+			err = json.Unmarshal(body, &ar.To)
+		}
+	}
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if rs.store.AddRoutes(loc, routes) != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := rs.store.AddRoutes(loc, ar.To, ar.Bidirectional); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
 		return
 	}
 }
 
-// PUT  /maps/delete/<location> (with JSON from: []string) : UPDATE remove the given connections from <location>
+// PUT  /maps/delete/<location> (with JSON from: []string, or a form or query of to repeated) : UPDATE remove the given connections from <location>
 func (rs *routeServer) removeRoutesHandler(w http.ResponseWriter, req *http.Request) {
 	log.Printf("Deleting routes at %s\n", req.URL.Path)
 
 	loc := mux.Vars(req)["location"]
 
-	mediatype, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	var routes []string
+	if isForm(req) {
+		form, err := parseForm(req, "to")
+		if err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+		routes = form["to"]
+	} else if !decodeJSON(w, req, &routes) {
+		return
+	}
+
+	if err := rs.store.RemoveRoutes(loc, routes); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /maps/<location> (?soft=true optional) : DELETE the given location (and all edges from/to it) (and error if no such location), or with soft archive it, keeping everything Redis has on it until it is restored
+func (rs *routeServer) deleteLocationHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Deleting location at %s\n", req.URL.Path)
+
+	loc := mux.Vars(req)["location"]
+	var soft bool
+	if s := req.URL.Query().Get("soft"); s != "" {
+		var err error
+		if soft, err = strconv.ParseBool(s); err != nil {
+			httpError(w, req, fmt.Sprintf("soft must be true or false, not %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if soft {
+		if err := rs.store.ArchiveLocation(loc); err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	if err := rs.store.DeleteLocation(loc); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /maps/<from>/<to>/connected : READ whether there is any route from <from> to <to>, and the fewest edges on one, without finding routes
+func (rs *routeServer) connectedHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Checking connectivity at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	connectivity, err := rs.store.Connected(vars["from"], vars["to"])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, req, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if mediatype != "application/json" {
-		http.Error(w, "requires application/json Content-Type", http.StatusUnsupportedMediaType)
+
+	renderJSON(w, connectivity)
+}
+
+// GET  /maps/<a>/similar/<b> : READ the neighbours <a> and <b> share, with their Jaccard coefficient and Adamic-Adar index
+func (rs *routeServer) similarityHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Comparing neighbours at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	similarity, err := rs.store.Similarity(vars["a"], vars["b"])
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	dec := json.NewDecoder(req.Body)
-	var routes []string
-	if err := dec.Decode(&routes); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	renderJSON(w, similarity)
+}
+
+// GET  /maps/<from>/<to>/edge : READ the edge from <from> to <to>, open or closed, with its weight, tags, attributes, modes, whether it is closed and since when, and when it was created and last changed, or 404 if there is none
+func (rs *routeServer) edgeInfoHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting edge at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	edge, err := rs.store.EdgeInfo(vars["from"], vars["to"])
+	if errors.Is(err, routes.ErrNoEdge) {
+		httpError(w, req, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if rs.store.RemoveRoutes(loc, routes) != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	renderJSON(w, edge)
+}
+
+// POST /maps/<location>/impact : READ which watched and critical routes would change or break if <location> were deleted, without deleting it
+func (rs *routeServer) locationImpactHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Assessing the impact of deleting a location at %s\n", req.URL.Path)
+
+	impact, err := rs.store.LocationImpact(mux.Vars(req)["location"])
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
 		return
 	}
+
+	renderJSON(w, impact)
 }
 
-// DELETE /maps/<location> : DELETE the given location (and all edges from/to it) (and error if no such location)
-func (rs *routeServer) deleteLocationHandler(w http.ResponseWriter, req *http.Request) {
-	log.Printf("Deleting location at %s\n", req.URL.Path)
+// POST /maps/<from>/edge/<to>/impact : READ which watched and critical routes would change or break if the edge from <from> to <to> were removed, without removing it
+func (rs *routeServer) edgeImpactHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Assessing the impact of removing an edge at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	impact, err := rs.store.EdgeImpact(vars["from"], vars["to"])
+	if errors.Is(err, routes.ErrNoEdge) {
+		httpError(w, req, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, impact)
+}
+
+// GET  /maps/<from>/edge/<to>/history (?limit=&cursor=&total=true optional) : READ the recorded weight changes of the edge from <from> to <to>
+func (rs *routeServer) edgeHistoryHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting edge history at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	from, to := vars["from"], vars["to"]
+
+	history, err := rs.store.EdgeHistory(from, to)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderList(w, req, history, func(i int) string { return history[i].At.UTC().Format(historyCursorFormat) })
+}
+
+// Fixed width, so that history cursors sort the same way as the times in them
+const historyCursorFormat = "2006-01-02T15:04:05.000000000Z"
+
+// POST /maps/route/ (with JSON from: string, to: string, avoid: []string, algorithm: string, metric: map[string]number, profile: string, depart_at: string, disjoint: edges|nodes, modes: []string optional) : READ shortest routes from <from> to <to> that use none of the avoided locations, or edges given as <from>/<to>, weighing edges by the metric's attributes or the profile's if given
+func (rs *routeServer) routeAvoidingHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding routes at %s\n", req.URL.Path)
+
+	var rr struct {
+		From      string             `json:"from"`
+		To        string             `json:"to"`
+		Avoid     []string           `json:"avoid"`
+		Algorithm string             `json:"algorithm"`
+		Metric    map[string]float64 `json:"metric"`
+		Profile   string             `json:"profile"`
+		DepartAt  string             `json:"depart_at"`
+		Disjoint  string             `json:"disjoint"`
+		Modes     []string           `json:"modes"`
+	}
+	if !decodeJSON(w, req, &rr) {
+		return
+	}
+
+	alg, err := routes.ParseAlgorithm(rr.Algorithm)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	disjoint, err := routes.ParseDisjointness(rr.Disjoint)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	routes, err := rs.store.RoutesBetween(rr.From, rr.To, routes.RouteOptions{Algorithm: alg, Avoid: rr.Avoid, Metric: rr.Metric, Profile: rr.Profile, DepartAt: rr.DepartAt, Disjoint: disjoint, Modes: rr.Modes, Cache: requestCacheMode(req)})
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, routes)
+}
+
+// POST /maps/routes/validate/ (with JSON token: string) : READ whether a previously returned route is still valid and optimal
+func (rs *routeServer) validateRouteHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Validating a route at %s\n", req.URL.Path)
+
+	var vr struct {
+		Token string `json:"token"`
+	}
+	if !decodeJSON(w, req, &vr) {
+		return
+	}
+
+	validation, err := rs.store.ValidateRoute(vr.Token)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, validation)
+}
+
+// POST /maps/rename-batch/ (with JSON map[old]new) : UPDATE rename many locations at once, rewriting every edge
+func (rs *routeServer) renameLocationsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Renaming locations at %s\n", req.URL.Path)
+
+	var mapping map[string]string
+	if !decodeJSON(w, req, &mapping) {
+		return
+	}
+
+	if err := rs.store.RenameLocations(mapping); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /maps/watched/ : READ every watched pair's best route and whether it changed since the last check
+func (rs *routeServer) getWatchedHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting watched routes at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.GetWatched())
+}
+
+// POST /maps/watched/check/ : UPDATE as GET /maps/watched/, then clear the changed flags
+func (rs *routeServer) checkWatchedHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Checking watched routes at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.CheckWatched())
+}
+
+// PUT  /maps/watched/<from>/<to> : UPDATE start watching the best route from <from> to <to>
+func (rs *routeServer) watchHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Watching a route at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	pair := routes.Pair{From: vars["from"], To: vars["to"]}
+
+	if err := rs.store.Watch(pair); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /maps/watched/<from>/<to> : DELETE stop watching the route from <from> to <to>
+func (rs *routeServer) unwatchHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Unwatching a route at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	pair := routes.Pair{From: vars["from"], To: vars["to"]}
+
+	if err := rs.store.Unwatch(pair); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /maps/critical/ : READ every critical pair and whether it has a route
+func (rs *routeServer) getCriticalHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting critical pairs at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.GetCritical())
+}
+
+// GET  /maps/critical/disconnected/ : READ the critical pairs that have no route, and since when
+func (rs *routeServer) disconnectedCriticalHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting disconnected critical pairs at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.DisconnectedCritical())
+}
+
+// PUT  /maps/critical/<from>/<to> : UPDATE mark the pair from <from> to <to> as critical, so losing every route between them is reported
+func (rs *routeServer) markCriticalHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Marking a critical pair at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	pair := routes.Pair{From: vars["from"], To: vars["to"]}
+
+	if err := rs.store.MarkCritical(pair); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /maps/critical/<from>/<to> : DELETE stop treating the pair from <from> to <to> as critical
+func (rs *routeServer) unmarkCriticalHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Unmarking a critical pair at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	pair := routes.Pair{From: vars["from"], To: vars["to"]}
+
+	if err := rs.store.UnmarkCritical(pair); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /maps/coordinates/ : READ the coordinates of every location that has them
+func (rs *routeServer) getCoordinatesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting coordinates at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.GetCoordinates())
+}
+
+// PUT  /maps/coordinates/<location> (with JSON lat: float, lon: float) : UPDATE set the coordinates of <location>
+func (rs *routeServer) setCoordinatesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting coordinates at %s\n", req.URL.Path)
 
 	loc := mux.Vars(req)["location"]
 
-	if err := rs.store.DeleteLocation(loc); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var c routes.Coordinates
+	if !decodeJSON(w, req, &c) {
+		return
+	}
+
+	if err := rs.store.SetCoordinates(loc, c); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /maps/trash/ : READ a list of deleted locations that can still be restored
+func (rs *routeServer) getTrashHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting trash at %s\n", req.URL.Path)
+
+	trash, err := rs.store.GetTrash()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(w, trash)
+}
+
+// PUT  /maps/trash/restore/<location> : UPDATE restore a deleted location and its coordinates, with its edges to and from locations that still exist, one-way and without tags, attributes or modes
+func (rs *routeServer) restoreLocationHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Restoring location at %s\n", req.URL.Path)
+
+	loc := mux.Vars(req)["location"]
+
+	if err := rs.store.RestoreLocation(loc); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /maps/trash/<location> : DELETE a deleted location permanently
+func (rs *routeServer) purgeLocationHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Purging location at %s\n", req.URL.Path)
+
+	loc := mux.Vars(req)["location"]
+
+	if err := rs.store.PurgeLocation(loc); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /maps/trash/ : DELETE every deleted location permanently
+func (rs *routeServer) emptyTrashHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Emptying trash at %s\n", req.URL.Path)
+
+	if err := rs.store.EmptyTrash(); err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// GET  /maps/archive/ : READ the archived locations, in name order
+func (rs *routeServer) getArchivedHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting archived locations at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.GetArchived())
+}
+
+// PUT  /maps/archive/restore/<location> : UPDATE bring an archived location back into routing with everything kept for it
+func (rs *routeServer) unarchiveLocationHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Unarchiving location at %s\n", req.URL.Path)
+
+	loc := mux.Vars(req)["location"]
+
+	if err := rs.store.UnarchiveLocation(loc); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
 		return
 	}
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// listParam reads an optional comma separated query parameter
+func listParam(req *http.Request, name string) []string {
+	s := req.URL.Query().Get(name)
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// GET  /maps/matrix/ (?origins=<name>,...&destinations=<name>,... optional) : READ the shortest route weights between every pair of locations, up to 500 origins and 250,000 pairs
+func (rs *routeServer) distanceMatrixHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Building a distance matrix at %s\n", req.URL.Path)
+
+	rs.renderDistanceMatrix(w, req, listParam(req, "origins"), listParam(req, "destinations"))
+}
+
+// POST /maps/matrix/ (with JSON origins: []string, destinations: []string optional) : READ as GET /maps/matrix/, for long lists
+func (rs *routeServer) postDistanceMatrixHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Building a distance matrix at %s\n", req.URL.Path)
+
+	type matrixRequest struct {
+		Origins      []string `json:"origins"`
+		Destinations []string `json:"destinations"`
+	}
+	var mr matrixRequest
+	if !decodeJSON(w, req, &mr) {
+		return
+	}
+
+	rs.renderDistanceMatrix(w, req, mr.Origins, mr.Destinations)
+}
+
+func (rs *routeServer) renderDistanceMatrix(w http.ResponseWriter, req *http.Request, origins, destinations []string) {
+	matrix, err := rs.store.DistanceMatrix(origins, destinations)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, matrix)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Translations of error messages, by language and then by the English format that produces them.
+// Translations refer to the English arguments by position (%[1]s, %[2]s, ...), already formatted.
+// Messages with no translation are sent in English.
+var messageCatalog = map[string]map[string]string{
+	"de": {
+		"%s does not exist":                                      "%[1]s existiert nicht",
+		"%s already exists":                                      "%[1]s existiert bereits",
+		"%s cannot have an edge to itself":                       "%[1]s kann keine Kante zu sich selbst haben",
+		"there is no edge from %s to %s":                         "es gibt keine Kante von %[1]s nach %[2]s",
+		"%s is not in the trash":                                 "%[1]s ist nicht im Papierkorb",
+		"%s is not watched":                                      "%[1]s wird nicht beobachtet",
+		"%s is already watched":                                  "%[1]s wird bereits beobachtet",
+		"%s is not critical":                                     "%[1]s ist nicht als kritisch markiert",
+		"%s is already critical":                                 "%[1]s ist bereits als kritisch markiert",
+		"%s is not a hot source":                                 "%[1]s ist keine Hot-Source",
+		"%s is already a hot source":                             "%[1]s ist bereits eine Hot-Source",
+		"%s must be an integer, not %q":                          "%[1]s muss eine ganze Zahl sein, nicht %[2]s",
+		"%s must be a number, not %q":                            "%[1]s muss eine Zahl sein, nicht %[2]s",
+		"requires application/json Content-Type":                 "erfordert den Content-Type application/json",
+		"location names cannot be empty":                         "Ortsnamen dürfen nicht leer sein",
+		"location name %q cannot contain '/'":                    "der Ortsname %[1]s darf kein '/' enthalten",
+		"latitude %g is not between -90 and 90":                  "der Breitengrad %[1]s liegt nicht zwischen -90 und 90",
+		"longitude %g is not between -180 and 180":               "der Längengrad %[1]s liegt nicht zwischen -180 und 180",
+		"negative cycle detected":                                "negativer Zyklus gefunden",
+		"malformed route token":                                  "ungültiges Routen-Token",
+		"malformed cursor":                                       "ungültiger Cursor",
+		"total must be true or false, not %q":                    "total muss true oder false sein, nicht %[1]s",
+		"limit must be between 1 and %d":                         "limit muss zwischen 1 und %[1]s liegen",
+		"import conflicts with existing edges":                   "der Import widerspricht vorhandenen Kanten",
+		"unknown algorithm %q, expected one of %s, %s, %s or %s": "unbekannter Algorithmus %[1]s, erwartet wird %[2]s, %[3]s, %[4]s oder %[5]s",
+	},
+	"fr": {
+		"%s does not exist":                                      "%[1]s n'existe pas",
+		"%s already exists":                                      "%[1]s existe déjà",
+		"%s cannot have an edge to itself":                       "%[1]s ne peut pas avoir d'arête vers lui-même",
+		"there is no edge from %s to %s":                         "il n'y a pas d'arête de %[1]s à %[2]s",
+		"%s is not in the trash":                                 "%[1]s n'est pas dans la corbeille",
+		"%s is not watched":                                      "%[1]s n'est pas surveillé",
+		"%s is already watched":                                  "%[1]s est déjà surveillé",
+		"%s is not critical":                                     "%[1]s n'est pas marqué comme critique",
+		"%s is already critical":                                 "%[1]s est déjà marqué comme critique",
+		"%s is not a hot source":                                 "%[1]s n'est pas une source chaude",
+		"%s is already a hot source":                             "%[1]s est déjà une source chaude",
+		"%s must be an integer, not %q":                          "%[1]s doit être un entier, pas %[2]s",
+		"%s must be a number, not %q":                            "%[1]s doit être un nombre, pas %[2]s",
+		"requires application/json Content-Type":                 "nécessite le Content-Type application/json",
+		"location names cannot be empty":                         "les noms de lieux ne peuvent pas être vides",
+		"location name %q cannot contain '/'":                    "le nom de lieu %[1]s ne peut pas contenir '/'",
+		"latitude %g is not between -90 and 90":                  "la latitude %[1]s n'est pas comprise entre -90 et 90",
+		"longitude %g is not between -180 and 180":               "la longitude %[1]s n'est pas comprise entre -180 et 180",
+		"negative cycle detected":                                "cycle négatif détecté",
+		"malformed route token":                                  "jeton d'itinéraire invalide",
+		"malformed cursor":                                       "curseur invalide",
+		"total must be true or false, not %q":                    "total doit être true ou false, pas %[1]s",
+		"limit must be between 1 and %d":                         "limit doit être compris entre 1 et %[1]s",
+		"import conflicts with existing edges":                   "l'import est en conflit avec des arêtes existantes",
+		"unknown algorithm %q, expected one of %s, %s, %s or %s": "algorithme inconnu %[1]s, attendu : %[2]s, %[3]s, %[4]s ou %[5]s",
+	},
+	"es": {
+		"%s does not exist":                                      "%[1]s no existe",
+		"%s already exists":                                      "%[1]s ya existe",
+		"%s cannot have an edge to itself":                       "%[1]s no puede tener una arista hacia sí mismo",
+		"there is no edge from %s to %s":                         "no hay ninguna arista de %[1]s a %[2]s",
+		"%s is not in the trash":                                 "%[1]s no está en la papelera",
+		"%s is not watched":                                      "%[1]s no está vigilado",
+		"%s is already watched":                                  "%[1]s ya está vigilado",
+		"%s is not critical":                                     "%[1]s no está marcado como crítico",
+		"%s is already critical":                                 "%[1]s ya está marcado como crítico",
+		"%s is not a hot source":                                 "%[1]s no es un origen caliente",
+		"%s is already a hot source":                             "%[1]s ya es un origen caliente",
+		"%s must be an integer, not %q":                          "%[1]s debe ser un entero, no %[2]s",
+		"%s must be a number, not %q":                            "%[1]s debe ser un número, no %[2]s",
+		"requires application/json Content-Type":                 "requiere el Content-Type application/json",
+		"location names cannot be empty":                         "los nombres de lugares no pueden estar vacíos",
+		"location name %q cannot contain '/'":                    "el nombre de lugar %[1]s no puede contener '/'",
+		"latitude %g is not between -90 and 90":                  "la latitud %[1]s no está entre -90 y 90",
+		"longitude %g is not between -180 and 180":               "la longitud %[1]s no está entre -180 y 180",
+		"negative cycle detected":                                "se detectó un ciclo negativo",
+		"malformed route token":                                  "token de ruta mal formado",
+		"malformed cursor":                                       "cursor mal formado",
+		"total must be true or false, not %q":                    "total debe ser true o false, no %[1]s",
+		"limit must be between 1 and %d":                         "limit debe estar entre 1 y %[1]s",
+		"import conflicts with existing edges":                   "la importación entra en conflicto con aristas existentes",
+		"unknown algorithm %q, expected one of %s, %s, %s or %s": "algoritmo desconocido %[1]s, se esperaba %[2]s, %[3]s, %[4]s o %[5]s",
+	},
+}
+
+// An English format and the pattern matching the messages it produces
+type messageFormat struct {
+	format  string
+	pattern *regexp.Regexp
+}
+
+var verb = regexp.MustCompile(`%[sqdgv]`)
+
+// Every English format in the catalog or with an error code, longest first so that the most specific one matches
+var messageFormats = func() []messageFormat {
+	seen := make(map[string]bool)
+	var ret []messageFormat
+	add := func(format string) {
+		if seen[format] {
+			return
+		}
+		seen[format] = true
+		parts := verb.Split(format, -1)
+		for i := range parts {
+			parts[i] = regexp.QuoteMeta(parts[i])
+		}
+		ret = append(ret, messageFormat{format, regexp.MustCompile("^" + strings.Join(parts, "(.+?)") + "$")})
+	}
+	for _, translations := range messageCatalog {
+		for format := range translations {
+			add(format)
+		}
+	}
+	for format := range errorCodes {
+		add(format)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if len(ret[i].format) != len(ret[j].format) {
+			return len(ret[i].format) > len(ret[j].format)
+		}
+		return ret[i].format < ret[j].format
+	})
+	return ret
+}()
+
+// matchFormat finds the English format msg was produced by, and the arguments formatted into it
+func matchFormat(msg string) (string, []interface{}) {
+	for _, mf := range messageFormats {
+		if match := mf.pattern.FindStringSubmatch(msg); match != nil {
+			args := make([]interface{}, len(match)-1)
+			for i, arg := range match[1:] {
+				args[i] = arg
+			}
+			return mf.format, args
+		}
+	}
+	return "", nil
+}
+
+// acceptedLanguages reads an Accept-Language header into primary language tags, most preferred first
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.SplitN(fields[0], "-", 2)[0])
+		if lang == "" || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if s := strings.TrimPrefix(strings.TrimSpace(param), "q="); s != param {
+				if parsed, err := strconv.ParseFloat(s, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, weighted{lang, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	ret := make([]string, len(langs))
+	for i, l := range langs {
+		ret[i] = l.lang
+	}
+	return ret
+}
+
+// localize translates msg into the most preferred language the catalog has it in, returning it and that language
+func localize(msg, acceptLanguage string) (string, string) {
+	format, args := matchFormat(msg)
+	if format == "" {
+		return msg, "en"
+	}
+	for _, lang := range acceptedLanguages(acceptLanguage) {
+		if lang == "en" {
+			break
+		}
+		if translated, ok := messageCatalog[lang][format]; ok {
+			return fmt.Sprintf(translated, args...), lang
+		}
+	}
+	return msg, "en"
+}
+
+// httpError is http.Error with msg translated for the request's Accept-Language, falling back to English.
+// The error's code is sent as X-Error-Code, and if the client asks for JSON the body is an errorBody.
+func httpError(w http.ResponseWriter, req *http.Request, msg string, status int) {
+	code := errorCode(msg, status)
+	failures.record(failureOf(req, code), time.Now())
+	msg, lang := localize(msg, req.Header.Get("Accept-Language"))
+	w.Header().Set("Content-Language", lang)
+	w.Header().Set("X-Error-Code", code)
+
+	if acceptsJSON(req) {
+		renderJSONStatus(w, status, errorBody{Code: code, Message: msg})
+		return
+	}
+	http.Error(w, msg, status)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Writes metrics in the Prometheus text exposition format, without pulling in a client library
+type metricsWriter struct {
+	w http.ResponseWriter
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (mw metricsWriter) header(name, kind, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labels alternate between names and values
+func (mw metricsWriter) sample(name string, value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	if len(pairs) > 0 {
+		name += "{" + strings.Join(pairs, ",") + "}"
+	}
+
+	switch {
+	case math.IsInf(value, 1):
+		fmt.Fprintf(mw.w, "%s +Inf\n", name)
+	case math.IsInf(value, -1):
+		fmt.Fprintf(mw.w, "%s -Inf\n", name)
+	default:
+		fmt.Fprintf(mw.w, "%s %g\n", name, value)
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// GET  /metrics : READ server metrics for Prometheus
+func (rs *routeServer) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Reporting metrics at %s\n", req.URL.Path)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	mw := metricsWriter{w}
+
+	cache := rs.store.CacheStats()
+	mw.header("rest_project_route_cache_hits_total", "counter", "Route queries answered from the cache.")
+	mw.sample("rest_project_route_cache_hits_total", float64(cache.Hits))
+	mw.header("rest_project_route_cache_misses_total", "counter", "Route queries that had to be computed.")
+	mw.sample("rest_project_route_cache_misses_total", float64(cache.Misses))
+	mw.header("rest_project_route_cache_evictions_total", "counter", "Cached routes dropped to make room.")
+	mw.sample("rest_project_route_cache_evictions_total", float64(cache.Evictions))
+	mw.header("rest_project_route_cache_bytes", "gauge", "Approximate size of the route cache.")
+	mw.sample("rest_project_route_cache_bytes", float64(cache.Bytes))
+
+	locks := rs.store.LockStats()
+	mw.header("rest_project_store_lock_acquisitions_total", "counter", "Times each kind of operation took the store lock.")
+	for _, stats := range locks {
+		mw.sample("rest_project_store_lock_acquisitions_total", float64(stats.Acquisitions), "operation", stats.Operation)
+	}
+	mw.header("rest_project_store_lock_wait_seconds_total", "counter", "Time each kind of operation spent waiting for the store lock.")
+	for _, stats := range locks {
+		mw.sample("rest_project_store_lock_wait_seconds_total", stats.WaitSeconds, "operation", stats.Operation)
+	}
+	mw.header("rest_project_store_lock_hold_seconds_total", "counter", "Time each kind of operation spent holding the store lock.")
+	for _, stats := range locks {
+		mw.sample("rest_project_store_lock_hold_seconds_total", stats.HoldSeconds, "operation", stats.Operation)
+	}
+	mw.header("rest_project_store_lock_max_wait_seconds", "gauge", "Longest wait for the store lock by each kind of operation.")
+	for _, stats := range locks {
+		mw.sample("rest_project_store_lock_max_wait_seconds", stats.MaxWait, "operation", stats.Operation)
+	}
+	mw.header("rest_project_store_lock_max_hold_seconds", "gauge", "Longest hold of the store lock by each kind of operation.")
+	for _, stats := range locks {
+		mw.sample("rest_project_store_lock_max_hold_seconds", stats.MaxHold, "operation", stats.Operation)
+	}
+
+	watched := rs.store.GetWatched()
+	mw.header("rest_project_watched_route_weight", "gauge", "Weight of the best route for each watched pair, +Inf when there is none.")
+	for _, route := range watched {
+		weight := math.Inf(1)
+		if route.Weight != nil {
+			weight = *route.Weight
+		}
+		mw.sample("rest_project_watched_route_weight", weight, "from", route.From, "to", route.To)
+	}
+	mw.header("rest_project_watched_route_changed", "gauge", "1 if the watched route's weight changed since it was last checked.")
+	for _, route := range watched {
+		mw.sample("rest_project_watched_route_changed", boolValue(route.Changed), "from", route.From, "to", route.To)
+	}
+
+	critical := rs.store.GetCritical()
+	disconnected := 0
+	mw.header("rest_project_critical_pair_disconnected", "gauge", "1 if the critical pair has no route.")
+	for _, pair := range critical {
+		if !pair.Connected {
+			disconnected++
+		}
+		mw.sample("rest_project_critical_pair_disconnected", boolValue(!pair.Connected), "from", pair.From, "to", pair.To)
+	}
+	mw.header("rest_project_critical_pairs_disconnected", "gauge", "How many critical pairs have no route.")
+	mw.sample("rest_project_critical_pairs_disconnected", float64(disconnected))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// GET  /maps/<from>/edge/<to>/modes : READ the weight of each mode of transport of the edge from <from> to <to>
+func (rs *routeServer) edgeModesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting edge modes at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	modes, err := rs.store.EdgeModes(vars["from"], vars["to"])
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, modes)
+}
+
+// PUT  /maps/<from>/edge/<to>/modes (with JSON map[string]weight) : UPDATE replace the modes of the edge from <from> to <to>
+func (rs *routeServer) setEdgeModesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting edge modes at %s\n", req.URL.Path)
+
+	var modes map[string]float64
+	if !decodeJSON(w, req, &modes) {
+		return
+	}
+
+	vars := mux.Vars(req)
+	if err := rs.store.SetEdgeModes(vars["from"], vars["to"], modes); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configureStore applies the settings given by environment variables to a map's store: the default map's at startup,
+// and each named map's when it is first used
+func configureStore(store *routes.RouteStore) error {
+	// TRASH_RETENTION is how long deleted locations can be restored; they are purged within the hour after
+	if envVar := os.Getenv("TRASH_RETENTION"); envVar != "" {
+		retention, err := time.ParseDuration(envVar)
+		if err != nil {
+			return err
+		}
+		store.SetTrashRetention(retention)
+	}
+
+	if envVar := os.Getenv("DEFAULT_ALGORITHM"); envVar != "" {
+		alg, err := routes.ParseAlgorithm(envVar)
+		if err != nil {
+			return err
+		}
+		if err := store.SetDefaultAlgorithm(alg); err != nil {
+			return err
+		}
+	}
+
+	// INTEGER_WEIGHTS=true requires every weight to be a whole number, so route totals are exact
+	if envVar := os.Getenv("INTEGER_WEIGHTS"); envVar != "" {
+		on, err := strconv.ParseBool(envVar)
+		if err != nil {
+			return err
+		}
+		if err := store.SetIntegerWeights(on); err != nil {
+			return err
+		}
+	}
+
+	// DISTANCE_WEIGHTS=true weighs edges added without a weight by the great-circle distance between their ends, in kilometres
+	if envVar := os.Getenv("DISTANCE_WEIGHTS"); envVar != "" {
+		on, err := strconv.ParseBool(envVar)
+		if err != nil {
+			return err
+		}
+		store.SetDistanceWeights(on)
+	}
+
+	// WEIGHT_PRECISION rounds weights and route totals to that many decimal places
+	if envVar := os.Getenv("WEIGHT_PRECISION"); envVar != "" {
+		digits, err := strconv.Atoi(envVar)
+		if err != nil {
+			return err
+		}
+		if err := store.SetWeightPrecision(digits); err != nil {
+			return err
+		}
+	}
+
+	// COST_FUNCTIONS defines cost functions, as <name>=<expression>;..., e.g. fastest=time;cheap=0.7*time+0.3*toll_cost
+	if envVar := os.Getenv("COST_FUNCTIONS"); envVar != "" {
+		for _, definition := range strings.Split(envVar, ";") {
+			parts := strings.SplitN(definition, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("bad cost function %q, expected <name>=<expression>", definition)
+			}
+			if err := store.DefineCostFunction(strings.TrimSpace(parts[0]), parts[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	// ASTAR_HEURISTIC names the default A* heuristic, HEURISTIC_SCALE converts its units into edge weights
+	if envVar := os.Getenv("ASTAR_HEURISTIC"); envVar != "" {
+		scale := 1.0
+		if scaleVar := os.Getenv("HEURISTIC_SCALE"); scaleVar != "" {
+			var err error
+			if scale, err = strconv.ParseFloat(scaleVar, 64); err != nil {
+				return err
+			}
+		}
+		if err := store.SetDefaultHeuristic(envVar, scale); err != nil {
+			return err
+		}
+	}
+
+	// LOCK_LOG_THRESHOLD logs every wait for or hold of the store lock at least this long
+	if envVar := os.Getenv("LOCK_LOG_THRESHOLD"); envVar != "" {
+		threshold, err := time.ParseDuration(envVar)
+		if err != nil {
+			return err
+		}
+		store.SetLockLogThreshold(threshold)
+	}
+
+	// REDIS_POOL_SIZE gives adding and removing routes that many Redis connections of their own, so that changes to
+	// different locations are written at once
+	if envVar := os.Getenv("REDIS_POOL_SIZE"); envVar != "" {
+		size, err := strconv.Atoi(envVar)
+		if err != nil {
+			return err
+		}
+		if err := store.SetRedisPool(size, dialConn); err != nil {
+			return err
+		}
+	}
+
+	if envVar := os.Getenv("EDGE_HISTORY_LENGTH"); envVar != "" {
+		length, err := strconv.Atoi(envVar)
+		if err != nil {
+			return err
+		}
+		store.SetHistoryLength(length)
+	}
+
+	// SNAPSHOT_HISTORY keeps the graph as it was after each of this many changes, to query as of a revision
+	if envVar := os.Getenv("SNAPSHOT_HISTORY"); envVar != "" {
+		length, err := strconv.Atoi(envVar)
+		if err != nil {
+			return err
+		}
+		store.SetSnapshotHistory(length)
+	}
+
+	return nil
+}
+
+// newRouter makes a router answering as every router of this server does, with the trailing slash optional
+func newRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+	router.NotFoundHandler = http.HandlerFunc(notFoundHandler)
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	router.Use(usageMiddleware)
+	return router
+}
+
+// splitMapPath splits a path under /namespaces/<map>/ into the map's name and the path within the map, as the
+// default map would be asked it; any other path is the default map's, with no name
+func splitMapPath(path string) (string, string) {
+	rest := strings.TrimPrefix(path, "/namespaces/")
+	if rest == path {
+		return "", path
+	}
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) < 2 {
+		return parts[0], "/"
+	}
+	return parts[0], "/" + parts[1]
+}
+
+// The named maps, each served under /namespaces/<map>/ by a router of its own with the default map's endpoints.
+// Settings from the environment apply to every map, but background work such as landmark refreshes, resyncs and
+// following Redis is done for the default map only, and a replica copies only the default map.
+type namespaces struct {
+	registry      *routes.Registry
+	snapshotReads bool
+
+	sync.Mutex
+	servers map[string]*namespace
+}
+
+// A named map's router, and the store it was made for, which a map deleted and created again replaces
+type namespace struct {
+	store  *routes.RouteStore
+	router *mux.Router
+}
+
+func (ns *namespaces) router(name string) (*mux.Router, error) {
+	store, err := ns.registry.Get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ns.Lock()
+	defer ns.Unlock()
+	if server, ok := ns.servers[name]; ok && server.store == store {
+		return server.router, nil
+	}
+	router := newRouter()
+	(&routeServer{store: store, snapshotReads: ns.snapshotReads}).routes(router)
+	ns.servers[name] = &namespace{store: store, router: router}
+	return router, nil
+}
+
+// serve passes a request under /namespaces/<map>/ to the map's router, with the path the default map would be asked
+func (ns *namespaces) serve(w http.ResponseWriter, req *http.Request) {
+	name, path := splitMapPath(req.URL.Path)
+	router, err := ns.router(name)
+	if errors.Is(err, routes.ErrNoMap) {
+		httpError(w, req, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The map's router would redirect to the path without /namespaces/<map>, so the slash is added here instead
+	if !strings.HasSuffix(path, "/") {
+		url := *req.URL
+		url.Path += "/"
+		http.Redirect(w, req, url.String(), http.StatusMovedPermanently)
+		return
+	}
+	inner := req.Clone(req.Context())
+	inner.URL.Path, inner.URL.RawPath = path, ""
+	router.ServeHTTP(w, inner)
+}
+
+// GET  /namespaces/ : READ the names of every named map, in name order
+func (ns *namespaces) listHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Listing maps at %s\n", req.URL.Path)
+
+	names, err := ns.registry.Maps()
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderJSON(w, names)
+}
+
+// PUT  /namespaces/<map> : CREATE an empty named map, with its own graph and Redis keys, served under /namespaces/<map>/
+func (ns *namespaces) createHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Creating a map at %s\n", req.URL.Path)
+
+	if err := ns.registry.Create(mux.Vars(req)["map"]); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /namespaces/<map> : DELETE a named map, with everything in it
+func (ns *namespaces) deleteHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Deleting a map at %s\n", req.URL.Path)
+
+	name := mux.Vars(req)["map"]
+	if err := ns.registry.Delete(name); errors.Is(err, routes.ErrNoMap) {
+		httpError(w, req, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		httpError(w, req, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ns.Lock()
+	defer ns.Unlock()
+	delete(ns.servers, name)
+}
+
+// GET  /admin/redis-memory/ (?samples=100 optional) : READ approximately how much Redis memory each map takes, the default map as the empty name, sampling up to <samples> keys of each with MEMORY USAGE, and each map's share of Redis's maxmemory
+func (ns *namespaces) redisMemoryHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Estimating Redis memory at %s\n", req.URL.Path)
+
+	samples, err := intParam(req, "samples", 100)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := ns.registry.RedisMemory(samples)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	renderJSON(w, report)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// GET  /maps/nearest/ (?origins=<name>,...&destinations=<name>,...) : READ the shortest routes from any origin to any destination
+func (rs *routeServer) nearestRoutesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding nearest routes at %s\n", req.URL.Path)
+
+	rs.renderNearestRoutes(w, req, listParam(req, "origins"), listParam(req, "destinations"))
+}
+
+// POST /maps/nearest/ (with JSON origins: []string, destinations: []string) : READ as GET /maps/nearest/, for long lists
+func (rs *routeServer) postNearestRoutesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding nearest routes at %s\n", req.URL.Path)
+
+	type nearestRequest struct {
+		Origins      []string `json:"origins"`
+		Destinations []string `json:"destinations"`
+	}
+	var nr nearestRequest
+	if !decodeJSON(w, req, &nr) {
+		return
+	}
+
+	rs.renderNearestRoutes(w, req, nr.Origins, nr.Destinations)
+}
+
+func (rs *routeServer) renderNearestRoutes(w http.ResponseWriter, req *http.Request, origins, destinations []string) {
+	routes, err := rs.store.NearestRoutes(origins, destinations)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, routes)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+)
+
+// GET  /maps/offline/ : READ every location's edges with a version of each, to edit offline and submit later
+func (rs *routeServer) offlineBundleHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Exporting for offline editing at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.OfflineBundle())
+}
+
+// POST /maps/offline/ (with JSON base: bundle, edited: map[location]map[string]weight) : UPDATE apply offline edits by three-way merge, reporting conflicts
+func (rs *routeServer) mergeOfflineHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Merging offline edits at %s\n", req.URL.Path)
+
+	var changes routes.OfflineChanges
+	if !decodeJSON(w, req, &changes) {
+		return
+	}
+
+	report, err := rs.store.MergeOffline(changes)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, report)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+)
+
+// POST /maps/optimize/ (?format=json|ics optional; with JSON locations: []string, start: string, open: bool, departure: number, windows: map[location]{earliest, latest}, depart_at: string optional) : READ a short tour from start, or the first location, through every location and back, or not if open, with a schedule that keeps to the windows, or as calendar events given depart_at
+func (rs *routeServer) optimizeTourHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Optimizing a tour at %s\n", req.URL.Path)
+
+	format := req.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "ics" {
+		httpError(w, req, fmt.Sprintf("unknown format %q, expected json or ics", format), http.StatusBadRequest)
+		return
+	}
+	var tr routes.TourRequest
+	if !decodeJSON(w, req, &tr) {
+		return
+	}
+	if format == "ics" && tr.DepartAt == "" {
+		httpError(w, req, "format=ics needs depart_at, to put the stops in a calendar", http.StatusBadRequest)
+		return
+	}
+
+	tour, err := rs.store.OptimizeTour(tr)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	if format == "ics" {
+		renderICS(w, tour)
+		return
+	}
+	renderJSON(w, tour)
+}
+
+// POST /maps/optimize/vrp/ (with JSON depot: string, vehicles: int, capacity: number, stops: [{location, demand}], pairs: [{pickup, delivery}] optional) : READ tours for the vehicles from the depot through every stop and back, within their capacity, each pair's pickup before its delivery on the same tour
+func (rs *routeServer) solveVRPHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Solving a vehicle routing problem at %s\n", req.URL.Path)
+
+	var problem routes.VRPProblem
+	if !decodeJSON(w, req, &problem) {
+		return
+	}
+
+	solution, err := rs.store.SolveVRP(problem)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, solution)
+}
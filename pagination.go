@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Pages hold this many items unless ?limit= asks for fewer (or more, up to maxPageLimit)
+const defaultPageLimit = 100
+const maxPageLimit = 1000
+
+// What a cursor carries; clients should treat the encoded form as opaque
+type pageCursor struct {
+	// The key of the last item on the previous page
+	After string `json:"after"`
+}
+
+// One page of a list endpoint's results
+type page struct {
+	Items interface{} `json:"items"`
+	// Pass as ?cursor= to get the next page; absent on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	// How many items there are across every page, with ?total=true
+	Total *int `json:"total,omitempty"`
+}
+
+// renderList writes items, a slice sorted so that key(i) increases with i.
+// Without ?limit= or ?cursor= the whole slice is written as before, otherwise one page of it;
+// since cursors hold keys rather than positions, items added or removed between requests
+// do not shift later pages.
+func renderList(w http.ResponseWriter, req *http.Request, items interface{}, key func(i int) string) {
+	query := req.URL.Query()
+	if query.Get("limit") == "" && query.Get("cursor") == "" {
+		renderJSON(w, items)
+		return
+	}
+
+	limit, err := intParam(req, "limit", defaultPageLimit)
+	if err == nil && (limit < 1 || limit > maxPageLimit) {
+		err = fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+	}
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var total bool
+	if s := query.Get("total"); s != "" {
+		if total, err = strconv.ParseBool(s); err != nil {
+			httpError(w, req, fmt.Sprintf("total must be true or false, not %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	slice := reflect.ValueOf(items)
+	start := 0
+	if s := query.Get("cursor"); s != "" {
+		cursor, err := decodeCursor(s)
+		if err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+		start = sort.Search(slice.Len(), func(i int) bool { return key(i) > cursor.After })
+	}
+	end := start + limit
+	if end > slice.Len() {
+		end = slice.Len()
+	}
+
+	ret := page{Items: slice.Slice(start, end).Interface()}
+	if end < slice.Len() {
+		ret.NextCursor = encodeCursor(pageCursor{After: key(end - 1)})
+	}
+	if total {
+		n := slice.Len()
+		ret.Total = &n
+	}
+	renderJSON(w, ret)
+}
+
+func encodeCursor(cursor pageCursor) string {
+	js, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var ret pageCursor
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(js, &ret) != nil {
+		return ret, errors.New("malformed cursor")
+	}
+	return ret, nil
+}
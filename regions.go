@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// GET  /maps/regions/ : READ every region with its parent, subregions and how many locations it holds
+func (rs *routeServer) getRegionsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting regions at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.Regions())
+}
+
+// GET  /maps/regions/<region> : READ a region, with the locations directly in it
+func (rs *routeServer) getRegionHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting a region at %s\n", req.URL.Path)
+
+	region, err := rs.store.GetRegion(mux.Vars(req)["region"])
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, region)
+}
+
+// PUT  /maps/regions/<region> (with JSON parent: string optional) : UPDATE create <region>, or move it inside another region or to the top
+func (rs *routeServer) setRegionHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting a region at %s\n", req.URL.Path)
+
+	var body struct {
+		Parent string `json:"parent"`
+	}
+	if !decodeJSON(w, req, &body) {
+		return
+	}
+
+	if err := rs.store.SetRegion(mux.Vars(req)["region"], body.Parent); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /maps/regions/<region> : DELETE <region>; its locations and subregions move to the region it was in, if any
+func (rs *routeServer) removeRegionHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Removing a region at %s\n", req.URL.Path)
+
+	if err := rs.store.RemoveRegion(mux.Vars(req)["region"]); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// PUT  /maps/regions/<region>/locations (with JSON []string) : UPDATE put the given locations in <region>, taking them out of any other
+func (rs *routeServer) addToRegionHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Adding locations to a region at %s\n", req.URL.Path)
+
+	var locations []string
+	if !decodeJSON(w, req, &locations) {
+		return
+	}
+
+	if err := rs.store.AddToRegion(mux.Vars(req)["region"], locations); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// DELETE /maps/regions/<region>/locations/<location> : DELETE take <location> out of <region>
+func (rs *routeServer) removeFromRegionHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Removing a location from a region at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	if err := rs.store.RemoveFromRegion(vars["region"], vars["location"]); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /maps/regions/<from>/routes/<to> : READ the shortest routes from any location in region <from> to any location in region <to>
+func (rs *routeServer) regionRoutesHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding region routes at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	routes, err := rs.store.RegionRoutes(vars["from"], vars["to"])
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, routes)
+}
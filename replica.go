@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoints that take a POST body but only read, which a replica still serves
+var readOnlyPosts = map[string]bool{
+	"/maps/nearest/":          true,
+	"/maps/matrix/":           true,
+	"/maps/route/":            true,
+	"/maps/compare/":          true,
+	"/maps/analysis/traffic/": true,
+	"/maps/route/capacity/":   true,
+	"/maps/routes/validate/":  true,
+	"/maps/optimize/":         true,
+	"/maps/optimize/vrp/":     true,
+}
+
+// Ends of paths that take a POST body but only read, for endpoints under a location or edge
+var readOnlyPostSuffixes = []string{"/impact/"}
+
+func readOnlyPost(path string) bool {
+	for _, suffix := range readOnlyPostSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return readOnlyPosts[path]
+}
+
+// A server started with REPLICA_OF copies the whole state of that primary when its store is empty, then
+// refuses anything that would change it. There is no change feed to tail yet, so it is as stale as its last
+// copy; restarting it on an emptied Redis takes a fresh one.
+type replica struct {
+	sync.Mutex
+	primary string
+	// When the state was copied; zero if the store already held data at startup, so its age is unknown
+	syncedAt time.Time
+	// Staleness beyond which GET /admin/replica/ answers 503, so load balancers can stop sending reads; 0 for no limit
+	maxStaleness time.Duration
+}
+
+// The replica's source and how far behind it is
+type ReplicaStatus struct {
+	Primary  string     `json:"primary"`
+	SyncedAt *time.Time `json:"synced_at"`
+	// Seconds since the copy was taken, or null if unknown
+	StalenessSeconds *float64 `json:"staleness_seconds"`
+	MaxStaleness     float64  `json:"max_staleness_seconds,omitempty"`
+	Stale            bool     `json:"stale"`
+}
+
+// bootstrap copies the primary's bundle into store if it is empty
+func (r *replica) bootstrap(store *routes.RouteStore) error {
+	resp, err := http.Get(strings.TrimSuffix(r.primary, "/") + "/admin/bundle/")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching the bundle from %s failed: %s", r.primary, resp.Status)
+	}
+	var bundle routes.Bundle
+	if err := json.NewDecoder(resp.Body).Decode(&bundle); err != nil {
+		return err
+	}
+
+	if err := store.ImportBundle(bundle); err == routes.ErrStoreNotEmpty {
+		log.Printf("Serving the replica's existing data, which may be stale, since its store is not empty\n")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.syncedAt = time.Now()
+	return nil
+}
+
+func (r *replica) status() ReplicaStatus {
+	r.Lock()
+	defer r.Unlock()
+
+	ret := ReplicaStatus{Primary: r.primary, MaxStaleness: r.maxStaleness.Seconds()}
+	if r.syncedAt.IsZero() {
+		ret.Stale = r.maxStaleness > 0
+		return ret
+	}
+	syncedAt, staleness := r.syncedAt, time.Since(r.syncedAt)
+	seconds := staleness.Seconds()
+	ret.SyncedAt, ret.StalenessSeconds = &syncedAt, &seconds
+	ret.Stale = r.maxStaleness > 0 && staleness > r.maxStaleness
+	return ret
+}
+
+// middleware refuses requests that would change the store, which only the primary may do
+func (r *replica) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name, path := splitMapPath(req.URL.Path)
+		if !strings.HasSuffix(path, "/") {
+			path += "/"
+		}
+		// A named map's own path is where it is created and deleted
+		writes := req.Method != http.MethodGet && req.Method != http.MethodHead &&
+			(name != "" && path == "/" ||
+				strings.HasPrefix(path, "/maps/") && !(req.Method == http.MethodPost && readOnlyPost(path)) ||
+				strings.HasPrefix(path, "/admin/costs/") || path == "/admin/bundle/" || path == "/admin/timezone/" || path == "/admin/symmetric/" ||
+				path == "/admin/default-weight/" || path == "/admin/generate/")
+		if writes {
+			httpError(w, req, fmt.Sprintf("this server is a read-only replica of %s", r.primary), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// GET  /admin/replica/ : READ the primary this replica copied and how stale it is, with 503 once past REPLICA_MAX_STALENESS
+func (r *replica) statusHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting replica status at %s\n", req.URL.Path)
+
+	status := r.status()
+	if status.Stale {
+		renderJSONStatus(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	renderJSON(w, status)
+}
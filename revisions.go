@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// GET  /maps/revisions/ : READ the revisions the graph can be queried as of, oldest first and the current one last
+func (rs *routeServer) revisionsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting revisions at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.SnapshotRevisions())
+}
+
+// The snapshot at the request's revision, or false once the error is reported
+func (rs *routeServer) snapshotAt(w http.ResponseWriter, req *http.Request) (*routes.Snapshot, bool) {
+	revision, err := strconv.ParseUint(mux.Vars(req)["revision"], 10, 64)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	snapshot, err := rs.store.SnapshotAt(revision)
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return nil, false
+	}
+	return snapshot, true
+}
+
+// GET  /maps/revisions/<revision> (?limit=&cursor=&total=true optional) : READ list of locations as the graph was at <revision>
+func (rs *routeServer) locationsAtHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting locations at a revision at %s\n", req.URL.Path)
+
+	snapshot, ok := rs.snapshotAt(w, req)
+	if !ok {
+		return
+	}
+	locations := snapshot.Locations()
+	renderList(w, req, locations, func(i int) string { return locations[i] })
+}
+
+// GET  /maps/revisions/<revision>/<from>/<to> : READ list of shortest routes from <from> to <to> as the graph was at <revision>
+func (rs *routeServer) routesAtHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding routes at a revision at %s\n", req.URL.Path)
+
+	snapshot, ok := rs.snapshotAt(w, req)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(req)
+	found, err := snapshot.RoutesBetween(vars["from"], vars["to"])
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+	renderJSON(w, found)
+}
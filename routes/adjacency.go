@@ -0,0 +1,469 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/iterator"
+	"gonum.org/v1/gonum/graph/simple"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// Edges at one end, sorted by the other end's slot. Parallel slices rather than a slice of
+// structs, so that each edge end costs 12 bytes instead of 16 with padding.
+type edgeList struct {
+	slots   []int32
+	weights []float64
+}
+
+// find returns where slot is or would be inserted, and whether it is there
+func (l *edgeList) find(slot int32) (int, bool) {
+	i := sort.Search(len(l.slots), func(i int) bool { return l.slots[i] >= slot })
+	return i, i < len(l.slots) && l.slots[i] == slot
+}
+
+// set adds or reweighs the edge to slot, returning whether it was already there
+func (l *edgeList) set(slot int32, weight float64) bool {
+	i, found := l.find(slot)
+	if found {
+		l.weights[i] = weight
+		return true
+	}
+	l.slots = append(l.slots, 0)
+	copy(l.slots[i+1:], l.slots[i:])
+	l.slots[i] = slot
+	l.weights = append(l.weights, 0)
+	copy(l.weights[i+1:], l.weights[i:])
+	l.weights[i] = weight
+	return false
+}
+
+// remove drops the edge to slot, returning whether it was there
+func (l *edgeList) remove(slot int32) bool {
+	i, found := l.find(slot)
+	if found {
+		l.slots = append(l.slots[:i], l.slots[i+1:]...)
+		l.weights = append(l.weights[:i], l.weights[i+1:]...)
+	}
+	return found
+}
+
+// A copy of the list that shares nothing with it
+func (l edgeList) clone() edgeList {
+	return edgeList{slots: append([]int32(nil), l.slots...), weights: append([]float64(nil), l.weights...)}
+}
+
+// A node and its edges in each direction; node is nil when the slot is free
+type adjacency struct {
+	node graph.Node
+	out  edgeList
+	in   edgeList
+	// The generation that owns out and in, see nextGeneration
+	gen uint64
+}
+
+// Slots are numbered into a three level table, of directories of pages of nodes, so that clones can share
+// the pages they do not change
+const (
+	pageBits = 6
+	dirBits  = 8
+	rootBits = 10
+	pageSize = 1 << pageBits
+	dirSize  = 1 << dirBits
+	// The most nodes the table holds
+	maxSlots = 1 << (pageBits + dirBits + rootBits)
+	// Node IDs are hashes of names, so their low bits spread them over the index's shards
+	shardBits = 8
+)
+
+type nodePage struct {
+	gen   uint64
+	nodes [pageSize]adjacency
+}
+
+type nodeDir struct {
+	gen   uint64
+	pages [dirSize]*nodePage
+}
+
+type nodeTable struct {
+	gen  uint64
+	dirs [1 << rootBits]*nodeDir
+}
+
+// The slot of each node by ID, sharded so that adding or removing a node after a clone copies one shard
+type slotShard struct {
+	gen   uint64
+	slots map[int64]int32
+}
+
+type slotIndex struct {
+	gen    uint64
+	shards [1 << shardBits]*slotShard
+}
+
+func shardOf(id int64) int {
+	return int(uint64(id) & (1<<shardBits - 1))
+}
+
+var lastGeneration uint64
+
+// Every adjacencyGraph and every clone of one has a generation of its own. Pages, directories, shards and
+// edge lists carry the generation that made them, and a graph writes in place only to its own; anything
+// else it copies first, and the copy is its own.
+func nextGeneration() uint64 {
+	return atomic.AddUint64(&lastGeneration, 1)
+}
+
+// adjacencyGraph is a weighted directed graph for gonum's algorithms, in place of
+// simple.WeightedDirectedGraph. Each node is interned once into a slot of a table, and
+// edges refer to nodes by 32 bit slot rather than by 64 bit ID or name, so neighbour
+// iteration is a walk along two slices with no map lookups. Lookups are binary searches,
+// and inserting into a node's edges is linear in its degree.
+// Self edges weigh 0 and absent ones +Inf, as in the graph it replaces.
+//
+// It is persistent: clone takes constant time, and the clone and the original share every
+// page of the table until one of them writes to it, so snapshots are cheap to take and to keep.
+type adjacencyGraph struct {
+	gen   uint64
+	index *slotIndex
+	table *nodeTable
+	// Slots handed out so far, free or not
+	size  int32
+	count int
+	// Slots of removed nodes, reused before the table grows, and the generation that owns the slice
+	free    []int32
+	freeGen uint64
+	edges   int
+}
+
+func newAdjacencyGraph() *adjacencyGraph {
+	gen := nextGeneration()
+	return &adjacencyGraph{gen: gen, index: &slotIndex{gen: gen}, table: &nodeTable{gen: gen}, freeGen: gen}
+}
+
+// clone returns a copy of g in constant time. Neither writes in place to what they share after this, so
+// g's generation changes too; that is atomic, and clone only reads g otherwise, so readers sharing the
+// store lock may clone the graph at once.
+func (g *adjacencyGraph) clone() *adjacencyGraph {
+	ret := &adjacencyGraph{gen: nextGeneration(), index: g.index, table: g.table, size: g.size, count: g.count, free: g.free, edges: g.edges}
+	atomic.StoreUint64(&g.gen, nextGeneration())
+	return ret
+}
+
+// compact returns a copy of g that shares nothing with it, with no free slots and each map and slice
+// only as big as it needs to be
+func (g *adjacencyGraph) compact() *adjacencyGraph {
+	ret := newAdjacencyGraph()
+	nodes := g.Nodes()
+	for nodes.Next() {
+		ret.AddNode(nodes.Node())
+	}
+	edges := g.WeightedEdges()
+	for edges.Next() {
+		edge := edges.WeightedEdge()
+		ret.SetWeightedEdge(ret.NewWeightedEdge(edge.From(), edge.To(), edge.Weight()))
+	}
+	return ret
+}
+
+func (g *adjacencyGraph) slot(id int64) (int32, bool) {
+	if shard := g.index.shards[shardOf(id)]; shard != nil {
+		slot, ok := shard.slots[id]
+		return slot, ok
+	}
+	return 0, false
+}
+
+// at is the adjacency in slot, which must have been handed out, for reading
+func (g *adjacencyGraph) at(slot int32) *adjacency {
+	return &g.table.dirs[slot>>(pageBits+dirBits)].pages[slot>>pageBits&(dirSize-1)].nodes[slot&(pageSize-1)]
+}
+
+// writable is the adjacency in slot for writing, copying whatever holds it that g does not own
+func (g *adjacencyGraph) writable(slot int32) *adjacency {
+	if g.table.gen != g.gen {
+		table := *g.table
+		table.gen = g.gen
+		g.table = &table
+	}
+	d := slot >> (pageBits + dirBits)
+	dir := g.table.dirs[d]
+	if dir == nil || dir.gen != g.gen {
+		copied := &nodeDir{gen: g.gen}
+		if dir != nil {
+			copied.pages = dir.pages
+		}
+		dir = copied
+		g.table.dirs[d] = dir
+	}
+	p := slot >> pageBits & (dirSize - 1)
+	page := dir.pages[p]
+	if page == nil || page.gen != g.gen {
+		copied := &nodePage{gen: g.gen}
+		if page != nil {
+			copied.nodes = page.nodes
+		}
+		page = copied
+		dir.pages[p] = page
+	}
+	adj := &page.nodes[slot&(pageSize-1)]
+	if adj.gen != g.gen {
+		adj.out = adj.out.clone()
+		adj.in = adj.in.clone()
+		adj.gen = g.gen
+	}
+	return adj
+}
+
+// writableShard is the index shard id belongs in, for writing
+func (g *adjacencyGraph) writableShard(id int64) *slotShard {
+	if g.index.gen != g.gen {
+		index := *g.index
+		index.gen = g.gen
+		g.index = &index
+	}
+	i := shardOf(id)
+	shard := g.index.shards[i]
+	if shard == nil || shard.gen != g.gen {
+		copied := &slotShard{gen: g.gen, slots: make(map[int64]int32)}
+		if shard != nil {
+			for id, slot := range shard.slots {
+				copied.slots[id] = slot
+			}
+		}
+		shard = copied
+		g.index.shards[i] = shard
+	}
+	return shard
+}
+
+func (g *adjacencyGraph) lookup(id int64) (*adjacency, int32, bool) {
+	slot, ok := g.slot(id)
+	if !ok {
+		return nil, 0, false
+	}
+	return g.at(slot), slot, true
+}
+
+// each calls f with the adjacency of every node, in slot order
+func (g *adjacencyGraph) each(f func(adj *adjacency)) {
+	for slot := int32(0); slot < g.size; slot++ {
+		if adj := g.at(slot); adj.node != nil {
+			f(adj)
+		}
+	}
+}
+
+func (g *adjacencyGraph) Node(id int64) graph.Node {
+	if adj, _, ok := g.lookup(id); ok {
+		return adj.node
+	}
+	return nil
+}
+
+func (g *adjacencyGraph) Nodes() graph.Nodes {
+	if g.count == 0 {
+		return graph.Empty
+	}
+	nodes := make([]graph.Node, 0, g.count)
+	g.each(func(adj *adjacency) {
+		nodes = append(nodes, adj.node)
+	})
+	return iterator.NewOrderedNodes(nodes)
+}
+
+func (g *adjacencyGraph) neighbours(edges edgeList) graph.Nodes {
+	if len(edges.slots) == 0 {
+		return graph.Empty
+	}
+	nodes := make([]graph.Node, len(edges.slots))
+	for i, slot := range edges.slots {
+		nodes[i] = g.at(slot).node
+	}
+	return iterator.NewOrderedNodes(nodes)
+}
+
+// From is the nodes reachable directly from id
+func (g *adjacencyGraph) From(id int64) graph.Nodes {
+	if adj, _, ok := g.lookup(id); ok {
+		return g.neighbours(adj.out)
+	}
+	return graph.Empty
+}
+
+// To is the nodes with edges directly to id
+func (g *adjacencyGraph) To(id int64) graph.Nodes {
+	if adj, _, ok := g.lookup(id); ok {
+		return g.neighbours(adj.in)
+	}
+	return graph.Empty
+}
+
+func (g *adjacencyGraph) HasEdgeFromTo(uid, vid int64) bool {
+	_, ok := g.Weight(uid, vid)
+	return ok && uid != vid
+}
+
+func (g *adjacencyGraph) HasEdgeBetween(xid, yid int64) bool {
+	return g.HasEdgeFromTo(xid, yid) || g.HasEdgeFromTo(yid, xid)
+}
+
+func (g *adjacencyGraph) Weight(xid, yid int64) (float64, bool) {
+	if xid == yid {
+		return 0, true
+	}
+	from, _, ok := g.lookup(xid)
+	if !ok {
+		return math.Inf(1), false
+	}
+	to, ok := g.slot(yid)
+	if !ok {
+		return math.Inf(1), false
+	}
+	if i, found := from.out.find(to); found {
+		return from.out.weights[i], true
+	}
+	return math.Inf(1), false
+}
+
+func (g *adjacencyGraph) Edge(uid, vid int64) graph.Edge {
+	return g.WeightedEdge(uid, vid)
+}
+
+func (g *adjacencyGraph) WeightedEdge(uid, vid int64) graph.WeightedEdge {
+	if uid == vid {
+		return nil
+	}
+	if w, ok := g.Weight(uid, vid); ok {
+		return simple.WeightedEdge{F: g.Node(uid), T: g.Node(vid), W: w}
+	}
+	return nil
+}
+
+func (g *adjacencyGraph) WeightedEdges() graph.WeightedEdges {
+	if g.edges == 0 {
+		return graph.Empty
+	}
+	edges := make([]graph.WeightedEdge, 0, g.edges)
+	g.each(func(adj *adjacency) {
+		for i, slot := range adj.out.slots {
+			edges = append(edges, simple.WeightedEdge{F: adj.node, T: g.at(slot).node, W: adj.out.weights[i]})
+		}
+	})
+	return iterator.NewOrderedWeightedEdges(edges)
+}
+
+func (g *adjacencyGraph) Edges() graph.Edges {
+	if g.edges == 0 {
+		return graph.Empty
+	}
+	edges := make([]graph.Edge, 0, g.edges)
+	g.each(func(adj *adjacency) {
+		for i, slot := range adj.out.slots {
+			edges = append(edges, simple.WeightedEdge{F: adj.node, T: g.at(slot).node, W: adj.out.weights[i]})
+		}
+	})
+	return iterator.NewOrderedEdges(edges)
+}
+
+// AddNode panics if a node with the same ID is already present
+func (g *adjacencyGraph) AddNode(n graph.Node) {
+	if _, ok := g.slot(n.ID()); ok {
+		panic(fmt.Sprintf("adjacency graph: node ID collision: %d", n.ID()))
+	}
+	g.add(n)
+}
+
+func (g *adjacencyGraph) add(n graph.Node) int32 {
+	var slot int32
+	if len(g.free) > 0 {
+		if g.freeGen != g.gen {
+			g.free, g.freeGen = append([]int32(nil), g.free...), g.gen
+		}
+		slot = g.free[len(g.free)-1]
+		g.free = g.free[:len(g.free)-1]
+	} else {
+		if g.size == maxSlots {
+			panic("adjacency graph: too many nodes")
+		}
+		slot = g.size
+		g.size++
+	}
+	*g.writable(slot) = adjacency{node: n, gen: g.gen}
+	g.writableShard(n.ID()).slots[n.ID()] = slot
+	g.count++
+	return slot
+}
+
+func (g *adjacencyGraph) RemoveNode(id int64) {
+	slot, ok := g.slot(id)
+	if !ok {
+		return
+	}
+	adj := g.writable(slot)
+	for _, other := range adj.out.slots {
+		g.writable(other).in.remove(slot)
+	}
+	for _, other := range adj.in.slots {
+		g.writable(other).out.remove(slot)
+	}
+	g.edges -= len(adj.out.slots) + len(adj.in.slots)
+	*adj = adjacency{}
+	if g.freeGen != g.gen {
+		g.free, g.freeGen = append([]int32(nil), g.free...), g.gen
+	}
+	g.free = append(g.free, slot)
+	delete(g.writableShard(id).slots, id)
+	g.count--
+}
+
+func (g *adjacencyGraph) NewWeightedEdge(from, to graph.Node, weight float64) graph.WeightedEdge {
+	return simple.WeightedEdge{F: from, T: to, W: weight}
+}
+
+// SetWeightedEdge adds either end if it is missing, and panics on a self edge. Ends already
+// present are kept as they are, so a name is held once however many edges mention it.
+func (g *adjacencyGraph) SetWeightedEdge(e graph.WeightedEdge) {
+	fid, tid := e.From().ID(), e.To().ID()
+	if fid == tid {
+		panic("adjacency graph: adding self edge")
+	}
+	from, ok := g.slot(fid)
+	if !ok {
+		from = g.add(e.From())
+	}
+	to, ok := g.slot(tid)
+	if !ok {
+		to = g.add(e.To())
+	}
+
+	if !g.writable(from).out.set(to, e.Weight()) {
+		g.edges++
+	}
+	g.writable(to).in.set(from, e.Weight())
+}
+
+func (g *adjacencyGraph) RemoveEdge(fid, tid int64) {
+	from, fromSlot, ok := g.lookup(fid)
+	to, ok2 := g.slot(tid)
+	if !ok || !ok2 {
+		return
+	}
+	if _, found := from.out.find(to); !found {
+		return
+	}
+	g.writable(fromSlot).out.remove(to)
+	g.writable(to).in.remove(fromSlot)
+	g.edges--
+}
+
+// Bytes held for node names, each once however many edges mention it
+func (g *adjacencyGraph) nameBytes() int64 {
+	var ret int64
+	g.each(func(adj *adjacency) {
+		ret += int64(len(nodeName(adj.node)))
+	})
+	return ret
+}
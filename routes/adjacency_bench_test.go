@@ -0,0 +1,130 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/path"
+	"gonum.org/v1/gonum/graph/simple"
+	"math"
+	"math/rand"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// The benchmarks compare adjacencyGraph with the simple.WeightedDirectedGraph it replaced, on a generated graph of a
+// million edges:
+//
+//	go test ./routes -run '^$' -bench . -benchmem
+const (
+	benchNodes        = 100000
+	benchEdgesPerNode = 10
+)
+
+type benchGraph interface {
+	graph.WeightedDirected
+	AddNode(graph.Node)
+	SetWeightedEdge(graph.WeightedEdge)
+}
+
+var benchGraphs = []struct {
+	name  string
+	empty func() benchGraph
+}{
+	{"adjacency", func() benchGraph { return newAdjacencyGraph() }},
+	{"simple", func() benchGraph { return simple.NewWeightedDirectedGraph(0, math.Inf(1)) }},
+}
+
+var (
+	benchEdgesOnce sync.Once
+	benchEdges     []simple.WeightedEdge
+)
+
+// The generated edges, the same on every run: each location has benchEdgesPerNode edges out to random others
+func generatedEdges() []simple.WeightedEdge {
+	benchEdgesOnce.Do(func() {
+		r := rand.New(rand.NewSource(1))
+		locations := make([]Location, benchNodes)
+		for i := range locations {
+			locations[i] = Location(fmt.Sprint("location-", i))
+		}
+		for i, from := range locations {
+			for j := 0; j < benchEdgesPerNode; j++ {
+				to := locations[(i+1+r.Intn(benchNodes-1))%benchNodes]
+				benchEdges = append(benchEdges, simple.WeightedEdge{F: from, T: to, W: float64(1 + r.Intn(100))})
+			}
+		}
+	})
+	return benchEdges
+}
+
+func buildGraph(empty func() benchGraph, edges []simple.WeightedEdge) benchGraph {
+	g := empty()
+	for _, e := range edges {
+		if g.Node(e.F.ID()) == nil {
+			g.AddNode(e.F)
+		}
+		if g.Node(e.T.ID()) == nil {
+			g.AddNode(e.T)
+		}
+		// Repeated pairs keep the last weight, as AddRoutes would
+		g.SetWeightedEdge(e)
+	}
+	return g
+}
+
+// Building the graph, reporting the heap it holds once built
+func BenchmarkBuildGraph(b *testing.B) {
+	edges := generatedEdges()
+	for _, bg := range benchGraphs {
+		b.Run(bg.name, func(b *testing.B) {
+			var heap uint64
+			for i := 0; i < b.N; i++ {
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				g := buildGraph(bg.empty, edges)
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				heap += after.HeapAlloc - before.HeapAlloc
+				runtime.KeepAlive(g)
+			}
+			b.ReportMetric(float64(heap)/float64(b.N), "heap-bytes/graph")
+		})
+	}
+}
+
+// Dijkstra's algorithm from one location to every other, as RoutesBetween searches
+func BenchmarkDijkstra(b *testing.B) {
+	edges := generatedEdges()
+	for _, bg := range benchGraphs {
+		b.Run(bg.name, func(b *testing.B) {
+			g := buildGraph(bg.empty, edges)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				path.DijkstraFrom(edges[i%len(edges)].F, g)
+			}
+		})
+	}
+}
+
+// Walking every edge out of every location with its weight, as the searches do
+func BenchmarkNeighbours(b *testing.B) {
+	edges := generatedEdges()
+	for _, bg := range benchGraphs {
+		b.Run(bg.name, func(b *testing.B) {
+			g := buildGraph(bg.empty, edges)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				nodes := g.Nodes()
+				for nodes.Next() {
+					id := nodes.Node().ID()
+					to := g.From(id)
+					for to.Next() {
+						g.Weight(id, to.Node().ID())
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// What a graph should hold, as plain maps
+type graphModel struct {
+	nodes map[Location]bool
+	edges map[[2]Location]float64
+}
+
+func (m graphModel) copy() graphModel {
+	ret := graphModel{nodes: make(map[Location]bool), edges: make(map[[2]Location]float64)}
+	for loc := range m.nodes {
+		ret.nodes[loc] = true
+	}
+	for e, w := range m.edges {
+		ret.edges[e] = w
+	}
+	return ret
+}
+
+func (m graphModel) String() string {
+	var lines []string
+	for loc := range m.nodes {
+		lines = append(lines, string(loc))
+	}
+	for e, w := range m.edges {
+		lines = append(lines, fmt.Sprintf("%s>%s:%g", e[0], e[1], w))
+	}
+	sort.Strings(lines)
+	return fmt.Sprint(lines)
+}
+
+// The model of g, read through both directions of its edges, which must agree
+func modelOf(t *testing.T, g *adjacencyGraph) graphModel {
+	ret := graphModel{nodes: make(map[Location]bool), edges: make(map[[2]Location]float64)}
+	in := make(map[[2]Location]bool)
+	nodes := g.Nodes()
+	for nodes.Next() {
+		loc := nodes.Node().(Location)
+		ret.nodes[loc] = true
+		to := g.From(loc.ID())
+		for to.Next() {
+			w, _ := g.Weight(loc.ID(), to.Node().ID())
+			ret.edges[[2]Location{loc, to.Node().(Location)}] = w
+		}
+		from := g.To(loc.ID())
+		for from.Next() {
+			in[[2]Location{from.Node().(Location), loc}] = true
+		}
+	}
+	if len(in) != len(ret.edges) || len(ret.edges) != g.edges {
+		t.Fatalf("%d edges out, %d in and %d counted", len(ret.edges), len(in), g.edges)
+	}
+	for e := range in {
+		if _, ok := ret.edges[e]; !ok {
+			t.Fatalf("%s>%s is an edge in but not out", e[0], e[1])
+		}
+	}
+	return ret
+}
+
+// Clones must keep what the graph was when they were taken however either side changes afterwards
+func TestAdjacencyGraphClonesAreIndependent(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	type version struct {
+		g    *adjacencyGraph
+		want graphModel
+	}
+	live := version{newAdjacencyGraph(), graphModel{nodes: make(map[Location]bool), edges: make(map[[2]Location]float64)}}
+	var clones []version
+
+	names := make([]Location, 300)
+	for i := range names {
+		names[i] = Location(fmt.Sprint("n", i))
+	}
+	edit := func(v version) {
+		a, b := names[r.Intn(len(names))], names[r.Intn(len(names))]
+		switch op := r.Intn(10); {
+		case op < 6 && a != b:
+			w := float64(r.Intn(100))
+			v.g.SetWeightedEdge(v.g.NewWeightedEdge(a, b, w))
+			v.want.nodes[a], v.want.nodes[b] = true, true
+			v.want.edges[[2]Location{a, b}] = w
+		case op < 8:
+			v.g.RemoveEdge(a.ID(), b.ID())
+			delete(v.want.edges, [2]Location{a, b})
+		case op < 9:
+			v.g.RemoveNode(a.ID())
+			delete(v.want.nodes, a)
+			for e := range v.want.edges {
+				if e[0] == a || e[1] == a {
+					delete(v.want.edges, e)
+				}
+			}
+		case !v.want.nodes[a]:
+			v.g.AddNode(a)
+			v.want.nodes[a] = true
+		}
+	}
+
+	for i := 0; i < 20000; i++ {
+		edit(live)
+		if i%500 == 0 {
+			clones = append(clones, version{live.g.clone(), live.want.copy()})
+		}
+		if len(clones) > 0 && i%3 == 0 {
+			edit(clones[r.Intn(len(clones))])
+		}
+	}
+
+	for i, v := range append(clones, live) {
+		if got, want := modelOf(t, v.g).String(), v.want.String(); got != want {
+			t.Fatalf("graph %d holds\n%s\nnot\n%s", i, got, want)
+		}
+	}
+}
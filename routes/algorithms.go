@@ -0,0 +1,475 @@
+package routes
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/path"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A shortest path search algorithm that RoutesBetween can use
+type Algorithm string
+
+const (
+	// Every tied shortest route; the default
+	Dijkstra Algorithm = "dijkstra"
+	// One shortest route
+	AStar Algorithm = "astar"
+	// Every tied shortest route, and the only choice when weights can be negative
+	BellmanFord Algorithm = "bellman-ford"
+	// One shortest route, searching from both ends at once
+	Bidirectional Algorithm = "bidirectional"
+)
+
+var ErrNegativeCycle = errors.New("negative cycle detected")
+
+var ErrNoRouteWithinBudget = errors.New("no route within budget")
+
+// Must be called with the lock held; whether there is a negative cycle anywhere in the graph, found by
+// one Bellman-Ford search from a virtual source with free edges to every location
+func (rs *RouteStore) hasNegativeCycle() bool {
+	if rs.negativeEdges == 0 {
+		return false
+	}
+	all := make(map[int64]bool)
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		all[nodes.Node().ID()] = true
+	}
+	_, ok := path.BellmanFordFrom(superSource, superGraph{WeightedDirected: rs.graph, sources: all})
+	return !ok
+}
+
+// ParseAlgorithm checks s names a known algorithm; the empty string means the store's default
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch alg := Algorithm(s); alg {
+	case "", Dijkstra, AStar, BellmanFord, Bidirectional:
+		return alg, nil
+	}
+	return "", fmt.Errorf("unknown algorithm %q, expected one of %s, %s, %s or %s", s, Dijkstra, AStar, BellmanFord, Bidirectional)
+}
+
+func (alg Algorithm) allowsNegativeWeights() bool {
+	return alg == BellmanFord
+}
+
+// Options for a route query, where the zero value gives the store's defaults
+type RouteOptions struct {
+	Algorithm Algorithm
+	// Only used by AStar
+	Heuristic string
+	// Routes use no edge with any of ExcludeTags, and only edges with all of RequireTags
+	ExcludeTags []string
+	RequireTags []string
+	// Routes pass through no location with any of ExcludeLocationTags, and only through locations with all of
+	// RequireLocationTags; their own ends may have any tags
+	ExcludeLocationTags []string
+	RequireLocationTags []string
+	// Routes stay within this region and its subregions, which both ends must be in
+	Within string
+	// Routes use only edges with one of these modes, each by the cheapest of them; see SetEdgeModes
+	Modes []string
+	// Routes have no more than this many edges; 0 for no limit. Only Dijkstra and BellmanFord
+	// can be limited, and both then find the cheapest routes within the limit the same way.
+	MaxHops int
+	// The name of a cost function to weigh edges by instead of their weights; A* cannot use one
+	Cost string
+	// Edge attributes, and weight for the edge weight, to weigh edges by instead, each times its coefficient:
+	// {"time": 1} routes by time alone. This is an unnamed cost function, so cannot be given with Cost.
+	Metric map[string]float64
+	// A weight profile to weigh edges by instead, one of Profiles; it cannot be given with Cost or Metric
+	Profile string
+	// Metric or Profile as a cost function, set by resolveOptions
+	metric *CostFunction
+	// When the routes leave, to say when they arrive by the edges' time attributes; it does not change the
+	// routes, so is not part of the cache key. An RFC 3339 time, or one without an offset in the map's time zone.
+	DepartAt string
+	// Locations, and edges as <from>/<to>, that routes must not use
+	Avoid []string
+	// Routes weigh no more than this, or the query fails with ErrNoRouteWithinBudget; nil for no limit.
+	// Routes over it are trimmed from the search's result, so it is not part of the cache key either.
+	MaxWeight *float64
+	// With DisjointEdges or DisjointNodes, routes share no edges or no locations, one per search with what the
+	// routes before it used avoided. Each search is cached as an ordinary query, so this is not part of the cache key.
+	Disjoint Disjointness
+	// How the route cache is used; it does not change the routes, so is not part of the cache key
+	Cache CacheMode
+}
+
+// Whether searches must see the graph through routingGraph rather than as it is
+func (opts RouteOptions) reshapesGraph() bool {
+	return len(opts.ExcludeTags) > 0 || len(opts.RequireTags) > 0 || len(opts.ExcludeLocationTags) > 0 || len(opts.RequireLocationTags) > 0 || opts.Within != "" || len(opts.Modes) > 0 || opts.Cost != "" || opts.metric != nil || len(opts.Avoid) > 0
+}
+
+// Must be called with the lock held; fills in defaults and checks the options suit the current graph
+func (rs *RouteStore) resolveOptions(opts RouteOptions) (RouteOptions, error) {
+	if opts.MaxHops < 0 {
+		return opts, fmt.Errorf("max_hops must not be negative, not %d", opts.MaxHops)
+	}
+	if opts.MaxWeight != nil && (math.IsNaN(*opts.MaxWeight) || math.IsInf(*opts.MaxWeight, 0)) {
+		return opts, fmt.Errorf("max_weight must be a number, not %g", *opts.MaxWeight)
+	}
+	if _, err := ParseDisjointness(string(opts.Disjoint)); err != nil {
+		return opts, err
+	}
+	if _, ok := rs.regions[opts.Within]; opts.Within != "" && !ok {
+		return opts, fmt.Errorf("region %s does not exist", opts.Within)
+	}
+	// The other algorithms can return wrong routes given negative weights, so queries that leave
+	// the choice to the store fall back to Bellman-Ford while there are any
+	if opts.Algorithm == "" && rs.negativeEdges > 0 {
+		opts.Algorithm = BellmanFord
+	}
+	if opts.MaxHops > 0 {
+		switch opts.Algorithm {
+		case "":
+			opts.Algorithm = Dijkstra
+		case AStar, Bidirectional:
+			return opts, fmt.Errorf("%s cannot limit max_hops, use %s or %s", opts.Algorithm, Dijkstra, BellmanFord)
+		}
+	}
+	if opts.Profile != "" {
+		if _, err := ParseProfile(opts.Profile); err != nil {
+			return opts, err
+		}
+		if opts.Cost != "" || len(opts.Metric) > 0 {
+			return opts, errors.New("profile cannot be combined with cost or metric")
+		}
+		opts.metric = profileCostFunction(opts.Profile)
+	}
+	if len(opts.Metric) > 0 {
+		if opts.Cost != "" {
+			return opts, errors.New("cost and metric cannot be combined")
+		}
+		var err error
+		if opts.metric, err = metricCostFunction(opts.Metric); err != nil {
+			return opts, err
+		}
+	}
+	if opts.Cost != "" || opts.metric != nil {
+		if _, ok := rs.costs[opts.Cost]; !ok && opts.metric == nil {
+			return opts, fmt.Errorf("unknown cost function %q", opts.Cost)
+		}
+		switch opts.Algorithm {
+		case "":
+			opts.Algorithm = Dijkstra
+		case AStar:
+			// Heuristics estimate weights, not costs, so could overestimate
+			return opts, fmt.Errorf("%s cannot use a cost function", opts.Algorithm)
+		}
+	}
+	if len(opts.Modes) > 0 {
+		switch opts.Algorithm {
+		case "":
+			opts.Algorithm = Dijkstra
+		case AStar:
+			// Modes can weigh less than their edges, so heuristics could overestimate
+			return opts, fmt.Errorf("%s cannot route by modes", opts.Algorithm)
+		}
+	}
+	if opts.Algorithm == "" {
+		opts.Algorithm = rs.defaultAlgorithm
+	}
+	if opts.Algorithm != AStar {
+		opts.Heuristic = ""
+	} else if opts.Heuristic == "" {
+		opts.Heuristic = rs.defaultHeuristic
+	}
+	// Sorted so that the same filters in any order share a cache entry
+	opts.ExcludeTags = sortedCopy(opts.ExcludeTags)
+	opts.RequireTags = sortedCopy(opts.RequireTags)
+	opts.ExcludeLocationTags = sortedCopy(opts.ExcludeLocationTags)
+	opts.RequireLocationTags = sortedCopy(opts.RequireLocationTags)
+	opts.Modes = sortedCopy(opts.Modes)
+	for _, mode := range opts.Modes {
+		if err := validateMode(mode, 0); err != nil {
+			return opts, err
+		}
+	}
+	opts.Avoid = sortedCopy(opts.Avoid)
+	for _, avoid := range opts.Avoid {
+		if strings.Contains(avoid, "/") {
+			if _, err := ParsePair(avoid); err != nil {
+				return opts, err
+			}
+		} else if avoid == "" {
+			return opts, errors.New("cannot avoid a location with no name")
+		}
+	}
+	if rs.negativeEdges > 0 && !opts.Algorithm.allowsNegativeWeights() {
+		return opts, fmt.Errorf("%s cannot be used while there are negative edge weights, use %s", opts.Algorithm, BellmanFord)
+	}
+	return opts, nil
+}
+
+// Must be called with the lock held, after resolveOptions. An A* query with no heuristic chosen,
+// by it or by default, uses great-circle distance when the destination has coordinates, and
+// otherwise Dijkstra's algorithm, since A* without a heuristic is no faster and finds fewer routes.
+func (rs *RouteStore) targetOptions(to Location, opts RouteOptions) RouteOptions {
+	if opts.Algorithm != AStar || opts.Heuristic != "" {
+		return opts
+	}
+	if _, ok := rs.coordinates[to.ID()]; ok {
+		opts.Heuristic = "great-circle"
+	} else {
+		opts.Algorithm = Dijkstra
+	}
+	return opts
+}
+
+func (opts RouteOptions) cacheKey(from, to string) string {
+	key := Pair{From: from, To: to}.String() + "?algorithm=" + string(opts.Algorithm)
+	if opts.Heuristic != "" {
+		key += "&heuristic=" + opts.Heuristic
+	}
+	if len(opts.ExcludeTags) > 0 {
+		key += "&exclude_tags=" + strings.Join(opts.ExcludeTags, ",")
+	}
+	if len(opts.RequireTags) > 0 {
+		key += "&require_tags=" + strings.Join(opts.RequireTags, ",")
+	}
+	if len(opts.ExcludeLocationTags) > 0 {
+		key += "&exclude_location_tags=" + strings.Join(opts.ExcludeLocationTags, ",")
+	}
+	if len(opts.RequireLocationTags) > 0 {
+		key += "&require_location_tags=" + strings.Join(opts.RequireLocationTags, ",")
+	}
+	if opts.Within != "" {
+		key += "&within=" + opts.Within
+	}
+	if len(opts.Modes) > 0 {
+		key += "&modes=" + strings.Join(opts.Modes, ",")
+	}
+	if opts.MaxHops > 0 {
+		key += "&max_hops=" + strconv.Itoa(opts.MaxHops)
+	}
+	if opts.Cost != "" {
+		key += "&cost=" + opts.Cost
+	}
+	if opts.Profile != "" {
+		key += "&profile=" + opts.Profile
+	} else if opts.metric != nil {
+		key += "&metric=" + opts.metric.Expression
+	}
+	if len(opts.Avoid) > 0 {
+		key += "&avoid=" + strings.Join(opts.Avoid, ",")
+	}
+	return key
+}
+
+func sortedCopy(ss []string) []string {
+	if len(ss) == 0 {
+		return nil
+	}
+	ret := append([]string(nil), ss...)
+	sort.Strings(ret)
+	return ret
+}
+
+// SetDefaultAlgorithm changes the algorithm used by queries that do not ask for one
+func (rs *RouteStore) SetDefaultAlgorithm(alg Algorithm) error {
+	if alg == "" {
+		return errors.New("the default algorithm cannot be empty")
+	}
+
+	defer rs.lock("SetDefaultAlgorithm")()
+
+	rs.defaultAlgorithm = alg
+	return nil
+}
+
+func pathsToRoutes(paths [][]graph.Node, weight float64) []Route {
+	var ret []Route
+	for _, path := range paths {
+		if len(path) == 0 {
+			continue
+		}
+		route := Route{Weight: weight}
+		for _, node := range path {
+			route.Route = append(route.Route, nodeName(node))
+		}
+		ret = append(ret, route)
+	}
+	return ret
+}
+
+// Must be called with the lock held, after checking both locations exist and resolving the options
+func (rs *RouteStore) search(from, to Location, opts RouteOptions) ([]Route, error) {
+	var ret []Route
+	g, err := rs.withinRegionGraph(rs.throughTaggedGraph(rs.routingGraph(opts), opts, from, to), opts, from, to)
+	if err != nil {
+		return nil, err
+	}
+	g = rs.visitCostGraph(g, from)
+	switch {
+	case opts.MaxHops > 0:
+		if ret, err = hopBoundedRoutes(g, from, to, opts.MaxHops, rs.precision); err != nil {
+			return nil, err
+		}
+	case opts.Algorithm == AStar:
+		shortest, _ := path.AStar(from, to, g, rs.heuristic(opts.Heuristic))
+		path, weight := shortest.To(to.ID())
+		ret = pathsToRoutes([][]graph.Node{path}, weight)
+	case opts.Algorithm == BellmanFord:
+		shortest, ok := path.BellmanFordAllFrom(from, g)
+		if !ok {
+			return nil, ErrNegativeCycle
+		}
+		ret = pathsToRoutes(shortest.AllTo(to.ID()))
+	case opts.Algorithm == Bidirectional:
+		ret = pathsToRoutes(bidirectionalDijkstra(g, from.ID(), to.ID()))
+	default:
+		// Hot source trees hold the whole graph's weights, so cannot answer filtered or costed queries, nor add visit costs
+		if opts.reshapesGraph() || len(rs.visitCosts) > 0 {
+			ret = shortestRoutes(g, from, to, rs.precision)
+		} else {
+			ret = rs.routesBetween(from, to)
+		}
+	}
+	rs.roundRoutes(ret)
+	if len(opts.Modes) > 0 {
+		rs.addLegModes(ret, opts.Modes)
+	}
+	return ret, nil
+}
+
+// eachShortestPath calls yield with each shortest path from one node to another in turn, walking back
+// from the destination over edges that lie on some shortest path, so the first path is ready as soon as
+// the search is done. Paths whose weights differ by no more than tolerance tie. Weights must not be
+// negative. It stops at the first error from yield and returns it.
+func eachShortestPath(g graph.WeightedDirected, from, to int64, tolerance float64, yield func([]graph.Node, float64) error) error {
+	shortest := path.DijkstraFrom(g.Node(from), g)
+	weight := shortest.WeightTo(to)
+	if math.IsInf(weight, 1) {
+		return nil
+	}
+
+	// Nodes before id on some shortest path
+	preds := func(id int64) []int64 {
+		var ret []int64
+		nodes := g.To(id)
+		for nodes.Next() {
+			u := nodes.Node().ID()
+			w, _ := g.Weight(u, id)
+			if du := shortest.WeightTo(u); !math.IsInf(du, 1) && du+w-shortest.WeightTo(id) <= tolerance {
+				ret = append(ret, u)
+			}
+		}
+		return ret
+	}
+
+	type frame struct {
+		id    int64
+		preds []int64
+		next  int
+	}
+	stack := []frame{{id: to, preds: preds(to)}}
+	onStack := map[int64]bool{to: true}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.id == from {
+			nodes := make([]graph.Node, 0, len(stack))
+			for i := len(stack) - 1; i >= 0; i-- {
+				nodes = append(nodes, g.Node(stack[i].id))
+			}
+			if err := yield(nodes, weight); err != nil {
+				return err
+			}
+		} else if top.next < len(top.preds) {
+			// Zero weight cycles would otherwise give endless routes
+			if next := top.preds[top.next]; !onStack[next] {
+				top.next++
+				onStack[next] = true
+				stack = append(stack, frame{id: next, preds: preds(next)})
+				continue
+			}
+			top.next++
+			continue
+		}
+		delete(onStack, top.id)
+		stack = stack[:len(stack)-1]
+	}
+	return nil
+}
+
+// bidirectionalDijkstra alternately grows a search forwards from s and backwards from t,
+// stopping once no shorter meeting point than the best seen so far is possible
+func bidirectionalDijkstra(g graph.WeightedDirected, s, t int64) ([][]graph.Node, float64) {
+	if s == t {
+		return [][]graph.Node{{g.Node(s)}}, 0
+	}
+
+	type side struct {
+		dist    map[int64]float64
+		prev    map[int64]int64
+		settled map[int64]bool
+		queue   distQueue
+	}
+	newSide := func(start int64) *side {
+		return &side{
+			dist:    map[int64]float64{start: 0},
+			prev:    make(map[int64]int64),
+			settled: make(map[int64]bool),
+			queue:   distQueue{{id: start}},
+		}
+	}
+	forward, backward := newSide(s), newSide(t)
+
+	best, meet := math.Inf(1), int64(-1)
+	found := false
+	expand := func(this, other *side, neighbours func(int64) graph.Nodes, weight func(u, v int64) float64) {
+		item := heap.Pop(&this.queue).(queueItem)
+		if this.settled[item.id] {
+			return
+		}
+		this.settled[item.id] = true
+
+		nodes := neighbours(item.id)
+		for nodes.Next() {
+			next := nodes.Node().ID()
+			joint := item.dist + weight(item.id, next)
+			if d, ok := this.dist[next]; !ok || joint < d {
+				this.dist[next] = joint
+				this.prev[next] = item.id
+				heap.Push(&this.queue, queueItem{id: next, dist: joint})
+			}
+			if d, ok := other.dist[next]; ok && this.dist[next]+d < best {
+				best, meet, found = this.dist[next]+d, next, true
+			}
+		}
+	}
+	forwardWeight := func(u, v int64) float64 { w, _ := g.Weight(u, v); return w }
+	backwardWeight := func(u, v int64) float64 { w, _ := g.Weight(v, u); return w }
+
+	for forward.queue.Len() > 0 && backward.queue.Len() > 0 {
+		if forward.queue[0].dist+backward.queue[0].dist >= best {
+			break
+		}
+		if forward.queue.Len() <= backward.queue.Len() {
+			expand(forward, backward, g.From, forwardWeight)
+		} else {
+			expand(backward, forward, g.To, backwardWeight)
+		}
+	}
+	if !found {
+		return nil, math.Inf(1)
+	}
+
+	var path []graph.Node
+	for id := meet; ; id = forward.prev[id] {
+		path = append([]graph.Node{g.Node(id)}, path...)
+		if id == s {
+			break
+		}
+	}
+	for id := meet; id != t; {
+		id = backward.prev[id]
+		path = append(path, g.Node(id))
+	}
+	return [][]graph.Node{path}, best
+}
@@ -0,0 +1,132 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph/path"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Small random graphs of one-way edges with few weights, so many ties, and visit costs on some locations: the
+// bidirectional search must find a route exactly as heavy as Dijkstra's between every pair, and one that is there
+func TestBidirectionalDijkstraMatchesDijkstra(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		n := 2 + r.Intn(11)
+		g := newAdjacencyGraph()
+		names := make([]Location, n)
+		costs := make(map[int64]float64)
+		for j := range names {
+			names[j] = Location(fmt.Sprint("n", j))
+			g.AddNode(names[j])
+			if r.Intn(3) == 0 {
+				costs[names[j].ID()] = float64(r.Intn(3))
+			}
+		}
+		for _, from := range names {
+			for _, to := range names {
+				if from != to && r.Float64() < 0.25 {
+					g.SetWeightedEdge(g.NewWeightedEdge(from, to, float64(r.Intn(4))))
+				}
+			}
+		}
+
+		for _, s := range names {
+			costed := withVisitCosts(g, costs, s.ID())
+			shortest := path.DijkstraFrom(s, costed)
+			for _, target := range names {
+				_, want := shortest.To(target.ID())
+				paths, got := bidirectionalDijkstra(costed, s.ID(), target.ID())
+				if got != want {
+					t.Fatalf("graph %d, %s to %s: bidirectional weighs %g, Dijkstra %g", i, s, target, got, want)
+				}
+				if math.IsInf(want, 1) {
+					if len(paths) != 0 {
+						t.Fatalf("graph %d, %s to %s: a route where there is none: %v", i, s, target, paths)
+					}
+					continue
+				}
+				nodes := paths[0]
+				if nodes[0].ID() != s.ID() || nodes[len(nodes)-1].ID() != target.ID() {
+					t.Fatalf("graph %d, %s to %s: the route %v has the wrong ends", i, s, target, nodes)
+				}
+				var weight float64
+				for j := 1; j < len(nodes); j++ {
+					w, ok := costed.Weight(nodes[j-1].ID(), nodes[j].ID())
+					if !ok {
+						t.Fatalf("graph %d, %s to %s: the route %v uses a missing edge", i, s, target, nodes)
+					}
+					weight += w
+				}
+				if weight != got {
+					t.Fatalf("graph %d, %s to %s: the route %v weighs %g, not %g", i, s, target, nodes, weight, got)
+				}
+			}
+		}
+	}
+}
+
+// Through the store, closed edges are not used by either search
+func TestBidirectionalRoutesAvoidClosedEdges(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 20; i++ {
+		rs := New(newMemoryRedis())
+		names := make([]string, 6)
+		for j := range names {
+			names[j] = fmt.Sprint("n", j)
+			if err := rs.AddLocation(names[j], nil, nil, new(bool)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var edges [][2]string
+		for _, from := range names {
+			weights := make(map[string]float64)
+			for _, to := range names {
+				if from != to && r.Float64() < 0.5 {
+					weights[to] = float64(r.Intn(4))
+					edges = append(edges, [2]string{from, to})
+				}
+			}
+			if err := rs.AddRoutes(from, givenWeights(weights), new(bool)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, e := range edges {
+			if r.Intn(4) == 0 {
+				if err := rs.CloseEdge(e[0], e[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if err := rs.SetVisitCost(names[r.Intn(len(names))], 1); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, from := range names {
+			for _, to := range names {
+				want, err := rs.RoutesBetween(from, to, RouteOptions{Algorithm: Dijkstra, Cache: CacheBypass})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := rs.RoutesBetween(from, to, RouteOptions{Algorithm: Bidirectional, Cache: CacheBypass})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (len(got) == 0) != (len(want) == 0) {
+					t.Fatalf("store %d, %s to %s: bidirectional found %v, Dijkstra %v", i, from, to, got, want)
+				}
+				if len(got) > 0 && got[0].Weight != want[0].Weight {
+					t.Fatalf("store %d, %s to %s: bidirectional weighs %g, Dijkstra %g", i, from, to, got[0].Weight, want[0].Weight)
+				}
+				for _, route := range got {
+					for j := 1; j < len(route.Route); j++ {
+						if rs.isClosed(Location(route.Route[j-1]), Location(route.Route[j])) {
+							t.Fatalf("store %d: the route %v uses a closed edge", i, route.Route)
+						}
+					}
+				}
+			}
+		}
+	}
+}
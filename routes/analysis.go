@@ -0,0 +1,132 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// A directed edge and its weight
+type Edge struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Weight float64 `json:"weight"`
+}
+
+// Must be called with the lock held; every edge, lightest first
+func (rs *RouteStore) sortedEdges() []Edge {
+	var ret []Edge
+	edges := rs.graph.WeightedEdges()
+	for edges.Next() {
+		edge := edges.WeightedEdge()
+		ret = append(ret, Edge{From: nodeName(edge.From()), To: nodeName(edge.To()), Weight: edge.Weight()})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Weight != ret[j].Weight {
+			return ret[i].Weight < ret[j].Weight
+		}
+		if ret[i].From != ret[j].From {
+			return ret[i].From < ret[j].From
+		}
+		return ret[i].To < ret[j].To
+	})
+	return ret
+}
+
+// Edges with weights in [Min, Max), except the last bucket which includes Max
+type WeightBucket struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+// The distribution of edge weights across the whole graph
+type WeightAnalysis struct {
+	Edges       int                `json:"edges"`
+	Mean        float64            `json:"mean"`
+	Histogram   []WeightBucket     `json:"histogram"`
+	Percentiles map[string]float64 `json:"percentiles"`
+	Lightest    []Edge             `json:"lightest"`
+	Heaviest    []Edge             `json:"heaviest"`
+	// Routes are found by Bellman-Ford while there are negative weights, and not at all while there is a negative cycle
+	NegativeEdges int  `json:"negative_edges"`
+	NegativeCycle bool `json:"negative_cycle"`
+}
+
+var reportedPercentiles = []float64{0, 1, 5, 25, 50, 75, 95, 99, 100}
+
+// Nearest-rank percentile of an ascending list
+func percentile(sorted []Edge, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Weight
+}
+
+// GET  /maps/analysis/weights/ (?buckets=10&top=5 optional) : READ a histogram, percentiles and extremes of the edge weights
+func (rs *RouteStore) AnalyseWeights(buckets, top int) (WeightAnalysis, error) {
+	if buckets < 1 {
+		return WeightAnalysis{}, fmt.Errorf("buckets must be at least 1, not %d", buckets)
+	}
+	if top < 0 {
+		return WeightAnalysis{}, fmt.Errorf("top must not be negative, not %d", top)
+	}
+
+	unlock := rs.rlock("AnalyseWeights")
+	edges := rs.sortedEdges()
+	negativeEdges, negativeCycle := rs.negativeEdges, rs.hasNegativeCycle()
+	unlock()
+
+	ret := WeightAnalysis{
+		Edges:         len(edges),
+		Histogram:     []WeightBucket{},
+		Percentiles:   make(map[string]float64),
+		Lightest:      []Edge{},
+		Heaviest:      []Edge{},
+		NegativeEdges: negativeEdges,
+		NegativeCycle: negativeCycle,
+	}
+	if len(edges) == 0 {
+		return ret, nil
+	}
+
+	sum := 0.0
+	for _, edge := range edges {
+		sum += edge.Weight
+	}
+	ret.Mean = sum / float64(len(edges))
+
+	for _, p := range reportedPercentiles {
+		ret.Percentiles[fmt.Sprintf("p%g", p)] = percentile(edges, p)
+	}
+
+	min, max := edges[0].Weight, edges[len(edges)-1].Weight
+	if min == max {
+		buckets = 1
+	}
+	width := (max - min) / float64(buckets)
+	for i := 0; i < buckets; i++ {
+		ret.Histogram = append(ret.Histogram, WeightBucket{Min: min + float64(i)*width, Max: min + float64(i+1)*width})
+	}
+	ret.Histogram[buckets-1].Max = max
+	for _, edge := range edges {
+		i := buckets - 1
+		if width > 0 {
+			i = int((edge.Weight - min) / width)
+			if i >= buckets {
+				i = buckets - 1
+			}
+		}
+		ret.Histogram[i].Count++
+	}
+
+	if top > len(edges) {
+		top = len(edges)
+	}
+	ret.Lightest = append(ret.Lightest, edges[:top]...)
+	for i := len(edges) - 1; i >= len(edges)-top; i-- {
+		ret.Heaviest = append(ret.Heaviest, edges[i])
+	}
+	return ret, nil
+}
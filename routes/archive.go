@@ -0,0 +1,201 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"sort"
+	"strings"
+)
+
+// Archived locations, which are kept out of locations_set while everything else Redis has on them stays as it was
+const archived_set = "rest_project:archived"
+
+func (rs *RouteStore) restoreArchived() error {
+	names, err := redis.Strings(rs.redis.Do("SMEMBERS", archived_set))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		rs.archived[name] = true
+	}
+	return nil
+}
+
+// Must be called with the lock held; an archived location's name stays taken, so nothing new is made in its place
+func (rs *RouteStore) checkNotArchived(name string) error {
+	if rs.archived[name] {
+		return fmt.Errorf("%s is archived", name)
+	}
+	return nil
+}
+
+// GET  /maps/archive/ : READ the archived locations, in name order
+func (rs *RouteStore) GetArchived() []string {
+	defer rs.rlock("GetArchived")()
+
+	ret := []string{}
+	for name := range rs.archived {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// DELETE /maps/<location>?soft=true : UPDATE archive <location>, taking it and its edges out of the graph, so out of routing and
+// listings, while Redis keeps its edges both ways, their tags, attributes and modes, its coordinates, its region and its tags until it
+// is restored. Unlike the trash, nothing expires. Its name cannot be used again meanwhile. A hot source cannot be archived.
+func (rs *RouteStore) ArchiveLocation(name string) error {
+	defer rs.lock("ArchiveLocation")()
+
+	loc := Location(name)
+	if rs.graph.Node(loc.ID()) == nil {
+		return fmt.Errorf("%s does not exist", loc)
+	}
+	if _, ok := rs.hot[name]; ok {
+		return fmt.Errorf("%s is a hot source, which cannot be archived", name)
+	}
+	rs.changed()
+
+	if _, err := rs.redis.Do("MULTI"); err != nil {
+		return err
+	}
+	if _, err := rs.redis.Do("SREM", locations_set, name); err != nil {
+		rs.redis.Do("DISCARD")
+		return err
+	}
+	if _, err := rs.redis.Do("SADD", archived_set, name); err != nil {
+		rs.redis.Do("DISCARD")
+		return err
+	}
+	if _, err := rs.redis.Do("EXEC"); err != nil {
+		return err
+	}
+
+	rs.archived[name] = true
+	delete(rs.coordinates, loc.ID())
+	delete(rs.locationRegions, loc.ID())
+	delete(rs.locationTags, loc.ID())
+	delete(rs.writableVisitCosts(), loc.ID())
+	to := rs.graph.From(loc.ID())
+	for to.Next() {
+		if w, _ := rs.graph.Weight(loc.ID(), to.Node().ID()); w < 0 {
+			rs.negativeEdges--
+		}
+	}
+	from := rs.graph.To(loc.ID())
+	for from.Next() {
+		if w, _ := rs.graph.Weight(from.Node().ID(), loc.ID()); w < 0 {
+			rs.negativeEdges--
+		}
+	}
+	rs.graph.RemoveNode(loc.ID())
+	rs.updateHot(func(tree *shortestPathTree) { tree.nodeRemoved(rs.graph, loc.ID()) })
+	return nil
+}
+
+// PUT  /maps/archive/restore/<location> : UPDATE bring an archived location back with everything Redis kept for it. Edges to
+// and from locations deleted or renamed while it was archived are dropped, as is its region if that was removed; edges to
+// other archived locations wait for them.
+func (rs *RouteStore) UnarchiveLocation(name string) error {
+	defer rs.lock("UnarchiveLocation")()
+
+	if !rs.archived[name] {
+		return fmt.Errorf("%s is not archived", name)
+	}
+	loc := Location(name)
+
+	// Read everything first, so a failed read leaves it archived
+	routesTo, err := getEdges(rs.redis, name)
+	if err != nil {
+		return err
+	}
+	routesFrom := make(map[string]float64)
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		from := nodeName(nodes.Node())
+		weight, err := redis.Float64(rs.redis.Do("HGET", from, name))
+		if err == redis.ErrNil {
+			continue
+		} else if err != nil {
+			return err
+		}
+		routesFrom[from] = weight
+	}
+	var at *Coordinates
+	if s, err := redis.String(rs.redis.Do("HGET", coordinates_hash, name)); err == nil {
+		c, err := parseCoordinates(s)
+		if err != nil {
+			return err
+		}
+		at = &c
+	} else if err != redis.ErrNil {
+		return err
+	}
+	region, err := redis.String(rs.redis.Do("HGET", location_regions_hash, name))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	tags, err := redis.String(rs.redis.Do("HGET", location_tags_hash, name))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	visitCost, err := redis.Float64(rs.redis.Do("HGET", visit_costs_hash, name))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+
+	if _, err := rs.redis.Do("MULTI"); err != nil {
+		return err
+	}
+	if _, err := rs.redis.Do("SREM", archived_set, name); err != nil {
+		rs.redis.Do("DISCARD")
+		return err
+	}
+	if _, err := rs.redis.Do("SADD", locations_set, name); err != nil {
+		rs.redis.Do("DISCARD")
+		return err
+	}
+	if _, err := rs.redis.Do("EXEC"); err != nil {
+		return err
+	}
+
+	rs.changed()
+	delete(rs.archived, name)
+	rs.graph.AddNode(loc)
+	for to, weight := range routesTo {
+		if rs.archived[to] {
+			continue
+		}
+		if rs.graph.Node(Location(to).ID()) == nil || to == name {
+			if _, err := rs.redis.Do("HDEL", name, to); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := rs.setEdge(loc, Location(to), weight); err != nil {
+			return err
+		}
+	}
+	for from, weight := range routesFrom {
+		if err := rs.setEdge(Location(from), loc, weight); err != nil {
+			return err
+		}
+	}
+	if at != nil {
+		rs.coordinates[loc.ID()] = *at
+	}
+	if tags != "" {
+		rs.locationTags[loc.ID()] = strings.Split(tags, ",")
+	}
+	if visitCost != 0 {
+		rs.writableVisitCosts()[loc.ID()] = visitCost
+	}
+	if _, ok := rs.regions[region]; ok {
+		rs.locationRegions[loc.ID()] = region
+	} else if region != "" {
+		if _, err := rs.redis.Do("HDEL", location_regions_hash, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"log"
+	"math"
+	"regexp"
+)
+
+// Attributes of every edge that has any, by "<from>/<to>", each a JSON object of numbers
+const edge_attributes_hash = "rest_project:edge_attributes"
+
+var attributeName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateAttribute rejects attributes that a cost function could not refer to or sum
+func validateAttribute(name string, value float64) error {
+	if !attributeName.MatchString(name) || name == "weight" {
+		return fmt.Errorf("attribute name %q must be letters, digits and '_', not starting with a digit, and not weight", name)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) || value < 0 {
+		return fmt.Errorf("attribute %s must be a non-negative number, not %g", name, value)
+	}
+	return nil
+}
+
+func (rs *RouteStore) restoreAttributes() error {
+	stringMap, err := redis.StringMap(rs.redis.Do("HGETALL", edge_attributes_hash))
+	if err != nil {
+		return err
+	}
+	for s, js := range stringMap {
+		pair, err := ParsePair(s)
+		if err != nil {
+			// Such as the edge of a location named with a '/', before names were checked
+			log.Printf("Ignoring the attributes of %q in Redis: %s\n", s, err.Error())
+			continue
+		}
+		var attributes map[string]float64
+		if err := json.Unmarshal([]byte(js), &attributes); err != nil {
+			return fmt.Errorf("bad attributes for %s: %s", pair, err)
+		}
+		rs.attributes[edgeKey(Location(pair.From).ID(), Location(pair.To).ID())] = attributes
+	}
+	return nil
+}
+
+// Must be called with the lock held, whenever an edge goes
+func (rs *RouteStore) removeAttributes(from, to Location) error {
+	key := edgeKey(from.ID(), to.ID())
+	if _, ok := rs.attributes[key]; !ok {
+		return nil
+	}
+	if _, err := rs.redis.Do("HDEL", edge_attributes_hash, Pair{From: string(from), To: string(to)}.String()); err != nil {
+		return err
+	}
+	delete(rs.attributes, key)
+	return nil
+}
+
+// GET  /maps/<from>/edge/<to>/attributes : READ the numeric attributes of the edge from <from> to <to>
+func (rs *RouteStore) EdgeAttributes(fromStr, toStr string) (map[string]float64, error) {
+	defer rs.rlock("EdgeAttributes")()
+
+	from, to := Location(fromStr), Location(toStr)
+	if !rs.hasEdge(from, to) {
+		return nil, fmt.Errorf("there is no edge from %s to %s", from, to)
+	}
+
+	ret := make(map[string]float64)
+	for name, value := range rs.attributes[edgeKey(from.ID(), to.ID())] {
+		ret[name] = value
+	}
+	return ret, nil
+}
+
+// PUT  /maps/<from>/edge/<to>/attributes (with JSON map[string]number) : UPDATE replace the attributes of the edge from <from> to <to>.
+// Attributes, such as time or toll_cost, are what cost functions combine, and distance, duration and cost what weight
+// profiles route by; like tags, they go with their edge.
+func (rs *RouteStore) SetEdgeAttributes(fromStr, toStr string, attributes map[string]float64) error {
+	for name, value := range attributes {
+		if err := validateAttribute(name, value); err != nil {
+			return err
+		}
+	}
+
+	defer rs.lock("SetEdgeAttributes")()
+
+	from, to := Location(fromStr), Location(toStr)
+	if !rs.hasEdge(from, to) {
+		return fmt.Errorf("there is no edge from %s to %s", from, to)
+	}
+	rs.changed()
+
+	if len(attributes) == 0 {
+		return rs.removeAttributes(from, to)
+	}
+	js, err := json.Marshal(attributes)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.redis.Do("HSET", edge_attributes_hash, Pair{From: fromStr, To: toStr}.String(), js); err != nil {
+		return err
+	}
+	copied := make(map[string]float64, len(attributes))
+	for name, value := range attributes {
+		copied[name] = value
+	}
+	rs.attributes[edgeKey(from.ID(), to.ID())] = copied
+	return nil
+}
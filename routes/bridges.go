@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"sort"
+)
+
+// The single points of failure in the graph, ignoring which way edges go
+type CriticalElements struct {
+	// Locations whose deletion would split the locations connected through them, in name order
+	ArticulationPoints []string `json:"articulation_points"`
+	// Pairs of locations whose edges are the only link between two parts of the graph, so that removing the
+	// edges between them, either way, splits it; each once, with from before to in name order
+	Bridges []Pair `json:"bridges"`
+}
+
+// GET  /maps/analysis/critical/ : READ the articulation points and bridges of the graph. Edges are treated as
+// links whichever way they go, as for weak connectivity, so these are where a deletion cuts locations off from
+// each other entirely. Found by Tarjan's depth-first search with low links, in time linear in the graph's size.
+func (rs *RouteStore) CriticalElements() CriticalElements {
+	defer rs.rlock("CriticalElements")()
+
+	ret := CriticalElements{ArticulationPoints: []string{}, Bridges: []Pair{}}
+	// order is when each node was first reached, and low the earliest node reachable from its subtree by one
+	// link other than the one the search arrived by
+	order, low := make(map[int64]int), make(map[int64]int)
+
+	var visit func(id, parent int64, root bool)
+	visit = func(id, parent int64, root bool) {
+		order[id] = len(order)
+		low[id] = order[id]
+		children, articulation := 0, false
+		for next := range rs.neighbours(id) {
+			if _, seen := order[next]; seen {
+				if root || next != parent {
+					low[id] = minInt(low[id], order[next])
+				}
+				continue
+			}
+			children++
+			visit(next, id, false)
+			low[id] = minInt(low[id], low[next])
+			if !root && low[next] >= order[id] {
+				articulation = true
+			}
+			if low[next] > order[id] {
+				ret.Bridges = append(ret.Bridges, twoWayPair(nodeName(rs.graph.Node(id)), nodeName(rs.graph.Node(next))))
+			}
+		}
+		if articulation || root && children > 1 {
+			ret.ArticulationPoints = append(ret.ArticulationPoints, nodeName(rs.graph.Node(id)))
+		}
+	}
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		if _, seen := order[nodes.Node().ID()]; !seen {
+			visit(nodes.Node().ID(), 0, true)
+		}
+	}
+
+	sort.Strings(ret.ArticulationPoints)
+	sort.Slice(ret.Bridges, func(i, j int) bool { return ret.Bridges[i].String() < ret.Bridges[j].String() })
+	return ret
+}
+
+// Must be called with the lock held; the locations linked to id by an edge either way, not counting id itself
+func (rs *RouteStore) neighbours(id int64) map[int64]bool {
+	ret := make(map[int64]bool)
+	from := rs.graph.From(id)
+	for from.Next() {
+		ret[from.Node().ID()] = true
+	}
+	to := rs.graph.To(id)
+	for to.Next() {
+		ret[to.Node().ID()] = true
+	}
+	delete(ret, id)
+	return ret
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,456 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The version of the bundle format ExportBundle writes and ImportBundle reads
+const BundleVersion = 1
+
+var ErrStoreNotEmpty = errors.New("a bundle can only be imported into an empty store")
+
+// Everything the store keeps in Redis that another server needs to answer the same queries
+type Bundle struct {
+	Version int       `json:"version"`
+	Graph   GraphData `json:"graph"`
+	// Edge tags, attributes and modes, by "<from>/<to>"
+	Tags       map[string][]string           `json:"tags"`
+	Attributes map[string]map[string]float64 `json:"attributes"`
+	Modes      map[string]map[string]float64 `json:"modes"`
+	// Pairs of locations whose edges are kept in step, each once as "<from>/<to>" with from before to in name order
+	TwoWay        []string          `json:"two_way"`
+	CostFunctions map[string]string `json:"cost_functions"`
+	// The region containing each region, "" at the top, and the region of each location in one
+	Regions         map[string]string `json:"regions"`
+	LocationRegions map[string]string `json:"location_regions"`
+	Watched         []Pair            `json:"watched"`
+	Critical        []Pair            `json:"critical"`
+	HotSources      []string          `json:"hot_sources"`
+	// The tags of each tagged location
+	LocationTags map[string][]string `json:"location_tags"`
+	// When each closed edge was closed, by "<from>/<to>"; closed edges are among the graph's edges with the rest
+	Closed map[string]time.Time `json:"closed"`
+	// The visit cost of each location that has one
+	VisitCosts map[string]float64 `json:"visit_costs"`
+	// The map's time zone; empty for UTC
+	Timezone string `json:"timezone,omitempty"`
+	// Whether edges added without bidirectional go both ways
+	Symmetric bool `json:"symmetric,omitempty"`
+	// The weight of edges added without one, if any
+	DefaultWeight *float64 `json:"default_weight,omitempty"`
+}
+
+// GET  /admin/bundle/ : READ the whole state of the store as one bundle: the graph, edge tags, attributes and modes,
+// closed edges, two-way pairs, cost functions, regions, location tags, visit costs, watched and critical pairs, hot sources, the time zone, whether the map is symmetric and its default weight
+func (rs *RouteStore) ExportBundle() (Bundle, error) {
+	defer rs.rlock("ExportBundle")()
+
+	graph, err := rs.export(ExportFilter{})
+	if err != nil {
+		return Bundle{}, err
+	}
+	for _, c := range rs.closed {
+		if rs.graph.Node(c.from.ID()) != nil && rs.graph.Node(c.to.ID()) != nil {
+			graph.Edges = append(graph.Edges, Edge{From: string(c.from), To: string(c.to), Weight: c.weight})
+		}
+	}
+	ret := Bundle{
+		Version:         BundleVersion,
+		Graph:           graph,
+		Tags:            make(map[string][]string),
+		Attributes:      make(map[string]map[string]float64),
+		Modes:           make(map[string]map[string]float64),
+		TwoWay:          []string{},
+		CostFunctions:   make(map[string]string),
+		Regions:         make(map[string]string),
+		LocationRegions: make(map[string]string),
+		LocationTags:    make(map[string][]string),
+		Closed:          make(map[string]time.Time),
+		VisitCosts:      make(map[string]float64),
+		Watched:         []Pair{},
+		Critical:        []Pair{},
+		HotSources:      []string{},
+	}
+	for _, edge := range graph.Edges {
+		key := edgeKey(Location(edge.From).ID(), Location(edge.To).ID())
+		pair := Pair{From: edge.From, To: edge.To}
+		if tags, ok := rs.tags[key]; ok {
+			ret.Tags[pair.String()] = append([]string{}, tags...)
+		}
+		if attributes, ok := rs.attributes[key]; ok {
+			ret.Attributes[pair.String()] = attributes
+		}
+		if modes, ok := rs.modes[key]; ok {
+			ret.Modes[pair.String()] = modes
+		}
+		if edge.From < edge.To && rs.isTwoWay(Location(edge.From), Location(edge.To)) {
+			ret.TwoWay = append(ret.TwoWay, pair.String())
+		}
+		if c, ok := rs.closed[key]; ok {
+			ret.Closed[pair.String()] = c.at
+		}
+	}
+	for name, c := range rs.costs {
+		ret.CostFunctions[name] = c.Expression
+	}
+	for name, parent := range rs.regions {
+		ret.Regions[name] = parent
+	}
+	for id, region := range rs.locationRegions {
+		ret.LocationRegions[nodeName(rs.graph.Node(id))] = region
+	}
+	for id, tags := range rs.locationTags {
+		ret.LocationTags[nodeName(rs.graph.Node(id))] = append([]string{}, tags...)
+	}
+	for id, cost := range rs.visitCosts {
+		ret.VisitCosts[nodeName(rs.graph.Node(id))] = cost
+	}
+	for pair := range rs.watched {
+		ret.Watched = append(ret.Watched, pair)
+	}
+	sort.Slice(ret.Watched, func(i, j int) bool { return ret.Watched[i].String() < ret.Watched[j].String() })
+	for pair := range rs.critical {
+		ret.Critical = append(ret.Critical, pair)
+	}
+	sort.Slice(ret.Critical, func(i, j int) bool { return ret.Critical[i].String() < ret.Critical[j].String() })
+	for name := range rs.hot {
+		ret.HotSources = append(ret.HotSources, name)
+	}
+	sort.Strings(ret.HotSources)
+	if rs.timezone != time.UTC {
+		ret.Timezone = rs.timezone.String()
+	}
+	ret.Symmetric = rs.symmetric
+	ret.DefaultWeight = rs.defaultWeight
+	return ret, nil
+}
+
+// POST /admin/bundle/ (with JSON bundle) : CREATE the whole state of an exported store, for standing up a replica.
+// The store must be empty. Everything is checked before anything is written, and written in one transaction.
+func (rs *RouteStore) ImportBundle(bundle Bundle) error {
+	if bundle.Version != BundleVersion {
+		return fmt.Errorf("unsupported bundle version %d, expected %d", bundle.Version, BundleVersion)
+	}
+
+	defer rs.lock("ImportBundle")()
+
+	if rs.graph.Nodes().Len() > 0 || len(rs.costs) > 0 || len(rs.regions) > 0 || len(rs.watched) > 0 || len(rs.critical) > 0 || len(rs.hot) > 0 || len(rs.archived) > 0 {
+		return ErrStoreNotEmpty
+	}
+
+	// Check everything first, so a bad bundle changes nothing
+	locations := make(map[string]bool)
+	for _, name := range bundle.Graph.Locations {
+		if err := validateName(name); err != nil {
+			return err
+		}
+		locations[name] = true
+	}
+	exists := func(name string) error {
+		if !locations[name] {
+			return fmt.Errorf("%s does not exist", name)
+		}
+		return nil
+	}
+	edges := make(map[Pair]float64)
+	for _, edge := range bundle.Graph.Edges {
+		if edge.From == edge.To {
+			return fmt.Errorf("%s cannot have an edge to itself", edge.From)
+		}
+		for _, name := range []string{edge.From, edge.To} {
+			if err := exists(name); err != nil {
+				return err
+			}
+		}
+		if err := rs.checkWeight(edge.From, edge.To, edge.Weight); err != nil {
+			return err
+		}
+		edges[Pair{From: edge.From, To: edge.To}] = rs.roundWeight(edge.Weight)
+	}
+	hasEdge := func(s string) (Pair, error) {
+		pair, err := ParsePair(s)
+		if err != nil {
+			return pair, err
+		}
+		if _, ok := edges[pair]; !ok {
+			return pair, fmt.Errorf("there is no edge from %s to %s", pair.From, pair.To)
+		}
+		return pair, nil
+	}
+	for name, c := range bundle.Graph.Coordinates {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if !locations[name] {
+			return fmt.Errorf("coordinates given for unknown location %s", name)
+		}
+	}
+
+	tags := make(map[string]string)
+	for s, list := range bundle.Tags {
+		if _, err := hasEdge(s); err != nil {
+			return err
+		}
+		set := make(map[string]bool)
+		for _, tag := range list {
+			if err := validateTag(tag); err != nil {
+				return err
+			}
+			set[tag] = true
+		}
+		sorted := make([]string, 0, len(set))
+		for tag := range set {
+			sorted = append(sorted, tag)
+		}
+		sort.Strings(sorted)
+		if len(sorted) > 0 {
+			tags[s] = strings.Join(sorted, ",")
+		}
+	}
+	attributes := make(map[string][]byte)
+	for s, values := range bundle.Attributes {
+		if _, err := hasEdge(s); err != nil {
+			return err
+		}
+		for name, value := range values {
+			if err := validateAttribute(name, value); err != nil {
+				return err
+			}
+		}
+		if len(values) > 0 {
+			js, err := json.Marshal(values)
+			if err != nil {
+				return err
+			}
+			attributes[s] = js
+		}
+	}
+	modes := make(map[string][]byte)
+	for s, values := range bundle.Modes {
+		pair, err := hasEdge(s)
+		if err != nil {
+			return err
+		}
+		rounded := make(map[string]float64, len(values))
+		for name, weight := range values {
+			if err := validateMode(name, weight); err != nil {
+				return err
+			}
+			if err := rs.checkWeight(pair.From, pair.To, weight); err != nil {
+				return err
+			}
+			rounded[name] = rs.roundWeight(weight)
+		}
+		if len(rounded) > 0 {
+			js, err := json.Marshal(rounded)
+			if err != nil {
+				return err
+			}
+			modes[s] = js
+		}
+	}
+	for s := range bundle.Closed {
+		if _, err := hasEdge(s); err != nil {
+			return err
+		}
+	}
+	for _, s := range bundle.TwoWay {
+		pair, err := hasEdge(s)
+		if err != nil {
+			return err
+		}
+		back, err := hasEdge(Pair{From: pair.To, To: pair.From}.String())
+		if err != nil {
+			return err
+		}
+		if edges[pair] != edges[back] {
+			return fmt.Errorf("the edges between %s and %s are two-way but weigh %g and %g", pair.From, pair.To, edges[pair], edges[back])
+		}
+	}
+	for name, expression := range bundle.CostFunctions {
+		if _, err := ParseCostFunction(name, expression); err != nil {
+			return err
+		}
+	}
+	for name, parent := range bundle.Regions {
+		if err := validateRegionName(name); err != nil {
+			return err
+		}
+		for r, steps := parent, 0; r != ""; r, steps = bundle.Regions[r], steps+1 {
+			if _, ok := bundle.Regions[r]; !ok {
+				return fmt.Errorf("region %s does not exist", r)
+			}
+			if r == name || steps > len(bundle.Regions) {
+				return fmt.Errorf("region %s cannot be inside %s, which is inside it", name, parent)
+			}
+		}
+	}
+	for name, region := range bundle.LocationRegions {
+		if err := exists(name); err != nil {
+			return err
+		}
+		if _, ok := bundle.Regions[region]; !ok {
+			return fmt.Errorf("region %s does not exist", region)
+		}
+	}
+	locationTags := make(map[string]string)
+	for name, list := range bundle.LocationTags {
+		if err := exists(name); err != nil {
+			return err
+		}
+		sorted, err := sortedTags(list)
+		if err != nil {
+			return err
+		}
+		if len(sorted) > 0 {
+			locationTags[name] = strings.Join(sorted, ",")
+		}
+	}
+	visitCosts := make(map[string]float64)
+	for name, cost := range bundle.VisitCosts {
+		if err := exists(name); err != nil {
+			return err
+		}
+		if err := validateVisitCost(name, cost); err != nil {
+			return err
+		}
+		if cost = rs.roundWeight(cost); cost != 0 {
+			visitCosts[name] = cost
+		}
+	}
+	for _, pair := range append(append([]Pair{}, bundle.Watched...), bundle.Critical...) {
+		for _, name := range []string{pair.From, pair.To} {
+			if err := exists(name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range bundle.HotSources {
+		if err := exists(name); err != nil {
+			return err
+		}
+	}
+	if _, err := time.LoadLocation(bundle.Timezone); err != nil || bundle.Timezone == "Local" {
+		return fmt.Errorf("unknown time zone %q", bundle.Timezone)
+	}
+
+	if _, err := rs.redis.Do("MULTI"); err != nil {
+		return err
+	}
+	if err := rs.queueBundle(bundle, edges, tags, locationTags, attributes, modes, visitCosts); err != nil {
+		rs.redis.Do("DISCARD")
+		return err
+	}
+	if _, err := rs.redis.Do("EXEC"); err != nil {
+		return err
+	}
+
+	// Read back what was written, as Restore would
+	rs.changed()
+	for name := range locations {
+		rs.graph.AddNode(Location(name))
+	}
+	for pair, weight := range edges {
+		if err := rs.setEdge(Location(pair.From), Location(pair.To), weight); err != nil {
+			return err
+		}
+	}
+	for _, restore := range []func() error{
+		rs.restoreCoordinates,
+		rs.restoreTags,
+		rs.restoreLocationTags,
+		rs.restoreVisitCosts,
+		rs.restoreAttributes,
+		rs.restoreModes,
+		rs.restoreCostFunctions,
+		rs.restoreRegions,
+		rs.restoreTwoWay,
+		rs.restoreClosures,
+		rs.restoreHotSources,
+		rs.restoreWatched,
+		rs.restoreCritical,
+		rs.restoreTimezone,
+		rs.restoreSymmetric,
+		rs.restoreDefaultWeight,
+	} {
+		if err := restore(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held, inside MULTI
+func (rs *RouteStore) queueBundle(bundle Bundle, edges map[Pair]float64, tags, locationTags map[string]string, attributes, modes map[string][]byte, visitCosts map[string]float64) error {
+	locations := make(map[string]bool)
+	for _, name := range bundle.Graph.Locations {
+		locations[name] = true
+	}
+	if err := rs.queueImport(locations, edges, bundle.Graph.Coordinates); err != nil {
+		return err
+	}
+
+	var commands [][]interface{}
+	for s, joined := range tags {
+		commands = append(commands, []interface{}{"HSET", edge_tags_hash, s, joined})
+	}
+	for s, js := range attributes {
+		commands = append(commands, []interface{}{"HSET", edge_attributes_hash, s, js})
+	}
+	for s, js := range modes {
+		commands = append(commands, []interface{}{"HSET", edge_modes_hash, s, js})
+	}
+	for s, at := range bundle.Closed {
+		commands = append(commands, []interface{}{"HSET", closed_edges_hash, s, at.Unix()})
+	}
+	for _, s := range bundle.TwoWay {
+		pair, _ := ParsePair(s)
+		commands = append(commands, []interface{}{"SADD", two_way_set, twoWayPair(pair.From, pair.To).String()})
+	}
+	for name, expression := range bundle.CostFunctions {
+		commands = append(commands, []interface{}{"HSET", cost_functions_hash, name, expression})
+	}
+	for name, parent := range bundle.Regions {
+		commands = append(commands, []interface{}{"HSET", regions_hash, name, parent})
+	}
+	for name, region := range bundle.LocationRegions {
+		commands = append(commands, []interface{}{"HSET", location_regions_hash, name, region})
+	}
+	for name, joined := range locationTags {
+		commands = append(commands, []interface{}{"HSET", location_tags_hash, name, joined})
+	}
+	for name, cost := range visitCosts {
+		commands = append(commands, []interface{}{"HSET", visit_costs_hash, name, cost})
+	}
+	for _, pair := range bundle.Watched {
+		commands = append(commands, []interface{}{"SADD", watched_set, pair.String()})
+	}
+	for _, pair := range bundle.Critical {
+		commands = append(commands, []interface{}{"SADD", critical_set, pair.String()})
+	}
+	for _, name := range bundle.HotSources {
+		commands = append(commands, []interface{}{"SADD", hot_sources_set, name})
+	}
+	if bundle.Timezone != "" {
+		commands = append(commands, []interface{}{"SET", timezone_key, bundle.Timezone})
+	}
+	if bundle.Symmetric {
+		commands = append(commands, []interface{}{"SET", symmetric_key, "true"})
+	}
+	if bundle.DefaultWeight != nil {
+		commands = append(commands, []interface{}{"SET", default_weight_key, strconv.FormatFloat(*bundle.DefaultWeight, 'g', -1, 64)})
+	}
+
+	for _, command := range commands {
+		if _, err := rs.redis.Do(command[0].(string), command[1:]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,513 @@
+package routes
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"hash/fnv"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const queries_zset = "rest_project:queries"
+
+// How often the queries counted in memory are added to queries_zset, see MonitorQueryCounts
+const queryCountInterval = 10 * time.Second
+
+// Size of the route cache until SetCacheMaxBytes is called
+const DefaultCacheBytes = 64 << 20
+
+// An ordered from/to pair of locations
+type Pair struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (p Pair) String() string {
+	return p.From + "/" + p.To
+}
+
+// ParsePair reads a pair written as "<from>/<to>"; location names come from URL path segments so cannot contain '/'
+func ParsePair(s string) (Pair, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Pair{}, fmt.Errorf("%q is not of the form <from>/<to>", s)
+	}
+	return Pair{From: parts[0], To: parts[1]}, nil
+}
+
+func keyHash(key string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(key))
+	return hasher.Sum64()
+}
+
+// A count-min sketch of how often each pair is asked for, halved periodically so old popularity fades (TinyLFU)
+type frequencySketch struct {
+	rows      [4][]uint8
+	mask      uint64
+	additions int
+}
+
+const sketchWidth = 1 << 14
+
+func newFrequencySketch() *frequencySketch {
+	var ret frequencySketch
+	for i := range ret.rows {
+		ret.rows[i] = make([]uint8, sketchWidth)
+	}
+	ret.mask = sketchWidth - 1
+	return &ret
+}
+
+func (fs *frequencySketch) index(h uint64, row int) uint64 {
+	return (h + uint64(row)*(h>>32|1)) & fs.mask
+}
+
+func (fs *frequencySketch) increment(key string) {
+	h := keyHash(key)
+	for i := range fs.rows {
+		if c := &fs.rows[i][fs.index(h, i)]; *c < 15 {
+			*c++
+		}
+	}
+
+	fs.additions++
+	if fs.additions >= 10*sketchWidth {
+		fs.additions = 0
+		for i := range fs.rows {
+			for j := range fs.rows[i] {
+				fs.rows[i][j] /= 2
+			}
+		}
+	}
+}
+
+func (fs *frequencySketch) estimate(key string) uint8 {
+	h := keyHash(key)
+	ret := uint8(15)
+	for i := range fs.rows {
+		if c := fs.rows[i][fs.index(h, i)]; c < ret {
+			ret = c
+		}
+	}
+	return ret
+}
+
+type cacheEntry struct {
+	key    string
+	routes []Route
+	bytes  int64
+	hits   uint64
+}
+
+// An LRU cache of computed routes bounded by approximate size, which only admits a new entry
+// over the least recently used one when it has been asked for more often. It guards itself, so
+// that queries holding the store lock only for reading can use it.
+type routeCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	lru      *list.List
+	entries  map[string]*list.Element
+	sketch   *frequencySketch
+
+	hits, misses, evictions, rejections uint64
+}
+
+// Route cache effectiveness since startup
+type CacheStats struct {
+	Entries       int     `json:"entries"`
+	Bytes         int64   `json:"bytes"`
+	MaxBytes      int64   `json:"max_bytes"`
+	BytesPerEntry float64 `json:"bytes_per_entry"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	HitRatio      float64 `json:"hit_ratio"`
+	Evictions     uint64  `json:"evictions"`
+	Rejections    uint64  `json:"rejections"`
+}
+
+func newRouteCache(maxBytes int64) *routeCache {
+	return &routeCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		sketch:   newFrequencySketch(),
+	}
+}
+
+func (rc *routeCache) get(key string) ([]Route, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.sketch.increment(key)
+	if elem, ok := rc.entries[key]; ok {
+		rc.hits++
+		elem.Value.(*cacheEntry).hits++
+		rc.lru.MoveToFront(elem)
+		return elem.Value.(*cacheEntry).routes, true
+	}
+	rc.misses++
+	return nil, false
+}
+
+func (rc *routeCache) contains(key string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	_, ok := rc.entries[key]
+	return ok
+}
+
+func (rc *routeCache) put(key string, routes []Route) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry := &cacheEntry{key: key, routes: routes, bytes: routesBytes(key, routes)}
+	if entry.bytes > rc.maxBytes {
+		rc.rejections++
+		return
+	}
+
+	for rc.bytes+entry.bytes > rc.maxBytes {
+		victim := rc.lru.Back().Value.(*cacheEntry)
+		if rc.sketch.estimate(key) <= rc.sketch.estimate(victim.key) {
+			rc.rejections++
+			return
+		}
+		rc.evict(victim.key)
+		rc.evictions++
+	}
+
+	rc.entries[key] = rc.lru.PushFront(entry)
+	rc.bytes += entry.bytes
+}
+
+// Must be called with mu held
+func (rc *routeCache) evict(key string) {
+	if elem, ok := rc.entries[key]; ok {
+		rc.bytes -= elem.Value.(*cacheEntry).bytes
+		rc.lru.Remove(elem)
+		delete(rc.entries, key)
+	}
+}
+
+func (rc *routeCache) resize(maxBytes int64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.maxBytes = maxBytes
+	for rc.bytes > rc.maxBytes {
+		rc.evict(rc.lru.Back().Value.(*cacheEntry).key)
+		rc.evictions++
+	}
+}
+
+// Drops every entry, but keeps the statistics and access frequencies
+func (rc *routeCache) clear() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.bytes = 0
+	rc.lru.Init()
+	rc.entries = make(map[string]*list.Element)
+}
+
+func (rc *routeCache) stats() CacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	ret := CacheStats{
+		Entries:    len(rc.entries),
+		Bytes:      rc.bytes,
+		MaxBytes:   rc.maxBytes,
+		Hits:       rc.hits,
+		Misses:     rc.misses,
+		Evictions:  rc.evictions,
+		Rejections: rc.rejections,
+	}
+	if ret.Entries > 0 {
+		ret.BytesPerEntry = float64(ret.Bytes) / float64(ret.Entries)
+	}
+	if lookups := ret.Hits + ret.Misses; lookups > 0 {
+		ret.HitRatio = float64(ret.Hits) / float64(lookups)
+	}
+	return ret
+}
+
+// Every entry, most recently used first
+func (rc *routeCache) list() []CacheEntryInfo {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	ret := []CacheEntryInfo{}
+	for elem := rc.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*cacheEntry)
+		pair := cacheKeyPair(entry.key)
+		ret = append(ret, CacheEntryInfo{
+			Key:    entry.key,
+			From:   pair.From,
+			To:     pair.To,
+			Routes: len(entry.routes),
+			Bytes:  entry.bytes,
+			Hits:   entry.hits,
+		})
+	}
+	return ret
+}
+
+// Drops the entries whose keys match, returning how many went
+func (rc *routeCache) removeMatching(match func(key string) bool) int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	var removed []string
+	for key := range rc.entries {
+		if match(key) {
+			removed = append(removed, key)
+		}
+	}
+	for _, key := range removed {
+		rc.evict(key)
+	}
+	return len(removed)
+}
+
+// Must be called with the lock held, whenever the graph is about to be modified
+func (rs *RouteStore) changed() {
+	rs.revision++
+	rs.cache.clear()
+	rs.signalWatched()
+}
+
+// How a query uses the route cache, as a client asks with Cache-Control
+type CacheMode int
+
+const (
+	// Use cached routes if there are any, otherwise search and cache the result
+	CacheDefault CacheMode = iota
+	// Always search, then cache the result (no-cache)
+	CacheBypass
+	// Only use cached routes, failing with ErrNotCached if there are none (only-if-cached)
+	CacheOnly
+)
+
+var ErrNotCached = errors.New("the routes are not cached")
+
+// Must be called with the lock held, if only for reading, after checking both locations exist and resolving the options
+func (rs *RouteStore) cachedRoutesBetween(from, to string, opts RouteOptions) ([]Route, error) {
+	key := opts.cacheKey(from, to)
+	if opts.Cache != CacheBypass {
+		if routes, ok := rs.cache.get(key); ok {
+			return routes, nil
+		}
+	}
+	if opts.Cache == CacheOnly {
+		return nil, ErrNotCached
+	}
+
+	routes, err := rs.search(Location(from), Location(to), opts)
+	if err != nil {
+		return nil, err
+	}
+	rs.issueTokens(routes, opts)
+	rs.cache.put(key, routes)
+	return routes, nil
+}
+
+// SetCacheMaxBytes changes the approximate size limit of the route cache, evicting entries if it shrank
+func (rs *RouteStore) SetCacheMaxBytes(maxBytes int64) {
+	defer rs.lock("SetCacheMaxBytes")()
+
+	rs.cache.resize(maxBytes)
+}
+
+// GET  /admin/cache/stats/ : READ route cache hit ratio, evictions and size
+func (rs *RouteStore) CacheStats() CacheStats {
+	defer rs.rlock("CacheStats")()
+
+	return rs.cache.stats()
+}
+
+// One cached query, as GET /admin/cache/ lists it
+type CacheEntryInfo struct {
+	// The query, as <from>/<to>?algorithm=...
+	Key    string `json:"key"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Routes int    `json:"routes"`
+	Bytes  int64  `json:"bytes"`
+	// Lookups answered by this entry since it was cached
+	Hits uint64 `json:"hits"`
+}
+
+// The route cache's statistics and everything in it
+type CacheInspection struct {
+	Stats CacheStats `json:"stats"`
+	// Most recently used first
+	Entries []CacheEntryInfo `json:"entries"`
+}
+
+// The pair a cache key is for
+func cacheKeyPair(key string) Pair {
+	pair, _ := ParsePair(strings.SplitN(key, "?", 2)[0])
+	return pair
+}
+
+// GET  /admin/cache/ : READ every route cache entry with its size and hits, and the cache's statistics
+func (rs *RouteStore) InspectCache() CacheInspection {
+	defer rs.rlock("InspectCache")()
+
+	return CacheInspection{Stats: rs.cache.stats(), Entries: rs.cache.list()}
+}
+
+// DELETE /admin/cache/ (?from=<pattern>&to=<pattern> optional) : DELETE the cached routes between matching locations, or every one.
+// Patterns are as for path.Match, such as depot-*; an empty pattern matches anything. Returns how many entries went.
+// The cache is otherwise only cleared by changes made through the store, so this is for after editing Redis directly.
+func (rs *RouteStore) FlushCache(fromPattern, toPattern string) (int, error) {
+	for _, pattern := range []string{fromPattern, toPattern} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return 0, fmt.Errorf("bad pattern %q: %s", pattern, err)
+		}
+	}
+	matches := func(pattern, name string) bool {
+		ok, _ := path.Match(pattern, name)
+		return pattern == "" || ok
+	}
+
+	defer rs.rlock("FlushCache")()
+
+	return rs.cache.removeMatching(func(key string) bool {
+		pair := cacheKeyPair(key)
+		return matches(fromPattern, pair.From) && matches(toPattern, pair.To)
+	}), nil
+}
+
+// How often each pair was asked for since the counts were last added to Redis. Kept apart from the store lock,
+// so that counting a query needs neither the lock nor Redis.
+type queryCounts struct {
+	sync.Mutex
+	pending map[Pair]int64
+}
+
+func (rs *RouteStore) countQuery(from, to string) {
+	rs.queries.Lock()
+	defer rs.queries.Unlock()
+
+	rs.queries.pending[Pair{From: from, To: to}]++
+}
+
+// Must be called with the lock held; adds the queries counted since the last flush to Redis in one transaction,
+// keeping them for the next if it fails
+func (rs *RouteStore) flushQueryCounts() error {
+	rs.queries.Lock()
+	pending := rs.queries.pending
+	rs.queries.pending = make(map[Pair]int64)
+	rs.queries.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := rs.writeQueryCounts(pending)
+	if err != nil {
+		rs.queries.Lock()
+		for pair, n := range pending {
+			rs.queries.pending[pair] += n
+		}
+		rs.queries.Unlock()
+	}
+	return err
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) writeQueryCounts(pending map[Pair]int64) error {
+	if _, err := rs.redis.Do("MULTI"); err != nil {
+		return err
+	}
+	for pair, n := range pending {
+		if _, err := rs.redis.Do("ZINCRBY", queries_zset, n, pair.String()); err != nil {
+			rs.redis.Do("DISCARD")
+			return err
+		}
+	}
+	_, err := rs.redis.Do("EXEC")
+	return err
+}
+
+// MonitorQueryCounts adds the queries counted in memory to Redis every queryCountInterval, all at once, so that
+// queries do not each wait on Redis. Failures are logged, and the counts kept for the next try. It never returns.
+func (rs *RouteStore) MonitorQueryCounts() {
+	for range time.Tick(queryCountInterval) {
+		unlock := rs.lock("MonitorQueryCounts")
+		err := rs.flushQueryCounts()
+		unlock()
+		if err != nil {
+			log.Printf("Could not save query counts: %s\n", err)
+		}
+	}
+}
+
+// The n most frequently queried pairs, most popular first, counting those not yet added to Redis
+func (rs *RouteStore) TopQueries(n int) ([]Pair, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	defer rs.lock("TopQueries")()
+
+	if err := rs.flushQueryCounts(); err != nil {
+		return nil, err
+	}
+	members, err := redis.Strings(rs.redis.Do("ZREVRANGE", queries_zset, 0, n-1))
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Pair
+	for _, member := range members {
+		if pair, err := ParsePair(member); err == nil {
+			ret = append(ret, pair)
+		}
+	}
+	return ret, nil
+}
+
+// Warm computes and caches the routes for the given pairs plus the topN most queried pairs,
+// returning how many were cached. Pairs naming unknown locations are skipped.
+func (rs *RouteStore) Warm(pairs []Pair, topN int) (int, error) {
+	top, err := rs.TopQueries(topN)
+	if err != nil {
+		return 0, err
+	}
+	pairs = append(pairs, top...)
+
+	defer rs.rlock("Warm")()
+
+	opts, err := rs.resolveOptions(RouteOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	warmed := 0
+	for _, pair := range pairs {
+		if rs.graph.Node(Location(pair.From).ID()) == nil || rs.graph.Node(Location(pair.To).ID()) == nil {
+			continue
+		}
+		opts := rs.targetOptions(Location(pair.To), opts)
+		key := opts.cacheKey(pair.From, pair.To)
+		if !rs.cache.contains(key) {
+			if _, err := rs.cachedRoutesBetween(pair.From, pair.To, opts); err != nil {
+				return warmed, err
+			}
+			if rs.cache.contains(key) {
+				warmed++
+			}
+		}
+	}
+	return warmed, nil
+}
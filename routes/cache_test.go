@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Queries are counted in memory, and only reach Redis when flushed, as TopQueries does first
+func TestQueryCountsFlushToRedis(t *testing.T) {
+	conn := newMemoryRedis()
+	rs := New(conn)
+	for _, name := range []string{"A", "B"} {
+		if err := rs.AddLocation(name, nil, nil, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.AddRoutes("A", givenWeights(map[string]float64{"B": 1}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := rs.RoutesBetween("A", "B", RouteOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(conn.zsets[queries_zset]) != 0 {
+		t.Fatalf("queries should not reach Redis until flushed, got %v", conn.zsets[queries_zset])
+	}
+
+	top, err := rs.TopQueries(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(top, []Pair{{From: "A", To: "B"}}) {
+		t.Fatalf("expected A/B to be the top query, got %v", top)
+	}
+	if n := conn.zsets[queries_zset]["A/B"]; n != 3 {
+		t.Fatalf("expected A/B counted 3 times in Redis, got %g", n)
+	}
+}
@@ -0,0 +1,179 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// How much to send from one location to another, and whether it may be split between routes
+type CapacityRequest struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Demand float64 `json:"demand"`
+	Split  bool    `json:"split"`
+}
+
+// One route of a capacity-aware routing, and how much of the demand it carries
+type CapacityRoute struct {
+	Route  []string `json:"route"`
+	Weight float64  `json:"weight"`
+	Flow   float64  `json:"flow"`
+}
+
+// Where a demand would go without taking any edge past its capacity attribute
+type CapacityRouting struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Demand float64 `json:"demand"`
+	// Cheapest first
+	Routes []CapacityRoute `json:"routes"`
+	// How much of the demand no route had room for
+	Unrouted float64 `json:"unrouted"`
+	// The edges the demand fills to capacity; when it was refused, the edges of its shortest route that lack
+	// the capacity, with the flow they would have carried. By from then to.
+	Saturated []EdgeLoad `json:"saturated"`
+}
+
+// POST /maps/route/capacity/ (with JSON from, to, demand, split: bool optional) : READ route demand from <from> to <to> without
+// taking any edge past its capacity attribute; edges without one carry any amount. Unsplit, the demand takes the shortest route with
+// room for all of it, or is refused, with the edges that stop it on its shortest route. Split, it takes the shortest route with room
+// left, as much as that holds, then the next, which is greedy rather than the cheapest split, until it is all routed or no route has room.
+func (rs *RouteStore) RouteWithinCapacity(cr CapacityRequest) (CapacityRouting, error) {
+	if math.IsNaN(cr.Demand) || math.IsInf(cr.Demand, 0) || cr.Demand < 0 {
+		return CapacityRouting{}, fmt.Errorf("demand from %s to %s must be a non-negative number, not %g", cr.From, cr.To, cr.Demand)
+	}
+
+	unlock := rs.rlock("RouteWithinCapacity")
+	g, negativeEdges, precision, visitCosts := rs.copyGraph(), rs.negativeEdges, rs.precision, rs.copyVisitCosts()
+	capacities := make(map[[2]int64]float64)
+	for key, attributes := range rs.attributes {
+		if capacity, ok := attributes[capacityAttribute]; ok {
+			capacities[key] = capacity
+		}
+	}
+	unlock()
+
+	from, to := Location(cr.From), Location(cr.To)
+	for _, loc := range []Location{from, to} {
+		if g.Node(loc.ID()) == nil {
+			return CapacityRouting{}, fmt.Errorf("%s does not exist", loc)
+		}
+	}
+	if from == to {
+		return CapacityRouting{}, fmt.Errorf("the demand from %s to itself needs no route", from)
+	}
+	if negativeEdges > 0 {
+		return CapacityRouting{}, fmt.Errorf("capacity-aware routes cannot be found while there are negative edge weights")
+	}
+
+	ret := CapacityRouting{From: cr.From, To: cr.To, Demand: cr.Demand, Routes: []CapacityRoute{}, Unrouted: cr.Demand, Saturated: []EdgeLoad{}}
+	if cr.Demand == 0 {
+		return ret, nil
+	}
+	// Within rounding, so that float capacities do not leave endless slivers to route
+	epsilon := cr.Demand * 1e-9
+	residual := make(map[[2]int64]float64, len(capacities))
+	for key, capacity := range capacities {
+		residual[key] = capacity
+	}
+	// The room left on an edge, infinite without a capacity
+	room := func(u, v int64) float64 {
+		if r, ok := residual[edgeKey(u, v)]; ok {
+			return r
+		}
+		return math.Inf(1)
+	}
+	use := func(route []string, flow float64) {
+		for _, edge := range routeEdges(route) {
+			if _, ok := residual[edgeKey(edge[0], edge[1])]; ok {
+				residual[edgeKey(edge[0], edge[1])] -= flow
+			}
+		}
+	}
+	costed := withVisitCosts(g, visitCosts, from.ID())
+	shortest := func(need float64) []string {
+		routes := shortestRoutes(filteredGraph{costed, func(u, v int64) bool { return room(u, v) >= need }}, from, to, precision)
+		if len(routes) == 0 {
+			return nil
+		}
+		return routes[0].Route
+	}
+	weigh := func(route []string) float64 {
+		var weight float64
+		for i := 1; i < len(route); i++ {
+			w, _ := costed.Weight(Location(route[i-1]).ID(), Location(route[i]).ID())
+			weight += w
+		}
+		return roundWeight(weight, precision)
+	}
+
+	load := func(edge [2]int64, flow, capacity float64) EdgeLoad {
+		ret := EdgeLoad{From: nodeName(g.Node(edge[0])), To: nodeName(g.Node(edge[1])), Flow: roundWeight(flow, precision), Capacity: &capacity}
+		if capacity > 0 {
+			utilization := flow / capacity
+			ret.Utilization = &utilization
+		}
+		ret.Overloaded = flow > capacity+epsilon
+		return ret
+	}
+
+	if !cr.Split {
+		route := shortest(cr.Demand)
+		if route == nil {
+			// Refused: report what stops it on the route it would otherwise have taken
+			for _, edge := range routeEdges(shortest(0)) {
+				if capacity := room(edge[0], edge[1]); capacity < cr.Demand {
+					ret.Saturated = append(ret.Saturated, load(edge, cr.Demand, capacity))
+				}
+			}
+			sortLoads(ret.Saturated)
+			return ret, nil
+		}
+		ret.Routes = append(ret.Routes, CapacityRoute{Route: route, Weight: weigh(route), Flow: roundWeight(cr.Demand, precision)})
+		ret.Unrouted = 0
+		use(route, cr.Demand)
+	} else {
+		remaining := cr.Demand
+		for remaining > epsilon {
+			route := shortest(epsilon)
+			if route == nil {
+				break
+			}
+			flow := remaining
+			for _, edge := range routeEdges(route) {
+				flow = math.Min(flow, room(edge[0], edge[1]))
+			}
+			use(route, flow)
+			ret.Routes = append(ret.Routes, CapacityRoute{Route: route, Weight: weigh(route), Flow: roundWeight(flow, precision)})
+			remaining -= flow
+		}
+		ret.Unrouted = 0
+		if remaining > epsilon {
+			ret.Unrouted = roundWeight(remaining, precision)
+		}
+	}
+
+	for key, capacity := range capacities {
+		if r := residual[key]; r <= epsilon && r < capacity {
+			ret.Saturated = append(ret.Saturated, load(key, capacity-r, capacity))
+		}
+	}
+	sortLoads(ret.Saturated)
+	return ret, nil
+}
+
+// The ends of each edge of a route, by ID
+func routeEdges(route []string) [][2]int64 {
+	var ret [][2]int64
+	for i := 1; i < len(route); i++ {
+		ret = append(ret, [2]int64{Location(route[i-1]).ID(), Location(route[i]).ID()})
+	}
+	return ret
+}
+
+func sortLoads(loads []EdgeLoad) {
+	sort.Slice(loads, func(i, j int) bool {
+		return loads[i].From < loads[j].From || loads[i].From == loads[j].From && loads[i].To < loads[j].To
+	})
+}
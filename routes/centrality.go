@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/network"
+	"gonum.org/v1/gonum/graph/path"
+	"sort"
+)
+
+// PageRank's damping factor, and how little the ranks may change between iterations before it stops
+const (
+	pageRankDamping   = 0.85
+	pageRankTolerance = 1e-8
+)
+
+// How central one location is to the graph
+type LocationCentrality struct {
+	Name      string `json:"name"`
+	InDegree  int    `json:"in_degree"`
+	OutDegree int    `json:"out_degree"`
+	// How many shortest routes between other locations pass through this one, split between ties
+	Betweenness float64 `json:"betweenness"`
+	// The chance of being at this location after wandering the edges at random, ignoring weights
+	PageRank float64 `json:"pagerank"`
+}
+
+// Centrality of every location, most between first
+type Centrality struct {
+	Locations []LocationCentrality `json:"locations"`
+	// Whether this came from the cache rather than being computed for the request
+	Cached bool `json:"cached"`
+	// The graph revision it was computed for, which the cache holds it until
+	revision uint64
+}
+
+// GET  /maps/analytics/centrality/ (Cache-Control: no-cache|only-if-cached optional) : READ degree, betweenness and PageRank of
+// every location, most between first. The result is kept until the graph changes; CacheBypass recomputes it and CacheOnly
+// fails with ErrNotCached rather than compute it. Computing takes a shortest path search from every location, on a copy of
+// the graph so queries are not held up meanwhile.
+func (rs *RouteStore) Centrality(mode CacheMode) (Centrality, error) {
+	unlock := rs.rlock("Centrality")
+	cached, revision := rs.centrality, rs.revision
+	if cached != nil && cached.revision == revision && mode != CacheBypass {
+		unlock()
+		ret := *cached
+		ret.Cached = true
+		return ret, nil
+	}
+	if mode == CacheOnly {
+		unlock()
+		return Centrality{}, ErrNotCached
+	}
+	g, negativeEdges := rs.copyGraph(), rs.negativeEdges
+	unlock()
+
+	var shortest path.AllShortest
+	if negativeEdges > 0 {
+		var ok bool
+		if shortest, ok = path.FloydWarshall(g); !ok {
+			return Centrality{}, ErrNegativeCycle
+		}
+	} else {
+		shortest = path.DijkstraAllPaths(g)
+	}
+	betweenness := network.BetweennessWeighted(g, shortest)
+	// Weights are costs, so the walk picks among edges evenly rather than by weight
+	pageRank := network.PageRankSparse(struct{ graph.Directed }{g}, pageRankDamping, pageRankTolerance)
+
+	ret := Centrality{Locations: []LocationCentrality{}, revision: revision}
+	nodes := g.Nodes()
+	for nodes.Next() {
+		id := nodes.Node().ID()
+		ret.Locations = append(ret.Locations, LocationCentrality{
+			Name:        nodeName(nodes.Node()),
+			InDegree:    g.To(id).Len(),
+			OutDegree:   g.From(id).Len(),
+			Betweenness: betweenness[id],
+			PageRank:    pageRank[id],
+		})
+	}
+	sort.Slice(ret.Locations, func(i, j int) bool {
+		if ret.Locations[i].Betweenness != ret.Locations[j].Betweenness {
+			return ret.Locations[i].Betweenness > ret.Locations[j].Betweenness
+		}
+		return ret.Locations[i].Name < ret.Locations[j].Name
+	})
+
+	defer rs.lock("Centrality")()
+	if rs.revision == revision {
+		rs.centrality = &ret
+	}
+	return ret, nil
+}
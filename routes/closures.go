@@ -0,0 +1,175 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"log"
+	"sort"
+	"time"
+)
+
+// Closed edges, by "<from>/<to>", each with when it was closed as Unix seconds. Their weights stay in the hash of
+// their from location, like every other edge's.
+const closed_edges_hash = "rest_project:closed_edges"
+
+// An edge taken out of the graph, so out of routing, while it is closed
+type closedEdge struct {
+	from, to Location
+	weight   float64
+	at       time.Time
+}
+
+// A closed edge, as GET /maps/close/ lists it
+type Closure struct {
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Weight   float64   `json:"weight"`
+	ClosedAt time.Time `json:"closed_at"`
+}
+
+// Must be called once the graph is restored, before the hot sources; closures of edges that are not in it, such as
+// those of archived locations, stay in Redis but are not applied
+func (rs *RouteStore) restoreClosures() error {
+	stringMap, err := redis.StringMap(rs.redis.Do("HGETALL", closed_edges_hash))
+	if err != nil {
+		return err
+	}
+	for s, unix := range stringMap {
+		pair, err := ParsePair(s)
+		if err != nil {
+			// Such as the edge of a location named with a '/', before names were checked
+			log.Printf("Ignoring the closure of %q in Redis: %s\n", s, err.Error())
+			continue
+		}
+		var seconds int64
+		if _, err := fmt.Sscan(unix, &seconds); err != nil {
+			return fmt.Errorf("bad closing time for %s: %s", pair, err)
+		}
+		if rs.graph.HasEdgeFromTo(Location(pair.From).ID(), Location(pair.To).ID()) {
+			rs.closeEdge(Location(pair.From), Location(pair.To), time.Unix(seconds, 0))
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held; whether the edge from one location to the other is closed
+func (rs *RouteStore) isClosed(from, to Location) bool {
+	_, ok := rs.closed[edgeKey(from.ID(), to.ID())]
+	return ok
+}
+
+// Must be called with the lock held; whether there is an edge from one location to the other, open or closed
+func (rs *RouteStore) hasEdge(from, to Location) bool {
+	return rs.graph.HasEdgeFromTo(from.ID(), to.ID()) || rs.isClosed(from, to)
+}
+
+// Must be called with the lock held; the weight of the edge from one location to the other, open or closed
+func (rs *RouteStore) edgeWeight(from, to Location) (float64, bool) {
+	if c, ok := rs.closed[edgeKey(from.ID(), to.ID())]; ok {
+		return c.weight, true
+	}
+	return rs.graph.Weight(from.ID(), to.ID())
+}
+
+// Must be called with the lock held; the closed edges from the location id
+func (rs *RouteStore) closedFrom(id int64) []*closedEdge {
+	var ret []*closedEdge
+	for key, c := range rs.closed {
+		if key[0] == id {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// Must be called with the lock held; takes an open edge out of the graph, keeping its weight
+func (rs *RouteStore) closeEdge(from, to Location, at time.Time) {
+	weight, _ := rs.graph.Weight(from.ID(), to.ID())
+	if weight < 0 {
+		rs.negativeEdges--
+	}
+	rs.graph.RemoveEdge(from.ID(), to.ID())
+	rs.updateHot(func(tree *shortestPathTree) { tree.edgeRemoved(rs.graph, from.ID(), to.ID()) })
+	rs.closed[edgeKey(from.ID(), to.ID())] = &closedEdge{from: from, to: to, weight: weight, at: at}
+}
+
+// Must be called with the lock held, whenever a closed edge goes
+func (rs *RouteStore) removeClosure(from, to Location) error {
+	key := edgeKey(from.ID(), to.ID())
+	if _, ok := rs.closed[key]; !ok {
+		return nil
+	}
+	if _, err := rs.redis.Do("HDEL", closed_edges_hash, Pair{From: string(from), To: string(to)}.String()); err != nil {
+		return err
+	}
+	delete(rs.closed, key)
+	return nil
+}
+
+// GET  /maps/close/ : READ every closed edge, with its weight and when it was closed, in name order
+func (rs *RouteStore) Closures() []Closure {
+	defer rs.rlock("Closures")()
+
+	ret := []Closure{}
+	for _, c := range rs.closed {
+		// Those of archived locations wait for them
+		if rs.graph.Node(c.from.ID()) == nil || rs.graph.Node(c.to.ID()) == nil {
+			continue
+		}
+		ret = append(ret, Closure{From: string(c.from), To: string(c.to), Weight: c.weight, ClosedAt: c.at})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].From != ret[j].From {
+			return ret[i].From < ret[j].From
+		}
+		return ret[i].To < ret[j].To
+	})
+	return ret
+}
+
+// PUT  /maps/close/<from>/<to> : UPDATE close the edge from <from> to <to> without deleting it, such as for road works, so that
+// routes go around it until it is opened again. It keeps its weight, tags, attributes and modes, which can still be changed, and
+// deleting it or either end deletes it as usual, but a deleted location's closed edges are not kept in the trash. Only that
+// direction closes, even of a two-way edge. Closing a closed edge does nothing.
+func (rs *RouteStore) CloseEdge(fromStr, toStr string) error {
+	defer rs.lock("CloseEdge")()
+
+	from, to := Location(fromStr), Location(toStr)
+	if rs.isClosed(from, to) {
+		return nil
+	}
+	if from == to || !rs.graph.HasEdgeFromTo(from.ID(), to.ID()) {
+		return fmt.Errorf("%w from %s to %s", ErrNoEdge, from, to)
+	}
+	rs.changed()
+
+	at := time.Now().Truncate(time.Second)
+	if _, err := rs.redis.Do("HSET", closed_edges_hash, Pair{From: fromStr, To: toStr}.String(), at.Unix()); err != nil {
+		return err
+	}
+	rs.closeEdge(from, to, at)
+	return nil
+}
+
+// PUT  /maps/open/<from>/<to> : UPDATE open the closed edge from <from> to <to> again, with its weight as it is now
+func (rs *RouteStore) OpenEdge(fromStr, toStr string) error {
+	defer rs.lock("OpenEdge")()
+
+	from, to := Location(fromStr), Location(toStr)
+	c, ok := rs.closed[edgeKey(from.ID(), to.ID())]
+	// Nor are those of archived locations, until they are restored
+	if !ok || rs.graph.Node(from.ID()) == nil || rs.graph.Node(to.ID()) == nil {
+		return fmt.Errorf("the edge from %s to %s is not closed", from, to)
+	}
+	rs.changed()
+
+	if err := rs.removeClosure(from, to); err != nil {
+		return err
+	}
+	if c.weight < 0 {
+		rs.negativeEdges++
+	}
+	rs.graph.SetWeightedEdge(rs.graph.NewWeightedEdge(from, to, c.weight))
+	rs.updateHot(func(tree *shortestPathTree) { tree.edgeSet(rs.graph, from.ID(), to.ID(), false, 0, c.weight) })
+	return nil
+}
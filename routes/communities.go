@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/community"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+// The only community detection algorithm so far, and the default
+const Louvain = "louvain"
+
+// Locations grouped into clusters more densely connected inside than between
+type Communities struct {
+	Algorithm string `json:"algorithm"`
+	// How much denser the communities are inside than chance would have them, at most 1
+	Modularity float64 `json:"modularity"`
+	// Largest first, each in name order
+	Communities [][]string `json:"communities"`
+}
+
+// GET  /maps/analysis/communities/ (?algorithm=louvain&resolution=1 optional) : READ the locations grouped into communities by
+// the Louvain method, maximising directed modularity. Weights are ignored, so communities follow how the locations are linked.
+// A higher resolution gives more, smaller communities. The search is seeded the same way each time, so an unchanged graph
+// is grouped the same way, and runs on a copy of the graph so queries are not held up meanwhile.
+func (rs *RouteStore) Communities(algorithm string, resolution float64) (Communities, error) {
+	if algorithm == "" {
+		algorithm = Louvain
+	}
+	if algorithm != Louvain {
+		return Communities{}, fmt.Errorf("unknown community algorithm %q, expected %s", algorithm, Louvain)
+	}
+	if math.IsNaN(resolution) || math.IsInf(resolution, 0) || resolution <= 0 {
+		return Communities{}, fmt.Errorf("resolution must be a positive number, not %g", resolution)
+	}
+
+	unlock := rs.rlock("Communities")
+	g := rs.copyGraph()
+	unlock()
+
+	ret := Communities{Algorithm: algorithm, Communities: [][]string{}}
+	if g.Nodes().Len() == 0 {
+		return ret, nil
+	}
+	// Weights are costs, so would read as how strongly locations are tied if Modularize saw them
+	unweighted := struct{ graph.Directed }{g}
+	var structure [][]graph.Node
+	if g.Edges().Next() {
+		structure = community.Modularize(unweighted, resolution, rand.NewPCG(1, 0)).Communities()
+		ret.Modularity = community.Q(unweighted, structure, resolution)
+	} else {
+		// Modularize panics on a graph without edges, where each location is a community of its own
+		nodes := g.Nodes()
+		for nodes.Next() {
+			structure = append(structure, []graph.Node{nodes.Node()})
+		}
+	}
+	for _, nodes := range structure {
+		names := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			names = append(names, nodeName(node))
+		}
+		sort.Strings(names)
+		ret.Communities = append(ret.Communities, names)
+	}
+	sort.Slice(ret.Communities, func(i, j int) bool {
+		a, b := ret.Communities[i], ret.Communities[j]
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return a[0] < b[0]
+	})
+	return ret, nil
+}
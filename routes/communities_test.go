@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Every location must be in exactly one community, none empty, each in name order and the largest first
+func checkPartition(t *testing.T, rs *RouteStore, c Communities) {
+	t.Helper()
+	seen := make(map[string]bool)
+	for i, community := range c.Communities {
+		if len(community) == 0 {
+			t.Fatalf("community %d is empty", i)
+		}
+		if !sort.StringsAreSorted(community) {
+			t.Fatalf("community %d is out of order: %v", i, community)
+		}
+		if i > 0 && len(c.Communities[i-1]) < len(community) {
+			t.Fatalf("community %d is larger than the one before it", i)
+		}
+		for _, name := range community {
+			if seen[name] {
+				t.Fatalf("%s is in more than one community", name)
+			}
+			seen[name] = true
+		}
+	}
+	for _, name := range rs.GetLocations() {
+		if !seen[name] {
+			t.Fatalf("%s is in no community", name)
+		}
+	}
+	if len(seen) != len(rs.GetLocations()) {
+		t.Fatalf("%d locations in communities, but %d in the store", len(seen), len(rs.GetLocations()))
+	}
+}
+
+// Two rings joined by one edge, and a location with no edges, which is a community of its own
+func TestCommunitiesPartitionEveryLocation(t *testing.T) {
+	edges := map[string]map[string]float64{"lone": {}}
+	for _, ring := range []string{"a", "b"} {
+		for i := 0; i < 5; i++ {
+			from := fmt.Sprint(ring, i)
+			edges[from] = make(map[string]float64)
+			for j := 0; j < 5; j++ {
+				if i != j {
+					edges[from][fmt.Sprint(ring, j)] = 1
+				}
+			}
+		}
+	}
+	edges["a0"]["b0"] = 1
+	rs := offlineStore(t, edges)
+
+	c, err := rs.Communities("", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPartition(t, rs, c)
+	want := [][]string{{"a0", "a1", "a2", "a3", "a4"}, {"b0", "b1", "b2", "b3", "b4"}, {"lone"}}
+	if !reflect.DeepEqual(c.Communities, want) {
+		t.Fatalf("got %v, want %v", c.Communities, want)
+	}
+}
+
+// Without edges, each location is a community of its own
+func TestCommunitiesWithoutEdges(t *testing.T) {
+	rs := offlineStore(t, map[string]map[string]float64{"b": {}, "a": {}, "c": {}})
+	c, err := rs.Communities("", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]string{{"a"}, {"b"}, {"c"}}; !reflect.DeepEqual(c.Communities, want) || c.Modularity != 0 {
+		t.Fatalf("got %+v, want %v with no modularity", c, want)
+	}
+}
+
+// Random graphs, some without edges and most with locations that have none
+func TestCommunitiesPartitionEveryLocationRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		edges := make(map[string]map[string]float64)
+		n := 1 + r.Intn(30)
+		for from := 0; from < n; from++ {
+			edges[fmt.Sprint("n", from)] = make(map[string]float64)
+		}
+		for from := 0; from < n; from++ {
+			for to := 0; to < n; to++ {
+				if from != to && r.Float64() < 0.1 {
+					edges[fmt.Sprint("n", from)][fmt.Sprint("n", to)] = 1
+				}
+			}
+		}
+		rs := offlineStore(t, edges)
+		for _, resolution := range []float64{0.5, 1, 4} {
+			c, err := rs.Communities(Louvain, resolution)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkPartition(t, rs, c)
+		}
+	}
+}
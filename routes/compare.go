@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/path"
+)
+
+// The most pairs one comparison may ask about
+const MaxComparePairs = 1000
+
+// A what-if version of the graph: the graph as it is now with some edges added, reweighted or taken away.
+// The zero value is the graph as it is.
+type Scenario struct {
+	Name string `json:"name,omitempty"`
+	// Edges to add or reweight
+	Set []Edge `json:"set"`
+	// Edges to take away
+	Remove []Pair `json:"remove"`
+}
+
+// The best route in one scenario
+type ScenarioRoute struct {
+	Route  []string `json:"route"`
+	Weight float64  `json:"weight"`
+}
+
+// The best route between a pair in each scenario; a route is null where there is none
+type PairComparison struct {
+	From   string         `json:"from"`
+	To     string         `json:"to"`
+	Before *ScenarioRoute `json:"before"`
+	After  *ScenarioRoute `json:"after"`
+	// After's weight less before's; null unless both have a route
+	Delta *float64 `json:"delta"`
+}
+
+// Must be called with the lock held; a copy of the graph with the scenario applied, and how many of its weights are negative
+func (rs *RouteStore) scenarioGraph(scenario Scenario) (*adjacencyGraph, int, error) {
+	g, negativeEdges := rs.copyGraph(), rs.negativeEdges
+	for _, pair := range scenario.Remove {
+		from, to := Location(pair.From), Location(pair.To)
+		edge := g.WeightedEdge(from.ID(), to.ID())
+		if edge == nil {
+			return nil, 0, fmt.Errorf("there is no edge from %s to %s", from, to)
+		}
+		if edge.Weight() < 0 {
+			negativeEdges--
+		}
+		g.RemoveEdge(from.ID(), to.ID())
+	}
+	for _, edge := range scenario.Set {
+		from, to := Location(edge.From), Location(edge.To)
+		for _, loc := range []Location{from, to} {
+			if g.Node(loc.ID()) == nil {
+				return nil, 0, fmt.Errorf("%s does not exist", loc)
+			}
+		}
+		if from == to {
+			return nil, 0, fmt.Errorf("%s cannot have an edge to itself", from)
+		}
+		if err := rs.checkWeight(edge.From, edge.To, edge.Weight); err != nil {
+			return nil, 0, err
+		}
+		if old, ok := g.Weight(from.ID(), to.ID()); ok && old < 0 {
+			negativeEdges--
+		}
+		if edge.Weight < 0 {
+			negativeEdges++
+		}
+		g.SetWeightedEdge(g.NewWeightedEdge(from, to, rs.roundWeight(edge.Weight)))
+	}
+	return g, negativeEdges, nil
+}
+
+// bestRoute finds the best route in g, paying visitCosts, by Bellman-Ford if it has negative weights
+func bestRoute(ag *adjacencyGraph, negativeEdges int, visitCosts map[int64]float64, from, to Location, precision int) (*ScenarioRoute, error) {
+	g := withVisitCosts(ag, visitCosts, from.ID())
+	var routes []Route
+	if negativeEdges > 0 {
+		shortest, ok := path.BellmanFordFrom(from, g)
+		if !ok {
+			return nil, ErrNegativeCycle
+		}
+		nodes, weight := shortest.To(to.ID())
+		routes = pathsToRoutes([][]graph.Node{nodes}, weight)
+	} else {
+		routes = shortestRoutes(g, from, to, precision)
+	}
+	if len(routes) == 0 {
+		return nil, nil
+	}
+	return &ScenarioRoute{Route: routes[0].Route, Weight: roundWeight(routes[0].Weight, precision)}, nil
+}
+
+// POST /maps/compare/ (with JSON before: scenario, after: scenario, pairs: [{from, to}]) : READ the best route and weight
+// between each pair in both scenarios, and how much heavier the after route is. Scenarios are copies of the graph with
+// edges set or removed, for weighing up a change before making it; nothing is changed.
+func (rs *RouteStore) CompareScenarios(before, after Scenario, pairs []Pair) ([]PairComparison, error) {
+	if len(pairs) < 1 || len(pairs) > MaxComparePairs {
+		return nil, fmt.Errorf("pairs must number between 1 and %d", MaxComparePairs)
+	}
+
+	unlock := rs.rlock("CompareScenarios")
+	beforeGraph, beforeNegative, err := rs.scenarioGraph(before)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	afterGraph, afterNegative, err := rs.scenarioGraph(after)
+	precision, visitCosts := rs.precision, rs.copyVisitCosts()
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// The searches run on the copies, without holding up the store
+	ret := []PairComparison{}
+	for _, pair := range pairs {
+		from, to := Location(pair.From), Location(pair.To)
+		for _, loc := range []Location{from, to} {
+			if beforeGraph.Node(loc.ID()) == nil {
+				return nil, fmt.Errorf("%s does not exist", loc)
+			}
+		}
+
+		comparison := PairComparison{From: pair.From, To: pair.To}
+		if comparison.Before, err = bestRoute(beforeGraph, beforeNegative, visitCosts, from, to, precision); err != nil {
+			return nil, err
+		}
+		if comparison.After, err = bestRoute(afterGraph, afterNegative, visitCosts, from, to, precision); err != nil {
+			return nil, err
+		}
+		if comparison.Before != nil && comparison.After != nil {
+			delta := roundWeight(comparison.After.Weight-comparison.Before.Weight, precision)
+			comparison.Delta = &delta
+		}
+		ret = append(ret, comparison)
+	}
+	return ret, nil
+}
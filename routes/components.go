@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"gonum.org/v1/gonum/graph/topo"
+	"sort"
+)
+
+// GET  /maps/components/ : READ the strongly connected components, largest first, each in name order.
+// Every location in a component can reach every other; more than one component means some routes are impossible.
+func (rs *RouteStore) Components() [][]string {
+	defer rs.rlock("Components")()
+
+	ret := [][]string{}
+	for _, component := range topo.TarjanSCC(rs.graph) {
+		names := make([]string, 0, len(component))
+		for _, node := range component {
+			names = append(names, nodeName(node))
+		}
+		sort.Strings(names)
+		ret = append(ret, names)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if len(ret[i]) != len(ret[j]) {
+			return len(ret[i]) > len(ret[j])
+		}
+		return ret[i][0] < ret[j][0]
+	})
+	return ret
+}
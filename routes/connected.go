@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/traverse"
+)
+
+// Whether one location can reach another, and in how few edges
+type Connectivity struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Connected bool   `json:"connected"`
+	// The fewest edges on any route, which need not be the cheapest; absent when there is no route
+	Hops *int `json:"hops,omitempty"`
+}
+
+// GET  /maps/<from>/<to>/connected : READ whether there is any route from <from> to <to>, and the fewest edges on one.
+// This is a breadth-first search that ignores weights and keeps no routes, so is much cheaper than finding the shortest.
+func (rs *RouteStore) Connected(fromStr, toStr string) (Connectivity, error) {
+	defer rs.rlock("Connected")()
+
+	from, to := Location(fromStr), Location(toStr)
+	for _, loc := range []Location{from, to} {
+		if rs.graph.Node(loc.ID()) == nil {
+			return Connectivity{}, fmt.Errorf("%s does not exist", loc)
+		}
+	}
+
+	ret := Connectivity{From: fromStr, To: toStr}
+	var search traverse.BreadthFirst
+	search.Walk(rs.graph, from, func(n graph.Node, depth int) bool {
+		if n.ID() != to.ID() {
+			return false
+		}
+		ret.Connected, ret.Hops = true, &depth
+		return true
+	})
+	return ret, nil
+}
@@ -0,0 +1,158 @@
+package routes
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// How long one kind of operation has spent waiting for and holding the store lock
+type LockStats struct {
+	Operation    string  `json:"operation"`
+	Acquisitions uint64  `json:"acquisitions"`
+	WaitSeconds  float64 `json:"wait_seconds"`
+	HoldSeconds  float64 `json:"hold_seconds"`
+	MaxWait      float64 `json:"max_wait_seconds"`
+	MaxHold      float64 `json:"max_hold_seconds"`
+}
+
+// Kept apart from the store lock so that measuring it does not add to it
+type lockTracker struct {
+	sync.Mutex
+	stats map[string]*LockStats
+	// Waits or holds at least this long are logged; 0 turns logging off
+	logThreshold time.Duration
+}
+
+// Location locks striped over location IDs; two locations sharing a stripe only ever wait for each other needlessly
+const locationStripes = 64
+
+// lock takes the store lock on behalf of op, returning the function that releases it. It waits for every writer
+// holding location locks, and keeps any more from starting:
+//
+//	defer rs.lock("AddLocation")()
+func (rs *RouteStore) lock(op string) func() {
+	start := time.Now()
+	rs.writers.Lock()
+	rs.Lock()
+	acquired := time.Now()
+	revision := rs.revision
+
+	return func() {
+		// Only now is the change whole, and fit for SnapshotAt
+		if rs.revision != revision {
+			rs.keepSnapshot()
+		}
+		held := time.Since(acquired)
+		rs.Unlock()
+		rs.writers.Unlock()
+		rs.locks.record(op, acquired.Sub(start), held)
+	}
+}
+
+// lockLocations is lock for writers that only change edges between the given locations. Writers of disjoint
+// locations hold it at once, taking the store lock only for as long as it takes to change the graph, see apply.
+// Anything else they read they read under the store lock held for reading, and they write Redis on a connection of
+// their own, see writeConn.
+func (rs *RouteStore) lockLocations(op string, names ...string) func() {
+	var stripes []int
+	seen := make(map[int]bool)
+	for _, name := range names {
+		stripe := int(uint64(Location(name).ID()) % locationStripes)
+		if !seen[stripe] {
+			seen[stripe] = true
+			stripes = append(stripes, stripe)
+		}
+	}
+	// Always in the same order, so that two writers never wait for each other's
+	sort.Ints(stripes)
+
+	start := time.Now()
+	rs.writers.RLock()
+	for _, stripe := range stripes {
+		rs.stripes[stripe].Lock()
+	}
+	acquired := time.Now()
+
+	return func() {
+		held := time.Since(acquired)
+		for _, stripe := range stripes {
+			rs.stripes[stripe].Unlock()
+		}
+		rs.writers.RUnlock()
+		rs.locks.record(op, acquired.Sub(start), held)
+	}
+}
+
+// apply takes the store lock for a writer holding location locks to change the graph, keeping a snapshot of the
+// change as lock does
+func (rs *RouteStore) apply() func() {
+	rs.Lock()
+	revision := rs.revision
+
+	return func() {
+		if rs.revision != revision {
+			rs.keepSnapshot()
+		}
+		rs.Unlock()
+	}
+}
+
+// rlock is lock for methods that only read the graph and derived in-memory state; several can hold it at once
+func (rs *RouteStore) rlock(op string) func() {
+	start := time.Now()
+	rs.RLock()
+	acquired := time.Now()
+
+	return func() {
+		held := time.Since(acquired)
+		rs.RUnlock()
+		rs.locks.record(op, acquired.Sub(start), held)
+	}
+}
+
+func (lt *lockTracker) record(op string, wait, hold time.Duration) {
+	lt.Lock()
+	defer lt.Unlock()
+
+	stats, ok := lt.stats[op]
+	if !ok {
+		stats = &LockStats{Operation: op}
+		lt.stats[op] = stats
+	}
+	stats.Acquisitions++
+	stats.WaitSeconds += wait.Seconds()
+	stats.HoldSeconds += hold.Seconds()
+	if wait.Seconds() > stats.MaxWait {
+		stats.MaxWait = wait.Seconds()
+	}
+	if hold.Seconds() > stats.MaxHold {
+		stats.MaxHold = hold.Seconds()
+	}
+
+	if lt.logThreshold > 0 && (wait >= lt.logThreshold || hold >= lt.logThreshold) {
+		log.Printf("Store lock for %s waited %s, held %s\n", op, wait, hold)
+	}
+}
+
+// SetLockLogThreshold logs every store lock waited for or held at least this long; 0 turns logging off
+func (rs *RouteStore) SetLockLogThreshold(threshold time.Duration) {
+	rs.locks.Lock()
+	defer rs.locks.Unlock()
+
+	rs.locks.logThreshold = threshold
+}
+
+// LockStats reports store lock wait and hold times for each kind of operation, in operation order
+func (rs *RouteStore) LockStats() []LockStats {
+	rs.locks.Lock()
+	defer rs.locks.Unlock()
+
+	ret := []LockStats{}
+	for _, stats := range rs.locks.stats {
+		ret = append(ret, *stats)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Operation < ret[j].Operation })
+	return ret
+}
@@ -0,0 +1,111 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"math"
+)
+
+const (
+	coordinates_hash       = "rest_project:coordinates"
+	trash_coordinates_hash = trash_prefix + "coordinates"
+)
+
+// A position for a location, used by A* heuristics
+type Coordinates struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func (c Coordinates) String() string {
+	return fmt.Sprintf("%g,%g", c.Lat, c.Lon)
+}
+
+func parseCoordinates(s string) (Coordinates, error) {
+	var ret Coordinates
+	if _, err := fmt.Sscanf(s, "%g,%g", &ret.Lat, &ret.Lon); err != nil {
+		return ret, fmt.Errorf("bad coordinates %q: %s", s, err)
+	}
+	return ret, ret.validate()
+}
+
+func (c Coordinates) validate() error {
+	if math.IsNaN(c.Lat) || c.Lat < -90 || c.Lat > 90 {
+		return fmt.Errorf("latitude %g is not between -90 and 90", c.Lat)
+	}
+	if math.IsNaN(c.Lon) || c.Lon < -180 || c.Lon > 180 {
+		return fmt.Errorf("longitude %g is not between -180 and 180", c.Lon)
+	}
+	return nil
+}
+
+func getCoordinates(conn redis.Conn, key string) (map[string]Coordinates, error) {
+	stringMap, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]Coordinates)
+	for name, s := range stringMap {
+		if ret[name], err = parseCoordinates(s); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (rs *RouteStore) restoreCoordinates() error {
+	coordinates, err := getCoordinates(rs.redis, coordinates_hash)
+	if err != nil {
+		return err
+	}
+	for name, c := range coordinates {
+		if !rs.archived[name] {
+			rs.coordinates[Location(name).ID()] = c
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) removeCoordinates(name string) error {
+	if _, err := rs.redis.Do("HDEL", coordinates_hash, name); err != nil {
+		return err
+	}
+	delete(rs.coordinates, Location(name).ID())
+	return nil
+}
+
+// GET  /maps/coordinates/ : READ the coordinates of every location that has them
+func (rs *RouteStore) GetCoordinates() map[string]Coordinates {
+	defer rs.rlock("GetCoordinates")()
+
+	ret := make(map[string]Coordinates)
+	for id, c := range rs.coordinates {
+		if node := rs.graph.Node(id); node != nil {
+			ret[nodeName(node)] = c
+		}
+	}
+	return ret
+}
+
+// PUT  /maps/coordinates/<location> (with JSON lat: float, lon: float) : UPDATE set the coordinates of <location>
+func (rs *RouteStore) SetCoordinates(name string, c Coordinates) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	defer rs.lock("SetCoordinates")()
+
+	loc := Location(name)
+	if rs.graph.Node(loc.ID()) == nil {
+		return fmt.Errorf("%s does not exist", loc)
+	}
+	rs.changed()
+
+	if _, err := rs.redis.Do("HSET", coordinates_hash, name, c.String()); err != nil {
+		return err
+	}
+	rs.coordinates[loc.ID()] = c
+	return nil
+}
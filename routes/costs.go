@@ -0,0 +1,206 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/simple"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Cost function expressions, by name
+const cost_functions_hash = "rest_project:cost_functions"
+
+// One term of a cost function: coefficient times the named attribute, or a constant per edge
+// when the attribute is empty
+type costTerm struct {
+	coefficient float64
+	attribute   string
+	// Edges without the attribute count their weight rather than 0, as for weight profiles
+	orWeight bool
+}
+
+// A named way of costing edges from their attributes, such as 0.7*time + 0.3*toll_cost
+type CostFunction struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
+	terms      []costTerm
+}
+
+// ParseCostFunction reads an expression: a sum of terms, each an attribute name, a number times an
+// attribute name, or a number, which is added once per edge. weight is the edge's weight; attributes
+// an edge does not have count as 0. Numbers must not be negative, so neither can costs be, unless
+// weight is used while there are negative weights.
+func ParseCostFunction(name, expression string) (*CostFunction, error) {
+	if !attributeName.MatchString(name) {
+		return nil, fmt.Errorf("cost function name %q must be letters, digits and '_', not starting with a digit", name)
+	}
+	ret := &CostFunction{Name: name, Expression: expression}
+	for _, s := range strings.Split(expression, "+") {
+		var term costTerm
+		factors := strings.Split(s, "*")
+		if len(factors) > 2 {
+			return nil, fmt.Errorf("bad cost function term %q, expected <number>*<attribute>", strings.TrimSpace(s))
+		}
+		term.coefficient = 1
+		numbers := 0
+		for _, factor := range factors {
+			factor = strings.TrimSpace(factor)
+			if f, err := strconv.ParseFloat(factor, 64); err == nil {
+				if math.IsNaN(f) || math.IsInf(f, 0) || f < 0 {
+					return nil, fmt.Errorf("bad cost function term %q, numbers must not be negative", strings.TrimSpace(s))
+				}
+				term.coefficient *= f
+				numbers++
+			} else if factor == "weight" || attributeName.MatchString(factor) {
+				term.attribute = factor
+			} else {
+				return nil, fmt.Errorf("bad cost function term %q, expected <number>*<attribute>", strings.TrimSpace(s))
+			}
+		}
+		if numbers == len(factors) && len(factors) > 1 {
+			return nil, fmt.Errorf("bad cost function term %q, expected <number>*<attribute>", strings.TrimSpace(s))
+		}
+		ret.terms = append(ret.terms, term)
+	}
+	return ret, nil
+}
+
+// metricCostFunction makes the cost function that weighs each named attribute by its coefficient. Its
+// expression lists them in name order, so the same metric always gives the same expression.
+func metricCostFunction(metric map[string]float64) (*CostFunction, error) {
+	var names []string
+	for name, coefficient := range metric {
+		if name != "weight" && !attributeName.MatchString(name) {
+			return nil, fmt.Errorf("metric %q must be an attribute name or weight", name)
+		}
+		if math.IsNaN(coefficient) || math.IsInf(coefficient, 0) || coefficient < 0 {
+			return nil, fmt.Errorf("metric %s must have a non-negative coefficient, not %g", name, coefficient)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := &CostFunction{}
+	var terms []string
+	for _, name := range names {
+		ret.terms = append(ret.terms, costTerm{coefficient: metric[name], attribute: name})
+		terms = append(terms, strconv.FormatFloat(metric[name], 'g', -1, 64)+"*"+name)
+	}
+	ret.Expression = strings.Join(terms, " + ")
+	return ret, nil
+}
+
+// cost is what the edge with the given weight and attributes costs
+func (c *CostFunction) cost(weight float64, attributes map[string]float64) float64 {
+	var ret float64
+	for _, term := range c.terms {
+		switch term.attribute {
+		case "":
+			ret += term.coefficient
+		case "weight":
+			ret += term.coefficient * weight
+		default:
+			value, ok := attributes[term.attribute]
+			if !ok && term.orWeight {
+				value = weight
+			}
+			ret += term.coefficient * value
+		}
+	}
+	return ret
+}
+
+func (rs *RouteStore) restoreCostFunctions() error {
+	stringMap, err := redis.StringMap(rs.redis.Do("HGETALL", cost_functions_hash))
+	if err != nil {
+		return err
+	}
+	for name, expression := range stringMap {
+		c, err := ParseCostFunction(name, expression)
+		if err != nil {
+			return err
+		}
+		rs.costs[name] = c
+	}
+	return nil
+}
+
+// GET  /admin/costs/ : READ every named cost function, in name order
+func (rs *RouteStore) CostFunctions() []CostFunction {
+	defer rs.rlock("CostFunctions")()
+
+	ret := []CostFunction{}
+	for _, c := range rs.costs {
+		ret = append(ret, *c)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// PUT  /admin/costs/<name> (with JSON expression: string) : UPDATE define or replace the cost function <name>
+func (rs *RouteStore) DefineCostFunction(name, expression string) error {
+	c, err := ParseCostFunction(name, expression)
+	if err != nil {
+		return err
+	}
+
+	defer rs.lock("DefineCostFunction")()
+
+	if _, err := rs.redis.Do("HSET", cost_functions_hash, name, expression); err != nil {
+		return err
+	}
+	// Cached routes may have been costed by the old definition
+	rs.changed()
+	rs.costs[name] = c
+	return nil
+}
+
+// DELETE /admin/costs/<name> : DELETE the cost function <name>
+func (rs *RouteStore) RemoveCostFunction(name string) error {
+	defer rs.lock("RemoveCostFunction")()
+
+	if _, ok := rs.costs[name]; !ok {
+		return fmt.Errorf("unknown cost function %q", name)
+	}
+	if _, err := rs.redis.Do("HDEL", cost_functions_hash, name); err != nil {
+		return err
+	}
+	rs.changed()
+	delete(rs.costs, name)
+	return nil
+}
+
+// A graph whose edges weigh what a cost function makes of them
+type costGraph struct {
+	graph.WeightedDirected
+	cost       *CostFunction
+	attributes map[[2]int64]map[string]float64
+}
+
+func (g costGraph) Weight(xid, yid int64) (float64, bool) {
+	w, ok := g.WeightedDirected.Weight(xid, yid)
+	if !ok || xid == yid {
+		return w, ok
+	}
+	return g.cost.cost(w, g.attributes[edgeKey(xid, yid)]), true
+}
+
+func (g costGraph) Edge(uid, vid int64) graph.Edge {
+	if e := g.WeightedEdge(uid, vid); e != nil {
+		return e
+	}
+	return nil
+}
+
+func (g costGraph) WeightedEdge(uid, vid int64) graph.WeightedEdge {
+	e := g.WeightedDirected.WeightedEdge(uid, vid)
+	if e == nil {
+		return nil
+	}
+	w, _ := g.Weight(uid, vid)
+	return simple.WeightedEdge{F: e.From(), T: e.To(), W: w}
+}
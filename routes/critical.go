@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"gonum.org/v1/gonum/graph/topo"
+	"log"
+	"sort"
+	"time"
+)
+
+const critical_set = "rest_project:critical"
+
+type critical struct {
+	connected bool
+	revision  uint64
+	// When the pair last lost its route; zero while it has one
+	disconnectedAt time.Time
+}
+
+// Whether a critical pair has any route at all, whatever its weight
+type CriticalPair struct {
+	From           string     `json:"from"`
+	To             string     `json:"to"`
+	Connected      bool       `json:"connected"`
+	DisconnectedAt *time.Time `json:"disconnected_at,omitempty"`
+}
+
+func (c *critical) report(pair Pair) CriticalPair {
+	ret := CriticalPair{From: pair.From, To: pair.To, Connected: c.connected}
+	if !c.disconnectedAt.IsZero() {
+		disconnectedAt := c.disconnectedAt
+		ret.DisconnectedAt = &disconnectedAt
+	}
+	return ret
+}
+
+// Must be called with the lock held; brings a critical pair up to date with the graph, logging when it loses its route
+func (rs *RouteStore) refreshCritical(pair Pair, c *critical) {
+	if c.revision == rs.revision {
+		return
+	}
+	c.revision = rs.revision
+
+	from, to := rs.graph.Node(Location(pair.From).ID()), rs.graph.Node(Location(pair.To).ID())
+	connected := from != nil && to != nil && topo.PathExistsIn(rs.graph, from, to)
+	switch {
+	case c.connected && !connected:
+		log.Printf("Critical pair %s no longer has a route\n", pair)
+		c.disconnectedAt = time.Now()
+	case !c.connected && connected:
+		c.disconnectedAt = time.Time{}
+	}
+	c.connected = connected
+}
+
+// Must be called with the lock held; a critical pair checked against the graph as it is, which counts as
+// disconnected from now if it has no route
+func (rs *RouteStore) newCritical(pair Pair) *critical {
+	c := &critical{revision: rs.revision - 1}
+	rs.refreshCritical(pair, c)
+	if !c.connected {
+		c.disconnectedAt = time.Now()
+	}
+	return c
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) refreshCriticalPairs() {
+	for pair, c := range rs.critical {
+		rs.refreshCritical(pair, c)
+	}
+}
+
+func (rs *RouteStore) restoreCritical() error {
+	members, err := redis.Strings(rs.redis.Do("SMEMBERS", critical_set))
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		pair, err := ParsePair(member)
+		if err != nil {
+			return err
+		}
+		rs.critical[pair] = rs.newCritical(pair)
+	}
+	return nil
+}
+
+// Must be called with the lock held; the critical pairs in name order, all of them or only those with no route
+func (rs *RouteStore) sortedCritical(disconnectedOnly bool) []CriticalPair {
+	ret := []CriticalPair{}
+	for pair, c := range rs.critical {
+		rs.refreshCritical(pair, c)
+		if !disconnectedOnly || !c.connected {
+			ret = append(ret, c.report(pair))
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].From != ret[j].From {
+			return ret[i].From < ret[j].From
+		}
+		return ret[i].To < ret[j].To
+	})
+	return ret
+}
+
+// GET  /maps/critical/ : READ every critical pair and whether it has a route
+func (rs *RouteStore) GetCritical() []CriticalPair {
+	defer rs.lock("GetCritical")()
+
+	return rs.sortedCritical(false)
+}
+
+// GET  /maps/critical/disconnected/ : READ the critical pairs that have no route, and since when
+func (rs *RouteStore) DisconnectedCritical() []CriticalPair {
+	defer rs.lock("DisconnectedCritical")()
+
+	return rs.sortedCritical(true)
+}
+
+// PUT  /maps/critical/<from>/<to> : UPDATE mark the pair from <from> to <to> as critical, so losing every route
+// between them is reported. Unlike watching, only whether there is a route matters, not its weight.
+func (rs *RouteStore) MarkCritical(pair Pair) error {
+	defer rs.lock("MarkCritical")()
+
+	if rs.graph.Node(Location(pair.From).ID()) == nil {
+		return fmt.Errorf("%s does not exist", pair.From)
+	}
+	if rs.graph.Node(Location(pair.To).ID()) == nil {
+		return fmt.Errorf("%s does not exist", pair.To)
+	}
+	if _, ok := rs.critical[pair]; ok {
+		return fmt.Errorf("%s is already critical", pair)
+	}
+
+	if _, err := rs.redis.Do("SADD", critical_set, pair.String()); err != nil {
+		return err
+	}
+	rs.critical[pair] = rs.newCritical(pair)
+	return nil
+}
+
+// DELETE /maps/critical/<from>/<to> : DELETE stop treating the pair from <from> to <to> as critical
+func (rs *RouteStore) UnmarkCritical(pair Pair) error {
+	defer rs.lock("UnmarkCritical")()
+
+	if _, ok := rs.critical[pair]; !ok {
+		return fmt.Errorf("%s is not critical", pair)
+	}
+
+	if _, err := rs.redis.Do("SREM", critical_set, pair.String()); err != nil {
+		return err
+	}
+	delete(rs.critical, pair)
+	return nil
+}
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph/topo"
+	"sort"
+)
+
+// The most cycles that can be asked for at once, since a graph can have exponentially many
+const MaxCycles = 1000
+
+// Whether the graph has cycles, and some of them
+type CycleReport struct {
+	Acyclic bool `json:"acyclic"`
+	// Each as the locations around it, starting from the one first by name; the last leads back to the first
+	Cycles [][]string `json:"cycles"`
+	// Whether there are more cycles than were listed
+	Truncated bool `json:"truncated"`
+}
+
+// GET  /maps/cycles/ (?max=100 optional) : READ whether the graph is acyclic, and up to max of its simple cycles.
+// Cycles are listed in order of the name of the location they start from, then by the names along them.
+func (rs *RouteStore) Cycles(max int) (CycleReport, error) {
+	if max < 1 || max > MaxCycles {
+		return CycleReport{}, fmt.Errorf("max must be between 1 and %d", MaxCycles)
+	}
+
+	defer rs.rlock("Cycles")()
+
+	// Every cycle lies within one strongly connected component, and starts from its first location by name
+	component := make(map[string]int)
+	var names []string
+	for i, scc := range topo.TarjanSCC(rs.graph) {
+		for _, node := range scc {
+			component[nodeName(node)] = i
+			names = append(names, nodeName(node))
+		}
+	}
+	sort.Strings(names)
+
+	ret := CycleReport{Cycles: [][]string{}}
+	for _, start := range names {
+		on := map[string]bool{start: true}
+		path := []string{start}
+		// visit extends the path from its last location, and is false once max cycles have been found
+		var visit func(string) bool
+		visit = func(name string) bool {
+			for _, next := range rs.successorNames(name) {
+				if component[next] != component[start] || next < start {
+					continue
+				}
+				if next == start {
+					if len(ret.Cycles) == max {
+						ret.Truncated = true
+						return false
+					}
+					ret.Cycles = append(ret.Cycles, append([]string{}, path...))
+					continue
+				}
+				if on[next] {
+					continue
+				}
+				on[next] = true
+				path = append(path, next)
+				if !visit(next) {
+					return false
+				}
+				path = path[:len(path)-1]
+				on[next] = false
+			}
+			return true
+		}
+		if !visit(start) {
+			break
+		}
+	}
+	ret.Acyclic = len(ret.Cycles) == 0
+	return ret, nil
+}
+
+// Must be called with the lock held; the locations with an edge from name, in name order
+func (rs *RouteStore) successorNames(name string) []string {
+	var ret []string
+	to := rs.graph.From(Location(name).ID())
+	for to.Next() {
+		ret = append(ret, nodeName(to.Node()))
+	}
+	sort.Strings(ret)
+	return ret
+}
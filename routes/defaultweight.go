@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"math"
+	"strconv"
+)
+
+// The weight of edges added without one, when the map has a default weight
+const default_weight_key = "rest_project:default_weight"
+
+func (rs *RouteStore) restoreDefaultWeight() error {
+	weight, err := redis.Float64(rs.redis.Do("GET", default_weight_key))
+	if err == redis.ErrNil {
+		rs.defaultWeight = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+	rs.defaultWeight = &weight
+	return nil
+}
+
+// GET  /admin/default-weight/ : READ the weight of edges added without one, or null if they need one
+func (rs *RouteStore) DefaultWeight() *float64 {
+	defer rs.rlock("DefaultWeight")()
+
+	if rs.defaultWeight == nil {
+		return nil
+	}
+	weight := *rs.defaultWeight
+	return &weight
+}
+
+// PUT  /admin/default-weight/ (with JSON default_weight: number or null) : UPDATE set the weight of edges added with a null weight,
+// or with null require a weight again. A weight of zero is a weight like any other, and is never replaced. In distance mode the
+// distance is used where both ends have coordinates, and the default weight only where they do not. Like given weights, the default
+// weight is checked and rounded per edge as it is used, so in integer mode a fractional one fails when an edge needs it.
+func (rs *RouteStore) SetDefaultWeight(weight *float64) error {
+	if weight != nil && (math.IsNaN(*weight) || math.IsInf(*weight, 0)) {
+		return fmt.Errorf("the default weight must be a finite number, not %g", *weight)
+	}
+
+	defer rs.lock("SetDefaultWeight")()
+
+	var err error
+	if weight == nil {
+		_, err = rs.redis.Do("DEL", default_weight_key)
+	} else {
+		_, err = rs.redis.Do("SET", default_weight_key, strconv.FormatFloat(*weight, 'g', -1, 64))
+	}
+	if err != nil {
+		return err
+	}
+	if weight == nil {
+		rs.defaultWeight = nil
+	} else {
+		copied := *weight
+		rs.defaultWeight = &copied
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"fmt"
+)
+
+// What alternative routes may not share, for redundancy planning
+type Disjointness string
+
+const (
+	// Routes may pass through the same locations, but never use the same edge
+	DisjointEdges Disjointness = "edges"
+	// Routes share no location but the ends, so no edge either
+	DisjointNodes Disjointness = "nodes"
+)
+
+// The most disjoint routes one query returns; each costs a search
+const MaxDisjointRoutes = 10
+
+// ParseDisjointness checks s names a kind of disjointness; the empty string means routes may share anything
+func ParseDisjointness(s string) (Disjointness, error) {
+	switch d := Disjointness(s); d {
+	case "", DisjointEdges, DisjointNodes:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown disjointness %q, expected %s or %s", s, DisjointEdges, DisjointNodes)
+}
+
+// Must be called with the lock held, after resolveOptions. Starting from first, the routes for the query
+// as it is, each further route is the best that avoids whatever the routes before it used, until there
+// is none or MaxDisjointRoutes are found. This is greedy, so a cheap first route can use up what two
+// others would have needed; it finds disjoint routes, not always the most of them.
+func (rs *RouteStore) disjointRoutes(from, to string, first []Route, opts RouteOptions) ([]Route, error) {
+	var ret []Route
+	routes := first
+	for len(routes) > 0 && len(ret) < MaxDisjointRoutes {
+		route := routes[0]
+		ret = append(ret, route)
+		if len(route.Route) < 2 {
+			break
+		}
+
+		avoid := append([]string{}, opts.Avoid...)
+		for i := 1; i < len(route.Route); i++ {
+			if opts.Disjoint == DisjointEdges || len(route.Route) == 2 {
+				avoid = append(avoid, Pair{From: route.Route[i-1], To: route.Route[i]}.String())
+			} else if i < len(route.Route)-1 {
+				avoid = append(avoid, route.Route[i])
+			}
+		}
+		// Sorted, as resolveOptions sorts them, so each pass has a cache entry of its own
+		opts.Avoid = sortedCopy(avoid)
+
+		var err error
+		if routes, err = rs.cachedRoutesBetween(from, to, opts); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+)
+
+// SetDistanceWeights turns distance mode on or off. In distance mode an edge added without a weight weighs
+// the great-circle distance between its ends in kilometres, which both must have coordinates for; in integer
+// mode the distance is rounded to whole kilometres. Otherwise every edge needs a weight.
+func (rs *RouteStore) SetDistanceWeights(on bool) {
+	defer rs.lock("SetDistanceWeights")()
+
+	rs.distanceWeights = on
+}
+
+// Whether distance mode is on
+func (rs *RouteStore) DistanceWeights() bool {
+	defer rs.rlock("DistanceWeights")()
+
+	return rs.distanceWeights
+}
+
+// givenWeights is routes with every weight given, for AddLocation and AddRoutes
+func givenWeights(routes map[string]float64) map[string]RouteSpec {
+	ret := make(map[string]RouteSpec, len(routes))
+	for to, weight := range routes {
+		weight := weight
+		ret[to] = RouteSpec{Weight: &weight}
+	}
+	return ret
+}
+
+// Must be called with the lock held, before changing anything. resolveWeights gives each route from name
+// without a weight the distance between its ends, as SetDistanceWeights describes, or failing that the
+// map's default weight, see SetDefaultWeight.
+func (rs *RouteStore) resolveWeights(name string, routes map[string]RouteSpec) (map[string]float64, error) {
+	ret := make(map[string]float64, len(routes))
+	for to, route := range routes {
+		if weight := route.Weight; weight != nil {
+			ret[to] = *weight
+			continue
+		}
+		if rs.distanceWeights {
+			distance, err := rs.distanceWeight(name, to)
+			if err == nil {
+				ret[to] = distance
+				continue
+			}
+			if rs.defaultWeight == nil {
+				return nil, err
+			}
+		} else if rs.defaultWeight == nil {
+			return nil, fmt.Errorf("the edge from %s to %s has no weight", name, to)
+		}
+		ret[to] = *rs.defaultWeight
+	}
+	return ret, nil
+}
+
+// Must be called with the lock held; the weight of the edge from name to to in distance mode
+func (rs *RouteStore) distanceWeight(name, to string) (float64, error) {
+	from, ok := rs.coordinates[Location(name).ID()]
+	if !ok {
+		return 0, fmt.Errorf("the edge from %s to %s has no weight, and %s has no coordinates to find one", name, to, name)
+	}
+	at, ok := rs.coordinates[Location(to).ID()]
+	if !ok {
+		return 0, fmt.Errorf("the edge from %s to %s has no weight, and %s has no coordinates to find one", name, to, to)
+	}
+	ret := haversine(from, at)
+	if rs.integerWeights {
+		ret = math.Round(ret)
+	}
+	return ret, nil
+}
@@ -0,0 +1,371 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many divergences a dual-write report keeps, most recent last
+const maxRecentDivergences = 100
+
+// The commands the store writes with; every other command it gives Redis only reads
+var writeCommands = map[string]bool{
+	"SET": true, "DEL": true, "INCR": true, "EXPIRE": true,
+	"SADD": true, "SREM": true,
+	"HSET": true, "HDEL": true, "HINCRBY": true,
+	"RPUSH": true, "LTRIM": true,
+	"ZADD": true, "ZINCRBY": true, "ZREM": true,
+}
+
+// A write the new backend answered differently from the old, which means their keys had already drifted apart, or
+// which it failed
+type Divergence struct {
+	At        time.Time `json:"at"`
+	Command   string    `json:"command"`
+	Key       string    `json:"key"`
+	Primary   string    `json:"primary"`
+	Secondary string    `json:"secondary"`
+}
+
+// What dual writing has done since the server started
+type DualWriteReport struct {
+	Since time.Time `json:"since"`
+	// Writes mirrored to the new backend, those it failed, and those it answered differently
+	Writes      int64 `json:"writes"`
+	Failures    int64 `json:"failures"`
+	Divergences int64 `json:"divergences"`
+	// The most recent failures and divergences, oldest first
+	Recent []Divergence `json:"recent"`
+}
+
+// DualWriteStats counts what every DualWriteConn given it mirrors, so one report covers every map's connection
+type DualWriteStats struct {
+	mu     sync.Mutex
+	report DualWriteReport
+}
+
+func NewDualWriteStats() *DualWriteStats {
+	return &DualWriteStats{report: DualWriteReport{Since: time.Now(), Recent: []Divergence{}}}
+}
+
+func (s *DualWriteStats) wrote(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.report.Writes += int64(n)
+}
+
+func (s *DualWriteStats) diverged(d Divergence, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if failed {
+		s.report.Failures++
+	} else {
+		s.report.Divergences++
+	}
+	s.report.Recent = append(s.report.Recent, d)
+	if len(s.report.Recent) > maxRecentDivergences {
+		s.report.Recent = s.report.Recent[len(s.report.Recent)-maxRecentDivergences:]
+	}
+}
+
+// GET  /admin/dual-write/ : READ how many writes were mirrored to the new backend, how many it failed or answered differently, and the most recent of those
+func (s *DualWriteStats) Report() DualWriteReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := s.report
+	ret.Recent = append([]Divergence{}, s.report.Recent...)
+	return ret
+}
+
+// A command queued in a transaction, to name it if its reply diverges
+type queuedCommand struct {
+	command, key string
+}
+
+// DualWriteConn is a connection to the old backend, the primary, that also sends every write to a new backend, the
+// secondary, which must speak the Redis protocol, for migrating to it without downtime. The primary answers every
+// command, so the secondary failing or lagging never fails a request; its failures, and writes it answers differently,
+// are counted in stats and logged. Transactions are mirrored as transactions. Keys written before dual writing began
+// must be copied across separately; VerifyDualWrite finds any that differ.
+type DualWriteConn struct {
+	redis.Conn
+	secondary redis.Conn
+	stats     *DualWriteStats
+
+	// Whether a transaction is open on the primary, whether it is mirrored in one on the secondary, and the commands
+	// queued in that
+	multi       bool
+	transaction bool
+	queued      []queuedCommand
+}
+
+func NewDualWriteConn(primary, secondary redis.Conn, stats *DualWriteStats) *DualWriteConn {
+	return &DualWriteConn{Conn: primary, secondary: secondary, stats: stats}
+}
+
+func (c *DualWriteConn) Close() error {
+	c.secondary.Close()
+	return c.Conn.Close()
+}
+
+func (c *DualWriteConn) Do(command string, args ...interface{}) (interface{}, error) {
+	reply, err := c.Conn.Do(command, args...)
+
+	name, key := strings.ToUpper(command), ""
+	if len(args) > 0 {
+		key = fmt.Sprint(args[0])
+	}
+	switch {
+	case name == "MULTI":
+		if err == nil {
+			c.multi, c.queued = true, nil
+			c.transaction = c.mirror(name, "", nil, args)
+		}
+	case name == "EXEC" || name == "DISCARD":
+		c.multi = false
+		if c.transaction {
+			c.transaction = false
+			secondary, secondaryErr := c.secondary.Do(name)
+			if name == "EXEC" {
+				c.compareExec(reply, err, secondary, secondaryErr)
+			} else if secondaryErr != nil {
+				c.failed(name, "", secondaryErr)
+			}
+		}
+		c.queued = nil
+	case c.multi:
+		// A transaction the secondary failed is not mirrored command by command, which would apply it there even if
+		// it were discarded; that it failed is counted already
+		if !c.transaction {
+			break
+		}
+		// Reads are queued too, so that the replies to EXEC line up; they come with it
+		if err != nil || !c.mirror(name, key, nil, args) {
+			c.secondary.Do("DISCARD")
+			c.transaction, c.queued = false, nil
+		} else {
+			c.queued = append(c.queued, queuedCommand{command: name, key: key})
+		}
+	case writeCommands[name] && err == nil:
+		if c.mirror(name, key, reply, args) {
+			c.stats.wrote(1)
+		}
+	}
+	return reply, err
+}
+
+// mirror sends a command to the secondary, comparing its reply with the primary's unless that is nil, and returns
+// whether it succeeded
+func (c *DualWriteConn) mirror(command, key string, primary interface{}, args []interface{}) bool {
+	secondary, err := c.secondary.Do(command, args...)
+	if err != nil {
+		c.failed(command, key, err)
+		return false
+	}
+	if primary != nil && !reflect.DeepEqual(primary, secondary) {
+		c.divergedReply(command, key, primary, secondary)
+	}
+	return true
+}
+
+func (c *DualWriteConn) compareExec(primary interface{}, primaryErr error, secondary interface{}, secondaryErr error) {
+	if primaryErr != nil {
+		// Nothing was written to the primary; there is nothing the secondary should match
+		return
+	}
+	if secondaryErr != nil {
+		c.failed("EXEC", "", secondaryErr)
+		return
+	}
+	writes := 0
+	for _, queued := range c.queued {
+		if writeCommands[queued.command] {
+			writes++
+		}
+	}
+	c.stats.wrote(writes)
+	primaries, _ := primary.([]interface{})
+	secondaries, _ := secondary.([]interface{})
+	for i, queued := range c.queued {
+		var p, s interface{}
+		if i < len(primaries) {
+			p = primaries[i]
+		}
+		if i < len(secondaries) {
+			s = secondaries[i]
+		}
+		if !reflect.DeepEqual(p, s) {
+			c.divergedReply(queued.command, queued.key, p, s)
+		}
+	}
+}
+
+func (c *DualWriteConn) failed(command, key string, err error) {
+	log.Printf("Dual write of %s %s failed on the new backend: %s\n", command, key, err.Error())
+	c.stats.diverged(Divergence{At: time.Now(), Command: command, Key: key, Secondary: err.Error()}, true)
+}
+
+func (c *DualWriteConn) divergedReply(command, key string, primary, secondary interface{}) {
+	log.Printf("Dual write of %s %s diverged: the old backend replied %s, the new %s\n", command, key, formatReply(primary), formatReply(secondary))
+	c.stats.diverged(Divergence{At: time.Now(), Command: command, Key: key, Primary: formatReply(primary), Secondary: formatReply(secondary)}, false)
+}
+
+// formatReply shows a Redis reply with its bulk strings as strings
+func formatReply(reply interface{}) string {
+	switch r := reply.(type) {
+	case []byte:
+		return fmt.Sprintf("%q", r)
+	case []interface{}:
+		var items []string
+		for _, item := range r {
+			items = append(items, formatReply(item))
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case redis.Error:
+		return "error " + r.Error()
+	case nil:
+		return "nil"
+	}
+	return fmt.Sprint(reply)
+}
+
+// Keys whose contents differ between the old and new backends
+type DualWriteVerification struct {
+	// Keys compared, which is every key the old backend has
+	Keys int `json:"keys"`
+	// Keys the new backend lacks, has with other contents, or has but the old does not
+	Missing   int `json:"missing"`
+	Different int `json:"different"`
+	Extra     int `json:"extra"`
+	// Up to the given limit of each, in name order
+	MissingKeys   []string `json:"missing_keys"`
+	DifferentKeys []string `json:"different_keys"`
+	ExtraKeys     []string `json:"extra_keys"`
+}
+
+// GET  /admin/dual-write/verify/ (?limit=100 optional) : READ compare every key of the old backend with the new, listing up to <limit>
+// of each of the keys the new lacks, has with other contents, or has that the old does not. This reads every key of both, so takes a
+// while on a large keyspace, and keys being written meanwhile may be reported as different.
+func VerifyDualWrite(primary, secondary redis.Conn, limit int) (DualWriteVerification, error) {
+	if limit < 0 {
+		return DualWriteVerification{}, fmt.Errorf("limit must not be negative, not %d", limit)
+	}
+	ret := DualWriteVerification{MissingKeys: []string{}, DifferentKeys: []string{}, ExtraKeys: []string{}}
+
+	primaryKeys, err := scanKeys(primary)
+	if err != nil {
+		return ret, err
+	}
+	secondaryKeys, err := scanKeys(secondary)
+	if err != nil {
+		return ret, err
+	}
+	inPrimary := make(map[string]bool, len(primaryKeys))
+	for _, key := range primaryKeys {
+		inPrimary[key] = true
+	}
+	inSecondary := make(map[string]bool, len(secondaryKeys))
+	for _, key := range secondaryKeys {
+		inSecondary[key] = true
+	}
+
+	ret.Keys = len(primaryKeys)
+	for _, key := range primaryKeys {
+		if !inSecondary[key] {
+			ret.Missing++
+			if len(ret.MissingKeys) < limit {
+				ret.MissingKeys = append(ret.MissingKeys, key)
+			}
+			continue
+		}
+		a, err := keyContents(primary, key)
+		if err != nil {
+			return ret, err
+		}
+		b, err := keyContents(secondary, key)
+		if err != nil {
+			return ret, err
+		}
+		if !reflect.DeepEqual(a, b) {
+			ret.Different++
+			if len(ret.DifferentKeys) < limit {
+				ret.DifferentKeys = append(ret.DifferentKeys, key)
+			}
+		}
+	}
+	for _, key := range secondaryKeys {
+		if !inPrimary[key] {
+			ret.Extra++
+			if len(ret.ExtraKeys) < limit {
+				ret.ExtraKeys = append(ret.ExtraKeys, key)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// scanKeys returns every key, in name order
+func scanKeys(conn redis.Conn) ([]string, error) {
+	var ret []string
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "COUNT", 1000))
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return nil, err
+		}
+		ret = append(ret, keys...)
+		if cursor == 0 {
+			break
+		}
+	}
+	// SCAN can return a key more than once
+	sort.Strings(ret)
+	unique := ret[:0]
+	for i, key := range ret {
+		if i == 0 || key != ret[i-1] {
+			unique = append(unique, key)
+		}
+	}
+	return unique, nil
+}
+
+// keyContents reads a key whole, in a form that is equal for equal contents whatever order Redis gives them in
+func keyContents(conn redis.Conn, key string) (interface{}, error) {
+	kind, err := redis.String(conn.Do("TYPE", key))
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "string":
+		s, err := redis.String(conn.Do("GET", key))
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return s, err
+	case "hash":
+		return redis.StringMap(conn.Do("HGETALL", key))
+	case "set":
+		members, err := redis.Strings(conn.Do("SMEMBERS", key))
+		sort.Strings(members)
+		return members, err
+	case "zset":
+		return redis.StringMap(conn.Do("ZRANGE", key, 0, -1, "WITHSCORES"))
+	case "list":
+		return redis.Strings(conn.Do("LRANGE", key, 0, -1))
+	}
+	// Gone since the SCAN, or of a type the store never writes
+	return kind, nil
+}
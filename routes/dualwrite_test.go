@@ -0,0 +1,387 @@
+package routes
+
+import (
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// transactionRedis is a memoryRedis that keeps commands sent after MULTI until EXEC, as Redis does, answering each
+// with QUEUED and EXEC with their replies, and remembers every command it was given
+type transactionRedis struct {
+	*memoryRedis
+	queued   [][]interface{}
+	multi    bool
+	commands []string
+}
+
+func newTransactionRedis() *transactionRedis {
+	return &transactionRedis{memoryRedis: newMemoryRedis()}
+}
+
+func (m *transactionRedis) Do(command string, args ...interface{}) (interface{}, error) {
+	name := strings.ToUpper(command)
+	m.commands = append(m.commands, name)
+	switch {
+	case name == "MULTI":
+		m.multi, m.queued = true, nil
+		return "OK", nil
+	case name == "DISCARD":
+		m.multi, m.queued = false, nil
+		return "OK", nil
+	case name == "EXEC":
+		ret := []interface{}{}
+		for _, queued := range m.queued {
+			reply, err := m.memoryRedis.Do(queued[0].(string), queued[1:]...)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, reply)
+		}
+		m.multi, m.queued = false, nil
+		return ret, nil
+	case m.multi:
+		m.queued = append(m.queued, append([]interface{}{command}, args...))
+		return "QUEUED", nil
+	}
+	return m.memoryRedis.Do(command, args...)
+}
+
+// A secondary that is down
+type failingRedis struct {
+	*memoryRedis
+}
+
+func (failingRedis) Do(string, ...interface{}) (interface{}, error) {
+	return nil, errors.New("connection refused")
+}
+
+func doAll(t *testing.T, conn *DualWriteConn, commands ...[]interface{}) []interface{} {
+	t.Helper()
+	var ret []interface{}
+	for _, command := range commands {
+		reply, err := conn.Do(command[0].(string), command[1:]...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, reply)
+	}
+	return ret
+}
+
+// Writes reach the secondary and reads do not; the primary's replies are what the caller gets
+func TestDualWriteMirrorsWrites(t *testing.T) {
+	primary, secondary := newTransactionRedis(), newTransactionRedis()
+	stats := NewDualWriteStats()
+	conn := NewDualWriteConn(primary, secondary, stats)
+
+	replies := doAll(t, conn,
+		[]interface{}{"SET", "k", "v"},
+		[]interface{}{"GET", "k"},
+		[]interface{}{"hset", "h", "f", "1"},
+		[]interface{}{"SMEMBERS", "s"},
+	)
+	if string(replies[1].([]byte)) != "v" {
+		t.Fatalf("GET replied %v, not the primary's v", replies[1])
+	}
+	if secondary.values["k"] != "v" || secondary.hashes["h"]["f"] != "1" {
+		t.Fatal("the writes should reach the secondary")
+	}
+	if want := []string{"SET", "HSET"}; !reflect.DeepEqual(secondary.commands, want) {
+		t.Fatalf("the secondary was sent %v, want %v", secondary.commands, want)
+	}
+	if report := stats.Report(); report.Writes != 2 || report.Failures != 0 || report.Divergences != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+// A transaction is mirrored whole, reads and all, so that the replies EXEC gives are compared command by command, and
+// a discarded one is discarded on both
+func TestDualWriteMirrorsTransactions(t *testing.T) {
+	primary, secondary := newTransactionRedis(), newTransactionRedis()
+	// The counter has already drifted apart
+	primary.hash("counts")["c"] = "0"
+	secondary.hash("counts")["c"] = "5"
+	for _, m := range []*memoryRedis{primary.memoryRedis, secondary.memoryRedis} {
+		m.hash("h")["f"] = "x"
+	}
+	stats := NewDualWriteStats()
+	conn := NewDualWriteConn(primary, secondary, stats)
+
+	replies := doAll(t, conn,
+		[]interface{}{"MULTI"},
+		[]interface{}{"HGET", "h", "f"},
+		[]interface{}{"SADD", "s", "a"},
+		[]interface{}{"HINCRBY", "counts", "c", 1},
+		[]interface{}{"EXEC"},
+	)
+	if want := []interface{}{bulk("x"), int64(1), int64(1)}; !reflect.DeepEqual(replies[4], want) {
+		t.Fatalf("EXEC replied %v, not the primary's %v", replies[4], want)
+	}
+	if !secondary.sets["s"]["a"] {
+		t.Fatal("the transaction's writes should reach the secondary")
+	}
+	if want := []string{"MULTI", "HGET", "SADD", "HINCRBY", "EXEC"}; !reflect.DeepEqual(secondary.commands, want) {
+		t.Fatalf("the secondary was sent %v, want %v", secondary.commands, want)
+	}
+	report := stats.Report()
+	if report.Writes != 2 || report.Failures != 0 || report.Divergences != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	d := report.Recent[0]
+	if d.Command != "HINCRBY" || d.Key != "counts" || d.Primary != "1" || d.Secondary != "6" {
+		t.Fatalf("the divergence should be the HINCRBY's, not %+v", d)
+	}
+
+	secondary.commands = nil
+	doAll(t, conn,
+		[]interface{}{"MULTI"},
+		[]interface{}{"SET", "discarded", "v"},
+		[]interface{}{"DISCARD"},
+		[]interface{}{"SET", "k", "v"},
+	)
+	if _, ok := secondary.values["discarded"]; ok {
+		t.Fatal("a discarded write should not reach the secondary")
+	}
+	if want := []string{"MULTI", "SET", "DISCARD", "SET"}; !reflect.DeepEqual(secondary.commands, want) {
+		t.Fatalf("the secondary was sent %v, want %v", secondary.commands, want)
+	}
+	if report := stats.Report(); report.Writes != 3 || report.Divergences != 1 {
+		t.Fatalf("a discarded transaction should count no writes: %+v", report)
+	}
+}
+
+// A write the secondary answers differently is a divergence and one it fails a failure; neither fails the command,
+// and a failed one is not counted as written
+func TestDualWriteCountsDivergencesAndFailures(t *testing.T) {
+	primary, secondary := newTransactionRedis(), newTransactionRedis()
+	secondary.hash("counts")["c"] = "5"
+	stats := NewDualWriteStats()
+	conn := NewDualWriteConn(primary, secondary, stats)
+	doAll(t, conn,
+		[]interface{}{"HINCRBY", "counts", "c", 1},
+		[]interface{}{"HINCRBY", "counts", "d", 1},
+	)
+	report := stats.Report()
+	if report.Writes != 2 || report.Divergences != 1 || report.Failures != 0 || len(report.Recent) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if d := report.Recent[0]; d.Key != "counts" || d.Primary != "1" || d.Secondary != "6" {
+		t.Fatalf("unexpected divergence %+v", d)
+	}
+
+	stats = NewDualWriteStats()
+	down := newTransactionRedis()
+	conn = NewDualWriteConn(down, failingRedis{newMemoryRedis()}, stats)
+	replies := doAll(t, conn,
+		[]interface{}{"SET", "k", "v"},
+		[]interface{}{"MULTI"},
+		[]interface{}{"SADD", "s", "a"},
+		[]interface{}{"EXEC"},
+	)
+	if replies[0] != "OK" || !reflect.DeepEqual(replies[3], []interface{}{int64(1)}) {
+		t.Fatalf("the primary's replies should be given whatever the secondary does, not %v", replies)
+	}
+	if down.values["k"] != "v" || !down.sets["s"]["a"] {
+		t.Fatal("the writes should still reach the primary")
+	}
+	report = stats.Report()
+	if report.Writes != 0 || report.Failures != 2 || report.Divergences != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if d := report.Recent[0]; d.Command != "SET" || d.Key != "k" || d.Secondary != "connection refused" {
+		t.Fatalf("unexpected failure %+v", d)
+	}
+}
+
+func TestVerifyDualWrite(t *testing.T) {
+	primary, secondary := newMemoryRedis(), newMemoryRedis()
+	for _, m := range []*memoryRedis{primary, secondary} {
+		m.values["same-string"] = "v"
+		m.set("same-set")["a"] = true
+		m.set("same-set")["b"] = true
+		m.zset("same-zset")["a"] = 1
+		m.lists["same-list"] = []string{"a", "b"}
+		m.hash("different-hash")["f"] = "1"
+	}
+	secondary.hash("different-hash")["f"] = "2"
+	primary.lists["different-list"] = []string{"a", "b"}
+	secondary.lists["different-list"] = []string{"b", "a"}
+	primary.values["different-type"] = "v"
+	secondary.set("different-type")["v"] = true
+	primary.values["missing-1"] = "v"
+	primary.set("missing-2")["a"] = true
+	secondary.hash("extra")["f"] = "1"
+	// Emptied keys do not exist
+	primary.hash("emptied")["f"] = "1"
+	delete(primary.hashes["emptied"], "f")
+
+	got, err := VerifyDualWrite(primary, secondary, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DualWriteVerification{
+		Keys:          9,
+		Missing:       2,
+		Different:     3,
+		Extra:         1,
+		MissingKeys:   []string{"missing-1", "missing-2"},
+		DifferentKeys: []string{"different-hash", "different-list", "different-type"},
+		ExtraKeys:     []string{"extra"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	got, err = VerifyDualWrite(primary, secondary, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.MissingKeys, want.DifferentKeys = want.MissingKeys[:1], want.DifferentKeys[:1]
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("with a limit of 1, got %+v, want %+v", got, want)
+	}
+}
+
+// The commands the store may give Redis that write nothing: reads, server settings, and transactions, which are
+// mirrored whole
+var readCommands = map[string]bool{
+	"GET": true, "EXISTS": true, "TYPE": true, "SCAN": true,
+	"SISMEMBER": true, "SMEMBERS": true,
+	"HGET": true, "HGETALL": true,
+	"LRANGE": true,
+	"ZRANGE": true, "ZREVRANGE": true,
+	"PING": true, "CONFIG": true, "MEMORY": true,
+	"MULTI": true, "EXEC": true, "DISCARD": true,
+}
+
+// Every command named in the store's source, either as given to Do or Send or as the first of a command built to be
+// given them later
+func storeCommands(t *testing.T) map[string][]string {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := make(map[string][]string)
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			var first ast.Expr
+			switch n := n.(type) {
+			case *ast.CallExpr:
+				if sel, ok := n.Fun.(*ast.SelectorExpr); ok && (sel.Sel.Name == "Do" || sel.Sel.Name == "Send") && len(n.Args) > 0 {
+					first = n.Args[0]
+				}
+			case *ast.CompositeLit:
+				if array, ok := n.Type.(*ast.ArrayType); ok && len(n.Elts) > 0 {
+					if iface, ok := array.Elt.(*ast.InterfaceType); ok && len(iface.Methods.List) == 0 {
+						first = n.Elts[0]
+					}
+				}
+			}
+			if lit, ok := first.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				if command, err := strconv.Unquote(lit.Value); err == nil && command == strings.ToUpper(command) && command != "" {
+					ret[command] = append(ret[command], fset.Position(lit.Pos()).String())
+				}
+			}
+			return true
+		})
+	}
+	return ret
+}
+
+// A command the store writes with that writeCommands lacks would never reach the new backend outside a transaction
+func TestWriteCommandsCoverTheStore(t *testing.T) {
+	commands := storeCommands(t)
+	if len(commands) == 0 {
+		t.Fatal("no commands found in the store's source")
+	}
+	var missing []string
+	for command, at := range commands {
+		if !writeCommands[command] && !readCommands[command] {
+			missing = append(missing, command+" at "+at[0])
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Fatalf("commands that are neither in writeCommands nor known to only read: %s", strings.Join(missing, ", "))
+	}
+}
+
+// A store working through a DualWriteConn onto an empty secondary leaves it the same as the primary
+func TestDualWriteKeepsStoreBackendsEqual(t *testing.T) {
+	primary, secondary := newTransactionRedis(), newTransactionRedis()
+	stats := NewDualWriteStats()
+	conn := NewDualWriteConn(primary, secondary, stats)
+	rs := New(conn)
+
+	check := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"A", "B", "C", "D", "E"} {
+		check(rs.AddLocation(name, &Coordinates{Lat: 1, Lon: 2}, nil, new(bool)))
+	}
+	bidirectional := true
+	check(rs.AddRoutes("A", givenWeights(map[string]float64{"B": 1, "C": 2}), &bidirectional))
+	check(rs.AddRoutes("B", givenWeights(map[string]float64{"C": 1, "D": 4}), new(bool)))
+	check(rs.AddRoutes("D", givenWeights(map[string]float64{"E": 1}), new(bool)))
+	check(rs.SetEdgeTags("A", "B", []string{"toll"}))
+	check(rs.SetEdgeAttributes("A", "B", map[string]float64{"time": 3}))
+	check(rs.SetEdgeModes("B", "C", map[string]float64{"walk": 2}))
+	check(rs.SetLocationTags("C", []string{"depot"}))
+	check(rs.SetVisitCost("C", 1))
+	check(rs.DefineCostFunction("fast", "time + 2"))
+	check(rs.SetRegion("north", ""))
+	check(rs.AddToRegion("north", []string{"A", "B"}))
+	check(rs.Watch(Pair{From: "A", To: "D"}))
+	check(rs.MarkCritical(Pair{From: "A", To: "E"}))
+	check(rs.AddHotSource("A"))
+	check(rs.SetTimezone("Europe/London"))
+	check(rs.CloseEdge("B", "D"))
+	for i := 0; i < 3; i++ {
+		_, err := rs.RoutesBetween("A", "E", RouteOptions{})
+		check(err)
+	}
+	_, err := rs.TopQueries(1)
+	check(err)
+	check(rs.OpenEdge("B", "D"))
+	check(rs.RemoveRoutes("B", []string{"C"}))
+	check(rs.RenameLocations(map[string]string{"C": "C2"}))
+	check(rs.ArchiveLocation("E"))
+	check(rs.UnarchiveLocation("E"))
+	check(rs.DeleteLocation("D"))
+	check(rs.RestoreLocation("D"))
+	check(rs.DeleteLocation("E"))
+	check(rs.EmptyTrash())
+	check(rs.RemoveCostFunction("fast"))
+	check(rs.RemoveFromRegion("north", "B"))
+	check(rs.Unwatch(Pair{From: "A", To: "D"}))
+	check(rs.RemoveHotSource("A"))
+
+	verification, err := VerifyDualWrite(primary, secondary, 10)
+	check(err)
+	if verification.Keys == 0 || verification.Missing+verification.Different+verification.Extra > 0 {
+		t.Fatalf("the backends differ: %+v", verification)
+	}
+	if report := stats.Report(); report.Writes == 0 || report.Failures+report.Divergences > 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
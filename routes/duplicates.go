@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Two locations that are probably the same place
+type DuplicateCandidate struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	// Edits between the normalised names
+	NameDistance int `json:"name_distance"`
+	// Only present when both locations have coordinates
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+}
+
+// normaliseName ignores case, spacing and punctuation, so "St. Albans" matches "st albans"
+func normaliseName(name string) []rune {
+	var ret []rune
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// levenshtein is the number of single character insertions, deletions and substitutions turning a into b
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// GET  /maps/analysis/duplicates/ (?threshold=2&km=0.5 optional) : READ pairs of locations whose names are within
+// threshold edits of each other, or whose coordinates are within km of each other
+func (rs *RouteStore) FindDuplicates(threshold int, km float64) ([]DuplicateCandidate, error) {
+	if threshold < 0 {
+		return nil, fmt.Errorf("threshold must not be negative, not %d", threshold)
+	}
+	if km < 0 {
+		return nil, fmt.Errorf("km must not be negative, not %g", km)
+	}
+
+	defer rs.rlock("FindDuplicates")()
+
+	return rs.findDuplicates(threshold, km), nil
+}
+
+// Must be called with the lock held, after checking threshold and km are not negative
+func (rs *RouteStore) findDuplicates(threshold int, km float64) []DuplicateCandidate {
+	type candidate struct {
+		name       string
+		normalised []rune
+		coords     *Coordinates
+	}
+	var locations []candidate
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		node := nodes.Node()
+		c := candidate{name: nodeName(node), normalised: normaliseName(nodeName(node))}
+		if coords, ok := rs.coordinates[node.ID()]; ok {
+			c.coords = &coords
+		}
+		locations = append(locations, c)
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i].name < locations[j].name })
+
+	ret := []DuplicateCandidate{}
+	for i, a := range locations {
+		for _, b := range locations[i+1:] {
+			// Names differing in length by more than the threshold cannot be within it
+			lengthGap := len(a.normalised) - len(b.normalised)
+			if lengthGap < 0 {
+				lengthGap = -lengthGap
+			}
+			dup := DuplicateCandidate{A: a.name, B: b.name, NameDistance: -1}
+			if lengthGap <= threshold {
+				dup.NameDistance = levenshtein(a.normalised, b.normalised)
+			}
+			near := false
+			if a.coords != nil && b.coords != nil {
+				distance := haversine(*a.coords, *b.coords)
+				dup.DistanceKm = &distance
+				near = distance <= km
+			}
+			if near || (dup.NameDistance >= 0 && dup.NameDistance <= threshold) {
+				if dup.NameDistance < 0 {
+					dup.NameDistance = levenshtein(a.normalised, b.normalised)
+				}
+				ret = append(ret, dup)
+			}
+		}
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].NameDistance < ret[j].NameDistance })
+	return ret
+}
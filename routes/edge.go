@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"time"
+)
+
+// ErrNoEdge is wrapped by the errors for an edge that does not exist, which say which edge it was
+var ErrNoEdge = errors.New("there is no edge")
+
+// Everything known about one edge
+type EdgeInfo struct {
+	From       string             `json:"from"`
+	To         string             `json:"to"`
+	Weight     float64            `json:"weight"`
+	Tags       []string           `json:"tags"`
+	Attributes map[string]float64 `json:"attributes"`
+	// The weight of each mode of transport, beside the edge's own
+	Modes map[string]float64 `json:"modes"`
+	// Whether the edge was added both ways, so that the edge back is kept in step with it
+	TwoWay bool `json:"two_way"`
+	// Whether the edge is closed, so routes go around it, and since when
+	Closed   bool       `json:"closed"`
+	ClosedAt *time.Time `json:"closed_at,omitempty"`
+	// When the edge was last added after not existing, or null if its history does not go back that far
+	CreatedAt *time.Time `json:"created_at"`
+	// When the edge's weight last changed, or null if no change was recorded
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// GET  /maps/<from>/<to>/edge : READ the edge from <from> to <to>, with its weight, tags, attributes, modes, whether it is closed,
+// and when it was created and last changed. The times come from the edge's history, so are missing where that was not recorded.
+func (rs *RouteStore) EdgeInfo(fromStr, toStr string) (EdgeInfo, error) {
+	defer rs.lock("EdgeInfo")()
+
+	from, to := Location(fromStr), Location(toStr)
+	weight, ok := rs.edgeWeight(from, to)
+	if !ok || from == to {
+		return EdgeInfo{}, fmt.Errorf("%w from %s to %s", ErrNoEdge, from, to)
+	}
+
+	ret := EdgeInfo{
+		From:       fromStr,
+		To:         toStr,
+		Weight:     weight,
+		Tags:       append([]string{}, rs.tags[edgeKey(from.ID(), to.ID())]...),
+		Attributes: make(map[string]float64),
+		Modes:      make(map[string]float64),
+		TwoWay:     rs.isTwoWay(from, to),
+	}
+	if c, ok := rs.closed[edgeKey(from.ID(), to.ID())]; ok {
+		ret.Closed, ret.ClosedAt = true, &c.at
+	}
+	for name, value := range rs.attributes[edgeKey(from.ID(), to.ID())] {
+		ret.Attributes[name] = value
+	}
+	for name, weight := range rs.modes[edgeKey(from.ID(), to.ID())] {
+		ret.Modes[name] = weight
+	}
+
+	entries, err := redis.ByteSlices(rs.redis.Do("LRANGE", historyKey(fromStr, toStr), 0, -1))
+	if err != nil {
+		return EdgeInfo{}, err
+	}
+	// The edge was created by the change after its last removal, which is only known to be the first
+	// change if the history has not been trimmed
+	created := -1
+	if len(entries) > 0 && len(entries) < rs.historyLength {
+		created = 0
+	}
+	changes := make([]EdgeChange, len(entries))
+	for i, entry := range entries {
+		if err := json.Unmarshal(entry, &changes[i]); err != nil {
+			return EdgeInfo{}, err
+		}
+		if changes[i].Weight == nil {
+			created = i + 1
+		}
+	}
+	if created >= 0 && created < len(changes) {
+		ret.CreatedAt = &changes[created].At
+	}
+	if len(changes) > 0 {
+		ret.UpdatedAt = &changes[len(changes)-1].At
+	}
+	return ret, nil
+}
@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Whether Redis could drop the graph's keys to free memory, and which it already has
+type EvictionReport struct {
+	// Redis's maxmemory-policy and maxmemory, 0 if it has none
+	Policy    string `json:"policy"`
+	MaxMemory int64  `json:"maxmemory"`
+	// Redis may evict the graph's keys: it has a maxmemory and an allkeys policy. The graph's keys never expire,
+	// so volatile policies leave them be, and without a maxmemory nothing is evicted.
+	Unsafe bool `json:"unsafe"`
+	// Keys the graph in memory has but Redis does not: the locations set, and the edges hashes of locations
+	// with edges, in name order
+	Lost []string `json:"lost"`
+	// Whether the lost keys were written again from memory
+	Rewritten bool `json:"rewritten"`
+}
+
+// evictsPersistentKeys is whether a maxmemory-policy can evict keys without a TTL
+func evictsPersistentKeys(policy string) bool {
+	return strings.HasPrefix(policy, "allkeys-")
+}
+
+// GET  /admin/eviction/ : READ Redis's eviction policy, whether it could evict the graph's keys, and which graph keys are missing from Redis
+func (rs *RouteStore) CheckEviction() (EvictionReport, error) {
+	defer rs.lock("CheckEviction")()
+
+	return rs.checkEviction()
+}
+
+// POST /admin/eviction/ : UPDATE write the graph keys missing from Redis again from memory: the locations set and the
+// edges of each location whose hash is gone. Edges to archived locations are only in Redis, so a lost hash loses them.
+func (rs *RouteStore) RewriteLostKeys() (EvictionReport, error) {
+	defer rs.lock("RewriteLostKeys")()
+
+	report, err := rs.checkEviction()
+	if err != nil || len(report.Lost) == 0 {
+		return report, err
+	}
+
+	if _, err := rs.redis.Do("MULTI"); err != nil {
+		return report, err
+	}
+	queue := func() error {
+		for _, key := range report.Lost {
+			if key == locations_set {
+				nodes := rs.graph.Nodes()
+				for nodes.Next() {
+					if _, err := rs.redis.Do("SADD", locations_set, nodeName(nodes.Node())); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			from := Location(key)
+			to := rs.graph.From(from.ID())
+			for to.Next() {
+				weight, _ := rs.graph.Weight(from.ID(), to.Node().ID())
+				if _, err := rs.redis.Do("HSET", key, nodeName(to.Node()), weight); err != nil {
+					return err
+				}
+			}
+			for _, c := range rs.closedFrom(from.ID()) {
+				if _, err := rs.redis.Do("HSET", key, string(c.to), c.weight); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := queue(); err != nil {
+		rs.redis.Do("DISCARD")
+		return report, err
+	}
+	if _, err := rs.redis.Do("EXEC"); err != nil {
+		return report, err
+	}
+	report.Rewritten = true
+	return report, nil
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) checkEviction() (EvictionReport, error) {
+	ret := EvictionReport{Lost: []string{}}
+	config, err := redis.StringMap(rs.redis.Do("CONFIG", "GET", "maxmemory*"))
+	if err != nil {
+		return ret, err
+	}
+	ret.Policy = config["maxmemory-policy"]
+	if s, ok := config["maxmemory"]; ok {
+		if ret.MaxMemory, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return ret, err
+		}
+	}
+	ret.Unsafe = ret.MaxMemory > 0 && evictsPersistentKeys(ret.Policy)
+
+	exists := func(key string) (bool, error) {
+		return redis.Bool(rs.redis.Do("EXISTS", key))
+	}
+	nodes := rs.graph.Nodes()
+	if nodes.Len() > 0 {
+		ok, err := exists(locations_set)
+		if err != nil {
+			return ret, err
+		}
+		if !ok {
+			ret.Lost = append(ret.Lost, locations_set)
+		}
+	}
+	var lost []string
+	for nodes.Next() {
+		if rs.graph.From(nodes.Node().ID()).Len() == 0 && len(rs.closedFrom(nodes.Node().ID())) == 0 {
+			continue
+		}
+		name := nodeName(nodes.Node())
+		ok, err := exists(name)
+		if err != nil {
+			return ret, err
+		}
+		if !ok {
+			lost = append(lost, name)
+		}
+	}
+	sort.Strings(lost)
+	ret.Lost = append(ret.Lost, lost...)
+	return ret, nil
+}
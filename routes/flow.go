@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// An edge carrying flow, and how much of its capacity it uses
+type EdgeFlow struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Flow     float64 `json:"flow"`
+	Capacity float64 `json:"capacity"`
+}
+
+// The most that can flow from one location to another when edge weights are capacities
+type MaxFlow struct {
+	From  string  `json:"from"`
+	To    string  `json:"to"`
+	Value float64 `json:"value"`
+	// Every edge with flow on it, by from then to
+	Flows []EdgeFlow `json:"flows"`
+	// The edges whose removal would cut every route, together as heavy as the flow, lightest first, then by from and to
+	MinCut []Edge `json:"min_cut"`
+}
+
+// GET  /maps/<from>/<to>/flow : READ the maximum flow from <from> to <to> treating weights as capacities, with a minimum cut.
+// Found by Edmonds-Karp, augmenting along the path with fewest edges each time, so it takes O(VE²) at worst.
+func (rs *RouteStore) MaxFlow(fromStr, toStr string) (MaxFlow, error) {
+	defer rs.rlock("MaxFlow")()
+
+	from, to := Location(fromStr), Location(toStr)
+	for _, loc := range []Location{from, to} {
+		if rs.graph.Node(loc.ID()) == nil {
+			return MaxFlow{}, fmt.Errorf("%s does not exist", loc)
+		}
+	}
+	if from == to {
+		return MaxFlow{}, fmt.Errorf("the flow from %s to itself is unbounded", from)
+	}
+	if rs.negativeEdges > 0 {
+		return MaxFlow{}, fmt.Errorf("flows cannot be found while there are negative edge weights")
+	}
+
+	edges := rs.sortedEdges()
+	capacity := make(map[[2]int64]float64, len(edges))
+	// Each location's neighbours either way, since flow can be pushed back along an edge
+	neighbours := make(map[int64][]int64)
+	for _, edge := range edges {
+		u, v := Location(edge.From).ID(), Location(edge.To).ID()
+		capacity[[2]int64{u, v}] = edge.Weight
+		neighbours[u] = append(neighbours[u], v)
+		neighbours[v] = append(neighbours[v], u)
+	}
+	flow := make(map[[2]int64]float64)
+	residual := func(u, v int64) float64 {
+		return capacity[[2]int64{u, v}] - flow[[2]int64{u, v}] + flow[[2]int64{v, u}]
+	}
+	// Within rounding, so that float capacities do not leave endless slivers to augment
+	tolerance := tieTolerance(rs.precision)
+
+	s, t := from.ID(), to.ID()
+	var value float64
+	for {
+		// Breadth first search of the residual graph, recording how each location was reached
+		parent := map[int64]int64{s: s}
+		queue := []int64{s}
+		for len(queue) > 0 && !hasKey(parent, t) {
+			u := queue[0]
+			queue = queue[1:]
+			for _, v := range neighbours[u] {
+				if !hasKey(parent, v) && residual(u, v) > tolerance {
+					parent[v] = u
+					queue = append(queue, v)
+				}
+			}
+		}
+		if !hasKey(parent, t) {
+			// What the search reached is the source side of a minimum cut
+			ret := MaxFlow{From: fromStr, To: toStr, Value: rs.roundWeight(value), Flows: []EdgeFlow{}, MinCut: []Edge{}}
+			for _, edge := range edges {
+				u, v := Location(edge.From).ID(), Location(edge.To).ID()
+				if f := flow[[2]int64{u, v}] - flow[[2]int64{v, u}]; f > tolerance {
+					ret.Flows = append(ret.Flows, EdgeFlow{From: edge.From, To: edge.To, Flow: rs.roundWeight(f), Capacity: edge.Weight})
+				}
+				if hasKey(parent, u) && !hasKey(parent, v) {
+					ret.MinCut = append(ret.MinCut, edge)
+				}
+			}
+			sort.Slice(ret.Flows, func(i, j int) bool {
+				if ret.Flows[i].From != ret.Flows[j].From {
+					return ret.Flows[i].From < ret.Flows[j].From
+				}
+				return ret.Flows[i].To < ret.Flows[j].To
+			})
+			sort.Slice(ret.MinCut, func(i, j int) bool {
+				a, b := ret.MinCut[i], ret.MinCut[j]
+				if a.Weight != b.Weight {
+					return a.Weight < b.Weight
+				}
+				if a.From != b.From {
+					return a.From < b.From
+				}
+				return a.To < b.To
+			})
+			return ret, nil
+		}
+
+		bottleneck := math.Inf(1)
+		for v := t; v != s; v = parent[v] {
+			bottleneck = math.Min(bottleneck, residual(parent[v], v))
+		}
+		for v := t; v != s; v = parent[v] {
+			u := parent[v]
+			// Cancel flow coming the other way before adding more
+			back := math.Min(bottleneck, flow[[2]int64{v, u}])
+			flow[[2]int64{v, u}] -= back
+			flow[[2]int64{u, v}] += bottleneck - back
+		}
+		value += bottleneck
+	}
+}
+
+func hasKey(m map[int64]int64, key int64) bool {
+	_, ok := m[key]
+	return ok
+}
@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// The lightest cut between from and to, by trying every set of locations holding from and not to
+func bruteForceMinCut(edges map[string]map[string]float64, from, to string) float64 {
+	var others []string
+	for name := range edges {
+		if name != from && name != to {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	lightest := math.Inf(1)
+	for mask := 0; mask < 1<<len(others); mask++ {
+		side := map[string]bool{from: true}
+		for i, name := range others {
+			if mask&(1<<i) != 0 {
+				side[name] = true
+			}
+		}
+		var weight float64
+		for u, targets := range edges {
+			for v, w := range targets {
+				if side[u] && !side[v] {
+					weight += w
+				}
+			}
+		}
+		lightest = math.Min(lightest, weight)
+	}
+	return lightest
+}
+
+// The flow must be as large as the lightest cut, and the cut given must weigh as much, be in order, and leave no route
+func checkMaxFlow(t *testing.T, edges map[string]map[string]float64, from, to string, want float64) {
+	t.Helper()
+	rs := offlineStore(t, edges)
+	flow, err := rs.MaxFlow(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flow.Value != want {
+		t.Fatalf("%s to %s: the flow is %g, want %g", from, to, flow.Value, want)
+	}
+
+	var cut float64
+	removed := make(map[[2]string]bool)
+	for i, edge := range flow.MinCut {
+		cut += edge.Weight
+		removed[[2]string{edge.From, edge.To}] = true
+		if i > 0 {
+			prev := flow.MinCut[i-1]
+			if prev.Weight > edge.Weight || prev.Weight == edge.Weight && (prev.From > edge.From || prev.From == edge.From && prev.To > edge.To) {
+				t.Fatalf("%s to %s: the cut %v is out of order", from, to, flow.MinCut)
+			}
+		}
+	}
+	if cut != flow.Value {
+		t.Fatalf("%s to %s: the cut %v weighs %g, not the flow's %g", from, to, flow.MinCut, cut, flow.Value)
+	}
+	reached := map[string]bool{from: true}
+	queue := []string{from}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		for v := range edges[u] {
+			if !reached[v] && !removed[[2]string{u, v}] {
+				reached[v] = true
+				queue = append(queue, v)
+			}
+		}
+	}
+	if reached[to] {
+		t.Fatalf("%s to %s: the cut %v leaves a route", from, to, flow.MinCut)
+	}
+}
+
+func TestMaxFlowEqualsMinCut(t *testing.T) {
+	tests := []struct {
+		name     string
+		edges    map[string]map[string]float64
+		from, to string
+		want     float64
+	}{
+		// The network of Cormen et al.
+		{"textbook", map[string]map[string]float64{
+			"s":  {"v1": 16, "v2": 13},
+			"v1": {"v3": 12},
+			"v2": {"v1": 4, "v4": 14},
+			"v3": {"v2": 9, "t": 20},
+			"v4": {"v3": 7, "t": 4},
+			"t":  {},
+		}, "s", "t", 23},
+		// The first route found, s -> a -> b -> t, blocks the others unless flow is pushed back along a -> b
+		{"pushing back", map[string]map[string]float64{
+			"s": {"a": 1, "c": 1}, "a": {"b": 1, "d": 1}, "c": {"b": 1}, "b": {"t": 1}, "d": {"t": 1}, "t": {},
+		}, "s", "t", 2},
+		{"parallel routes", map[string]map[string]float64{
+			"s": {"a": 3, "b": 2, "c": 5}, "a": {"t": 1}, "b": {"t": 4}, "c": {"t": 5}, "t": {},
+		}, "s", "t", 8},
+		{"one bottleneck", map[string]map[string]float64{
+			"s": {"a": 10, "b": 10}, "a": {"m": 10}, "b": {"m": 10}, "m": {"t": 1}, "t": {"s": 7},
+		}, "s", "t", 1},
+		{"unreachable", map[string]map[string]float64{"s": {"a": 1}, "a": {}, "t": {"s": 1}}, "s", "t", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := bruteForceMinCut(test.edges, test.from, test.to); got != test.want {
+				t.Fatalf("the lightest cut weighs %g, not %g", got, test.want)
+			}
+			checkMaxFlow(t, test.edges, test.from, test.to, test.want)
+		})
+	}
+}
+
+// Small random graphs, against every cut there is
+func TestMaxFlowEqualsMinCutRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		edges := make(map[string]map[string]float64)
+		n := 2 + r.Intn(7)
+		for from := 0; from < n; from++ {
+			edges[fmt.Sprint("n", from)] = make(map[string]float64)
+		}
+		for from := 0; from < n; from++ {
+			for to := 0; to < n; to++ {
+				if from != to && r.Float64() < 0.4 {
+					edges[fmt.Sprint("n", from)][fmt.Sprint("n", to)] = float64(1 + r.Intn(9))
+				}
+			}
+		}
+		to := fmt.Sprint("n", n-1)
+		checkMaxFlow(t, edges, "n0", to, bruteForceMinCut(edges, "n0", to))
+	}
+}
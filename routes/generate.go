@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	// The most locations one generated graph may have; every ordered pair of them is considered for an edge
+	MaxGeneratedLocations = 5000
+	// The most edges one generated graph may be expected to have, at its size and density
+	MaxGeneratedEdges = 200000
+)
+
+// What random graph to generate. The zero value of Prefix names locations generated-0, generated-1 and so on.
+type GeneratorOptions struct {
+	Locations int `json:"locations"`
+	// The chance of an edge from each location to each other one, from 0 to 1
+	Density float64 `json:"density"`
+	// Weights are drawn uniformly between these
+	MinWeight float64 `json:"min_weight"`
+	MaxWeight float64 `json:"max_weight"`
+	Prefix    string  `json:"prefix"`
+	// The same seed and options give the same graph; nil for a seed from the clock
+	Seed *int64 `json:"seed"`
+}
+
+// What a generated graph added, and the seed to generate it again
+type GeneratorReport struct {
+	Seed int64 `json:"seed"`
+	ImportReport
+}
+
+// POST /admin/generate/ (with JSON locations: int, density: number, min_weight: number, max_weight: number, prefix: string, seed: int optional) :
+// CREATE a random graph for load testing and demos, with an edge between each ordered pair of locations by the given chance.
+// It is added through Import, so is stored in Redis like any other import; an existing edge with another weight is a conflict,
+// and nothing is added.
+func (rs *RouteStore) Generate(opts GeneratorOptions) (GeneratorReport, error) {
+	if opts.Locations < 1 || opts.Locations > MaxGeneratedLocations {
+		return GeneratorReport{}, fmt.Errorf("locations must be between 1 and %d", MaxGeneratedLocations)
+	}
+	if math.IsNaN(opts.Density) || opts.Density < 0 || opts.Density > 1 {
+		return GeneratorReport{}, fmt.Errorf("density must be between 0 and 1, not %g", opts.Density)
+	}
+	if expected := opts.Density * float64(opts.Locations) * float64(opts.Locations-1); expected > MaxGeneratedEdges {
+		return GeneratorReport{}, fmt.Errorf("the graph would have about %d edges, more than %d", int(expected), MaxGeneratedEdges)
+	}
+	for _, weight := range []float64{opts.MinWeight, opts.MaxWeight} {
+		if math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return GeneratorReport{}, fmt.Errorf("weights must be finite numbers, not %g", weight)
+		}
+	}
+	if opts.MinWeight > opts.MaxWeight {
+		return GeneratorReport{}, fmt.Errorf("min_weight %g is more than max_weight %g", opts.MinWeight, opts.MaxWeight)
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "generated-"
+	}
+
+	ret := GeneratorReport{Seed: time.Now().UnixNano()}
+	if opts.Seed != nil {
+		ret.Seed = *opts.Seed
+	}
+	random := rand.New(rand.NewSource(ret.Seed))
+
+	data := GraphData{Locations: make([]string, opts.Locations)}
+	for i := range data.Locations {
+		data.Locations[i] = fmt.Sprintf("%s%d", opts.Prefix, i)
+	}
+	for _, from := range data.Locations {
+		for _, to := range data.Locations {
+			if from != to && random.Float64() < opts.Density {
+				weight := opts.MinWeight + random.Float64()*(opts.MaxWeight-opts.MinWeight)
+				data.Edges = append(data.Edges, Edge{From: from, To: to, Weight: weight})
+			}
+		}
+	}
+
+	var err error
+	ret.ImportReport, err = rs.Import(data, ConflictError)
+	return ret, err
+}
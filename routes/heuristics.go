@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/path"
+	"math"
+	"sort"
+	"sync"
+)
+
+// What a heuristic may look at when it is built for a query. Scale converts the
+// heuristic's natural units (kilometres, degrees, ...) into edge weight units, and
+// must be no more than the cheapest weight per unit for A* to stay admissible.
+// Landmarks is nil unless landmarks have been computed for the current graph.
+type HeuristicInput struct {
+	Coordinates map[int64]Coordinates
+	Scale       float64
+	Landmarks   *LandmarkTable
+}
+
+// Builds an A* heuristic for one query; it must never overestimate the remaining cost
+type HeuristicFactory func(in HeuristicInput) path.Heuristic
+
+var heuristics = struct {
+	sync.Mutex
+	factories map[string]HeuristicFactory
+}{factories: make(map[string]HeuristicFactory)}
+
+// RegisterHeuristic makes a heuristic available to A* queries under name, replacing any already registered
+func RegisterHeuristic(name string, factory HeuristicFactory) {
+	heuristics.Lock()
+	defer heuristics.Unlock()
+
+	heuristics.factories[name] = factory
+}
+
+func lookupHeuristic(name string) (HeuristicFactory, bool) {
+	heuristics.Lock()
+	defer heuristics.Unlock()
+
+	factory, ok := heuristics.factories[name]
+	return factory, ok
+}
+
+// Heuristics lists the names of every registered heuristic
+func Heuristics() []string {
+	heuristics.Lock()
+	defer heuristics.Unlock()
+
+	var ret []string
+	for name := range heuristics.factories {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ParseHeuristic checks s names a registered heuristic; the empty string means the store's default
+func ParseHeuristic(s string) (string, error) {
+	if _, ok := lookupHeuristic(s); s != "" && !ok {
+		return "", fmt.Errorf("unknown heuristic %q, expected one of %v", s, Heuristics())
+	}
+	return s, nil
+}
+
+// coordinateHeuristic applies distance to the coordinates of both nodes, or gives 0 when either has none
+func coordinateHeuristic(distance func(a, b Coordinates) float64) HeuristicFactory {
+	return func(in HeuristicInput) path.Heuristic {
+		return func(x, y graph.Node) float64 {
+			a, okA := in.Coordinates[x.ID()]
+			b, okB := in.Coordinates[y.ID()]
+			if !okA || !okB {
+				return 0
+			}
+			return in.Scale * distance(a, b)
+		}
+	}
+}
+
+const earthRadiusKm = 6371.0
+
+// The great-circle distance between two points in kilometres
+func haversine(a, b Coordinates) float64 {
+	toRad := math.Pi / 180
+	dLat := (b.Lat - a.Lat) * toRad
+	dLon := (b.Lon - a.Lon) * toRad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(a.Lat*toRad)*math.Cos(b.Lat*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Treats coordinates as a grid, for maps where travel follows the axes
+func manhattan(a, b Coordinates) float64 {
+	return math.Abs(a.Lat-b.Lat) + math.Abs(a.Lon-b.Lon)
+}
+
+func init() {
+	RegisterHeuristic("none", func(HeuristicInput) path.Heuristic { return path.NullHeuristic })
+	RegisterHeuristic("great-circle", coordinateHeuristic(haversine))
+	RegisterHeuristic("manhattan", coordinateHeuristic(manhattan))
+}
+
+// SetDefaultHeuristic changes the heuristic A* uses when a query does not ask for one, and its scale.
+// The empty name restores the default, choosing by the destination as described at targetOptions.
+func (rs *RouteStore) SetDefaultHeuristic(name string, scale float64) error {
+	if _, ok := lookupHeuristic(name); name != "" && !ok {
+		return fmt.Errorf("unknown heuristic %q, expected one of %v", name, Heuristics())
+	}
+	if scale < 0 || math.IsNaN(scale) {
+		return fmt.Errorf("heuristic scale %g must not be negative", scale)
+	}
+
+	defer rs.lock("SetDefaultHeuristic")()
+
+	rs.defaultHeuristic = name
+	rs.heuristicScale = scale
+	return nil
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) heuristic(name string) path.Heuristic {
+	factory, ok := lookupHeuristic(name)
+	if !ok {
+		return path.NullHeuristic
+	}
+	return factory(HeuristicInput{Coordinates: rs.coordinates, Scale: rs.heuristicScale, Landmarks: rs.freshLandmarks()})
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"time"
+)
+
+const history_prefix = "rest_project:history:"
+
+// How many changes are kept per edge, unless changed with SetHistoryLength
+const DefaultHistoryLength = 100
+
+// One change to an edge; Weight is absent when the edge was removed
+type EdgeChange struct {
+	At     time.Time `json:"at"`
+	Weight *float64  `json:"weight"`
+}
+
+func historyKey(from, to string) string {
+	return history_prefix + Pair{From: from, To: to}.String()
+}
+
+// SetHistoryLength changes how many changes are kept per edge; 0 stops recording
+func (rs *RouteStore) SetHistoryLength(length int) {
+	defer rs.lock("SetHistoryLength")()
+
+	rs.historyLength = length
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) recordEdge(from, to string, weight *float64) error {
+	if rs.loading || rs.historyLength <= 0 {
+		return nil
+	}
+
+	js, err := json.Marshal(EdgeChange{At: time.Now(), Weight: weight})
+	if err != nil {
+		return err
+	}
+	key := historyKey(from, to)
+	if _, err := rs.redis.Do("RPUSH", key, js); err != nil {
+		return err
+	}
+	_, err = rs.redis.Do("LTRIM", key, -rs.historyLength, -1)
+	return err
+}
+
+// GET  /maps/<from>/edge/<to>/history (?limit=&cursor=&total=true optional) : READ the recorded weight changes of the edge from <from> to <to>, oldest first
+func (rs *RouteStore) EdgeHistory(from, to string) ([]EdgeChange, error) {
+	defer rs.lock("EdgeHistory")()
+
+	entries, err := redis.ByteSlices(rs.redis.Do("LRANGE", historyKey(from, to), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 && rs.graph.Edge(Location(from).ID(), Location(to).ID()) == nil {
+		return nil, fmt.Errorf("there is no edge from %s to %s", from, to)
+	}
+
+	ret := []EdgeChange{}
+	for _, entry := range entries {
+		var change EdgeChange
+		if err := json.Unmarshal(entry, &change); err != nil {
+			return nil, err
+		}
+		ret = append(ret, change)
+	}
+	return ret, nil
+}
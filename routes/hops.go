@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/path"
+	"math"
+)
+
+// hopBoundedRoutes lists every cheapest route from one location to another with no more than maxHops
+// edges, which Dijkstra's algorithm cannot find since the cheapest route overall may be longer. It runs
+// Bellman-Ford for maxHops rounds, keeping the cheapest weight to each node within each number of hops,
+// then walks back from the destination as eachShortestPath does, spending one hop per edge. Negative
+// weights are allowed; a negative cycle reachable from the source is an error, as for BellmanFord.
+func hopBoundedRoutes(g graph.WeightedDirected, from, to Location, maxHops int, precision int) ([]Route, error) {
+	nodes := graph.NodesOf(g.Nodes())
+	// A cheapest route never needs more hops than there are other nodes
+	if maxHops > len(nodes)-1 {
+		maxHops = len(nodes) - 1
+	}
+	if _, ok := path.BellmanFordFrom(from, g); !ok {
+		return nil, ErrNegativeCycle
+	}
+
+	// within[h][id] is the cheapest weight from the source to id in at most h hops
+	within := make([]map[int64]float64, maxHops+1)
+	within[0] = map[int64]float64{from.ID(): 0}
+	for h := 1; h <= maxHops; h++ {
+		within[h] = make(map[int64]float64, len(within[h-1]))
+		for id, d := range within[h-1] {
+			within[h][id] = d
+		}
+		for u, du := range within[h-1] {
+			next := g.From(u)
+			for next.Next() {
+				v := next.Node().ID()
+				w, _ := g.Weight(u, v)
+				if d, ok := within[h][v]; !ok || du+w < d {
+					within[h][v] = du + w
+				}
+			}
+		}
+	}
+	weightWithin := func(h int, id int64) float64 {
+		if d, ok := within[h][id]; ok {
+			return d
+		}
+		return math.Inf(1)
+	}
+
+	weight := weightWithin(maxHops, to.ID())
+	if math.IsInf(weight, 1) {
+		return nil, nil
+	}
+	tolerance := tieTolerance(precision)
+
+	var ret []Route
+	onPath := map[int64]bool{}
+	var reversed []graph.Node
+	// walk extends the route back from id, which must be reached from the source at cost weight in at most hops
+	var walk func(id int64, hops int, weight float64)
+	walk = func(id int64, hops int, weight float64) {
+		onPath[id] = true
+		reversed = append(reversed, g.Node(id))
+		if id == from.ID() {
+			nodes := make([]graph.Node, len(reversed))
+			for i, node := range reversed {
+				nodes[len(reversed)-1-i] = node
+			}
+			ret = append(ret, pathsToRoutes([][]graph.Node{nodes}, weightWithin(maxHops, to.ID()))...)
+		} else if hops > 0 {
+			preds := g.To(id)
+			for preds.Next() {
+				u := preds.Node().ID()
+				w, _ := g.Weight(u, id)
+				if du := weightWithin(hops-1, u); !onPath[u] && !math.IsInf(du, 1) && math.Abs(du+w-weight) <= tolerance {
+					walk(u, hops-1, du)
+				}
+			}
+		}
+		reversed = reversed[:len(reversed)-1]
+		onPath[id] = false
+	}
+	walk(to.ID(), maxHops, weight)
+
+	return ret, nil
+}
@@ -0,0 +1,317 @@
+package routes
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"gonum.org/v1/gonum/graph"
+	"math"
+	"sort"
+)
+
+const hot_sources_set = "rest_project:hot_sources"
+
+// A shortest path tree (strictly a DAG, to keep ties) from one source, kept up to date
+// as edges change rather than recomputed per query. Weight decreases and new edges are
+// relaxed outwards from the changed edge; losing the last shortest predecessor of a node
+// falls back to a full recomputation. Being Dijkstra's, it cannot follow negative weights,
+// so is dropped while the graph has any, see updateHot.
+type shortestPathTree struct {
+	source int64
+	dist   map[int64]float64
+	preds  map[int64][]int64
+	// Distances no further apart than this tie, see tieTolerance
+	tolerance float64
+	// Set while the graph has negative weights, when dist and preds are empty
+	dropped bool
+
+	recomputations, relaxations uint64
+}
+
+type queueItem struct {
+	id   int64
+	dist float64
+}
+
+type distQueue []queueItem
+
+func (q distQueue) Len() int            { return len(q) }
+func (q distQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q distQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *distQueue) Push(x interface{}) { *q = append(*q, x.(queueItem)) }
+func (q *distQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+func newShortestPathTree(g graph.Weighted, source int64, tolerance float64) *shortestPathTree {
+	tree := &shortestPathTree{source: source, tolerance: tolerance}
+	tree.recompute(g)
+	return tree
+}
+
+func (t *shortestPathTree) distTo(id int64) float64 {
+	if d, ok := t.dist[id]; ok {
+		return d
+	}
+	return math.Inf(1)
+}
+
+func (t *shortestPathTree) hasPred(id, pred int64) bool {
+	for _, p := range t.preds[id] {
+		if p == pred {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *shortestPathTree) removePred(id, pred int64) {
+	preds := t.preds[id]
+	for i, p := range preds {
+		if p == pred {
+			t.preds[id] = append(preds[:i:i], preds[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *shortestPathTree) recompute(g graph.Weighted) {
+	t.recomputations++
+	t.dropped = false
+	t.dist = make(map[int64]float64)
+	t.preds = make(map[int64][]int64)
+	if g.Node(t.source) == nil {
+		return
+	}
+	t.dist[t.source] = 0
+	t.relax(g, t.source)
+}
+
+// drop empties the tree until it is recomputed
+func (t *shortestPathTree) drop() {
+	t.dropped = true
+	t.dist = make(map[int64]float64)
+	t.preds = make(map[int64][]int64)
+}
+
+// relax runs Dijkstra outwards from start, whose distance has just been lowered
+func (t *shortestPathTree) relax(g graph.Weighted, start int64) {
+	t.relaxations++
+	queue := distQueue{{id: start, dist: t.dist[start]}}
+	for queue.Len() > 0 {
+		item := heap.Pop(&queue).(queueItem)
+		if item.dist > t.distTo(item.id) {
+			continue
+		}
+
+		to := g.From(item.id)
+		for to.Next() {
+			next := to.Node().ID()
+			w, _ := g.Weight(item.id, next)
+			joint := item.dist + w
+			switch current := t.distTo(next); {
+			case joint < current-t.tolerance:
+				t.dist[next] = joint
+				t.preds[next] = []int64{item.id}
+				heap.Push(&queue, queueItem{id: next, dist: joint})
+			case joint <= current+t.tolerance && !t.hasPred(next, item.id):
+				t.preds[next] = append(t.preds[next], item.id)
+			}
+		}
+	}
+}
+
+func (t *shortestPathTree) edgeSet(g graph.Weighted, from, to int64, existed bool, old, weight float64) {
+	joint := t.distTo(from) + weight
+	if math.IsInf(joint, 1) {
+		return
+	}
+
+	switch current := t.distTo(to); {
+	case joint < current-t.tolerance:
+		t.dist[to] = joint
+		t.preds[to] = []int64{from}
+		t.relax(g, to)
+	case joint <= current+t.tolerance:
+		if !t.hasPred(to, from) {
+			t.preds[to] = append(t.preds[to], from)
+		}
+	case existed && weight > old && t.hasPred(to, from):
+		t.edgeRemoved(g, from, to)
+	}
+}
+
+func (t *shortestPathTree) edgeRemoved(g graph.Weighted, from, to int64) {
+	if !t.hasPred(to, from) {
+		return
+	}
+	t.removePred(to, from)
+	if !t.supported(to) {
+		t.recompute(g)
+	}
+}
+
+// supported reports whether the remaining predecessors of id still lead back to the source
+// without passing through id, which zero weight cycles can otherwise fake
+func (t *shortestPathTree) supported(id int64) bool {
+	seen := map[int64]bool{id: true}
+	stack := append([]int64(nil), t.preds[id]...)
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if next == t.source {
+			return true
+		}
+		if !seen[next] {
+			seen[next] = true
+			stack = append(stack, t.preds[next]...)
+		}
+	}
+	return false
+}
+
+func (t *shortestPathTree) nodeRemoved(g graph.Weighted, id int64) {
+	if _, reachable := t.dist[id]; reachable {
+		t.recompute(g)
+	}
+}
+
+// routes lists every shortest route to the given node, in the same form as RoutesBetween
+func (t *shortestPathTree) routes(g graph.Graph, to int64) []Route {
+	weight, ok := t.dist[to]
+	if !ok {
+		return nil
+	}
+
+	var ret []Route
+	onPath := make(map[int64]bool)
+	var reversed []string
+	var walk func(id int64)
+	walk = func(id int64) {
+		if onPath[id] {
+			return
+		}
+		onPath[id] = true
+		reversed = append(reversed, nodeName(g.Node(id)))
+		if id == t.source {
+			route := Route{Weight: weight, Route: make([]string, len(reversed))}
+			for i, name := range reversed {
+				route.Route[len(reversed)-1-i] = name
+			}
+			ret = append(ret, route)
+		} else {
+			for _, pred := range t.preds[id] {
+				walk(pred)
+			}
+		}
+		reversed = reversed[:len(reversed)-1]
+		onPath[id] = false
+	}
+	walk(to)
+
+	return ret
+}
+
+// A registered hot source and how much work keeping it current has taken
+type HotSource struct {
+	Name           string `json:"name"`
+	Reachable      int    `json:"reachable"`
+	Recomputations uint64 `json:"recomputations"`
+	Relaxations    uint64 `json:"relaxations"`
+	// Whether its tree is dropped while the graph has negative weights
+	Dropped bool `json:"dropped,omitempty"`
+}
+
+// Must be called with the lock held; a tree from the location id, dropped from the start if the graph has negative weights
+func (rs *RouteStore) newHotTree(source int64) *shortestPathTree {
+	if rs.negativeEdges > 0 {
+		tree := &shortestPathTree{source: source, tolerance: tieTolerance(rs.precision)}
+		tree.drop()
+		return tree
+	}
+	return newShortestPathTree(rs.graph, source, tieTolerance(rs.precision))
+}
+
+// Must be called with the lock held, after each change to the graph; passes it on to every hot tree by update. While
+// the graph has negative weights the trees are dropped instead, since relaxing them could loop forever round a negative
+// cycle, and once it has none again they are recomputed.
+func (rs *RouteStore) updateHot(update func(tree *shortestPathTree)) {
+	for _, tree := range rs.hot {
+		switch {
+		case rs.negativeEdges > 0:
+			if !tree.dropped {
+				tree.drop()
+			}
+		case tree.dropped:
+			tree.recompute(rs.graph)
+		default:
+			update(tree)
+		}
+	}
+}
+
+func (rs *RouteStore) restoreHotSources() error {
+	sources, err := redis.Strings(rs.redis.Do("SMEMBERS", hot_sources_set))
+	if err != nil {
+		return err
+	}
+	for _, name := range sources {
+		rs.hot[name] = rs.newHotTree(Location(name).ID())
+	}
+	return nil
+}
+
+// GET  /admin/hot/ : READ the locations whose shortest paths are maintained incrementally
+func (rs *RouteStore) GetHotSources() []HotSource {
+	defer rs.rlock("GetHotSources")()
+
+	ret := []HotSource{}
+	for name, tree := range rs.hot {
+		ret = append(ret, HotSource{
+			Name:           name,
+			Reachable:      len(tree.dist),
+			Recomputations: tree.recomputations,
+			Relaxations:    tree.relaxations,
+			Dropped:        tree.dropped,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// PUT  /admin/hot/<location> : UPDATE maintain shortest paths from <location> incrementally
+func (rs *RouteStore) AddHotSource(name string) error {
+	defer rs.lock("AddHotSource")()
+
+	loc := Location(name)
+	if rs.graph.Node(loc.ID()) == nil {
+		return fmt.Errorf("%s does not exist", loc)
+	}
+	if _, ok := rs.hot[name]; ok {
+		return fmt.Errorf("%s is already a hot source", loc)
+	}
+
+	if _, err := rs.redis.Do("SADD", hot_sources_set, name); err != nil {
+		return err
+	}
+	rs.hot[name] = rs.newHotTree(loc.ID())
+	return nil
+}
+
+// DELETE /admin/hot/<location> : DELETE stop maintaining shortest paths from <location>
+func (rs *RouteStore) RemoveHotSource(name string) error {
+	defer rs.lock("RemoveHotSource")()
+
+	if _, ok := rs.hot[name]; !ok {
+		return fmt.Errorf("%s is not a hot source", name)
+	}
+
+	if _, err := rs.redis.Do("SREM", hot_sources_set, name); err != nil {
+		return err
+	}
+	delete(rs.hot, name)
+	return nil
+}
@@ -0,0 +1,58 @@
+package routes
+
+import (
+	"testing"
+	"time"
+)
+
+// Setting the edges of a negative cycle reachable from a hot source used to relax its tree forever, holding the lock
+func TestHotSourceSurvivesNegativeCycle(t *testing.T) {
+	rs := New(newMemoryRedis())
+	for _, name := range []string{"A", "B"} {
+		if err := rs.AddLocation(name, nil, nil, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.AddHotSource("A"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		if err := rs.AddRoutes("A", givenWeights(map[string]float64{"B": -5}), new(bool)); err != nil {
+			done <- err
+			return
+		}
+		done <- rs.AddRoutes("B", givenWeights(map[string]float64{"A": 1}), new(bool))
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("adding a negative cycle reachable from a hot source did not return")
+	}
+
+	if hot := rs.GetHotSources(); len(hot) != 1 || !hot[0].Dropped {
+		t.Fatalf("the hot source should be dropped while there are negative weights, got %+v", hot)
+	}
+	if _, err := rs.Within("A", 10); err != ErrNegativeCycle {
+		t.Fatalf("Within should find the negative cycle rather than read the dropped tree, got %v", err)
+	}
+
+	// Once the weights are not negative, the tree is rebuilt and answers again
+	if err := rs.AddRoutes("A", givenWeights(map[string]float64{"B": 2}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	if hot := rs.GetHotSources(); hot[0].Dropped || hot[0].Reachable != 2 {
+		t.Fatalf("the hot source should be rebuilt once there are no negative weights, got %+v", hot)
+	}
+	routes, err := rs.RoutesBetween("A", "B", RouteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].Weight != 2 {
+		t.Fatalf("expected one route from A to B weighing 2, got %+v", routes)
+	}
+}
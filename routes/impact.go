@@ -0,0 +1,172 @@
+package routes
+
+import (
+	"fmt"
+	"sort"
+)
+
+// How a watched or critical pair's best route would change; a route is null where there would be none
+type RouteImpact struct {
+	From     string         `json:"from"`
+	To       string         `json:"to"`
+	Watched  bool           `json:"watched"`
+	Critical bool           `json:"critical"`
+	Before   *ScenarioRoute `json:"before"`
+	After    *ScenarioRoute `json:"after"`
+	// After's weight less before's; null unless both have a route
+	Delta *float64 `json:"delta"`
+	// Whether the pair has a route now and would have none
+	Broken bool `json:"broken"`
+}
+
+// What taking a location or edge away would do, without doing it
+type Impact struct {
+	// The edges that would go, both ways for a two-way edge
+	Removed []Pair `json:"removed"`
+	// The watched and critical pairs whose best route would change or break, in name order; the rest are unaffected
+	Affected []RouteImpact `json:"affected"`
+}
+
+func sameRoute(a, b *ScenarioRoute) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Weight != b.Weight || len(a.Route) != len(b.Route) {
+		return false
+	}
+	for i := range a.Route {
+		if a.Route[i] != b.Route[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Copies of the graph as it is and with some edges taken away, and the watched and critical pairs to compare in them
+type impactScenario struct {
+	before, after                 *adjacencyGraph
+	beforeNegative, afterNegative int
+	removed                       []Pair
+	pairs                         map[Pair]*RouteImpact
+	precision                     int
+	visitCosts                    map[int64]float64
+}
+
+// Must be called with the lock held; a scenario with the edges in removed taken away, and location too if it is not ""
+func (rs *RouteStore) newImpactScenario(location string, removed []Pair) *impactScenario {
+	ret := &impactScenario{
+		before:         rs.copyGraph(),
+		after:          rs.copyGraph(),
+		beforeNegative: rs.negativeEdges,
+		afterNegative:  rs.negativeEdges,
+		removed:        removed,
+		pairs:          make(map[Pair]*RouteImpact),
+		precision:      rs.precision,
+		visitCosts:     rs.copyVisitCosts(),
+	}
+	for _, pair := range removed {
+		from, to := Location(pair.From), Location(pair.To)
+		if weight, _ := ret.after.Weight(from.ID(), to.ID()); weight < 0 {
+			ret.afterNegative--
+		}
+		ret.after.RemoveEdge(from.ID(), to.ID())
+	}
+	if location != "" {
+		ret.after.RemoveNode(Location(location).ID())
+	}
+
+	impact := func(pair Pair) *RouteImpact {
+		if ret.pairs[pair] == nil {
+			ret.pairs[pair] = &RouteImpact{From: pair.From, To: pair.To}
+		}
+		return ret.pairs[pair]
+	}
+	for pair := range rs.watched {
+		impact(pair).Watched = true
+	}
+	for pair := range rs.critical {
+		impact(pair).Critical = true
+	}
+	return ret
+}
+
+// assess compares the best route of each pair before and after, keeping those that change
+func (s *impactScenario) assess() (Impact, error) {
+	ret := Impact{Removed: s.removed, Affected: []RouteImpact{}}
+	bestIn := func(g *adjacencyGraph, negativeEdges int, pair Pair) (*ScenarioRoute, error) {
+		from, to := Location(pair.From), Location(pair.To)
+		if g.Node(from.ID()) == nil || g.Node(to.ID()) == nil {
+			return nil, nil
+		}
+		return bestRoute(g, negativeEdges, s.visitCosts, from, to, s.precision)
+	}
+	for pair, impact := range s.pairs {
+		var err error
+		if impact.Before, err = bestIn(s.before, s.beforeNegative, pair); err != nil {
+			return Impact{}, err
+		}
+		if impact.After, err = bestIn(s.after, s.afterNegative, pair); err != nil {
+			return Impact{}, err
+		}
+		if sameRoute(impact.Before, impact.After) {
+			continue
+		}
+		if impact.Before != nil && impact.After != nil {
+			delta := roundWeight(impact.After.Weight-impact.Before.Weight, s.precision)
+			impact.Delta = &delta
+		}
+		impact.Broken = impact.Before != nil && impact.After == nil
+		ret.Affected = append(ret.Affected, *impact)
+	}
+	sort.Slice(ret.Affected, func(i, j int) bool {
+		if ret.Affected[i].From != ret.Affected[j].From {
+			return ret.Affected[i].From < ret.Affected[j].From
+		}
+		return ret.Affected[i].To < ret.Affected[j].To
+	})
+	return ret, nil
+}
+
+// POST /maps/<location>/impact : READ which watched and critical routes would change or break if <location> were deleted.
+// Nothing is changed; the routes are found on copies of the graph, without holding up the store.
+func (rs *RouteStore) LocationImpact(name string) (Impact, error) {
+	unlock := rs.rlock("LocationImpact")
+	loc := Location(name)
+	if rs.graph.Node(loc.ID()) == nil {
+		unlock()
+		return Impact{}, fmt.Errorf("%s does not exist", loc)
+	}
+	removed := []Pair{}
+	out := rs.graph.From(loc.ID())
+	for out.Next() {
+		removed = append(removed, Pair{From: name, To: nodeName(out.Node())})
+	}
+	in := rs.graph.To(loc.ID())
+	for in.Next() {
+		removed = append(removed, Pair{From: nodeName(in.Node()), To: name})
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i].String() < removed[j].String() })
+	scenario := rs.newImpactScenario(name, removed)
+	unlock()
+
+	return scenario.assess()
+}
+
+// POST /maps/<from>/edge/<to>/impact : READ which watched and critical routes would change or break if the edge from <from>
+// to <to> were removed, with the edge back too if the pair is two-way, as removing it would
+func (rs *RouteStore) EdgeImpact(fromStr, toStr string) (Impact, error) {
+	unlock := rs.rlock("EdgeImpact")
+	from, to := Location(fromStr), Location(toStr)
+	if from == to || !rs.graph.HasEdgeFromTo(from.ID(), to.ID()) {
+		unlock()
+		return Impact{}, fmt.Errorf("%w from %s to %s", ErrNoEdge, from, to)
+	}
+	removed := []Pair{{From: fromStr, To: toStr}}
+	if rs.isTwoWay(from, to) && rs.graph.HasEdgeFromTo(to.ID(), from.ID()) {
+		removed = append(removed, Pair{From: toStr, To: fromStr})
+	}
+	scenario := rs.newImpactScenario("", removed)
+	unlock()
+
+	return scenario.assess()
+}
@@ -0,0 +1,147 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/iterator"
+	"gonum.org/v1/gonum/graph/path"
+	"sort"
+	"strings"
+)
+
+var ErrKShortestNegativeWeights = errors.New("k shortest routes cannot be found while there are negative edge weights")
+
+// GET  /maps/<from>/<to> (?k=<n>) : READ list of the k cheapest routes from <from> to <to>, cheapest first
+func (rs *RouteStore) KShortestRoutes(fromStr, toStr string, k int) ([]Route, error) {
+	if k < 1 {
+		return nil, fmt.Errorf("k must be at least 1, not %d", k)
+	}
+
+	defer rs.rlock("KShortestRoutes")()
+
+	from, to := Location(fromStr), Location(toStr)
+
+	if rs.graph.Node(from.ID()) == nil {
+		return nil, fmt.Errorf("%s does not exist", from)
+	}
+	if rs.graph.Node(to.ID()) == nil {
+		return nil, fmt.Errorf("%s does not exist", to)
+	}
+	if rs.negativeEdges > 0 {
+		return nil, ErrKShortestNegativeWeights
+	}
+
+	rs.countQuery(fromStr, toStr)
+
+	var ret []Route
+	for _, p := range yenKShortestPaths(rs.visitCostGraph(rs.graph, from), from, to, k) {
+		ret = append(ret, pathsToRoutes([][]graph.Node{p.nodes}, p.weight)...)
+	}
+	rs.roundRoutes(ret)
+	rs.issueTokens(ret, RouteOptions{})
+	return ret, nil
+}
+
+// The graph with some nodes and edges hidden, for the spur searches of Yen's algorithm
+type spurGraph struct {
+	graph.WeightedDirected
+	hiddenNodes map[int64]bool
+	hiddenEdges map[[2]int64]bool
+}
+
+func (g spurGraph) From(id int64) graph.Nodes {
+	var nodes []graph.Node
+	it := g.WeightedDirected.From(id)
+	for it.Next() {
+		next := it.Node().ID()
+		if !g.hiddenNodes[next] && !g.hiddenEdges[[2]int64{id, next}] {
+			nodes = append(nodes, it.Node())
+		}
+	}
+	if len(nodes) == 0 {
+		return graph.Empty
+	}
+	return iterator.NewOrderedNodes(nodes)
+}
+
+type weightedPath struct {
+	nodes  []graph.Node
+	weight float64
+}
+
+func (p weightedPath) key() string {
+	names := make([]string, len(p.nodes))
+	for i, node := range p.nodes {
+		names[i] = nodeName(node)
+	}
+	return strings.Join(names, "/")
+}
+
+// yenKShortestPaths finds up to k loopless paths from s to t, cheapest first, using Yen's algorithm.
+// gonum's own path.YenKShortestPaths leaves the nodes of the root path in the graph for the spur
+// searches, so it can return paths that loop, and miss cheaper loopless ones; this hides them, as Yen does.
+// Weights must not be negative.
+func yenKShortestPaths(g graph.WeightedDirected, s, t graph.Node, k int) []weightedPath {
+	first, weight := path.DijkstraFrom(s, g).To(t.ID())
+	if len(first) == 0 {
+		return nil
+	}
+	paths := []weightedPath{{first, weight}}
+
+	var candidates []weightedPath
+	seen := map[string]bool{paths[0].key(): true}
+	for len(paths) < k {
+		last := paths[len(paths)-1].nodes
+		var rootWeight float64
+		for i := 0; i < len(last)-1; i++ {
+			spur := last[i]
+			sg := spurGraph{WeightedDirected: g, hiddenNodes: make(map[int64]bool), hiddenEdges: make(map[[2]int64]bool)}
+			for _, node := range last[:i] {
+				sg.hiddenNodes[node.ID()] = true
+			}
+			for _, p := range paths {
+				if len(p.nodes) > i+1 && samePrefix(p.nodes, last, i+1) {
+					sg.hiddenEdges[[2]int64{p.nodes[i].ID(), p.nodes[i+1].ID()}] = true
+				}
+			}
+
+			spurPath, spurWeight := path.DijkstraFrom(spur, sg).To(t.ID())
+			if len(spurPath) > 0 {
+				nodes := append(append([]graph.Node(nil), last[:i]...), spurPath...)
+				candidate := weightedPath{nodes, rootWeight + spurWeight}
+				if key := candidate.key(); !seen[key] {
+					seen[key] = true
+					candidates = append(candidates, candidate)
+				}
+			}
+
+			w, _ := g.Weight(spur.ID(), last[i+1].ID())
+			rootWeight += w
+		}
+		if len(candidates) == 0 {
+			break
+		}
+
+		// Ties are broken by name, so the same graph always gives the same routes
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].weight != candidates[j].weight {
+				return candidates[i].weight < candidates[j].weight
+			}
+			return candidates[i].key() < candidates[j].key()
+		})
+		paths = append(paths, candidates[0])
+		candidates = candidates[1:]
+	}
+	return paths
+}
+
+// samePrefix is whether a and b agree on their first n nodes
+func samePrefix(a, b []graph.Node, n int) bool {
+	for i := 0; i < n; i++ {
+		if a[i].ID() != b[i].ID() {
+			return false
+		}
+	}
+	return true
+}
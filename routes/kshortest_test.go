@@ -0,0 +1,146 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// Every loopless path from s to t, by walking every one
+func allSimplePaths(g *adjacencyGraph, s, t Location) []weightedPath {
+	var ret []weightedPath
+	onPath := map[int64]bool{s.ID(): true}
+	var walk func(p weightedPath)
+	walk = func(p weightedPath) {
+		at := p.nodes[len(p.nodes)-1]
+		if at.ID() == t.ID() {
+			ret = append(ret, weightedPath{append([]graph.Node(nil), p.nodes...), p.weight})
+			return
+		}
+		next := g.From(at.ID())
+		for next.Next() {
+			node := next.Node()
+			if onPath[node.ID()] {
+				continue
+			}
+			w, _ := g.Weight(at.ID(), node.ID())
+			onPath[node.ID()] = true
+			walk(weightedPath{append(p.nodes, node), p.weight + w})
+			onPath[node.ID()] = false
+		}
+	}
+	walk(weightedPath{[]graph.Node{s}, 0})
+	sort.Slice(ret, func(i, j int) bool { return ret[i].weight < ret[j].weight })
+	return ret
+}
+
+// yenKShortestPaths must give the k lightest loopless paths, each a real path of g weighing what it says, with no path
+// twice. Which of several paths tied at the last place it gives is not checked, only that the weights are right.
+func checkKShortest(t *testing.T, g *adjacencyGraph, s, target Location, k int) {
+	t.Helper()
+	want := allSimplePaths(g, s, target)
+	if len(want) > k {
+		want = want[:k]
+	}
+	got := yenKShortestPaths(g, s, target, k)
+	if len(got) != len(want) {
+		t.Fatalf("k=%d: got %d paths, want %d: %v", k, len(got), len(want), got)
+	}
+
+	seen := make(map[string]bool)
+	for i, p := range got {
+		if p.weight != want[i].weight {
+			t.Fatalf("k=%d: path %d %s weighs %g, the %d lightest weigh %g", k, i, p.key(), p.weight, i+1, want[i].weight)
+		}
+		if seen[p.key()] {
+			t.Fatalf("k=%d: %s is given twice", k, p.key())
+		}
+		seen[p.key()] = true
+
+		if p.nodes[0].ID() != s.ID() || p.nodes[len(p.nodes)-1].ID() != target.ID() {
+			t.Fatalf("k=%d: %s does not go from %s to %s", k, p.key(), s, target)
+		}
+		visited := make(map[int64]bool)
+		var weight float64
+		for j, node := range p.nodes {
+			if visited[node.ID()] {
+				t.Fatalf("k=%d: %s loops", k, p.key())
+			}
+			visited[node.ID()] = true
+			if j > 0 {
+				w, ok := g.Weight(p.nodes[j-1].ID(), node.ID())
+				if !ok {
+					t.Fatalf("k=%d: %s uses a missing edge", k, p.key())
+				}
+				weight += w
+			}
+		}
+		if weight != p.weight {
+			t.Fatalf("k=%d: %s weighs %g, not %g", k, p.key(), weight, p.weight)
+		}
+	}
+}
+
+func graphOf(edges map[string]float64) *adjacencyGraph {
+	g := newAdjacencyGraph()
+	for e, w := range edges {
+		var from, to string
+		fmt.Sscanf(e, "%s %s", &from, &to)
+		for _, name := range []string{from, to} {
+			if g.Node(Location(name).ID()) == nil {
+				g.AddNode(Location(name))
+			}
+		}
+		g.SetWeightedEdge(g.NewWeightedEdge(Location(from), Location(to), w))
+	}
+	return g
+}
+
+func TestYenKShortestPaths(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges map[string]float64
+	}{
+		{"ties", map[string]float64{"s a": 1, "a t": 1, "s b": 1, "b t": 1, "s c": 1, "c t": 1, "s t": 2}},
+		{"loops", map[string]float64{"s a": 1, "a b": 1, "b a": 1, "b s": 1, "a t": 3, "b t": 1, "t s": 1, "t a": 1}},
+		// Spurs from s and from a both find s -> a -> c -> t and s -> b -> c -> t again
+		{"duplicate spur paths", map[string]float64{"s a": 1, "s b": 1, "a b": 0, "b a": 0, "a c": 1, "b c": 1, "c t": 1, "a t": 3, "b t": 3}},
+		{"zero weights", map[string]float64{"s a": 0, "a b": 0, "b t": 0, "s b": 0, "a t": 0, "s t": 0}},
+		{"one path", map[string]float64{"s a": 1, "a t": 1, "t a": 1}},
+		{"unreachable", map[string]float64{"s a": 1, "t a": 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := graphOf(test.edges)
+			// Up to well past the number of paths there are
+			for k := 1; k <= 12; k++ {
+				checkKShortest(t, g, "s", "t", k)
+			}
+			checkKShortest(t, g, "s", "s", 3)
+		})
+	}
+}
+
+// Small random graphs, with many ties among few weights, against every path there is
+func TestYenKShortestPathsRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 300; i++ {
+		edges := make(map[string]float64)
+		for from := 0; from < 6; from++ {
+			for to := 0; to < 6; to++ {
+				if from != to && r.Float64() < 0.4 {
+					edges[fmt.Sprint("n", from, " n", to)] = float64(r.Intn(4))
+				}
+			}
+		}
+		g := graphOf(edges)
+		for _, name := range []Location{"n0", "n5"} {
+			if g.Node(name.ID()) == nil {
+				g.AddNode(name)
+			}
+		}
+		checkKShortest(t, g, "n0", "n5", 1+r.Intn(20))
+	}
+}
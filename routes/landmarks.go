@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/path"
+	"math"
+	"sort"
+)
+
+// reversed presents a graph with every edge turned around, so searches can run towards a node
+type reversed struct {
+	g *adjacencyGraph
+}
+
+func (r reversed) Node(id int64) graph.Node           { return r.g.Node(id) }
+func (r reversed) Nodes() graph.Nodes                 { return r.g.Nodes() }
+func (r reversed) From(id int64) graph.Nodes          { return r.g.To(id) }
+func (r reversed) HasEdgeBetween(xid, yid int64) bool { return r.g.HasEdgeBetween(xid, yid) }
+func (r reversed) Edge(uid, vid int64) graph.Edge {
+	if e := r.g.Edge(vid, uid); e != nil {
+		return e.ReversedEdge()
+	}
+	return nil
+}
+func (r reversed) Weight(xid, yid int64) (float64, bool) { return r.g.Weight(yid, xid) }
+
+// Distances to and from a few landmark nodes, giving the ALT lower bound
+// d(v, t) >= max(d(L, t) - d(L, v), d(v, L) - d(t, L)) for A*.
+// Only valid for the graph revision it was computed from.
+type LandmarkTable struct {
+	revision uint64
+	ids      []int64
+	from     []path.Shortest
+	to       []path.Shortest
+}
+
+func finite(f float64) bool {
+	return !math.IsInf(f, 0) && !math.IsNaN(f)
+}
+
+// Bound is the ALT lower bound on the cost from v to t
+func (table *LandmarkTable) Bound(v, t int64) float64 {
+	best := 0.0
+	for i := range table.ids {
+		if lv, lt := table.from[i].WeightTo(v), table.from[i].WeightTo(t); finite(lv) && finite(lt) && lt-lv > best {
+			best = lt - lv
+		}
+		if vl, tl := table.to[i].WeightTo(v), table.to[i].WeightTo(t); finite(vl) && finite(tl) && vl-tl > best {
+			best = vl - tl
+		}
+	}
+	return best
+}
+
+// computeLandmarks picks count landmarks spread apart (each the node furthest from those already
+// chosen) and runs Dijkstra to and from each
+func computeLandmarks(g *adjacencyGraph, count int, revision uint64) *LandmarkTable {
+	table := &LandmarkTable{revision: revision}
+
+	nodes := graph.NodesOf(g.Nodes())
+	sort.Slice(nodes, func(i, j int) bool { return nodeName(nodes[i]) < nodeName(nodes[j]) })
+	if len(nodes) == 0 {
+		return table
+	}
+
+	chosen := make(map[int64]bool)
+	next := nodes[0]
+	for len(table.ids) < count && next != nil {
+		chosen[next.ID()] = true
+		table.ids = append(table.ids, next.ID())
+		table.from = append(table.from, path.DijkstraFrom(next, g))
+		table.to = append(table.to, path.DijkstraFrom(next, reversed{g}))
+
+		next = nil
+		furthest := -1.0
+		for _, node := range nodes {
+			if chosen[node.ID()] {
+				continue
+			}
+			nearest := math.Inf(1)
+			for i := range table.ids {
+				nearest = math.Min(nearest, table.from[i].WeightTo(node.ID())+table.to[i].WeightTo(node.ID()))
+			}
+			// Nodes no landmark can reach are the most in need of one
+			if nearest > furthest {
+				next, furthest = node, nearest
+			}
+		}
+	}
+	return table
+}
+
+// Landmark selection and freshness
+type LandmarkStatus struct {
+	Landmarks []string `json:"landmarks"`
+	Fresh     bool     `json:"fresh"`
+}
+
+func init() {
+	RegisterHeuristic("landmark", func(in HeuristicInput) path.Heuristic {
+		if in.Landmarks == nil {
+			return path.NullHeuristic
+		}
+		return func(x, y graph.Node) float64 {
+			return in.Landmarks.Bound(x.ID(), y.ID())
+		}
+	})
+}
+
+// SetLandmarkCount changes how many landmarks RefreshLandmarks picks; 0 turns ALT off
+func (rs *RouteStore) SetLandmarkCount(count int) {
+	defer rs.lock("SetLandmarkCount")()
+
+	rs.landmarkCount = count
+}
+
+// Must be called with the lock held; the table if it matches the current graph, otherwise nil
+func (rs *RouteStore) freshLandmarks() *LandmarkTable {
+	if rs.landmarks == nil || rs.landmarks.revision != rs.revision || rs.negativeEdges > 0 {
+		return nil
+	}
+	return rs.landmarks
+}
+
+// RefreshLandmarks recomputes the landmark table if the graph has changed since it was built.
+// The search runs on a copy of the graph so queries are not held up meanwhile.
+func (rs *RouteStore) RefreshLandmarks() {
+	unlock := rs.lock("RefreshLandmarks")
+	if rs.landmarkCount <= 0 || rs.negativeEdges > 0 || rs.freshLandmarks() != nil {
+		unlock()
+		return
+	}
+	g, revision, count := rs.copyGraph(), rs.revision, rs.landmarkCount
+	unlock()
+
+	table := computeLandmarks(g, count, revision)
+
+	defer rs.lock("RefreshLandmarks")()
+	if rs.revision == revision {
+		rs.landmarks = table
+	}
+}
+
+// GET  /admin/landmarks/ : READ the current landmarks and whether they match the graph
+func (rs *RouteStore) LandmarkStatus() LandmarkStatus {
+	defer rs.rlock("LandmarkStatus")()
+
+	ret := LandmarkStatus{Landmarks: []string{}, Fresh: rs.freshLandmarks() != nil}
+	if rs.landmarks != nil {
+		for _, id := range rs.landmarks.ids {
+			if node := rs.graph.Node(id); node != nil {
+				ret.Landmarks = append(ret.Landmarks, nodeName(node))
+			}
+		}
+	}
+	return ret
+}
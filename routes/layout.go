@@ -0,0 +1,193 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/layout"
+	"gonum.org/v1/gonum/mat"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+// Ways of placing locations on a plane
+const (
+	// Force-directed: linked locations pull together and all of them push apart; the default
+	ForceLayout = "force"
+	// The second and third eigenvectors of the graph's Laplacian, which puts closely linked locations near each other;
+	// in a graph of several components, each of those can collapse to a point
+	SpectralLayout = "spectral"
+)
+
+const (
+	// Steps of the force-directed layout
+	forceLayoutUpdates = 100
+	// The most locations a spectral layout is computed for, since it solves an eigenproblem the size of the graph
+	MaxSpectralLocations = 1000
+)
+
+// Where one location is drawn, within the unit square
+type LocationPosition struct {
+	Name string  `json:"name"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+}
+
+// Positions for every location, for drawing the graph where locations have no coordinates
+type Layout struct {
+	Algorithm string `json:"algorithm"`
+	// In name order
+	Locations []LocationPosition `json:"locations"`
+	// Whether this came from the cache rather than being computed for the request
+	Cached bool `json:"cached"`
+	// The graph revision it was computed for, which the cache holds it until
+	revision uint64
+}
+
+// Must be called with the lock held; a copy of the graph with its locations in name order, so that a layout
+// seeded the same way comes out the same whatever order the locations were added or restored in
+func (rs *RouteStore) sortedGraphCopy() *adjacencyGraph {
+	nodes := graph.NodesOf(rs.graph.Nodes())
+	sort.Slice(nodes, func(i, j int) bool { return nodeName(nodes[i]) < nodeName(nodes[j]) })
+	ret := newAdjacencyGraph()
+	for _, node := range nodes {
+		ret.AddNode(node)
+	}
+	edges := rs.graph.WeightedEdges()
+	for edges.Next() {
+		edge := edges.WeightedEdge()
+		ret.SetWeightedEdge(ret.NewWeightedEdge(edge.From(), edge.To(), edge.Weight()))
+	}
+	return ret
+}
+
+// GET  /maps/layout/ (?algorithm=force|spectral optional; Cache-Control: no-cache|only-if-cached optional) : READ a position in the
+// unit square for every location, so UIs can draw graphs without coordinates. Weights are ignored, and both algorithms are seeded
+// the same way each time, so an unchanged graph is drawn the same way. Each layout is kept until the graph changes; CacheBypass
+// recomputes it and CacheOnly fails with ErrNotCached rather than compute it. Computing runs on a copy of the graph.
+func (rs *RouteStore) Layout(algorithm string, mode CacheMode) (Layout, error) {
+	if algorithm == "" {
+		algorithm = ForceLayout
+	}
+	if algorithm != ForceLayout && algorithm != SpectralLayout {
+		return Layout{}, fmt.Errorf("unknown layout algorithm %q, expected %s or %s", algorithm, ForceLayout, SpectralLayout)
+	}
+
+	unlock := rs.rlock("Layout")
+	cached, revision := rs.layouts[algorithm], rs.revision
+	if cached != nil && cached.revision == revision && mode != CacheBypass {
+		unlock()
+		ret := *cached
+		ret.Cached = true
+		return ret, nil
+	}
+	if mode == CacheOnly {
+		unlock()
+		return Layout{}, ErrNotCached
+	}
+	if n := rs.graph.Nodes().Len(); algorithm == SpectralLayout && n > MaxSpectralLocations {
+		unlock()
+		return Layout{}, fmt.Errorf("the graph has %d locations, more than the %d a spectral layout allows", n, MaxSpectralLocations)
+	}
+	g := rs.sortedGraphCopy()
+	unlock()
+
+	nodes := graph.NodesOf(g.Nodes())
+	var xs, ys []float64
+	if algorithm == SpectralLayout {
+		xs, ys = spectralLayout(g, nodes)
+	} else {
+		xs, ys = forceLayout(g, nodes)
+	}
+	fitUnitSquare(xs, ys)
+
+	ret := Layout{Algorithm: algorithm, Locations: []LocationPosition{}, revision: revision}
+	for i, node := range nodes {
+		ret.Locations = append(ret.Locations, LocationPosition{Name: nodeName(node), X: xs[i], Y: ys[i]})
+	}
+
+	defer rs.lock("Layout")()
+	if rs.revision == revision {
+		rs.layouts[algorithm] = &ret
+	}
+	return ret, nil
+}
+
+// forceLayout places nodes by Eades' spring embedder, with every edge pulling alike whatever its weight
+func forceLayout(g *adjacencyGraph, nodes []graph.Node) (xs, ys []float64) {
+	eades := layout.EadesR2{Repulsion: 1, Rate: 0.05, Updates: forceLayoutUpdates, Theta: 0.2, Src: rand.NewPCG(1, 0)}
+	optimizer := layout.NewOptimizerR2(struct{ graph.Directed }{g}, eades.Update)
+	for optimizer.Update() {
+	}
+	xs, ys = make([]float64, len(nodes)), make([]float64, len(nodes))
+	for i, node := range nodes {
+		pos := optimizer.Coord2(node.ID())
+		xs[i], ys[i] = pos.X, pos.Y
+	}
+	return xs, ys
+}
+
+// spectralLayout places nodes by the eigenvectors of the two smallest eigenvalues after the first of the Laplacian
+// D - A, with edges either way linking two locations once
+func spectralLayout(g *adjacencyGraph, nodes []graph.Node) (xs, ys []float64) {
+	n := len(nodes)
+	xs, ys = make([]float64, n), make([]float64, n)
+	if n < 2 {
+		return xs, ys
+	}
+	index := make(map[int64]int, n)
+	for i, node := range nodes {
+		index[node.ID()] = i
+	}
+	laplacian := mat.NewSymDense(n, nil)
+	for i, node := range nodes {
+		linked := make(map[int]bool)
+		for _, it := range []graph.Nodes{g.From(node.ID()), g.To(node.ID())} {
+			for it.Next() {
+				if j := index[it.Node().ID()]; j != i {
+					linked[j] = true
+				}
+			}
+		}
+		laplacian.SetSym(i, i, float64(len(linked)))
+		for j := range linked {
+			laplacian.SetSym(i, j, -1)
+		}
+	}
+
+	var eigen mat.EigenSym
+	if !eigen.Factorize(laplacian, true) {
+		return xs, ys
+	}
+	var vectors mat.Dense
+	eigen.VectorsTo(&vectors)
+	// Eigenvalues come smallest first, and the first eigenvector is constant
+	for i := range nodes {
+		xs[i] = vectors.At(i, 1)
+		if n > 2 {
+			ys[i] = vectors.At(i, 2)
+		}
+	}
+	return xs, ys
+}
+
+// fitUnitSquare scales positions alike on both axes, keeping their shape, to fill the unit square from its corner
+func fitUnitSquare(xs, ys []float64) {
+	span := func(vs []float64) (float64, float64) {
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, v := range vs {
+			min, max = math.Min(min, v), math.Max(max, v)
+		}
+		return min, max
+	}
+	minX, maxX := span(xs)
+	minY, maxY := span(ys)
+	scale := math.Max(maxX-minX, maxY-minY)
+	for i := range xs {
+		if scale > 0 {
+			xs[i], ys[i] = (xs[i]-minX)/scale, (ys[i]-minY)/scale
+		} else {
+			xs[i], ys[i] = 0.5, 0.5
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Kinds of likely data problem
+const (
+	// Edges both ways between two locations whose weights differ by more than the tolerance
+	LintAsymmetricWeight = "asymmetric_weight"
+	// An edge far lighter or heavier than the rest
+	LintOutlierWeight = "outlier_weight"
+	// Two locations that are probably the same place, as FindDuplicates finds them
+	LintDuplicateLocation = "duplicate_location"
+	// A location with no edges either way
+	LintIsolatedLocation = "isolated_location"
+	// Metadata naming what it describes: an edge tagged with one of its ends, or a location in a region of its own name
+	LintSelfReference = "self_reference"
+)
+
+// How strict a lint report is; see Lint
+type LintOptions struct {
+	// How far apart the weights of edges both ways may be, as a fraction of the heavier
+	Tolerance float64
+	// How many interquartile ranges past the quartiles a weight must be to be an outlier
+	Fence float64
+	// As for FindDuplicates
+	Threshold int
+	Km        float64
+}
+
+// One likely data problem
+type LintIssue struct {
+	Kind string `json:"kind"`
+	// The locations involved, in name order
+	Locations []string `json:"locations"`
+	Message   string   `json:"message"`
+}
+
+// The likely data problems in the graph, and a score for how clean it is
+type LintReport struct {
+	// The percentage of locations not involved in any issue, 100 for an empty graph
+	Score     float64     `json:"score"`
+	Locations int         `json:"locations"`
+	Issues    []LintIssue `json:"issues"`
+	// How many issues there are of each kind found
+	Counts map[string]int `json:"counts"`
+}
+
+// GET  /maps/analysis/lint/ (?tolerance=0.1&fence=3&threshold=2&km=0.5 optional) : READ a report of likely data problems: edges
+// both ways whose weights differ by more than tolerance, as a fraction of the heavier; weights more than fence interquartile ranges
+// outside the quartiles; locations that are probably duplicates, as FindDuplicates finds them; isolated locations; and tags or
+// regions naming what they describe. Issues come grouped by kind, each in name order; none of them is necessarily wrong.
+func (rs *RouteStore) Lint(opts LintOptions) (LintReport, error) {
+	if math.IsNaN(opts.Tolerance) || opts.Tolerance < 0 {
+		return LintReport{}, fmt.Errorf("tolerance must not be negative, not %g", opts.Tolerance)
+	}
+	if math.IsNaN(opts.Fence) || opts.Fence < 0 {
+		return LintReport{}, fmt.Errorf("fence must not be negative, not %g", opts.Fence)
+	}
+	if opts.Threshold < 0 {
+		return LintReport{}, fmt.Errorf("threshold must not be negative, not %d", opts.Threshold)
+	}
+	if opts.Km < 0 {
+		return LintReport{}, fmt.Errorf("km must not be negative, not %g", opts.Km)
+	}
+
+	defer rs.rlock("Lint")()
+
+	ret := LintReport{Locations: rs.graph.Nodes().Len(), Issues: []LintIssue{}, Counts: make(map[string]int)}
+	report := func(kind, message string, locations ...string) {
+		sort.Strings(locations)
+		ret.Issues = append(ret.Issues, LintIssue{Kind: kind, Locations: locations, Message: message})
+		ret.Counts[kind]++
+	}
+
+	// Lightest first for the quartiles, and in name order for reporting
+	sorted := rs.sortedEdges()
+	edges := append([]Edge{}, sorted...)
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	for _, edge := range edges {
+		if edge.From > edge.To {
+			continue
+		}
+		back, ok := rs.graph.Weight(Location(edge.To).ID(), Location(edge.From).ID())
+		if !ok {
+			continue
+		}
+		if gap := math.Abs(edge.Weight - back); gap > opts.Tolerance*math.Max(math.Abs(edge.Weight), math.Abs(back)) {
+			report(LintAsymmetricWeight, fmt.Sprintf("the edge from %s to %s weighs %g, but the edge back weighs %g", edge.From, edge.To, edge.Weight, back),
+				edge.From, edge.To)
+		}
+	}
+
+	// Tukey's fences need enough weights for the quartiles to mean anything
+	if len(sorted) >= 4 {
+		q1, q3 := percentile(sorted, 25), percentile(sorted, 75)
+		low, high := q1-opts.Fence*(q3-q1), q3+opts.Fence*(q3-q1)
+		for _, edge := range edges {
+			if edge.Weight < low || edge.Weight > high {
+				report(LintOutlierWeight, fmt.Sprintf("the edge from %s to %s weighs %g, outside %g to %g", edge.From, edge.To, edge.Weight, low, high),
+					edge.From, edge.To)
+			}
+		}
+	}
+
+	for _, dup := range rs.findDuplicates(opts.Threshold, opts.Km) {
+		message := fmt.Sprintf("%s and %s are %d edits apart", dup.A, dup.B, dup.NameDistance)
+		if dup.DistanceKm != nil {
+			message += fmt.Sprintf(" and %g km apart", *dup.DistanceKm)
+		}
+		report(LintDuplicateLocation, message, dup.A, dup.B)
+	}
+
+	var names []string
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		names = append(names, nodeName(nodes.Node()))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if len(rs.neighbours(Location(name).ID())) == 0 {
+			report(LintIsolatedLocation, fmt.Sprintf("%s has no edges", name), name)
+		}
+	}
+
+	for _, edge := range edges {
+		for _, tag := range rs.tags[edgeKey(Location(edge.From).ID(), Location(edge.To).ID())] {
+			if tag == edge.From || tag == edge.To {
+				report(LintSelfReference, fmt.Sprintf("the edge from %s to %s is tagged %s", edge.From, edge.To, tag), edge.From, edge.To)
+			}
+		}
+	}
+	for _, name := range names {
+		if rs.locationRegions[Location(name).ID()] == name {
+			report(LintSelfReference, fmt.Sprintf("%s is in a region of its own name", name), name)
+		}
+	}
+
+	ret.Score = 100
+	if ret.Locations > 0 {
+		involved := make(map[string]bool)
+		for _, issue := range ret.Issues {
+			for _, name := range issue.Locations {
+				involved[name] = true
+			}
+		}
+		ret.Score = 100 * float64(ret.Locations-len(involved)) / float64(ret.Locations)
+	}
+	return ret, nil
+}
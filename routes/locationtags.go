@@ -0,0 +1,163 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"gonum.org/v1/gonum/graph"
+	"sort"
+	"strings"
+)
+
+// Tags of every tagged location, by name, each a comma separated list
+const location_tags_hash = "rest_project:location_tags"
+
+func (rs *RouteStore) restoreLocationTags() error {
+	stringMap, err := redis.StringMap(rs.redis.Do("HGETALL", location_tags_hash))
+	if err != nil {
+		return err
+	}
+	for name, tags := range stringMap {
+		// Archived locations keep their tags in Redis, to have them back when restored
+		if !rs.archived[name] {
+			rs.locationTags[Location(name).ID()] = strings.Split(tags, ",")
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held, whenever a location goes
+func (rs *RouteStore) removeLocationTags(name string) error {
+	if _, ok := rs.locationTags[Location(name).ID()]; !ok {
+		return nil
+	}
+	if _, err := rs.redis.Do("HDEL", location_tags_hash, name); err != nil {
+		return err
+	}
+	delete(rs.locationTags, Location(name).ID())
+	return nil
+}
+
+// sortedTags checks tags and returns them without duplicates, in name order
+func sortedTags(tags []string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, tag := range tags {
+		if err := validateTag(tag); err != nil {
+			return nil, err
+		}
+		set[tag] = true
+	}
+	ret := make([]string, 0, len(set))
+	for tag := range set {
+		ret = append(ret, tag)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// GET  /maps/location-tags/ : READ the tags of every tagged location
+func (rs *RouteStore) AllLocationTags() map[string][]string {
+	defer rs.rlock("AllLocationTags")()
+
+	ret := make(map[string][]string)
+	for id, tags := range rs.locationTags {
+		if node := rs.graph.Node(id); node != nil {
+			ret[nodeName(node)] = append([]string{}, tags...)
+		}
+	}
+	return ret
+}
+
+// GET  /maps/location-tags/<location> : READ the tags of <location>, in name order
+func (rs *RouteStore) LocationTags(name string) ([]string, error) {
+	defer rs.rlock("LocationTags")()
+
+	loc := Location(name)
+	if rs.graph.Node(loc.ID()) == nil {
+		return nil, fmt.Errorf("%s does not exist", loc)
+	}
+
+	ret := append([]string{}, rs.locationTags[loc.ID()]...)
+	return ret, nil
+}
+
+// PUT  /maps/location-tags/<location> (with JSON []string) : UPDATE replace the tags of <location>, such as warehouse or customer.
+// Tags go when their location is deleted, and follow it when it is renamed.
+func (rs *RouteStore) SetLocationTags(name string, tags []string) error {
+	sorted, err := sortedTags(tags)
+	if err != nil {
+		return err
+	}
+
+	defer rs.lock("SetLocationTags")()
+
+	loc := Location(name)
+	if rs.graph.Node(loc.ID()) == nil {
+		return fmt.Errorf("%s does not exist", loc)
+	}
+	rs.changed()
+
+	if len(sorted) == 0 {
+		return rs.removeLocationTags(name)
+	}
+	if _, err := rs.redis.Do("HSET", location_tags_hash, name, strings.Join(sorted, ",")); err != nil {
+		return err
+	}
+	rs.locationTags[loc.ID()] = sorted
+	return nil
+}
+
+// GET  /maps/?tag=<tag> : READ the locations tagged <tag>, in name order
+func (rs *RouteStore) TaggedLocations(tag string) ([]string, error) {
+	if err := validateTag(tag); err != nil {
+		return nil, err
+	}
+
+	defer rs.rlock("TaggedLocations")()
+
+	ret := []string{}
+	for id, tags := range rs.locationTags {
+		if hasTag(tags, tag) {
+			ret = append(ret, nodeName(rs.graph.Node(id)))
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Must be called with the lock held; whether a route may pass through the location id under the options' location
+// tag filters. A route's own ends are not passed through, so are always allowed.
+func (rs *RouteStore) locationTagsAllow(opts RouteOptions, id int64) bool {
+	tags := rs.locationTags[id]
+	for _, tag := range opts.ExcludeLocationTags {
+		if hasTag(tags, tag) {
+			return false
+		}
+	}
+	for _, tag := range opts.RequireLocationTags {
+		if !hasTag(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Must be called with the lock held; g without the edges that would pass through a location the options' location
+// tag filters rule out, on the way from one end to the other
+func (rs *RouteStore) throughTaggedGraph(g graph.WeightedDirected, opts RouteOptions, from, to Location) graph.WeightedDirected {
+	if len(opts.ExcludeLocationTags) == 0 && len(opts.RequireLocationTags) == 0 {
+		return g
+	}
+	passes := func(id int64) bool {
+		return id == from.ID() || id == to.ID() || rs.locationTagsAllow(opts, id)
+	}
+	return filteredGraph{WeightedDirected: g, allow: func(u, v int64) bool { return passes(u) && passes(v) }}
+}
@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/graph/path"
+	"math"
+	"sort"
+)
+
+const (
+	// The most origins one distance matrix may have, each costing a shortest path search, and the most pairs
+	MaxMatrixOrigins = 500
+	MaxMatrixCells   = 250000
+)
+
+// The weights of the shortest routes from each origin to each destination
+type DistanceMatrix struct {
+	Origins      []string `json:"origins"`
+	Destinations []string `json:"destinations"`
+	// Weights[i][j] is from Origins[i] to Destinations[j], null when there is no route
+	Weights [][]*float64 `json:"weights"`
+}
+
+// GET  /maps/matrix/ (?origins=<name>,...&destinations=<name>,... optional) : READ the shortest route weights between every pair of locations, up to 500 origins and 250,000 pairs
+// POST /maps/matrix/ (with JSON origins: []string, destinations: []string optional) : READ as GET /maps/matrix/, for long lists
+// Empty origins or destinations mean every location, in name order. Each origin costs one shortest path
+// search, Dijkstra's or, while there are negative weights, Bellman-Ford's, made under the read lock, so there may be
+// at most MaxMatrixOrigins origins and MaxMatrixCells pairs; on a larger map, origins must be listed.
+func (rs *RouteStore) DistanceMatrix(origins, destinations []string) (DistanceMatrix, error) {
+	defer rs.rlock("DistanceMatrix")()
+
+	var all []string
+	if len(origins) == 0 || len(destinations) == 0 {
+		nodes := rs.graph.Nodes()
+		for nodes.Next() {
+			all = append(all, nodeName(nodes.Node()))
+		}
+		sort.Strings(all)
+	}
+	if len(origins) == 0 {
+		origins = all
+	}
+	if len(destinations) == 0 {
+		destinations = all
+	}
+	if len(origins) > MaxMatrixOrigins {
+		return DistanceMatrix{}, fmt.Errorf("a matrix may have at most %d origins, not %d; list fewer", MaxMatrixOrigins, len(origins))
+	}
+	if cells := len(origins) * len(destinations); cells > MaxMatrixCells {
+		return DistanceMatrix{}, fmt.Errorf("a matrix may have at most %d pairs, not %d; list fewer origins or destinations", MaxMatrixCells, cells)
+	}
+	for _, names := range [][]string{origins, destinations} {
+		for _, name := range names {
+			if rs.graph.Node(Location(name).ID()) == nil {
+				return DistanceMatrix{}, fmt.Errorf("%s does not exist", name)
+			}
+		}
+	}
+
+	ret := DistanceMatrix{Origins: origins, Destinations: destinations, Weights: make([][]*float64, len(origins))}
+	for i, origin := range origins {
+		var weightTo func(id int64) float64
+		g := rs.visitCostGraph(rs.graph, Location(origin))
+		if rs.negativeEdges > 0 {
+			shortest, ok := path.BellmanFordFrom(Location(origin), g)
+			if !ok {
+				return DistanceMatrix{}, ErrNegativeCycle
+			}
+			weightTo = shortest.WeightTo
+		} else {
+			weightTo = path.DijkstraFrom(Location(origin), g).WeightTo
+		}
+
+		row := make([]*float64, len(destinations))
+		for j, destination := range destinations {
+			if weight := weightTo(Location(destination).ID()); !math.IsInf(weight, 1) {
+				weight = rs.roundWeight(weight)
+				row[j] = &weight
+			}
+		}
+		ret.Weights[i] = row
+	}
+	return ret, nil
+}
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"fmt"
+	"testing"
+)
+
+// On a map with more locations than a matrix may have origins, every location must not be asked for
+func TestDistanceMatrixIsCapped(t *testing.T) {
+	rs := New(newMemoryRedis())
+	for i := 0; i <= MaxMatrixOrigins; i++ {
+		if err := rs.AddLocation(fmt.Sprint("n", i), nil, nil, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.AddRoutes("n0", givenWeights(map[string]float64{"n1": 2}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rs.DistanceMatrix(nil, nil); err == nil {
+		t.Fatal("every location as origins should be refused")
+	}
+	if _, err := rs.DistanceMatrix(nil, []string{"n1"}); err == nil {
+		t.Fatal("every location as origins should be refused, whatever the destinations")
+	}
+	matrix, err := rs.DistanceMatrix([]string{"n0"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matrix.Destinations) != MaxMatrixOrigins+1 || *matrix.Weights[0][1] != 2 || matrix.Weights[0][2] != nil {
+		t.Fatalf("unexpected matrix from n0 to %d destinations", len(matrix.Destinations))
+	}
+}
@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Rough per-item costs of adjacencyGraph: a node is a map entry for its slot, the slot itself
+// (the node, four slice headers and a generation) and the string header of its name, and an edge
+// a slot and a weight at each end, allowing for the slack slices grow with.
+const (
+	nodeOverhead  = (8 + 4 + 8) + (16 + 4*24 + 8) + 16
+	edgeOverhead  = 2 * (4 + 8) * 5 / 4
+	entryOverhead = 128
+)
+
+// Approximate memory held by a RouteStore, in bytes
+type MemoryReport struct {
+	Nodes        int    `json:"nodes"`
+	Edges        int    `json:"edges"`
+	GraphBytes   int64  `json:"graph_bytes"`
+	TrashEntries int    `json:"trash_entries"`
+	TrashBytes   int64  `json:"trash_bytes"`
+	CacheEntries int    `json:"cache_entries"`
+	CacheBytes   int64  `json:"cache_bytes"`
+	HeapBytes    uint64 `json:"heap_bytes"`
+	// Names are held once, in GraphBytes, and edges refer to them by 32 bit slot; this is
+	// what edges would take on top of that if each end held its name instead
+	NameBytes           int64 `json:"name_bytes"`
+	InternedSavingBytes int64 `json:"interned_saving_bytes"`
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) memoryReport() MemoryReport {
+	var report MemoryReport
+
+	report.Nodes = rs.graph.count
+	report.Edges = rs.graph.edges
+	report.NameBytes = rs.graph.nameBytes()
+	report.GraphBytes = int64(report.Nodes)*nodeOverhead + int64(report.Edges)*edgeOverhead + report.NameBytes
+	rs.graph.each(func(adj *adjacency) {
+		// Both ends of each edge, counted at its source
+		for _, slot := range adj.out.slots {
+			named := 2*16 + len(nodeName(adj.node)) + len(nodeName(rs.graph.at(slot).node))
+			report.InternedSavingBytes += int64(named - 2*4)
+		}
+	})
+
+	for name, entry := range rs.trash {
+		report.TrashEntries++
+		report.TrashBytes += entryOverhead + int64(len(name))
+		for to := range entry.RoutesTo {
+			report.TrashBytes += int64(len(to)) + 8
+		}
+		for from := range entry.RoutesFrom {
+			report.TrashBytes += int64(len(from)) + 8
+		}
+	}
+
+	cache := rs.cache.stats()
+	report.CacheEntries = cache.Entries
+	report.CacheBytes = cache.Bytes
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	report.HeapBytes = stats.HeapAlloc
+
+	return report
+}
+
+func routesBytes(key string, routes []Route) int64 {
+	bytes := int64(entryOverhead + len(key))
+	for _, route := range routes {
+		bytes += 48 + int64(len(route.Token))
+		for _, name := range route.Route {
+			bytes += 16 + int64(len(name))
+		}
+		for _, mode := range route.Modes {
+			bytes += 16 + int64(len(mode))
+		}
+	}
+	return bytes
+}
+
+// Must be called with the lock held, if only for reading; a copy of the graph in constant time, see clone
+func (rs *RouteStore) copyGraph() *adjacencyGraph {
+	return rs.graph.clone()
+}
+
+// GET  /admin/memory/ : READ approximate memory used by the store
+func (rs *RouteStore) MemoryReport() MemoryReport {
+	defer rs.rlock("MemoryReport")()
+
+	return rs.memoryReport()
+}
+
+// POST /admin/compact/ : UPDATE rebuild the in-memory graph, empty the route cache and drop expired trash, returning the new usage
+func (rs *RouteStore) Compact() (MemoryReport, error) {
+	unlock := rs.lock("Compact")
+
+	// Go maps never shrink, so after heavy churn the only way to give memory back is to copy
+	rs.graph = rs.graph.compact()
+	rs.cache.clear()
+
+	err := rs.purgeExpiredTrash()
+	unlock()
+	if err != nil {
+		return MemoryReport{}, err
+	}
+
+	debug.FreeOSMemory()
+	return rs.MemoryReport(), nil
+}
@@ -0,0 +1,185 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/simple"
+	"log"
+	"math"
+	"strings"
+)
+
+// The weight of each mode of every edge that has any, by "<from>/<to>", each a JSON object of numbers
+const edge_modes_hash = "rest_project:edge_modes"
+
+// validateMode rejects modes that could not be listed in ?modes=, and weights Dijkstra could not use
+func validateMode(name string, weight float64) error {
+	if !attributeName.MatchString(name) {
+		return fmt.Errorf("mode name %q must be letters, digits and '_', not starting with a digit", name)
+	}
+	if math.IsNaN(weight) || math.IsInf(weight, 0) || weight < 0 {
+		return fmt.Errorf("mode %s must weigh a non-negative number, not %g", name, weight)
+	}
+	return nil
+}
+
+// ParseModes reads a comma separated list of modes, as given in ?modes=
+func ParseModes(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ret := strings.Split(s, ",")
+	for _, mode := range ret {
+		if err := validateMode(mode, 0); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func (rs *RouteStore) restoreModes() error {
+	stringMap, err := redis.StringMap(rs.redis.Do("HGETALL", edge_modes_hash))
+	if err != nil {
+		return err
+	}
+	for s, js := range stringMap {
+		pair, err := ParsePair(s)
+		if err != nil {
+			// Such as the edge of a location named with a '/', before names were checked
+			log.Printf("Ignoring the modes of %q in Redis: %s\n", s, err.Error())
+			continue
+		}
+		var modes map[string]float64
+		if err := json.Unmarshal([]byte(js), &modes); err != nil {
+			return fmt.Errorf("bad modes for %s: %s", pair, err)
+		}
+		rs.modes[edgeKey(Location(pair.From).ID(), Location(pair.To).ID())] = modes
+	}
+	return nil
+}
+
+// Must be called with the lock held, whenever an edge goes
+func (rs *RouteStore) removeModes(from, to Location) error {
+	key := edgeKey(from.ID(), to.ID())
+	if _, ok := rs.modes[key]; !ok {
+		return nil
+	}
+	if _, err := rs.redis.Do("HDEL", edge_modes_hash, Pair{From: string(from), To: string(to)}.String()); err != nil {
+		return err
+	}
+	delete(rs.modes, key)
+	return nil
+}
+
+// GET  /maps/<from>/edge/<to>/modes : READ the weight of each mode of transport of the edge from <from> to <to>
+func (rs *RouteStore) EdgeModes(fromStr, toStr string) (map[string]float64, error) {
+	defer rs.rlock("EdgeModes")()
+
+	from, to := Location(fromStr), Location(toStr)
+	if !rs.hasEdge(from, to) {
+		return nil, fmt.Errorf("there is no edge from %s to %s", from, to)
+	}
+
+	ret := make(map[string]float64)
+	for name, weight := range rs.modes[edgeKey(from.ID(), to.ID())] {
+		ret[name] = weight
+	}
+	return ret, nil
+}
+
+// PUT  /maps/<from>/edge/<to>/modes (with JSON map[string]weight) : UPDATE replace the modes of the edge from <from> to <to>.
+// Each mode, such as train or bus, is a parallel edge of its own weight beside the edge's own, which queries choose among with
+// ?modes=; queries without it use the edge's own weight as before. Like tags and attributes, modes go with their edge.
+func (rs *RouteStore) SetEdgeModes(fromStr, toStr string, modes map[string]float64) error {
+	for name, weight := range modes {
+		if err := validateMode(name, weight); err != nil {
+			return err
+		}
+	}
+
+	defer rs.lock("SetEdgeModes")()
+
+	from, to := Location(fromStr), Location(toStr)
+	if !rs.hasEdge(from, to) {
+		return fmt.Errorf("there is no edge from %s to %s", from, to)
+	}
+	copied := make(map[string]float64, len(modes))
+	for name, weight := range modes {
+		if err := rs.checkWeight(fromStr, toStr, weight); err != nil {
+			return err
+		}
+		copied[name] = rs.roundWeight(weight)
+	}
+	rs.changed()
+
+	if len(modes) == 0 {
+		return rs.removeModes(from, to)
+	}
+	js, err := json.Marshal(copied)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.redis.Do("HSET", edge_modes_hash, Pair{From: fromStr, To: toStr}.String(), js); err != nil {
+		return err
+	}
+	rs.modes[edgeKey(from.ID(), to.ID())] = copied
+	return nil
+}
+
+// Must be called with the lock held; the cheapest of the given modes of the edge from u to v, or false if it has none of them
+func (rs *RouteStore) cheapestMode(u, v int64, modes []string) (string, float64, bool) {
+	best, weight, found := "", math.Inf(1), false
+	edgeModes := rs.modes[edgeKey(u, v)]
+	// modes is sorted, so ties go to the first in name order
+	for _, mode := range modes {
+		if w, ok := edgeModes[mode]; ok && w < weight {
+			best, weight, found = mode, w, true
+		}
+	}
+	return best, weight, found
+}
+
+// Must be called with the lock held, with resolved options that give modes; which mode each edge of each route is taken by
+func (rs *RouteStore) addLegModes(routes []Route, modes []string) {
+	for i, route := range routes {
+		routes[i].Modes = make([]string, 0, len(route.Route))
+		for k := 1; k < len(route.Route); k++ {
+			mode, _, _ := rs.cheapestMode(Location(route.Route[k-1]).ID(), Location(route.Route[k]).ID(), modes)
+			routes[i].Modes = append(routes[i].Modes, mode)
+		}
+	}
+}
+
+// A graph whose edges weigh the cheapest of the allowed modes; filteredGraph must leave out the edges with none of them
+type modeGraph struct {
+	filteredGraph
+	rs    *RouteStore
+	modes []string
+}
+
+func (g modeGraph) Weight(xid, yid int64) (float64, bool) {
+	w, ok := g.filteredGraph.Weight(xid, yid)
+	if !ok || xid == yid {
+		return w, ok
+	}
+	_, w, _ = g.rs.cheapestMode(xid, yid, g.modes)
+	return w, true
+}
+
+func (g modeGraph) Edge(uid, vid int64) graph.Edge {
+	if e := g.WeightedEdge(uid, vid); e != nil {
+		return e
+	}
+	return nil
+}
+
+func (g modeGraph) WeightedEdge(uid, vid int64) graph.WeightedEdge {
+	e := g.filteredGraph.WeightedEdge(uid, vid)
+	if e == nil {
+		return nil
+	}
+	w, _ := g.Weight(uid, vid)
+	return simple.WeightedEdge{F: e.From(), T: e.To(), W: w}
+}
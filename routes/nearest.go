@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/iterator"
+	"gonum.org/v1/gonum/graph/path"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+// The virtual ends of a route between sets of locations. Location names cannot contain '/', as AddLocation and
+// AddRoutes check, so neither can collide with a location added since; routesBetweenSets refuses to run if one
+// read from Redis does.
+const (
+	superSource = Location("/source")
+	superSink   = Location("/sink")
+)
+
+// GET  /maps/nearest/ (?origins=<name>,...&destinations=<name>,...) : READ the shortest routes from any origin to any destination.
+// One search answers this: a virtual source with free edges to every origin and a virtual sink with free edges from every
+// destination are added to the graph, and both are left out of the routes returned.
+func (rs *RouteStore) NearestRoutes(origins, destinations []string) ([]Route, error) {
+	if len(origins) == 0 || len(destinations) == 0 {
+		return nil, errors.New("at least one origin and one destination are needed")
+	}
+
+	defer rs.rlock("NearestRoutes")()
+
+	sets := make([]map[int64]bool, 2)
+	for i, names := range [][]string{origins, destinations} {
+		sets[i] = make(map[int64]bool)
+		for _, name := range names {
+			if rs.graph.Node(Location(name).ID()) == nil {
+				return nil, fmt.Errorf("%s does not exist", name)
+			}
+			sets[i][Location(name).ID()] = true
+		}
+	}
+	return rs.routesBetweenSets(sets[0], sets[1])
+}
+
+// Must be called with the lock held; the shortest routes from any of sources to any of sinks, by their IDs
+func (rs *RouteStore) routesBetweenSets(sources, sinks map[int64]bool) ([]Route, error) {
+	for _, end := range []Location{superSource, superSink} {
+		if rs.graph.Node(end.ID()) != nil {
+			return nil, fmt.Errorf("location %s has the name of a virtual end of routes between sets, and must be renamed first", end)
+		}
+	}
+
+	var ids []int64
+	for id := range sources {
+		ids = append(ids, id)
+	}
+	g := superGraph{WeightedDirected: withVisitCosts(rs.graph, rs.visitCosts, ids...), sources: sources, sinks: sinks}
+	var ret []Route
+	if rs.negativeEdges > 0 {
+		shortest, ok := path.BellmanFordAllFrom(superSource, g)
+		if !ok {
+			return nil, ErrNegativeCycle
+		}
+		ret = pathsToRoutes(shortest.AllTo(superSink.ID()))
+	} else {
+		ret = shortestRoutes(g, superSource, superSink, rs.precision)
+	}
+	for i := range ret {
+		ret[i].Route = ret[i].Route[1 : len(ret[i].Route)-1]
+	}
+	rs.roundRoutes(ret)
+	rs.issueTokens(ret, RouteOptions{})
+	return ret, nil
+}
+
+// The graph plus superSource, with free edges to sources, and superSink, with free edges from sinks
+type superGraph struct {
+	graph.WeightedDirected
+	sources, sinks map[int64]bool
+}
+
+func nodesIn(g graph.Graph, ids map[int64]bool) []graph.Node {
+	var ret []graph.Node
+	for id := range ids {
+		ret = append(ret, g.Node(id))
+	}
+	return ret
+}
+
+func (g superGraph) Node(id int64) graph.Node {
+	switch id {
+	case superSource.ID():
+		return superSource
+	case superSink.ID():
+		return superSink
+	}
+	return g.WeightedDirected.Node(id)
+}
+
+func (g superGraph) Nodes() graph.Nodes {
+	return iterator.NewOrderedNodes(append(graph.NodesOf(g.WeightedDirected.Nodes()), superSource, superSink))
+}
+
+func (g superGraph) From(id int64) graph.Nodes {
+	switch id {
+	case superSource.ID():
+		return iterator.NewOrderedNodes(nodesIn(g.WeightedDirected, g.sources))
+	case superSink.ID():
+		return graph.Empty
+	}
+	nodes := graph.NodesOf(g.WeightedDirected.From(id))
+	if g.sinks[id] {
+		nodes = append(nodes, superSink)
+	}
+	return iterator.NewOrderedNodes(nodes)
+}
+
+func (g superGraph) To(id int64) graph.Nodes {
+	switch id {
+	case superSource.ID():
+		return graph.Empty
+	case superSink.ID():
+		return iterator.NewOrderedNodes(nodesIn(g.WeightedDirected, g.sinks))
+	}
+	nodes := graph.NodesOf(g.WeightedDirected.To(id))
+	if g.sources[id] {
+		nodes = append(nodes, superSource)
+	}
+	return iterator.NewOrderedNodes(nodes)
+}
+
+// Whether the edge from uid to vid is one of the free virtual ones
+func (g superGraph) virtualEdge(uid, vid int64) bool {
+	return (uid == superSource.ID() && g.sources[vid]) || (vid == superSink.ID() && g.sinks[uid])
+}
+
+func (g superGraph) HasEdgeFromTo(uid, vid int64) bool {
+	return g.virtualEdge(uid, vid) || g.WeightedDirected.HasEdgeFromTo(uid, vid)
+}
+
+func (g superGraph) HasEdgeBetween(xid, yid int64) bool {
+	return g.HasEdgeFromTo(xid, yid) || g.HasEdgeFromTo(yid, xid)
+}
+
+func (g superGraph) Edge(uid, vid int64) graph.Edge {
+	if e := g.WeightedEdge(uid, vid); e != nil {
+		return e
+	}
+	return nil
+}
+
+func (g superGraph) WeightedEdge(uid, vid int64) graph.WeightedEdge {
+	if g.virtualEdge(uid, vid) {
+		return simple.WeightedEdge{F: g.Node(uid), T: g.Node(vid)}
+	}
+	return g.WeightedDirected.WeightedEdge(uid, vid)
+}
+
+func (g superGraph) Weight(xid, yid int64) (float64, bool) {
+	if xid == yid || g.virtualEdge(xid, yid) {
+		return 0, true
+	}
+	return g.WeightedDirected.Weight(xid, yid)
+}
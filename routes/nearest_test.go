@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"testing"
+)
+
+// A location named as a virtual end, read from Redis from before names were checked, would be given the free edges
+// of that end, so NearestRoutes refuses rather than answering wrongly
+func TestNearestRoutesRefusesVirtualEndNames(t *testing.T) {
+	conn := newMemoryRedis()
+	for _, name := range []string{"a", "b", string(superSource)} {
+		conn.set(locations_set)[name] = true
+	}
+	conn.hash("a")["b"] = "1"
+	conn.hash(string(superSource))["b"] = "1"
+	rs, err := Restore(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.NearestRoutes([]string{"a"}, []string{"b"}); err == nil {
+		t.Fatalf("a location named %s should stop NearestRoutes", superSource)
+	}
+
+	if err := rs.AddLocation(string(superSink), nil, nil, new(bool)); err == nil {
+		t.Fatalf("a new location named %s should be refused", superSink)
+	}
+}
@@ -0,0 +1,258 @@
+package routes
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// A location's edges as an offline editor was given them, with a version that changes whenever they do
+type OfflineLocation struct {
+	Version string             `json:"version"`
+	Edges   map[string]float64 `json:"edges"`
+}
+
+// The graph for editing offline. The versions of the locations make a revision vector: when one is
+// unchanged on submission, nobody else has touched that location meanwhile.
+type OfflineBundle struct {
+	// The revision of the whole store, for reference; merging compares versions location by location
+	Revision  uint64                     `json:"revision"`
+	Locations map[string]OfflineLocation `json:"locations"`
+}
+
+// Edits made offline: the bundle they started from, and every location with its edges as they are now.
+// A location in the base but not edited is to be deleted; one edited but not in the base is to be created.
+type OfflineChanges struct {
+	Base   OfflineBundle                 `json:"base"`
+	Edited map[string]map[string]float64 `json:"edited"`
+}
+
+// A change made offline that clashes with one made on the server since the base was exported.
+// To is empty for a whole location; weights are null where there was no edge or location.
+type MergeConflict struct {
+	From    string   `json:"from"`
+	To      string   `json:"to,omitempty"`
+	Base    *float64 `json:"base"`
+	Current *float64 `json:"current"`
+	Edited  *float64 `json:"edited"`
+}
+
+// What a three-way merge applied, and what it left for someone to resolve by hand
+type MergeReport struct {
+	LocationsCreated []string        `json:"locations_created"`
+	LocationsDeleted []string        `json:"locations_deleted"`
+	EdgesSet         int             `json:"edges_set"`
+	EdgesRemoved     int             `json:"edges_removed"`
+	Conflicts        []MergeConflict `json:"conflicts"`
+	// The revision after merging; export again to carry on editing from here
+	Revision uint64 `json:"revision"`
+}
+
+// edgesVersion identifies a set of edges, the same for the same edges in any order
+func edgesVersion(edges map[string]float64) string {
+	var targets []string
+	for to := range edges {
+		targets = append(targets, to)
+	}
+	sort.Strings(targets)
+	hasher := fnv.New64a()
+	for _, to := range targets {
+		hasher.Write([]byte(to))
+		hasher.Write([]byte{0})
+		hasher.Write([]byte(strconv.FormatFloat(edges[to], 'g', -1, 64)))
+		hasher.Write([]byte{0})
+	}
+	return strconv.FormatUint(hasher.Sum64(), 16)
+}
+
+// Must be called with the lock held; the edges from the location name, or nil if it does not exist
+func (rs *RouteStore) currentEdges(name string) map[string]float64 {
+	id := Location(name).ID()
+	if rs.graph.Node(id) == nil {
+		return nil
+	}
+	ret := make(map[string]float64)
+	to := rs.graph.From(id)
+	for to.Next() {
+		ret[nodeName(to.Node())], _ = rs.graph.Weight(id, to.Node().ID())
+	}
+	return ret
+}
+
+// GET  /maps/offline/ : READ every location's edges with a version of each, to edit offline and submit later
+func (rs *RouteStore) OfflineBundle() OfflineBundle {
+	defer rs.rlock("OfflineBundle")()
+
+	ret := OfflineBundle{Revision: rs.revision, Locations: make(map[string]OfflineLocation)}
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		name := nodeName(nodes.Node())
+		edges := rs.currentEdges(name)
+		ret.Locations[name] = OfflineLocation{Version: edgesVersion(edges), Edges: edges}
+	}
+	return ret
+}
+
+// weightOf is the weight of the edge to to in edges, or nil if there is none
+func weightOf(edges map[string]float64, to string) *float64 {
+	if w, ok := edges[to]; ok {
+		return &w
+	}
+	return nil
+}
+
+func sameWeight(a, b *float64) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// POST /maps/offline/ (with JSON base: bundle, edited: map[location]map[string]weight) : UPDATE apply edits made offline by
+// three-way merge. A change is applied where the server still has what the base had, and is a conflict where both sides
+// changed the same edge, or a location was deleted on one side and changed on the other, differently. Conflicts are
+// reported and left as the server has them; everything else is applied.
+func (rs *RouteStore) MergeOffline(changes OfflineChanges) (MergeReport, error) {
+	defer rs.lock("MergeOffline")()
+
+	report := MergeReport{LocationsCreated: []string{}, LocationsDeleted: []string{}, Conflicts: []MergeConflict{}}
+
+	// Check everything first, so a bad submission changes nothing
+	for name, edges := range changes.Edited {
+		if err := validateName(name); err != nil {
+			return report, err
+		}
+		if err := rs.checkNotArchived(name); err != nil {
+			return report, err
+		}
+		if err := rs.checkWeights(name, edges); err != nil {
+			return report, err
+		}
+		for to := range edges {
+			if to == name {
+				return report, fmt.Errorf("%s cannot have an edge to itself", name)
+			}
+			if err := rs.checkNotArchived(to); err != nil {
+				return report, err
+			}
+			if _, edited := changes.Edited[to]; !edited && rs.graph.Node(Location(to).ID()) == nil {
+				return report, fmt.Errorf("%s does not exist", to)
+			}
+		}
+	}
+	var names []string
+	for name := range changes.Base.Locations {
+		names = append(names, name)
+	}
+	for name := range changes.Edited {
+		if _, ok := changes.Base.Locations[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var deletes []string
+	sets := make(map[string]map[string]float64)
+	removes := make(map[string][]string)
+	for _, name := range names {
+		baseLocation, inBase := changes.Base.Locations[name]
+		base := baseLocation.Edges
+		edited, isEdited := changes.Edited[name]
+		current := rs.currentEdges(name)
+
+		switch {
+		case !isEdited:
+			// Deleted offline
+			if current == nil {
+				continue
+			}
+			if edgesVersion(current) != edgesVersion(base) {
+				report.Conflicts = append(report.Conflicts, MergeConflict{From: name})
+				continue
+			}
+			deletes = append(deletes, name)
+			continue
+		case current == nil && inBase:
+			// Deleted on the server; only a conflict if it was also changed offline
+			if edgesVersion(edited) != edgesVersion(base) {
+				report.Conflicts = append(report.Conflicts, MergeConflict{From: name})
+			}
+			continue
+		case current == nil:
+			report.LocationsCreated = append(report.LocationsCreated, name)
+			current = map[string]float64{}
+		}
+
+		targets := make(map[string]bool)
+		for to := range base {
+			targets[to] = true
+		}
+		for to := range edited {
+			targets[to] = true
+		}
+		var sorted []string
+		for to := range targets {
+			sorted = append(sorted, to)
+		}
+		sort.Strings(sorted)
+		for _, to := range sorted {
+			b, c, e := weightOf(base, to), weightOf(current, to), weightOf(edited, to)
+			switch {
+			case sameWeight(e, b) || sameWeight(e, c):
+				// Not changed offline, or changed the same way on both sides
+			case !sameWeight(c, b):
+				report.Conflicts = append(report.Conflicts, MergeConflict{From: name, To: to, Base: b, Current: c, Edited: e})
+			case e == nil:
+				removes[name] = append(removes[name], to)
+			default:
+				if sets[name] == nil {
+					sets[name] = make(map[string]float64)
+				}
+				sets[name][to] = *e
+			}
+		}
+	}
+
+	// Creating every location first, in case edges lead to each other
+	for _, name := range report.LocationsCreated {
+		rs.graph.AddNode(Location(name))
+		if _, err := rs.redis.Do("SADD", locations_set, name); err != nil {
+			return report, err
+		}
+	}
+	for _, name := range names {
+		edges, ok := sets[name]
+		if !ok {
+			continue
+		}
+		for to := range edges {
+			if rs.graph.Node(Location(to).ID()) == nil {
+				// Its location was deleted on the server, or is a conflict
+				report.Conflicts = append(report.Conflicts, MergeConflict{From: name, To: to, Edited: weightOf(edges, to)})
+				delete(edges, to)
+			}
+		}
+		if err := rs.addEdges(name, edges, nil); err != nil {
+			return report, err
+		}
+		report.EdgesSet += len(edges)
+	}
+	for _, name := range names {
+		if targets, ok := removes[name]; ok {
+			if err := rs.removeRoutes(name, targets); err != nil {
+				return report, err
+			}
+			report.EdgesRemoved += len(targets)
+		}
+	}
+	for _, name := range deletes {
+		if err := rs.deleteLocation(name); err != nil {
+			return report, err
+		}
+		report.LocationsDeleted = append(report.LocationsDeleted, name)
+	}
+
+	if len(report.LocationsCreated)+len(report.LocationsDeleted)+report.EdgesSet+report.EdgesRemoved > 0 {
+		rs.changed()
+	}
+	report.Revision = rs.revision
+	return report, nil
+}
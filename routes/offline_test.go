@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"reflect"
+	"testing"
+)
+
+// A store holding edges, by location and then target, with every location in them
+func offlineStore(t *testing.T, edges map[string]map[string]float64) *RouteStore {
+	t.Helper()
+	rs := New(newMemoryRedis())
+	for name := range edges {
+		if err := rs.AddLocation(name, nil, nil, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, weights := range edges {
+		if err := rs.AddRoutes(name, givenWeights(weights), new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rs
+}
+
+// The edges of every location, as offlineStore takes them
+func edgesOf(rs *RouteStore) map[string]map[string]float64 {
+	ret := make(map[string]map[string]float64)
+	for name, location := range rs.OfflineBundle().Locations {
+		ret[name] = location.Edges
+	}
+	return ret
+}
+
+// What an offline editor submits after starting from base: its edges, with edit applied
+func offlineEdit(base OfflineBundle, edit func(edited map[string]map[string]float64)) OfflineChanges {
+	edited := make(map[string]map[string]float64)
+	for name, location := range base.Locations {
+		edited[name] = make(map[string]float64)
+		for to, w := range location.Edges {
+			edited[name][to] = w
+		}
+	}
+	edit(edited)
+	return OfflineChanges{Base: base, Edited: edited}
+}
+
+func conflictsOf(report MergeReport) []MergeConflict {
+	for i := range report.Conflicts {
+		report.Conflicts[i].Base, report.Conflicts[i].Current, report.Conflicts[i].Edited = nil, nil, nil
+	}
+	return report.Conflicts
+}
+
+// Edits to different edges on each side are both kept; the same edge changed both ways is a conflict, left as the
+// server has it, unless both sides changed it alike
+func TestMergeOfflineEdgeConflicts(t *testing.T) {
+	rs := offlineStore(t, map[string]map[string]float64{"A": {"B": 1, "C": 1}, "B": {"C": 1}, "C": {}})
+	base := rs.OfflineBundle()
+
+	if err := rs.AddRoutes("A", givenWeights(map[string]float64{"B": 2, "C": 5}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	changes := offlineEdit(base, func(edited map[string]map[string]float64) {
+		edited["A"]["B"] = 2
+		edited["A"]["C"] = 7
+		edited["B"]["C"] = 3
+		edited["C"]["A"] = 4
+	})
+	report, err := rs.MergeOffline(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := conflictsOf(report), []MergeConflict{{From: "A", To: "C"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("conflicts %+v, want %+v", got, want)
+	}
+	want := map[string]map[string]float64{"A": {"B": 2, "C": 5}, "B": {"C": 3}, "C": {"A": 4}}
+	if got := edgesOf(rs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged into %v, want %v", got, want)
+	}
+	if report.EdgesSet != 2 || report.Revision != rs.Snapshot().Revision() {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+// A location deleted on one side and changed on the other is a conflict and stays; deleted on one side and untouched
+// on the other, it goes; deleted on both, there is nothing to do
+func TestMergeOfflineConcurrentDeletes(t *testing.T) {
+	rs := offlineStore(t, map[string]map[string]float64{
+		"A": {"B": 1}, "B": {"A": 1}, "C": {"A": 1}, "D": {"A": 1}, "E": {"A": 1}, "F": {"A": 1},
+	})
+	base := rs.OfflineBundle()
+
+	// Changed on the server, deleted offline
+	if err := rs.AddRoutes("B", givenWeights(map[string]float64{"A": 9}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	// Deleted on the server: D changed offline, E not, F deleted offline too
+	for _, name := range []string{"D", "E", "F"} {
+		if err := rs.DeleteLocation(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changes := offlineEdit(base, func(edited map[string]map[string]float64) {
+		delete(edited, "B")
+		delete(edited, "C")
+		delete(edited, "F")
+		edited["D"]["A"] = 3
+	})
+	report, err := rs.MergeOffline(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := conflictsOf(report), []MergeConflict{{From: "B"}, {From: "D"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("conflicts %+v, want %+v", got, want)
+	}
+	if !reflect.DeepEqual(report.LocationsDeleted, []string{"C"}) {
+		t.Fatalf("deleted %v, want only C", report.LocationsDeleted)
+	}
+	want := map[string]map[string]float64{"A": {"B": 1}, "B": {"A": 9}}
+	if got := edgesOf(rs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged into %v, want %v", got, want)
+	}
+}
+
+// A location renamed on the server is gone by its old name: editing it offline is a conflict, and an edge added to it
+// offline cannot be made, rather than either bringing the old name back
+func TestMergeOfflineRenameAgainstEdit(t *testing.T) {
+	rs := offlineStore(t, map[string]map[string]float64{"A": {"B": 1}, "B": {"C": 1}, "C": {}})
+	base := rs.OfflineBundle()
+
+	if err := rs.RenameLocations(map[string]string{"B": "B2"}); err != nil {
+		t.Fatal(err)
+	}
+	changes := offlineEdit(base, func(edited map[string]map[string]float64) {
+		edited["B"]["C"] = 5
+		edited["C"]["B"] = 2
+	})
+	report, err := rs.MergeOffline(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := conflictsOf(report), []MergeConflict{{From: "B"}, {From: "C", To: "B"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("conflicts %+v, want %+v", got, want)
+	}
+	want := map[string]map[string]float64{"A": {"B2": 1}, "B2": {"C": 1}, "C": {}}
+	if got := edgesOf(rs); !reflect.DeepEqual(got, want) {
+		t.Fatalf("merged into %v, want %v", got, want)
+	}
+}
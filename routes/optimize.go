@@ -0,0 +1,348 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/graph/path"
+	"math"
+	"sort"
+	"time"
+)
+
+// The most locations one tour may visit; each costs a shortest path search
+const MaxTourLocations = 200
+
+// Shortest path searches from each of the locations an optimizer visits, by index
+type stopSearches struct {
+	names []string
+	trees []path.Shortest
+}
+
+// newStopSearches searches g from each of names, by Bellman-Ford if it has negative weights, paying visitCosts. It is
+// meant for copies of the graph and costs, so as not to hold up the store.
+func newStopSearches(g *adjacencyGraph, negativeEdges int, visitCosts map[int64]float64, names []string) (*stopSearches, error) {
+	ret := &stopSearches{names: names, trees: make([]path.Shortest, len(names))}
+	for i, name := range names {
+		loc := Location(name)
+		if g.Node(loc.ID()) == nil {
+			return nil, fmt.Errorf("%s does not exist", loc)
+		}
+		if negativeEdges > 0 {
+			shortest, ok := path.BellmanFordFrom(loc, withVisitCosts(g, visitCosts, loc.ID()))
+			if !ok {
+				return nil, ErrNegativeCycle
+			}
+			ret.trees[i] = shortest
+		} else {
+			ret.trees[i] = path.DijkstraFrom(loc, withVisitCosts(g, visitCosts, loc.ID()))
+		}
+	}
+	return ret, nil
+}
+
+// dist is the weight of the shortest route from names[i] to names[j], infinite if there is none
+func (s *stopSearches) dist(i, j int) float64 {
+	return s.trees[i].WeightTo(Location(s.names[j]).ID())
+}
+
+// leg appends to route the locations passed through after names[i] up to and including names[j]
+func (s *stopSearches) leg(route []string, i, j int) []string {
+	nodes, _ := s.trees[i].To(Location(s.names[j]).ID())
+	for _, node := range nodes[1:] {
+		route = append(route, nodeName(node))
+	}
+	return route
+}
+
+// When a location may be reached; either end may be left out
+type TimeWindow struct {
+	Earliest *float64 `json:"earliest"`
+	Latest   *float64 `json:"latest"`
+}
+
+// What OptimizeTour is to visit
+type TourRequest struct {
+	Locations []string `json:"locations"`
+	// Where the tour starts; the first location if empty
+	Start string `json:"start"`
+	// Whether the tour ends at the last location visited rather than returning to the start
+	Open bool `json:"open"`
+	// When the tour leaves the start, in the units of the edge weights, which are taken for travel times
+	Departure float64 `json:"departure"`
+	// Windows of the locations that have one. The start's is when a round trip must be back.
+	Windows map[string]TimeWindow `json:"windows"`
+	// When the tour leaves by the clock, to give each arrival a time of day with weights read as seconds. An
+	// RFC 3339 time, or one without an offset in the map's time zone.
+	DepartAt string `json:"depart_at"`
+}
+
+// When a tour reaches a location, and how long it waits there for the location's window to open
+type Arrival struct {
+	Location string  `json:"location"`
+	Arrival  float64 `json:"arrival"`
+	Wait     float64 `json:"wait"`
+	// When it arrives by the clock, for tours given DepartAt
+	At *time.Time `json:"at,omitempty"`
+}
+
+// A route visiting every location asked for
+type Tour struct {
+	// The locations asked for in the order visited, starting with the start, and ending with it again for a round trip
+	Order  []string `json:"order"`
+	Weight float64  `json:"weight"`
+	// Every location passed through
+	Route []string `json:"route"`
+	// When each location in Order is reached, leaving at the departure time
+	Schedule []Arrival `json:"schedule"`
+}
+
+var ErrInfeasible = errors.New("no tour meets every time window")
+
+// tourWeight is the weight of visiting order in turn, infinite if some leg has no route
+func (s *stopSearches) tourWeight(order []int) float64 {
+	var ret float64
+	for k := 1; k < len(order); k++ {
+		ret += s.dist(order[k-1], order[k])
+	}
+	return ret
+}
+
+// Searches between a tour's locations, with their windows by index
+type timedSearches struct {
+	*stopSearches
+	departure        float64
+	earliest, latest []float64
+	tolerance        float64
+}
+
+// arrive is when a location whose window opens at earliest is reached from one left at t, and when it can be left
+func (s *timedSearches) arrive(t float64, from, to int) (arrival, leave float64) {
+	arrival = t + s.dist(from, to)
+	return arrival, math.Max(arrival, s.earliest[to])
+}
+
+// late is the first position in order reached after its window closes, or -1 if there is none
+func (s *timedSearches) late(order []int) int {
+	t := s.departure
+	for k := 1; k < len(order); k++ {
+		var arrival float64
+		arrival, t = s.arrive(t, order[k-1], order[k])
+		if arrival > s.latest[order[k]]+s.tolerance {
+			return k
+		}
+	}
+	return -1
+}
+
+// nearestNeighbour builds a tour from the start by always going on to the location that can be left soonest
+// of those not visited yet. It fails with a reason as soon as one of them can no longer be reached in time.
+func (s *timedSearches) nearestNeighbour(open bool) ([]int, error) {
+	order := []int{0}
+	visited := make([]bool, len(s.names))
+	visited[0] = true
+	t := s.departure
+	for len(order) < len(s.names) {
+		last, next, nextLeave := order[len(order)-1], -1, math.Inf(1)
+		missed := -1
+		for j := range s.names {
+			if visited[j] || math.IsInf(s.dist(last, j), 1) {
+				continue
+			}
+			arrival, leave := s.arrive(t, last, j)
+			if arrival > s.latest[j]+s.tolerance {
+				if missed < 0 || s.latest[j] < s.latest[missed] {
+					missed = j
+				}
+				continue
+			}
+			if next < 0 || leave < nextLeave {
+				next, nextLeave = j, leave
+			}
+		}
+		// Arriving only gets later from here on, so a window missed now stays missed
+		if missed >= 0 {
+			return nil, fmt.Errorf("%w: leaving %s at %g, %s cannot be reached before its window closes at %g",
+				ErrInfeasible, s.names[last], t, s.names[missed], s.latest[missed])
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("cannot find a tour through every location, none of the rest can be reached from %s", s.names[last])
+		}
+		visited[next] = true
+		order = append(order, next)
+		t = nextLeave
+	}
+	if !open {
+		last := order[len(order)-1]
+		if math.IsInf(s.dist(last, 0), 1) {
+			return nil, fmt.Errorf("cannot find a tour through every location, %s cannot be reached from %s", s.names[0], s.names[last])
+		}
+		order = append(order, 0)
+		if s.late(order) >= 0 {
+			return nil, fmt.Errorf("%w: the tour is back at %s after its window closes at %g", ErrInfeasible, s.names[0], s.latest[0])
+		}
+	}
+	return order, nil
+}
+
+// byDeadline is the tour visiting locations in the order their windows close, as a fallback when nearest
+// neighbour misses one; ok is false if it is no good either
+func (s *timedSearches) byDeadline(open bool) ([]int, bool) {
+	order := make([]int, len(s.names))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order[1:], func(a, b int) bool {
+		x, y := order[1+a], order[1+b]
+		return s.latest[x] < s.latest[y] || s.latest[x] == s.latest[y] && s.earliest[x] < s.earliest[y]
+	})
+	if !open {
+		order = append(order, 0)
+	}
+	return order, !math.IsInf(s.tourWeight(order), 1) && s.late(order) < 0
+}
+
+// POST /maps/optimize/ (with JSON locations: []string, start: string, open: bool, departure: number, windows: map[location]{earliest, latest}, depart_at: string optional) :
+// READ a short tour from start, or the first location, through every location and back to start, or ending at the last visited
+// if open, with when it reaches each. Tours are built nearest neighbour first, then improved by 2-opt moves over the shortest
+// route weights, so are near the best rather than the best. Since edges are directed, a move reverses part of the tour and
+// reweighs it whole. Edge weights are taken for travel times: a tour waits wherever it is early, and fails with ErrInfeasible,
+// saying which location it could not reach in time, if no tour it finds reaches every location within its window.
+func (rs *RouteStore) OptimizeTour(request TourRequest) (Tour, error) {
+	locations, start, open := request.Locations, request.Start, request.Open
+	if len(locations) < 1 || len(locations) > MaxTourLocations {
+		return Tour{}, fmt.Errorf("locations must number between 1 and %d", MaxTourLocations)
+	}
+	if start == "" {
+		start = locations[0]
+	}
+	names := []string{start}
+	seen := map[string]bool{start: true}
+	for _, name := range locations {
+		if name == start {
+			continue
+		}
+		if seen[name] {
+			return Tour{}, fmt.Errorf("%s is listed more than once", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if math.IsNaN(request.Departure) || math.IsInf(request.Departure, 0) {
+		return Tour{}, fmt.Errorf("departure must be a number, not %g", request.Departure)
+	}
+	for name, window := range request.Windows {
+		if !seen[name] {
+			return Tour{}, fmt.Errorf("%s has a time window but is not on the tour", name)
+		}
+		if open && name == start {
+			return Tour{}, fmt.Errorf("%s starts an open tour, so cannot have a time window", name)
+		}
+		for _, bound := range []*float64{window.Earliest, window.Latest} {
+			if bound != nil && (math.IsNaN(*bound) || math.IsInf(*bound, 0)) {
+				return Tour{}, fmt.Errorf("the time window of %s must be numbers", name)
+			}
+		}
+		if window.Earliest != nil && window.Latest != nil && *window.Earliest > *window.Latest {
+			return Tour{}, fmt.Errorf("the time window of %s closes before it opens", name)
+		}
+	}
+
+	unlock := rs.rlock("OptimizeTour")
+	g, negativeEdges, precision, visitCosts := rs.copyGraph(), rs.negativeEdges, rs.precision, rs.copyVisitCosts()
+	var departAt time.Time
+	var err error
+	if request.DepartAt != "" {
+		departAt, err = rs.parseDepartAt(request.DepartAt)
+	}
+	unlock()
+	if err != nil {
+		return Tour{}, err
+	}
+
+	searches, err := newStopSearches(g, negativeEdges, visitCosts, names)
+	if err != nil {
+		return Tour{}, err
+	}
+	timed := &timedSearches{
+		stopSearches: searches,
+		departure:    request.Departure,
+		earliest:     make([]float64, len(names)),
+		latest:       make([]float64, len(names)),
+		tolerance:    tieTolerance(precision),
+	}
+	for i, name := range names {
+		timed.earliest[i], timed.latest[i] = math.Inf(-1), math.Inf(1)
+		if window, ok := request.Windows[name]; ok {
+			if window.Earliest != nil {
+				timed.earliest[i] = *window.Earliest
+			}
+			if window.Latest != nil {
+				timed.latest[i] = *window.Latest
+			}
+		}
+	}
+	// Where a location cannot be reached in time even straight from the start, no tour can, so say so rather than search
+	for i := 1; i < len(names); i++ {
+		if arrival := request.Departure + searches.dist(0, i); !math.IsInf(arrival, 1) && arrival > timed.latest[i]+timed.tolerance {
+			return Tour{}, fmt.Errorf("%w: %s closes at %g, but cannot be reached before %g even straight from %s",
+				ErrInfeasible, names[i], timed.latest[i], arrival, start)
+		}
+	}
+
+	order, err := timed.nearestNeighbour(open)
+	if errors.Is(err, ErrInfeasible) {
+		if byDeadline, ok := timed.byDeadline(open); ok {
+			order, err = byDeadline, nil
+		}
+	}
+	if err != nil {
+		return Tour{}, err
+	}
+
+	// 2-opt: reverse order[i..j] while that makes the tour lighter and keeps to every window. The start
+	// stays first, and last for a round trip.
+	best := searches.tourWeight(order)
+	end := len(order) - 1
+	if open {
+		end = len(order)
+	}
+	for improved := true; improved; {
+		improved = false
+		for i := 1; i < end-1; i++ {
+			for j := i + 1; j < end; j++ {
+				reverse(order[i : j+1])
+				if weight := searches.tourWeight(order); weight < best-timed.tolerance && timed.late(order) < 0 {
+					best, improved = weight, true
+				} else {
+					reverse(order[i : j+1])
+				}
+			}
+		}
+	}
+
+	ret := Tour{Weight: roundWeight(best, precision), Route: []string{start}}
+	t := request.Departure
+	for k, i := range order {
+		ret.Order = append(ret.Order, names[i])
+		arrival := Arrival{Location: names[i], Arrival: roundWeight(t, precision)}
+		if k > 0 {
+			ret.Route = searches.leg(ret.Route, order[k-1], i)
+			var leave float64
+			arrival.Arrival, leave = timed.arrive(t, order[k-1], i)
+			arrival.Wait = roundWeight(leave-arrival.Arrival, precision)
+			arrival.Arrival = roundWeight(arrival.Arrival, precision)
+			t = leave
+		}
+		if request.DepartAt != "" {
+			at := departAt.Add(time.Duration((arrival.Arrival - request.Departure) * float64(time.Second)))
+			arrival.At = &at
+		}
+		ret.Schedule = append(ret.Schedule, arrival)
+	}
+	return ret, nil
+}
+func reverse(s []int) {
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+}
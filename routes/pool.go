@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+)
+
+// SetRedisPool gives writers holding location locks up to size Redis connections of their own, made by dial, so that
+// they write Redis without holding the store lock and writers of disjoint locations do so at once. A named map's
+// connections get its prefix as the store's own has it. 0 closes the pool, leaving them the store's connection.
+func (rs *RouteStore) SetRedisPool(size int, dial func() (redis.Conn, error)) error {
+	if size < 0 {
+		return fmt.Errorf("the Redis pool size must not be negative, not %d", size)
+	}
+	defer rs.lock("SetRedisPool")()
+
+	if rs.pool != nil {
+		rs.pool.Close()
+		rs.pool = nil
+	}
+	if size == 0 {
+		return nil
+	}
+	if prefixed, ok := rs.redis.(prefixedConn); ok {
+		dialConn := dial
+		dial = func() (redis.Conn, error) {
+			conn, err := dialConn()
+			if err != nil {
+				return nil, err
+			}
+			return prefixedConn{Conn: conn, prefix: prefixed.prefix}, nil
+		}
+	}
+	rs.pool = &redis.Pool{Dial: dial, MaxIdle: size, MaxActive: size, Wait: true}
+	return nil
+}
+
+// Must be called with location locks held; a connection for the writer's own Redis writes, and the function that
+// gives it back. Without a pool it is the store's connection, held with the store lock as every other use of it is.
+func (rs *RouteStore) writeConn() (redis.Conn, func()) {
+	if rs.pool == nil {
+		rs.Lock()
+		return rs.redis, rs.Unlock
+	}
+	conn := rs.pool.Get()
+	return conn, func() { conn.Close() }
+}
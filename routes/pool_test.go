@@ -0,0 +1,215 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sharedRedis is a memoryRedis that several connections can use at once, as they would one Redis. Before each HSET
+// it calls hset, if set.
+type sharedRedis struct {
+	*memoryRedis
+	mu   *sync.Mutex
+	hset func(key string)
+}
+
+func (c sharedRedis) Do(command string, args ...interface{}) (interface{}, error) {
+	if command == "HSET" && c.hset != nil {
+		c.hset(fmt.Sprint(args[0]))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.memoryRedis.Do(command, args...)
+}
+
+// A store whose pool of size connections shares its Redis
+func pooledStore(t *testing.T, size int, hset func(key string)) (*RouteStore, sharedRedis) {
+	t.Helper()
+	conn := sharedRedis{memoryRedis: newMemoryRedis(), mu: new(sync.Mutex), hset: hset}
+	rs := New(conn)
+	if err := rs.SetRedisPool(size, func() (redis.Conn, error) { return conn, nil }); err != nil {
+		t.Fatal(err)
+	}
+	return rs, conn
+}
+
+func addLocations(t *testing.T, rs *RouteStore, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := rs.AddLocation(name, nil, nil, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func stripeOf(name string) uint64 {
+	return uint64(Location(name).ID()) % locationStripes
+}
+
+func waitFor(t *testing.T, done <-chan error, what string) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s waited for a writer of other locations", what)
+	}
+}
+
+// While one writer waits on Redis, writers of other locations and queries go on, and only writers of its own wait
+func TestDisjointWritersDoNotWait(t *testing.T) {
+	names := []string{"a", "b", "c", "d"}
+	for i, name := range names {
+		for _, other := range names[i+1:] {
+			if stripeOf(name) == stripeOf(other) {
+				t.Fatalf("%s and %s share a stripe, so would wait for each other", name, other)
+			}
+		}
+	}
+
+	blocked, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	rs, conn := pooledStore(t, 4, func(key string) {
+		if key == "a" {
+			once.Do(func() { close(blocked) })
+			<-release
+		}
+	})
+	addLocations(t, rs, names...)
+
+	first := make(chan error, 1)
+	go func() { first <- rs.AddRoutes("a", givenWeights(map[string]float64{"b": 1}), new(bool)) }()
+	<-blocked
+
+	done := make(chan error, 1)
+	go func() { done <- rs.AddRoutes("c", givenWeights(map[string]float64{"d": 2}), new(bool)) }()
+	waitFor(t, done, "adding routes")
+	go func() {
+		routes, err := rs.RoutesBetween("c", "d", RouteOptions{})
+		if err == nil && (len(routes) != 1 || routes[0].Weight != 2) {
+			err = fmt.Errorf("expected c to d weighing 2, got %v", routes)
+		}
+		done <- err
+	}()
+	waitFor(t, done, "a query")
+	go func() { done <- rs.RemoveRoutes("c", []string{"d"}) }()
+	waitFor(t, done, "removing routes")
+
+	// b is the first writer's
+	go func() { done <- rs.RemoveRoutes("b", []string{"a"}) }()
+	select {
+	case <-done:
+		t.Fatal("a writer of b should wait for the one adding a -> b")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	waitFor(t, first, "the first writer")
+	waitFor(t, done, "the writer of b")
+	if conn.hashes["a"]["b"] != "1" || rs.graph.WeightedEdge(Location("a").ID(), Location("b").ID()) == nil {
+		t.Fatal("a -> b should be in Redis and the graph")
+	}
+	if _, ok := conn.hashes["c"]["d"]; ok || rs.graph.HasEdgeFromTo(Location("c").ID(), Location("d").ID()) {
+		t.Fatal("c -> d should be gone from Redis and the graph")
+	}
+}
+
+// Writers of disjoint locations, all at once with queries, leave Redis holding the graph they leave, as Restore reads
+// it back
+func TestConcurrentWritersKeepRedisAndGraphEqual(t *testing.T) {
+	rs, conn := pooledStore(t, 3, nil)
+	const writers, locations = 8, 5
+	name := func(writer, i int) string { return fmt.Sprintf("w%d-%d", writer, i) }
+	for w := 0; w < writers; w++ {
+		for i := 0; i < locations; i++ {
+			addLocations(t, rs, name(w, i))
+		}
+	}
+
+	base := rs.revision
+	var wg sync.WaitGroup
+	errs := make(chan error, writers+1)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for n := 0; n < 200; n++ {
+				from, to := name(w, r.Intn(locations)), name(w, r.Intn(locations))
+				var err error
+				if r.Intn(3) == 0 {
+					err = rs.RemoveRoutes(from, []string{to})
+				} else {
+					both := r.Intn(2) == 0
+					err = rs.AddRoutes(from, givenWeights(map[string]float64{to: float64(1 + r.Intn(9))}), &both)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	stop := make(chan struct{})
+	go func() {
+		defer close(errs)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := rs.RoutesBetween(name(0, 0), name(0, 1), RouteOptions{}); err != nil {
+				errs <- err
+				return
+			}
+			rs.Snapshot()
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	inGraph := make(map[string]map[string]string)
+	for _, edge := range rs.sortedEdges() {
+		if inGraph[edge.From] == nil {
+			inGraph[edge.From] = make(map[string]string)
+		}
+		inGraph[edge.From][edge.To] = strconv.FormatFloat(edge.Weight, 'g', -1, 64)
+	}
+	if len(inGraph) == 0 {
+		t.Fatal("the writers should leave some edges")
+	}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < locations; i++ {
+			from := name(w, i)
+			if len(inGraph[from])+len(conn.hashes[from]) > 0 && !reflect.DeepEqual(inGraph[from], conn.hashes[from]) {
+				t.Fatalf("the edges from %s are %v in the graph but %v in Redis", from, inGraph[from], conn.hashes[from])
+			}
+		}
+	}
+
+	restored, err := Restore(conn.memoryRedis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := restored.sortedEdges(), rs.sortedEdges(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("restored %v, not %v", got, want)
+	}
+	if !reflect.DeepEqual(restored.twoWay, rs.twoWay) {
+		t.Fatal("the two-way edges should restore as they were")
+	}
+	if rs.revision-base != writers*200 {
+		t.Fatalf("%d changes made %d revisions", writers*200, rs.revision-base)
+	}
+}
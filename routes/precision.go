@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+)
+
+// Weights are kept as given unless SetWeightPrecision chooses a number of decimal places
+const DefaultWeightPrecision = -1
+
+// The most decimal places a float64 weight can usefully be rounded to
+const MaxWeightPrecision = 15
+
+// roundWeight rounds weight to precision decimal places, or returns it as it is if precision is negative
+func roundWeight(weight float64, precision int) float64 {
+	if precision < 0 || math.IsInf(weight, 0) || math.IsNaN(weight) {
+		return weight
+	}
+	scale := math.Pow10(precision)
+	return math.Round(weight*scale) / scale
+}
+
+// How far apart two route totals can be and still tie: half the smallest step at precision
+// decimal places, far more than the error adding rounded weights can build up, and far less
+// than the difference between two totals that really differ
+func tieTolerance(precision int) float64 {
+	if precision < 0 {
+		return 0
+	}
+	return math.Pow10(-precision) / 2
+}
+
+// SetWeightPrecision makes weights be rounded to digits decimal places as they are stored, route totals
+// as they are returned, and totals that agree to that many places tie, so that floating point noise
+// such as 0.1+0.2 != 0.3 does not split or hide shortest routes. Stored weights are rounded at once.
+// A negative digits keeps weights as given.
+func (rs *RouteStore) SetWeightPrecision(digits int) error {
+	if digits > MaxWeightPrecision {
+		return fmt.Errorf("weight precision must be at most %d decimal places, not %d", MaxWeightPrecision, digits)
+	}
+	if digits < 0 {
+		digits = DefaultWeightPrecision
+	}
+
+	defer rs.lock("SetWeightPrecision")()
+
+	rs.precision = digits
+	rs.changed()
+
+	type edge struct {
+		from, to Location
+		weight   float64
+	}
+	var rounded []edge
+	edges := rs.graph.WeightedEdges()
+	for edges.Next() {
+		e := edges.WeightedEdge()
+		if weight := roundWeight(e.Weight(), digits); weight != e.Weight() {
+			rounded = append(rounded, edge{Location(nodeName(e.From())), Location(nodeName(e.To())), weight})
+		}
+	}
+	for _, e := range rounded {
+		if err := rs.setEdge(e.from, e.to, e.weight); err != nil {
+			return err
+		}
+		if _, err := rs.redis.Do("HSET", string(e.from), string(e.to), e.weight); err != nil {
+			return err
+		}
+	}
+
+	for name := range rs.hot {
+		rs.hot[name] = rs.newHotTree(Location(name).ID())
+	}
+	return nil
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) roundWeight(weight float64) float64 {
+	return roundWeight(weight, rs.precision)
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) roundRoutes(routes []Route) {
+	for i := range routes {
+		routes[i].Weight = rs.roundWeight(routes[i].Weight)
+	}
+}
@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Weight profiles, each an edge attribute of that name that a route query can weigh edges by instead of their
+// weights, as set by PUT /maps/<from>/edge/<to>/attributes/. An edge without the attribute weighs its weight, so a
+// map can give a profile for only the edges where it differs.
+var Profiles = []string{"distance", "duration", "cost"}
+
+// ParseProfile checks s names a weight profile; the empty string means the edges' weights
+func ParseProfile(s string) (string, error) {
+	if s == "" {
+		return s, nil
+	}
+	for _, profile := range Profiles {
+		if s == profile {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("unknown profile %q, expected one of %s", s, strings.Join(Profiles, ", "))
+}
+
+// profileCostFunction makes the cost function that weighs each edge by the profile's attribute, or its weight
+// without one. Edges are weighed lazily as searches reach them, rather than by a graph kept per profile.
+func profileCostFunction(profile string) *CostFunction {
+	return &CostFunction{Expression: profile, terms: []costTerm{{coefficient: 1, attribute: profile, orWeight: true}}}
+}
@@ -0,0 +1,262 @@
+package routes
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// memoryRedis stands in for Redis in tests, keeping just enough of each command the store uses. MULTI and EXEC
+// do nothing, so commands take effect as they are sent.
+type memoryRedis struct {
+	sets   map[string]map[string]bool
+	hashes map[string]map[string]string
+	zsets  map[string]map[string]float64
+	lists  map[string][]string
+	values map[string]string
+}
+
+func newMemoryRedis() *memoryRedis {
+	return &memoryRedis{
+		sets:   make(map[string]map[string]bool),
+		hashes: make(map[string]map[string]string),
+		zsets:  make(map[string]map[string]float64),
+		lists:  make(map[string][]string),
+		values: make(map[string]string),
+	}
+}
+
+func (m *memoryRedis) Close() error                      { return nil }
+func (m *memoryRedis) Err() error                        { return nil }
+func (m *memoryRedis) Send(string, ...interface{}) error { return nil }
+func (m *memoryRedis) Flush() error                      { return nil }
+func (m *memoryRedis) Receive() (interface{}, error)     { return nil, nil }
+
+func (m *memoryRedis) hash(key string) map[string]string {
+	if m.hashes[key] == nil {
+		m.hashes[key] = make(map[string]string)
+	}
+	return m.hashes[key]
+}
+
+func (m *memoryRedis) set(key string) map[string]bool {
+	if m.sets[key] == nil {
+		m.sets[key] = make(map[string]bool)
+	}
+	return m.sets[key]
+}
+
+func (m *memoryRedis) zset(key string) map[string]float64 {
+	if m.zsets[key] == nil {
+		m.zsets[key] = make(map[string]float64)
+	}
+	return m.zsets[key]
+}
+
+func bulk(s string) interface{} {
+	return []byte(s)
+}
+
+func formatScore(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func (m *memoryRedis) Do(command string, args ...interface{}) (interface{}, error) {
+	a := make([]string, len(args))
+	for i, arg := range args {
+		if b, ok := arg.([]byte); ok {
+			a[i] = string(b)
+		} else {
+			a[i] = fmt.Sprint(arg)
+		}
+	}
+
+	switch strings.ToUpper(command) {
+	case "MULTI", "EXEC", "DISCARD", "EXPIRE":
+		return "OK", nil
+	case "PING":
+		return "PONG", nil
+	case "CONFIG":
+		return []interface{}{}, nil
+	case "MEMORY":
+		return int64(0), nil
+	case "SADD":
+		for _, member := range a[1:] {
+			m.set(a[0])[member] = true
+		}
+		return int64(len(a) - 1), nil
+	case "SREM":
+		for _, member := range a[1:] {
+			delete(m.sets[a[0]], member)
+		}
+		return int64(len(a) - 1), nil
+	case "SISMEMBER":
+		if m.sets[a[0]][a[1]] {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "SMEMBERS":
+		ret := []interface{}{}
+		for member := range m.sets[a[0]] {
+			ret = append(ret, bulk(member))
+		}
+		return ret, nil
+	case "HSET":
+		for i := 1; i+1 < len(a); i += 2 {
+			m.hash(a[0])[a[i]] = a[i+1]
+		}
+		return int64(1), nil
+	case "HINCRBY":
+		n, _ := strconv.ParseInt(m.hash(a[0])[a[1]], 10, 64)
+		by, _ := strconv.ParseInt(a[2], 10, 64)
+		m.hash(a[0])[a[1]] = strconv.FormatInt(n+by, 10)
+		return n + by, nil
+	case "HGET":
+		if value, ok := m.hashes[a[0]][a[1]]; ok {
+			return bulk(value), nil
+		}
+		return nil, nil
+	case "HDEL":
+		for _, field := range a[1:] {
+			delete(m.hashes[a[0]], field)
+		}
+		return int64(1), nil
+	case "HGETALL":
+		ret := []interface{}{}
+		for field, value := range m.hashes[a[0]] {
+			ret = append(ret, bulk(field), bulk(value))
+		}
+		return ret, nil
+	case "SET":
+		m.values[a[0]] = a[1]
+		return "OK", nil
+	case "GET":
+		if value, ok := m.values[a[0]]; ok {
+			return bulk(value), nil
+		}
+		return nil, nil
+	case "EXISTS":
+		n := int64(0)
+		for _, key := range a {
+			if len(m.sets[key]) > 0 || len(m.hashes[key]) > 0 || len(m.zsets[key]) > 0 || len(m.lists[key]) > 0 {
+				n++
+			} else if _, ok := m.values[key]; ok {
+				n++
+			}
+		}
+		return n, nil
+	case "DEL":
+		for _, key := range a {
+			delete(m.sets, key)
+			delete(m.hashes, key)
+			delete(m.zsets, key)
+			delete(m.lists, key)
+			delete(m.values, key)
+		}
+		return int64(len(a)), nil
+	case "RPUSH":
+		m.lists[a[0]] = append(m.lists[a[0]], a[1:]...)
+		return int64(len(m.lists[a[0]])), nil
+	case "LTRIM":
+		list := m.lists[a[0]]
+		start, _ := strconv.Atoi(a[1])
+		if start < 0 {
+			if start += len(list); start < 0 {
+				start = 0
+			}
+		}
+		m.lists[a[0]] = list[start:]
+		return "OK", nil
+	case "LRANGE":
+		ret := []interface{}{}
+		for _, value := range m.lists[a[0]] {
+			ret = append(ret, bulk(value))
+		}
+		return ret, nil
+	case "ZADD":
+		score, _ := strconv.ParseFloat(a[1], 64)
+		m.zset(a[0])[a[2]] = score
+		return int64(1), nil
+	case "ZINCRBY":
+		by, _ := strconv.ParseFloat(a[1], 64)
+		m.zset(a[0])[a[2]] += by
+		return bulk(formatScore(m.zsets[a[0]][a[2]])), nil
+	case "ZREM":
+		for _, member := range a[1:] {
+			delete(m.zsets[a[0]], member)
+		}
+		return int64(1), nil
+	case "ZRANGE", "ZREVRANGE":
+		members := make([]string, 0, len(m.zsets[a[0]]))
+		for member := range m.zsets[a[0]] {
+			members = append(members, member)
+		}
+		scores := m.zsets[a[0]]
+		sort.Slice(members, func(i, j int) bool { return scores[members[i]] < scores[members[j]] })
+		if strings.ToUpper(command) == "ZREVRANGE" {
+			for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+				members[i], members[j] = members[j], members[i]
+			}
+		}
+		ret := []interface{}{}
+		for _, member := range members {
+			ret = append(ret, bulk(member))
+			if strings.EqualFold(a[len(a)-1], "WITHSCORES") {
+				ret = append(ret, bulk(formatScore(scores[member])))
+			}
+		}
+		return ret, nil
+	case "TYPE":
+		switch {
+		case len(m.sets[a[0]]) > 0:
+			return "set", nil
+		case len(m.hashes[a[0]]) > 0:
+			return "hash", nil
+		case len(m.zsets[a[0]]) > 0:
+			return "zset", nil
+		case len(m.lists[a[0]]) > 0:
+			return "list", nil
+		}
+		if _, ok := m.values[a[0]]; ok {
+			return "string", nil
+		}
+		return "none", nil
+	case "SCAN":
+		ret := []interface{}{}
+		for key := range m.keys() {
+			ret = append(ret, bulk(key))
+		}
+		return []interface{}{bulk("0"), ret}, nil
+	}
+	return nil, fmt.Errorf("memoryRedis does not support %s", command)
+}
+
+// keys returns the keys that exist, which as in Redis leaves out those emptied
+func (m *memoryRedis) keys() map[string]bool {
+	ret := make(map[string]bool)
+	for key, value := range m.sets {
+		if len(value) > 0 {
+			ret[key] = true
+		}
+	}
+	for key, value := range m.hashes {
+		if len(value) > 0 {
+			ret[key] = true
+		}
+	}
+	for key, value := range m.zsets {
+		if len(value) > 0 {
+			ret[key] = true
+		}
+	}
+	for key, value := range m.lists {
+		if len(value) > 0 {
+			ret[key] = true
+		}
+	}
+	for key := range m.values {
+		ret[key] = true
+	}
+	return ret
+}
@@ -0,0 +1,152 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The most keys of one map MEMORY USAGE is asked about for one report
+const MaxMemorySamples = 10000
+
+// Approximately how much Redis memory one map's keys take
+type MapMemory struct {
+	// The empty name is the default map
+	Map  string `json:"map"`
+	Keys int    `json:"keys"`
+	// How many of the keys MEMORY USAGE was asked about; the estimate is their mean times Keys
+	Sampled int   `json:"sampled"`
+	Bytes   int64 `json:"bytes"`
+	// Bytes as a fraction of Redis's maxmemory, if it has one
+	Share *float64 `json:"share,omitempty"`
+}
+
+// Approximately how much Redis memory each map takes, largest first
+type RedisMemoryReport struct {
+	Maps []MapMemory `json:"maps"`
+	// Keys of the server rather than any map: the list of named maps, and usage counts
+	Server MapMemory `json:"server"`
+	// The total of every estimate
+	Bytes int64 `json:"bytes"`
+	// Redis's maxmemory, 0 if it has none, past which it evicts keys or refuses writes
+	MaxMemory int64 `json:"maxmemory"`
+}
+
+// Keys sampled for one map, by reservoir sampling so that every key is as likely to be chosen
+type keySample struct {
+	keys    int
+	sampled []string
+}
+
+func (s *keySample) add(key string, samples int, random *rand.Rand) {
+	s.keys++
+	if len(s.sampled) < samples {
+		s.sampled = append(s.sampled, key)
+	} else if i := random.Intn(s.keys); i < samples {
+		s.sampled[i] = key
+	}
+}
+
+// GET  /admin/redis-memory/ (?samples=100 optional) : READ approximately how much Redis memory each map takes, the default map as
+// the empty name, by a SCAN of every key and MEMORY USAGE of up to <samples> of each map's keys, chosen at random, with each map's
+// share of Redis's maxmemory. This walks the whole keyspace, so takes a while on a large Redis, though it blocks Redis no longer
+// than each SCAN and MEMORY USAGE does.
+func (r *Registry) RedisMemory(samples int) (RedisMemoryReport, error) {
+	if samples < 1 || samples > MaxMemorySamples {
+		return RedisMemoryReport{}, fmt.Errorf("samples must be between 1 and %d, not %d", MaxMemorySamples, samples)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Seeded alike every time, so that the same keyspace gives the same estimate
+	random := rand.New(rand.NewSource(1))
+	maps := make(map[string]*keySample)
+	server := &keySample{}
+	cursor := 0
+	for {
+		values, err := redis.Values(r.redis.Do("SCAN", cursor, "COUNT", 1000))
+		if err != nil {
+			return RedisMemoryReport{}, err
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return RedisMemoryReport{}, err
+		}
+		for _, key := range keys {
+			sample := server
+			if rest := strings.TrimPrefix(key, map_key_prefix); rest != key {
+				name := strings.SplitN(rest, ":", 2)[0]
+				if maps[name] == nil {
+					maps[name] = &keySample{}
+				}
+				sample = maps[name]
+			} else if key != maps_set && !strings.HasPrefix(key, usage_key_prefix) {
+				if maps[""] == nil {
+					maps[""] = &keySample{}
+				}
+				sample = maps[""]
+			}
+			sample.add(key, samples, random)
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+
+	ret := RedisMemoryReport{Maps: []MapMemory{}}
+	config, err := redis.Strings(r.redis.Do("CONFIG", "GET", "maxmemory"))
+	if err != nil {
+		return RedisMemoryReport{}, err
+	}
+	if len(config) == 2 {
+		if ret.MaxMemory, err = strconv.ParseInt(config[1], 10, 64); err != nil {
+			return RedisMemoryReport{}, err
+		}
+	}
+	estimate := func(name string, sample *keySample) (MapMemory, error) {
+		ret := MapMemory{Map: name, Keys: sample.keys}
+		var total int64
+		for _, key := range sample.sampled {
+			bytes, err := redis.Int64(r.redis.Do("MEMORY", "USAGE", key))
+			if err == redis.ErrNil {
+				// Deleted since the SCAN
+				continue
+			} else if err != nil {
+				return ret, err
+			}
+			total += bytes
+			ret.Sampled++
+		}
+		if ret.Sampled > 0 {
+			ret.Bytes = total * int64(ret.Keys) / int64(ret.Sampled)
+		}
+		return ret, nil
+	}
+	for name, sample := range maps {
+		usage, err := estimate(name, sample)
+		if err != nil {
+			return RedisMemoryReport{}, err
+		}
+		ret.Maps = append(ret.Maps, usage)
+		ret.Bytes += usage.Bytes
+	}
+	if ret.Server, err = estimate("", server); err != nil {
+		return RedisMemoryReport{}, err
+	}
+	ret.Bytes += ret.Server.Bytes
+	if ret.MaxMemory > 0 {
+		for i := range ret.Maps {
+			share := float64(ret.Maps[i].Bytes) / float64(ret.MaxMemory)
+			ret.Maps[i].Share = &share
+		}
+	}
+	sort.Slice(ret.Maps, func(i, j int) bool {
+		a, b := ret.Maps[i], ret.Maps[j]
+		return a.Bytes > b.Bytes || a.Bytes == b.Bytes && a.Map < b.Map
+	})
+	return ret, nil
+}
@@ -0,0 +1,292 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"gonum.org/v1/gonum/graph"
+	"sort"
+	"strings"
+)
+
+const (
+	// Every region, each with the region containing it or "" at the top
+	regions_hash = "rest_project:regions"
+	// The region of every location in one
+	location_regions_hash = "rest_project:location_regions"
+)
+
+// A named group of locations, which may sit inside a larger region
+type Region struct {
+	Name       string   `json:"name"`
+	Parent     string   `json:"parent,omitempty"`
+	Subregions []string `json:"subregions"`
+	// The locations directly in the region; only listed for a single region
+	Locations []string `json:"locations,omitempty"`
+	// How many locations are in the region, including those in its subregions
+	TotalLocations int `json:"total_locations"`
+}
+
+// validateRegionName rejects names that could not be used in a URL path segment
+func validateRegionName(name string) error {
+	if name == "" {
+		return fmt.Errorf("region names cannot be empty")
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("region name %q cannot contain '/'", name)
+	}
+	return nil
+}
+
+func (rs *RouteStore) restoreRegions() error {
+	parents, err := redis.StringMap(rs.redis.Do("HGETALL", regions_hash))
+	if err != nil {
+		return err
+	}
+	for name, parent := range parents {
+		rs.regions[name] = parent
+	}
+	members, err := redis.StringMap(rs.redis.Do("HGETALL", location_regions_hash))
+	if err != nil {
+		return err
+	}
+	for name, region := range members {
+		if !rs.archived[name] {
+			rs.locationRegions[Location(name).ID()] = region
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held, whenever a location goes
+func (rs *RouteStore) removeFromRegion(name string) error {
+	if _, ok := rs.locationRegions[Location(name).ID()]; !ok {
+		return nil
+	}
+	if _, err := rs.redis.Do("HDEL", location_regions_hash, name); err != nil {
+		return err
+	}
+	delete(rs.locationRegions, Location(name).ID())
+	return nil
+}
+
+// Must be called with the lock held; the regions directly inside each region
+func (rs *RouteStore) subregions() map[string][]string {
+	ret := make(map[string][]string)
+	for name, parent := range rs.regions {
+		if parent != "" {
+			ret[parent] = append(ret[parent], name)
+		}
+	}
+	for _, children := range ret {
+		sort.Strings(children)
+	}
+	return ret
+}
+
+// Must be called with the lock held; the IDs of the locations in the region or any of its subregions
+func (rs *RouteStore) regionMembers(region string) map[int64]bool {
+	within := map[string]bool{region: true}
+	for changed := true; changed; {
+		changed = false
+		for name, parent := range rs.regions {
+			if within[parent] && !within[name] {
+				within[name], changed = true, true
+			}
+		}
+	}
+	ret := make(map[int64]bool)
+	for id, r := range rs.locationRegions {
+		if within[r] {
+			ret[id] = true
+		}
+	}
+	return ret
+}
+
+// GET  /maps/regions/ : READ every region with its parent, subregions and how many locations it holds, in name order
+func (rs *RouteStore) Regions() []Region {
+	defer rs.rlock("Regions")()
+
+	subregions := rs.subregions()
+	ret := []Region{}
+	for name, parent := range rs.regions {
+		ret = append(ret, Region{
+			Name:           name,
+			Parent:         parent,
+			Subregions:     append([]string{}, subregions[name]...),
+			TotalLocations: len(rs.regionMembers(name)),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// GET  /maps/regions/<region> : READ a region, with the locations directly in it
+func (rs *RouteStore) GetRegion(name string) (Region, error) {
+	defer rs.rlock("GetRegion")()
+
+	parent, ok := rs.regions[name]
+	if !ok {
+		return Region{}, fmt.Errorf("region %s does not exist", name)
+	}
+	ret := Region{
+		Name:           name,
+		Parent:         parent,
+		Subregions:     append([]string{}, rs.subregions()[name]...),
+		Locations:      []string{},
+		TotalLocations: len(rs.regionMembers(name)),
+	}
+	for id, region := range rs.locationRegions {
+		if region == name {
+			ret.Locations = append(ret.Locations, nodeName(rs.graph.Node(id)))
+		}
+	}
+	sort.Strings(ret.Locations)
+	return ret, nil
+}
+
+// GET  /maps/?region=<region> : READ the locations in <region> or any of its subregions, in name order
+func (rs *RouteStore) RegionLocations(name string) ([]string, error) {
+	defer rs.rlock("RegionLocations")()
+
+	if _, ok := rs.regions[name]; !ok {
+		return nil, fmt.Errorf("region %s does not exist", name)
+	}
+	var ret []string
+	for id := range rs.regionMembers(name) {
+		ret = append(ret, nodeName(rs.graph.Node(id)))
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// PUT  /maps/regions/<region> (with JSON parent: string optional) : UPDATE create <region>, or move it inside another region or to the top
+func (rs *RouteStore) SetRegion(name, parent string) error {
+	if err := validateRegionName(name); err != nil {
+		return err
+	}
+
+	defer rs.lock("SetRegion")()
+
+	if parent != "" {
+		if _, ok := rs.regions[parent]; !ok {
+			return fmt.Errorf("region %s does not exist", parent)
+		}
+		for r := parent; r != ""; r = rs.regions[r] {
+			if r == name {
+				return fmt.Errorf("region %s cannot be inside %s, which is inside it", name, parent)
+			}
+		}
+	}
+
+	if _, err := rs.redis.Do("HSET", regions_hash, name, parent); err != nil {
+		return err
+	}
+	rs.regions[name] = parent
+	return nil
+}
+
+// DELETE /maps/regions/<region> : DELETE <region>; its locations and subregions move to the region it was in, if any
+func (rs *RouteStore) RemoveRegion(name string) error {
+	defer rs.lock("RemoveRegion")()
+
+	parent, ok := rs.regions[name]
+	if !ok {
+		return fmt.Errorf("region %s does not exist", name)
+	}
+
+	for child, p := range rs.regions {
+		if p != name {
+			continue
+		}
+		if _, err := rs.redis.Do("HSET", regions_hash, child, parent); err != nil {
+			return err
+		}
+		rs.regions[child] = parent
+	}
+	for id, region := range rs.locationRegions {
+		if region != name {
+			continue
+		}
+		loc := nodeName(rs.graph.Node(id))
+		if parent == "" {
+			if err := rs.removeFromRegion(loc); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := rs.redis.Do("HSET", location_regions_hash, loc, parent); err != nil {
+			return err
+		}
+		rs.locationRegions[id] = parent
+	}
+	if _, err := rs.redis.Do("HDEL", regions_hash, name); err != nil {
+		return err
+	}
+	delete(rs.regions, name)
+	return nil
+}
+
+// PUT  /maps/regions/<region>/locations (with JSON []string) : UPDATE put the given locations in <region>, taking them out of any other.
+// A location is in one region at most, and leaves it when deleted.
+func (rs *RouteStore) AddToRegion(region string, locations []string) error {
+	defer rs.lock("AddToRegion")()
+
+	if _, ok := rs.regions[region]; !ok {
+		return fmt.Errorf("region %s does not exist", region)
+	}
+	for _, name := range locations {
+		if rs.graph.Node(Location(name).ID()) == nil {
+			return fmt.Errorf("%s does not exist", name)
+		}
+	}
+
+	for _, name := range locations {
+		if _, err := rs.redis.Do("HSET", location_regions_hash, name, region); err != nil {
+			return err
+		}
+		rs.locationRegions[Location(name).ID()] = region
+	}
+	return nil
+}
+
+// DELETE /maps/regions/<region>/locations/<location> : DELETE take <location> out of <region>
+func (rs *RouteStore) RemoveFromRegion(region, name string) error {
+	defer rs.lock("RemoveFromRegion")()
+
+	if _, ok := rs.regions[region]; !ok {
+		return fmt.Errorf("region %s does not exist", region)
+	}
+	if rs.locationRegions[Location(name).ID()] != region {
+		return fmt.Errorf("%s is not directly in region %s", name, region)
+	}
+	return rs.removeFromRegion(name)
+}
+
+// GET  /maps/regions/<from>/routes/<to> : READ the shortest routes from any location in region <from> to any location in region <to>,
+// as NearestRoutes finds them
+func (rs *RouteStore) RegionRoutes(fromRegion, toRegion string) ([]Route, error) {
+	defer rs.rlock("RegionRoutes")()
+
+	for _, region := range []string{fromRegion, toRegion} {
+		if _, ok := rs.regions[region]; !ok {
+			return nil, fmt.Errorf("region %s does not exist", region)
+		}
+	}
+	return rs.routesBetweenSets(rs.regionMembers(fromRegion), rs.regionMembers(toRegion))
+}
+
+// Must be called with the lock held; g without the edges that leave the options' Within region, and its subregions, which
+// both ends must be in
+func (rs *RouteStore) withinRegionGraph(g graph.WeightedDirected, opts RouteOptions, from, to Location) (graph.WeightedDirected, error) {
+	if opts.Within == "" {
+		return g, nil
+	}
+	members := rs.regionMembers(opts.Within)
+	for _, loc := range []Location{from, to} {
+		if !members[loc.ID()] {
+			return nil, fmt.Errorf("%s is not in region %s", loc, opts.Within)
+		}
+	}
+	return filteredGraph{WeightedDirected: g, allow: func(u, v int64) bool { return members[u] && members[v] }}, nil
+}
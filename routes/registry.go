@@ -0,0 +1,202 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// The names of every named map
+	maps_set = "rest_project:maps"
+	// Every key of a named map is this, its name and a colon, then the key the default map would use
+	map_key_prefix = "rest_project:map:"
+)
+
+// Map names go into Redis key prefixes and SCAN patterns, so are kept to characters neither gives a meaning
+var mapName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var ErrNoMap = errors.New("there is no such map")
+
+func validateMapName(name string) error {
+	if !mapName.MatchString(name) {
+		return fmt.Errorf("map name %q must be letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// Registry holds the default map, kept under the Redis keys it always had, and any number of named maps, each an
+// independent RouteStore with its own graph and its own Redis key prefix. Named maps are restored the first time
+// they are used, each on a connection of its own since a RouteStore uses its connection as if nothing else did.
+type Registry struct {
+	mu sync.Mutex
+	// For maps_set only, guarded by mu
+	redis redis.Conn
+	dial  func() (redis.Conn, error)
+	// Called on each named map once it is restored, before it is used, to apply the server's settings
+	configure func(*RouteStore) error
+
+	defaultStore *RouteStore
+	stores       map[string]*RouteStore
+	conns        map[string]redis.Conn
+}
+
+func NewRegistry(defaultStore *RouteStore, dial func() (redis.Conn, error), configure func(*RouteStore) error) (*Registry, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &Registry{
+		redis:        conn,
+		dial:         dial,
+		configure:    configure,
+		defaultStore: defaultStore,
+		stores:       make(map[string]*RouteStore),
+		conns:        make(map[string]redis.Conn),
+	}, nil
+}
+
+// GET  /namespaces/ : READ the names of every named map, in name order
+func (r *Registry) Maps() ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ret, err := redis.Strings(r.redis.Do("SMEMBERS", maps_set))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// Get returns the named map, restoring it from Redis if this is its first use, or an error wrapping ErrNoMap;
+// the empty name is the default map
+func (r *Registry) Get(name string) (*RouteStore, error) {
+	if name == "" {
+		return r.defaultStore, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if store, ok := r.stores[name]; ok {
+		return store, nil
+	}
+	exists, err := redis.Bool(r.redis.Do("SISMEMBER", maps_set, name))
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrNoMap, name)
+	}
+
+	conn, err := r.dial()
+	if err != nil {
+		return nil, err
+	}
+	store, err := Restore(prefixedConn{Conn: conn, prefix: map_key_prefix + name + ":"})
+	if err == nil && r.configure != nil {
+		err = r.configure(store)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r.stores[name], r.conns[name] = store, conn
+	return store, nil
+}
+
+// PUT  /namespaces/<map> : CREATE an empty named map
+func (r *Registry) Create(name string) error {
+	if err := validateMapName(name); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	added, err := redis.Int(r.redis.Do("SADD", maps_set, name))
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return fmt.Errorf("map %s already exists", name)
+	}
+	return nil
+}
+
+// DELETE /namespaces/<map> : DELETE a named map and every Redis key it has. Requests already being served by the
+// map may fail, as its connections are closed under them.
+func (r *Registry) Delete(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	removed, err := redis.Int(r.redis.Do("SREM", maps_set, name))
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return fmt.Errorf("%w: %s", ErrNoMap, name)
+	}
+	if conn, ok := r.conns[name]; ok {
+		r.stores[name].SetRedisPool(0, nil)
+		conn.Close()
+		delete(r.stores, name)
+		delete(r.conns, name)
+	}
+
+	cursor := 0
+	for {
+		values, err := redis.Values(r.redis.Do("SCAN", cursor, "MATCH", map_key_prefix+name+":*", "COUNT", 1000))
+		if err != nil {
+			return err
+		}
+		var keys []interface{}
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if _, err := r.redis.Do("DEL", keys...); err != nil {
+				return err
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// prefixedConn puts a named map's prefix before every key the store gives Redis, so each map's keys are its own
+type prefixedConn struct {
+	redis.Conn
+	prefix string
+}
+
+func (c prefixedConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return c.Conn.Do(command, c.prefixed(command, args)...)
+}
+
+func (c prefixedConn) Send(command string, args ...interface{}) error {
+	return c.Conn.Send(command, c.prefixed(command, args)...)
+}
+
+// prefixed returns args with the keys among them prefixed: none for commands that take no key, every one for DEL,
+// and otherwise the first, which is all the store ever gives a single command
+func (c prefixedConn) prefixed(command string, args []interface{}) []interface{} {
+	keys := 1
+	switch strings.ToUpper(command) {
+	case "", "MULTI", "EXEC", "DISCARD", "PING", "CONFIG":
+		return args
+	case "DEL":
+		keys = len(args)
+	}
+	ret := append([]interface{}{}, args...)
+	for i := 0; i < keys && i < len(ret); i++ {
+		ret[i] = c.prefix + fmt.Sprint(ret[i])
+	}
+	return ret
+}
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"reflect"
+	"sort"
+)
+
+// POST /admin/reload-graph/ : UPDATE read the whole store from Redis again, as Restore does at startup, and swap it in
+// for the one in memory at once, so bulk changes made by offline tools show without a restart. Queries wait for the
+// swap rather than seeing it half done, and a store that cannot be read, such as one with a bad weight, leaves this one
+// as it was. The settings the store was configured with, such as its weight precision, apply to what is read and are
+// kept. Reports what changed, as ResyncAll does.
+func (rs *RouteStore) ReloadGraph() (ResyncReport, error) {
+	defer rs.lock("ReloadGraph")()
+
+	fresh := New(rs.redis)
+	fresh.precision = rs.precision
+	fresh.integerWeights = rs.integerWeights
+	fresh.distanceWeights = rs.distanceWeights
+	fresh.trashRetention = rs.trashRetention
+	if err := fresh.load(); err != nil {
+		return ResyncReport{}, err
+	}
+
+	report := rs.reloadReport(fresh)
+	rs.graph = fresh.graph
+	rs.negativeEdges = fresh.negativeEdges
+	rs.trash = fresh.trash
+	rs.hot = fresh.hot
+	rs.symmetric = fresh.symmetric
+	rs.defaultWeight = fresh.defaultWeight
+	rs.tags = fresh.tags
+	rs.locationTags = fresh.locationTags
+	rs.visitCosts = fresh.visitCosts
+	rs.attributes = fresh.attributes
+	rs.modes = fresh.modes
+	rs.costs = fresh.costs
+	rs.timezone = fresh.timezone
+	rs.regions = fresh.regions
+	rs.locationRegions = fresh.locationRegions
+	rs.twoWay = fresh.twoWay
+	rs.archived = fresh.archived
+	rs.closed = fresh.closed
+	rs.coordinates = fresh.coordinates
+	rs.watched = fresh.watched
+	rs.critical = fresh.critical
+	rs.changed()
+	return report, nil
+}
+
+// Must be called with the lock held; every edge of the graph, open or closed, by its ends' names
+func (rs *RouteStore) edgeWeights() map[Pair]float64 {
+	ret := make(map[Pair]float64)
+	edges := rs.graph.WeightedEdges()
+	for edges.Next() {
+		e := edges.WeightedEdge()
+		ret[Pair{From: nodeName(e.From()), To: nodeName(e.To())}] = e.Weight()
+	}
+	for _, c := range rs.closed {
+		// Those of archived locations wait for them
+		if rs.graph.Node(c.from.ID()) != nil && rs.graph.Node(c.to.ID()) != nil {
+			ret[Pair{From: string(c.from), To: string(c.to)}] = c.weight
+		}
+	}
+	return ret
+}
+
+// Must be called with the lock held; what reloading would change, from this store to fresh
+func (rs *RouteStore) reloadReport(fresh *RouteStore) ResyncReport {
+	report := ResyncReport{
+		LocationsAdded:   []string{},
+		LocationsRemoved: []string{},
+		EdgesSet:         []string{},
+		EdgesRemoved:     []string{},
+		Reloaded:         []string{},
+	}
+
+	nodes := fresh.graph.Nodes()
+	for nodes.Next() {
+		if rs.graph.Node(nodes.Node().ID()) == nil {
+			report.LocationsAdded = append(report.LocationsAdded, nodeName(nodes.Node()))
+		}
+	}
+	nodes = rs.graph.Nodes()
+	for nodes.Next() {
+		if fresh.graph.Node(nodes.Node().ID()) == nil {
+			report.LocationsRemoved = append(report.LocationsRemoved, nodeName(nodes.Node()))
+		}
+	}
+
+	old, edges := rs.edgeWeights(), fresh.edgeWeights()
+	for pair, weight := range edges {
+		if w, ok := old[pair]; !ok || w != weight {
+			report.EdgesSet = append(report.EdgesSet, pair.String())
+		}
+	}
+	for pair := range old {
+		if _, ok := edges[pair]; !ok {
+			report.EdgesRemoved = append(report.EdgesRemoved, pair.String())
+		}
+	}
+
+	for key, r := range reloadable {
+		if !reflect.DeepEqual(r.field(rs), r.field(fresh)) {
+			report.Reloaded = append(report.Reloaded, key)
+		}
+	}
+	if !reflect.DeepEqual(closedAt(rs.closed), closedAt(fresh.closed)) {
+		report.Reloaded = append(report.Reloaded, closed_edges_hash)
+	}
+
+	for _, list := range [][]string{report.LocationsAdded, report.LocationsRemoved, report.EdgesSet, report.EdgesRemoved, report.Reloaded} {
+		sort.Strings(list)
+	}
+	return report
+}
+
+// When each closed edge was closed, without the weights edgeWeights compares
+func closedAt(closed map[[2]int64]*closedEdge) map[[2]int64]int64 {
+	ret := make(map[[2]int64]int64, len(closed))
+	for key, c := range closed {
+		ret[key] = c.at.Unix()
+	}
+	return ret
+}
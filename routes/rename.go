@@ -0,0 +1,318 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// validateName rejects names that could not be used in a URL path segment
+func validateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("location names cannot be empty")
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("location name %q cannot contain '/'", name)
+	}
+	return nil
+}
+
+// Must be called with the lock held; validateName for each name a change would add to the graph. Restore takes the
+// names in Redis as they are, so that one written before they were checked cannot stop the store from loading.
+func (rs *RouteStore) validateNewNames(names ...string) error {
+	if rs.loading {
+		return nil
+	}
+	for _, name := range names {
+		if err := validateName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// POST /maps/rename-batch/ (with JSON map[old]new) : UPDATE rename many locations at once, rewriting every edge.
+// Either every rename happens or none do. Names may be swapped or chained; edge history stays under the old names.
+func (rs *RouteStore) RenameLocations(mapping map[string]string) error {
+	defer rs.lock("RenameLocations")()
+
+	targets := make(map[string]string)
+	for old, renamed := range mapping {
+		if err := validateName(renamed); err != nil {
+			return err
+		}
+		if err := rs.checkNotArchived(renamed); err != nil {
+			return err
+		}
+		if rs.graph.Node(Location(old).ID()) == nil {
+			return fmt.Errorf("%s does not exist", old)
+		}
+		if other, ok := targets[renamed]; ok {
+			return fmt.Errorf("%s and %s cannot both be renamed to %s", other, old, renamed)
+		}
+		targets[renamed] = old
+		if _, moving := mapping[renamed]; !moving && rs.graph.Node(Location(renamed).ID()) != nil {
+			return fmt.Errorf("%s already exists", renamed)
+		}
+	}
+	rename := func(name string) string {
+		if renamed, ok := mapping[name]; ok {
+			return renamed
+		}
+		return name
+	}
+
+	// Every location with an edge that mentions a renamed location needs its hash rewritten
+	affected := make(map[string]map[string]float64)
+	for old := range mapping {
+		affected[old] = nil
+		from := rs.graph.To(Location(old).ID())
+		for from.Next() {
+			affected[nodeName(from.Node())] = nil
+		}
+	}
+	for _, c := range rs.closed {
+		if _, ok := mapping[string(c.to)]; ok {
+			affected[string(c.from)] = nil
+		}
+	}
+	for name := range affected {
+		edges := make(map[string]float64)
+		to := rs.graph.From(Location(name).ID())
+		for to.Next() {
+			w, _ := rs.graph.Weight(Location(name).ID(), to.Node().ID())
+			edges[rename(nodeName(to.Node()))] = w
+		}
+		for _, c := range rs.closed {
+			if string(c.from) == name {
+				edges[rename(string(c.to))] = c.weight
+			}
+		}
+		affected[name] = edges
+	}
+
+	if _, err := rs.redis.Do("MULTI"); err != nil {
+		return err
+	}
+	if err := rs.queueRenames(mapping, affected, rename); err != nil {
+		rs.redis.Do("DISCARD")
+		return err
+	}
+	if _, err := rs.redis.Do("EXEC"); err != nil {
+		return err
+	}
+
+	rs.changed()
+	tags := make(map[[2]int64][]string)
+	for key, edgeTags := range rs.tags {
+		from, to := rename(nodeName(rs.graph.Node(key[0]))), rename(nodeName(rs.graph.Node(key[1])))
+		tags[edgeKey(Location(from).ID(), Location(to).ID())] = edgeTags
+	}
+	rs.tags = tags
+	attributes := make(map[[2]int64]map[string]float64)
+	for key, edgeAttributes := range rs.attributes {
+		from, to := rename(nodeName(rs.graph.Node(key[0]))), rename(nodeName(rs.graph.Node(key[1])))
+		attributes[edgeKey(Location(from).ID(), Location(to).ID())] = edgeAttributes
+	}
+	rs.attributes = attributes
+	modes := make(map[[2]int64]map[string]float64)
+	for key, edgeModes := range rs.modes {
+		from, to := rename(nodeName(rs.graph.Node(key[0]))), rename(nodeName(rs.graph.Node(key[1])))
+		modes[edgeKey(Location(from).ID(), Location(to).ID())] = edgeModes
+	}
+	rs.modes = modes
+	twoWay := make(map[[2]int64]bool)
+	for key := range rs.twoWay {
+		a, b := rename(nodeName(rs.graph.Node(key[0]))), rename(nodeName(rs.graph.Node(key[1])))
+		twoWay[twoWayKey(Location(a), Location(b))] = true
+	}
+	rs.twoWay = twoWay
+	closed := make(map[[2]int64]*closedEdge)
+	for _, c := range rs.closed {
+		c.from, c.to = Location(rename(string(c.from))), Location(rename(string(c.to)))
+		closed[edgeKey(c.from.ID(), c.to.ID())] = c
+	}
+	rs.closed = closed
+
+	renamed := newAdjacencyGraph()
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		renamed.AddNode(Location(rename(nodeName(nodes.Node()))))
+	}
+	edges := rs.graph.WeightedEdges()
+	for edges.Next() {
+		edge := edges.WeightedEdge()
+		from, to := Location(rename(nodeName(edge.From()))), Location(rename(nodeName(edge.To())))
+		renamed.SetWeightedEdge(renamed.NewWeightedEdge(from, to, edge.Weight()))
+	}
+	rs.graph = renamed
+
+	coordinates := make(map[int64]Coordinates)
+	for old, renamed := range mapping {
+		if c, ok := rs.coordinates[Location(old).ID()]; ok {
+			coordinates[Location(renamed).ID()] = c
+			delete(rs.coordinates, Location(old).ID())
+		}
+	}
+	for id, c := range coordinates {
+		rs.coordinates[id] = c
+	}
+
+	locationRegions := make(map[int64]string)
+	for old, renamed := range mapping {
+		if region, ok := rs.locationRegions[Location(old).ID()]; ok {
+			locationRegions[Location(renamed).ID()] = region
+			delete(rs.locationRegions, Location(old).ID())
+		}
+	}
+	for id, region := range locationRegions {
+		rs.locationRegions[id] = region
+	}
+
+	locationTags := make(map[int64][]string)
+	for old, renamed := range mapping {
+		if tags, ok := rs.locationTags[Location(old).ID()]; ok {
+			locationTags[Location(renamed).ID()] = tags
+			delete(rs.locationTags, Location(old).ID())
+		}
+	}
+	for id, tags := range locationTags {
+		rs.locationTags[id] = tags
+	}
+
+	visitCosts := make(map[int64]float64)
+	for old, renamed := range mapping {
+		if cost, ok := rs.visitCosts[Location(old).ID()]; ok {
+			visitCosts[Location(renamed).ID()] = cost
+			delete(rs.writableVisitCosts(), Location(old).ID())
+		}
+	}
+	for id, cost := range visitCosts {
+		rs.writableVisitCosts()[id] = cost
+	}
+
+	hot := make(map[string]*shortestPathTree)
+	for name := range rs.hot {
+		hot[rename(name)] = rs.newHotTree(Location(rename(name)).ID())
+	}
+	rs.hot = hot
+
+	watched := make(map[Pair]*watch)
+	for pair, w := range rs.watched {
+		watched[Pair{From: rename(pair.From), To: rename(pair.To)}] = w
+	}
+	rs.watched = watched
+
+	critical := make(map[Pair]*critical)
+	for pair, c := range rs.critical {
+		critical[Pair{From: rename(pair.From), To: rename(pair.To)}] = c
+	}
+	rs.critical = critical
+
+	return nil
+}
+
+// Must be called with the lock held, inside MULTI. Everything under an old name is removed
+// before anything is written under a new one, so swaps work.
+func (rs *RouteStore) queueRenames(mapping map[string]string, affected map[string]map[string]float64, rename func(string) string) error {
+	do := func(commands [][]interface{}) error {
+		for _, command := range commands {
+			if _, err := rs.redis.Do(command[0].(string), command[1:]...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var removals, additions [][]interface{}
+	for name, edges := range affected {
+		removals = append(removals, []interface{}{"DEL", name})
+		for to, weight := range edges {
+			additions = append(additions, []interface{}{"HSET", rename(name), to, weight})
+		}
+	}
+	for old, renamed := range mapping {
+		removals = append(removals, []interface{}{"SREM", locations_set, old})
+		additions = append(additions, []interface{}{"SADD", locations_set, renamed})
+		if c, ok := rs.coordinates[Location(old).ID()]; ok {
+			removals = append(removals, []interface{}{"HDEL", coordinates_hash, old})
+			additions = append(additions, []interface{}{"HSET", coordinates_hash, renamed, c.String()})
+		}
+		if region, ok := rs.locationRegions[Location(old).ID()]; ok {
+			removals = append(removals, []interface{}{"HDEL", location_regions_hash, old})
+			additions = append(additions, []interface{}{"HSET", location_regions_hash, renamed, region})
+		}
+		if tags, ok := rs.locationTags[Location(old).ID()]; ok {
+			removals = append(removals, []interface{}{"HDEL", location_tags_hash, old})
+			additions = append(additions, []interface{}{"HSET", location_tags_hash, renamed, strings.Join(tags, ",")})
+		}
+		if cost, ok := rs.visitCosts[Location(old).ID()]; ok {
+			removals = append(removals, []interface{}{"HDEL", visit_costs_hash, old})
+			additions = append(additions, []interface{}{"HSET", visit_costs_hash, renamed, cost})
+		}
+		if _, ok := rs.hot[old]; ok {
+			removals = append(removals, []interface{}{"SREM", hot_sources_set, old})
+			additions = append(additions, []interface{}{"SADD", hot_sources_set, renamed})
+		}
+	}
+	for key, tags := range rs.tags {
+		pair := Pair{From: nodeName(rs.graph.Node(key[0])), To: nodeName(rs.graph.Node(key[1]))}
+		if renamed := (Pair{From: rename(pair.From), To: rename(pair.To)}); renamed != pair {
+			removals = append(removals, []interface{}{"HDEL", edge_tags_hash, pair.String()})
+			additions = append(additions, []interface{}{"HSET", edge_tags_hash, renamed.String(), strings.Join(tags, ",")})
+		}
+	}
+	for key, attributes := range rs.attributes {
+		pair := Pair{From: nodeName(rs.graph.Node(key[0])), To: nodeName(rs.graph.Node(key[1]))}
+		if renamed := (Pair{From: rename(pair.From), To: rename(pair.To)}); renamed != pair {
+			js, err := json.Marshal(attributes)
+			if err != nil {
+				return err
+			}
+			removals = append(removals, []interface{}{"HDEL", edge_attributes_hash, pair.String()})
+			additions = append(additions, []interface{}{"HSET", edge_attributes_hash, renamed.String(), js})
+		}
+	}
+	for key, modes := range rs.modes {
+		pair := Pair{From: nodeName(rs.graph.Node(key[0])), To: nodeName(rs.graph.Node(key[1]))}
+		if renamed := (Pair{From: rename(pair.From), To: rename(pair.To)}); renamed != pair {
+			js, err := json.Marshal(modes)
+			if err != nil {
+				return err
+			}
+			removals = append(removals, []interface{}{"HDEL", edge_modes_hash, pair.String()})
+			additions = append(additions, []interface{}{"HSET", edge_modes_hash, renamed.String(), js})
+		}
+	}
+	for key := range rs.twoWay {
+		pair := Pair{From: nodeName(rs.graph.Node(key[0])), To: nodeName(rs.graph.Node(key[1]))}
+		if renamed := twoWayPair(rename(pair.From), rename(pair.To)); renamed != pair {
+			removals = append(removals, []interface{}{"SREM", two_way_set, pair.String()})
+			additions = append(additions, []interface{}{"SADD", two_way_set, renamed.String()})
+		}
+	}
+	for _, c := range rs.closed {
+		pair := Pair{From: string(c.from), To: string(c.to)}
+		if renamed := (Pair{From: rename(pair.From), To: rename(pair.To)}); renamed != pair {
+			removals = append(removals, []interface{}{"HDEL", closed_edges_hash, pair.String()})
+			additions = append(additions, []interface{}{"HSET", closed_edges_hash, renamed.String(), c.at.Unix()})
+		}
+	}
+	for pair := range rs.watched {
+		if renamed := (Pair{From: rename(pair.From), To: rename(pair.To)}); renamed != pair {
+			removals = append(removals, []interface{}{"SREM", watched_set, pair.String()})
+			additions = append(additions, []interface{}{"SADD", watched_set, renamed.String()})
+		}
+	}
+	for pair := range rs.critical {
+		if renamed := (Pair{From: rename(pair.From), To: rename(pair.To)}); renamed != pair {
+			removals = append(removals, []interface{}{"SREM", critical_set, pair.String()})
+			additions = append(additions, []interface{}{"SADD", critical_set, renamed.String()})
+		}
+	}
+
+	if err := do(removals); err != nil {
+		return err
+	}
+	return do(additions)
+}
@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"testing"
+)
+
+// A store with edges a -> c and c -> a, and whatever else is given, as Redis would hold it
+func legacyRedis(hash string, fields map[string]string) *memoryRedis {
+	conn := newMemoryRedis()
+	conn.set(locations_set)["a"] = true
+	conn.set(locations_set)["c"] = true
+	conn.hash("a")["c"] = "1"
+	conn.hash("c")["a"] = "1"
+	for field, value := range fields {
+		conn.hash(hash)[field] = value
+	}
+	return conn
+}
+
+// Tags under a key written for a location named with a '/' are skipped rather than stopping the store from loading
+func TestRestoreSkipsUnparsableTags(t *testing.T) {
+	rs, err := Restore(legacyRedis(edge_tags_hash, map[string]string{"a/c": "toll", "a/b/c": "toll"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := rs.tags[edgeKey(Location("a").ID(), Location("c").ID())]; len(tags) != 1 || tags[0] != "toll" {
+		t.Fatalf("the tags that parse should still be restored, got %v", tags)
+	}
+}
+
+// As tags, attributes under a key that cannot be parsed are skipped
+func TestRestoreSkipsUnparsableAttributes(t *testing.T) {
+	rs, err := Restore(legacyRedis(edge_attributes_hash, map[string]string{"a/c": `{"toll":2}`, "a/b/c": `{"toll":2}`}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attributes := rs.attributes[edgeKey(Location("a").ID(), Location("c").ID())]; attributes["toll"] != 2 {
+		t.Fatalf("the attributes that parse should still be restored, got %v", attributes)
+	}
+}
+
+// As tags, closures under a key that cannot be parsed are skipped
+func TestRestoreSkipsUnparsableClosures(t *testing.T) {
+	rs, err := Restore(legacyRedis(closed_edges_hash, map[string]string{"a/c": "1600000000", "a/b/c": "1600000000"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.isClosed("a", "c") {
+		t.Fatal("the closure that parses should still be restored")
+	}
+}
+
+// As tags, modes under a key that cannot be parsed are skipped
+func TestRestoreSkipsUnparsableModes(t *testing.T) {
+	rs, err := Restore(legacyRedis(edge_modes_hash, map[string]string{"a/c": `{"bus":3}`, "a/b/c": `{"bus":3}`}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if modes := rs.modes[edgeKey(Location("a").ID(), Location("c").ID())]; modes["bus"] != 3 {
+		t.Fatalf("the modes that parse should still be restored, got %v", modes)
+	}
+}
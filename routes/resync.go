@@ -0,0 +1,418 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What reconciling the store with Redis found had changed there
+type ResyncReport struct {
+	LocationsAdded   []string `json:"locations_added"`
+	LocationsRemoved []string `json:"locations_removed"`
+	// Edges added or reweighted, and removed, as <from>/<to>
+	EdgesSet     []string `json:"edges_set"`
+	EdgesRemoved []string `json:"edges_removed"`
+	// The other keys, such as rest_project:edge_tags, whose contents had changed
+	Reloaded []string `json:"reloaded"`
+}
+
+func (r *ResyncReport) Changed() bool {
+	return len(r.LocationsAdded)+len(r.LocationsRemoved)+len(r.EdgesSet)+len(r.EdgesRemoved)+len(r.Reloaded) > 0
+}
+
+func (r *ResyncReport) String() string {
+	return fmt.Sprintf("%d locations added, %d removed, %d edges set, %d removed, %v reloaded",
+		len(r.LocationsAdded), len(r.LocationsRemoved), len(r.EdgesSet), len(r.EdgesRemoved), r.Reloaded)
+}
+
+// Keys read whole into a map by Restore, each with the map to empty before reading it again
+var reloadable = map[string]struct {
+	field   func(rs *RouteStore) interface{}
+	reset   func(rs *RouteStore)
+	restore func(rs *RouteStore) error
+}{
+	edge_tags_hash: {
+		func(rs *RouteStore) interface{} { return rs.tags },
+		func(rs *RouteStore) { rs.tags = make(map[[2]int64][]string) },
+		(*RouteStore).restoreTags,
+	},
+	location_tags_hash: {
+		func(rs *RouteStore) interface{} { return rs.locationTags },
+		func(rs *RouteStore) { rs.locationTags = make(map[int64][]string) },
+		(*RouteStore).restoreLocationTags,
+	},
+	visit_costs_hash: {
+		func(rs *RouteStore) interface{} { return rs.visitCosts },
+		func(rs *RouteStore) { rs.visitCosts = make(map[int64]float64) },
+		(*RouteStore).restoreVisitCosts,
+	},
+	edge_attributes_hash: {
+		func(rs *RouteStore) interface{} { return rs.attributes },
+		func(rs *RouteStore) { rs.attributes = make(map[[2]int64]map[string]float64) },
+		(*RouteStore).restoreAttributes,
+	},
+	edge_modes_hash: {
+		func(rs *RouteStore) interface{} { return rs.modes },
+		func(rs *RouteStore) { rs.modes = make(map[[2]int64]map[string]float64) },
+		(*RouteStore).restoreModes,
+	},
+	cost_functions_hash: {
+		func(rs *RouteStore) interface{} { return rs.costs },
+		func(rs *RouteStore) { rs.costs = make(map[string]*CostFunction) },
+		(*RouteStore).restoreCostFunctions,
+	},
+	coordinates_hash: {
+		func(rs *RouteStore) interface{} { return rs.coordinates },
+		func(rs *RouteStore) { rs.coordinates = make(map[int64]Coordinates) },
+		(*RouteStore).restoreCoordinates,
+	},
+	two_way_set: {
+		func(rs *RouteStore) interface{} { return rs.twoWay },
+		func(rs *RouteStore) { rs.twoWay = make(map[[2]int64]bool) },
+		(*RouteStore).restoreTwoWay,
+	},
+	timezone_key: {
+		func(rs *RouteStore) interface{} { return rs.timezone.String() },
+		func(rs *RouteStore) { rs.timezone = time.UTC },
+		(*RouteStore).restoreTimezone,
+	},
+	symmetric_key: {
+		func(rs *RouteStore) interface{} { return rs.symmetric },
+		func(rs *RouteStore) { rs.symmetric = false },
+		(*RouteStore).restoreSymmetric,
+	},
+	default_weight_key: {
+		func(rs *RouteStore) interface{} { return rs.defaultWeight },
+		func(rs *RouteStore) { rs.defaultWeight = nil },
+		(*RouteStore).restoreDefaultWeight,
+	},
+	// restoreRegions reads both region hashes
+	regions_hash: {
+		func(rs *RouteStore) interface{} { return [2]interface{}{rs.regions, rs.locationRegions} },
+		func(rs *RouteStore) { rs.regions, rs.locationRegions = make(map[string]string), make(map[int64]string) },
+		(*RouteStore).restoreRegions,
+	},
+	location_regions_hash: {
+		func(rs *RouteStore) interface{} { return [2]interface{}{rs.regions, rs.locationRegions} },
+		func(rs *RouteStore) { rs.regions, rs.locationRegions = make(map[string]string), make(map[int64]string) },
+		(*RouteStore).restoreRegions,
+	},
+}
+
+// Must be called with the lock held; adds and removes locations to match locations_set
+func (rs *RouteStore) resyncLocations(report *ResyncReport) error {
+	locations, err := redis.Strings(rs.redis.Do("SMEMBERS", locations_set))
+	if err != nil {
+		return err
+	}
+	inRedis := make(map[int64]bool, len(locations))
+	var added []string
+	for _, name := range locations {
+		loc := Location(name)
+		inRedis[loc.ID()] = true
+		if rs.graph.Node(loc.ID()) == nil {
+			rs.graph.AddNode(loc)
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	report.LocationsAdded = append(report.LocationsAdded, added...)
+	// Their edges may have been written before they were added to the set
+	for _, name := range added {
+		if err := rs.resyncEdges(name, report); err != nil {
+			return err
+		}
+	}
+
+	var gone []int64
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		if !inRedis[nodes.Node().ID()] {
+			gone = append(gone, nodes.Node().ID())
+		}
+	}
+	for _, id := range gone {
+		name := nodeName(rs.graph.Node(id))
+		if err := rs.removeCoordinates(name); err != nil {
+			return err
+		}
+		if err := rs.removeFromRegion(name); err != nil {
+			return err
+		}
+		if err := rs.removeLocationTags(name); err != nil {
+			return err
+		}
+		if err := rs.removeVisitCost(name); err != nil {
+			return err
+		}
+		if err := rs.removeNode(id); err != nil {
+			return err
+		}
+		report.LocationsRemoved = append(report.LocationsRemoved, name)
+	}
+	return nil
+}
+
+// Must be called with the lock held; sets and removes the edges from the location name to match its hash
+func (rs *RouteStore) resyncEdges(name string, report *ResyncReport) error {
+	from := Location(name)
+	stringMap, err := redis.StringMap(rs.redis.Do("HGETALL", name))
+	if err != nil {
+		return err
+	}
+
+	edges := make(map[int64]float64, len(stringMap))
+	for toStr, s := range stringMap {
+		to := Location(toStr)
+		if rs.archived[toStr] {
+			continue
+		}
+		weight, err := strconv.ParseFloat(s, 64)
+		if err != nil || to == from || rs.graph.Node(to.ID()) == nil {
+			log.Printf("Ignoring the edge from %s to %s in Redis, which has weight %q\n", from, to, s)
+			continue
+		}
+		edges[to.ID()] = weight
+		if old, ok := rs.edgeWeight(from, to); ok && old == weight {
+			continue
+		}
+		if err := rs.setEdge(from, to, weight); err != nil {
+			return err
+		}
+		report.EdgesSet = append(report.EdgesSet, Pair{From: name, To: toStr}.String())
+	}
+
+	var gone []Location
+	to := rs.graph.From(from.ID())
+	for to.Next() {
+		if _, ok := edges[to.Node().ID()]; !ok {
+			gone = append(gone, Location(nodeName(to.Node())))
+		}
+	}
+	for key, c := range rs.closed {
+		if _, ok := edges[key[1]]; key[0] == from.ID() && !ok && rs.graph.Node(key[1]) != nil {
+			gone = append(gone, c.to)
+		}
+	}
+	for _, to := range gone {
+		if err := rs.removeEdge(from, to); err != nil {
+			return err
+		}
+		report.EdgesRemoved = append(report.EdgesRemoved, Pair{From: name, To: string(to)}.String())
+	}
+	return nil
+}
+
+// Must be called with the lock held; reads a key in reloadable again
+func (rs *RouteStore) reload(key string, report *ResyncReport) error {
+	r := reloadable[key]
+	old := r.field(rs)
+	r.reset(rs)
+	if err := r.restore(rs); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(old, r.field(rs)) {
+		report.Reloaded = append(report.Reloaded, key)
+	}
+	return nil
+}
+
+// Resync reconciles the store with the given Redis keys, for when they may have been changed by
+// something else, such as a script. The locations set is reconciled first, then the edges of any
+// location named, then the other keys Restore reads whole. Keys the store does not keep, or that
+// only it uses, such as the trash, are ignored.
+func (rs *RouteStore) Resync(keys []string) (ResyncReport, error) {
+	defer rs.lock("Resync")()
+
+	report := ResyncReport{
+		LocationsAdded:   []string{},
+		LocationsRemoved: []string{},
+		EdgesSet:         []string{},
+		EdgesRemoved:     []string{},
+		Reloaded:         []string{},
+	}
+	// Reconcile every change, even if an error stops us part way
+	defer func() {
+		if report.Changed() {
+			rs.changed()
+		}
+	}()
+
+	for _, key := range keys {
+		if key == locations_set {
+			if err := rs.resyncLocations(&report); err != nil {
+				return report, err
+			}
+		}
+	}
+	reloaded := make(map[string]bool)
+	for _, key := range keys {
+		if _, ok := reloadable[key]; ok {
+			if !reloaded[key] {
+				if err := rs.reload(key, &report); err != nil {
+					return report, err
+				}
+				reloaded[key] = true
+			}
+		} else if rs.graph.Node(Location(key).ID()) != nil {
+			if err := rs.resyncEdges(key, &report); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, nil
+}
+
+// The most recent full resync
+type ResyncRun struct {
+	At     time.Time    `json:"at"`
+	Report ResyncReport `json:"report"`
+	Error  string       `json:"error,omitempty"`
+}
+
+// POST /admin/resync/ : UPDATE reconcile the whole store with Redis now, fixing and reporting any drift.
+// A safety net for changes no notification told us of; Resync is run on every location and every key Restore reads.
+func (rs *RouteStore) ResyncAll() (ResyncReport, error) {
+	unlock := rs.rlock("ResyncAll")
+	keys := []string{locations_set}
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		keys = append(keys, nodeName(nodes.Node()))
+	}
+	unlock()
+	for key := range reloadable {
+		keys = append(keys, key)
+	}
+
+	report, err := rs.Resync(keys)
+	run := &ResyncRun{At: time.Now(), Report: report}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	defer rs.lock("ResyncAll")()
+	rs.lastResync = run
+	return report, err
+}
+
+// GET  /admin/resync/ : READ when the last full resync ran and the drift it found, or null if none has
+func (rs *RouteStore) LastResync() *ResyncRun {
+	defer rs.rlock("LastResync")()
+
+	return rs.lastResync
+}
+
+// Reconcile loads everything Restore would into a store made with New, for one that started serving empty because
+// Redis could not be reached, once it can be. The keys only Restore reads, such as the archive, the trash and the
+// watched routes, are read around a ResyncAll, which also undoes anything a write that failed for want of Redis
+// left in memory.
+func (rs *RouteStore) Reconcile() (ResyncReport, error) {
+	unlock := rs.lock("Reconcile")
+	// Edges to archived locations are skipped, so the archive comes first
+	for _, restore := range []func() error{rs.restoreArchived, rs.restoreTrash} {
+		if err := restore(); err != nil {
+			unlock()
+			return ResyncReport{}, err
+		}
+	}
+	unlock()
+
+	report, err := rs.ResyncAll()
+	if err != nil {
+		return report, err
+	}
+
+	// These follow routes through the graph, so come once it is there
+	defer rs.lock("Reconcile")()
+	for _, restore := range []func() error{rs.restoreHotSources, rs.restoreWatched, rs.restoreCritical} {
+		if err := restore(); err != nil {
+			return report, err
+		}
+	}
+	rs.changed()
+	return report, nil
+}
+
+// FollowRedis subscribes conn, which must be a connection of its own, to keyspace notifications for
+// the database db, and calls Resync with the keys changed in each interval of debounce. Changes the
+// store makes itself are notified too; Resync finds nothing to do for those. Redis only sends
+// notifications if notify-keyspace-events includes K and the events for hashes, sets and DEL, such
+// as KA, so FollowRedis turns them on if it can. It returns when the subscription fails.
+func (rs *RouteStore) FollowRedis(conn redis.Conn, db int, debounce time.Duration) error {
+	if err := rs.enableKeyspaceEvents(); err != nil {
+		log.Printf("Could not enable keyspace notifications, they must be enabled in Redis: %s\n", err.Error())
+	}
+
+	prefix := fmt.Sprintf("__keyspace@%d__:", db)
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.PSubscribe(prefix + "*"); err != nil {
+		return err
+	}
+
+	keys := make(chan string, 1024)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			switch msg := psc.Receive().(type) {
+			case redis.Message:
+				keys <- strings.TrimPrefix(msg.Channel, prefix)
+			case error:
+				failed <- msg
+				close(keys)
+				return
+			}
+		}
+	}()
+
+	for key := range keys {
+		pending := map[string]bool{key: true}
+		timer := time.After(debounce)
+	collect:
+		for {
+			select {
+			case key, ok := <-keys:
+				if !ok {
+					break collect
+				}
+				pending[key] = true
+			case <-timer:
+				break collect
+			}
+		}
+
+		var changed []string
+		for key := range pending {
+			changed = append(changed, key)
+		}
+		report, err := rs.Resync(changed)
+		if err != nil {
+			log.Printf("Resyncing %d keys changed in Redis failed: %s\n", len(changed), err.Error())
+		} else if report.Changed() {
+			log.Printf("Resynced changes made directly in Redis: %s\n", report.String())
+		}
+	}
+	return <-failed
+}
+
+// enableKeyspaceEvents adds KA to notify-keyspace-events unless Redis already sends every notification
+// FollowRedis needs
+func (rs *RouteStore) enableKeyspaceEvents() error {
+	defer rs.lock("enableKeyspaceEvents")()
+
+	config, err := redis.StringMap(rs.redis.Do("CONFIG", "GET", "notify-keyspace-events"))
+	if err != nil {
+		return err
+	}
+	flags := config["notify-keyspace-events"]
+	if strings.Contains(flags, "K") && (strings.Contains(flags, "A") || strings.Contains(flags, "g") && strings.Contains(flags, "h") && strings.Contains(flags, "s")) {
+		return nil
+	}
+	_, err = rs.redis.Do("CONFIG", "SET", "notify-keyspace-events", flags+"KA")
+	return err
+}
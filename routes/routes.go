@@ -3,12 +3,13 @@ package routes
 import (
 	"fmt"
 	"github.com/gomodule/redigo/redis"
+	"gonum.org/v1/gonum/graph"
 	"gonum.org/v1/gonum/graph/path"
-	"gonum.org/v1/gonum/graph/simple"
 	"hash/fnv"
-	"math"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 )
 
 const locations_set = "rest_project:locations"
@@ -22,48 +23,243 @@ func (self Location) ID() int64 {
 	return int64(hasher.Sum64())
 }
 
-type RouteStore struct {
-	sync.Mutex
+// The name of a node, or its ID if it was not created from a Location
+func nodeName(node graph.Node) string {
+	if loc, ok := node.(Location); ok {
+		return string(loc)
+	}
+	return strconv.FormatInt(node.ID(), 10)
+}
 
-	graph *simple.WeightedDirectedGraph
+type RouteStore struct {
+	// Held for reading only by methods that neither change the store nor use the Redis connection. The route
+	// cache and query counts guard themselves, so queries that fill them need it only for reading.
+	sync.RWMutex
+	// Held by lock, and for reading by writers holding location locks, see lockLocations
+	writers sync.RWMutex
+	stripes [locationStripes]sync.Mutex
+
+	graph *adjacencyGraph
 	redis redis.Conn
+	// Connections for writers holding location locks, if any, see SetRedisPool
+	pool *redis.Pool
+
+	trash          map[string]*TrashEntry
+	trashRetention time.Duration
+
+	cache *routeCache
+	hot   map[string]*shortestPathTree
+
+	defaultAlgorithm Algorithm
+	negativeEdges    int
+	// Whether weights must be whole numbers, see SetIntegerWeights
+	integerWeights bool
+	// Whether edges added without a weight weigh the distance between their ends, see SetDistanceWeights
+	distanceWeights bool
+	// Whether edges added without bidirectional go both ways, see SetSymmetric
+	symmetric bool
+	// The weight of edges added without one, if any, see SetDefaultWeight
+	defaultWeight *float64
+	// Decimal places weights are rounded to, see SetWeightPrecision
+	precision int
+	// Tags of each tagged edge, by the IDs of its ends, sorted
+	tags map[[2]int64][]string
+	// Tags of each tagged location, by its ID, sorted
+	locationTags map[int64][]string
+	// The cost of passing through each location that has one, by its ID
+	visitCosts map[int64]float64
+	// Numeric attributes of each edge that has any, by the IDs of its ends
+	attributes map[[2]int64]map[string]float64
+	// The weight of each mode of each edge that has any, by the IDs of its ends
+	modes map[[2]int64]map[string]float64
+	// Named cost functions queries can choose instead of edge weights
+	costs map[string]*CostFunction
+	// The map's time zone, which arrival times are given in
+	timezone *time.Location
+	// The region containing each region, "" at the top, and the region of each location in one
+	regions         map[string]string
+	locationRegions map[int64]string
+	// Pairs of locations whose edges were added as two-way, by twoWayKey
+	twoWay map[[2]int64]bool
+	// Locations taken out of the graph with everything Redis has on them kept, see ArchiveLocation
+	archived map[string]bool
+	// Edges taken out of the graph while closed, by the IDs of their ends, see CloseEdge
+	closed map[[2]int64]*closedEdge
+
+	coordinates map[int64]Coordinates
+	// Empty to choose per query, see targetOptions
+	defaultHeuristic string
+	heuristicScale   float64
+
+	// Bumped by every change to the graph
+	revision      uint64
+	landmarks     *LandmarkTable
+	landmarkCount int
+
+	watched     map[Pair]*watch
+	watchSignal chan struct{}
+	critical    map[Pair]*critical
+
+	historyLength int
+	// Set while Restore replays Redis into the graph, when nothing new is happening
+	loading bool
+
+	locks lockTracker
+	// Queries not yet added to Redis, see MonitorQueryCounts
+	queries queryCounts
+	// Those given by Snapshot and kept for SnapshotAt
+	snapshots snapshotHistory
+	// Set when a snapshot shares visitCosts, which must then be copied before they change
+	visitCostsShared bool
+	// The most recent ResyncAll, if any
+	lastResync *ResyncRun
+	// The most recent Centrality, reused until the graph changes
+	centrality *Centrality
+	// The most recent Layout for each algorithm, reused until the graph changes
+	layouts map[string]*Layout
+	// The most recent Stats, reused until the graph changes
+	stats *GraphStats
 }
 
 type Route struct {
 	Route  []string `json:"route"`
 	Weight float64  `json:"weight"`
+	// Identifies this route to ValidateRoute
+	Token string `json:"token"`
+	// When each edge is done, for queries given a departure time
+	Arrivals []LegArrival `json:"arrivals,omitempty"`
+	// The mode each edge is taken by, for queries given modes
+	Modes []string `json:"modes,omitempty"`
 }
 
 func New(conn redis.Conn) *RouteStore {
 	var ret RouteStore
-	ret.graph = simple.NewWeightedDirectedGraph(0.0, math.Inf(1))
+	ret.graph = newAdjacencyGraph()
 	ret.redis = conn
+	ret.trash = make(map[string]*TrashEntry)
+	ret.trashRetention = DefaultTrashRetention
+	ret.cache = newRouteCache(DefaultCacheBytes)
+	ret.hot = make(map[string]*shortestPathTree)
+	ret.defaultAlgorithm = Dijkstra
+	ret.precision = DefaultWeightPrecision
+	ret.tags = make(map[[2]int64][]string)
+	ret.locationTags = make(map[int64][]string)
+	ret.visitCosts = make(map[int64]float64)
+	ret.attributes = make(map[[2]int64]map[string]float64)
+	ret.modes = make(map[[2]int64]map[string]float64)
+	ret.costs = make(map[string]*CostFunction)
+	ret.timezone = time.UTC
+	ret.regions = make(map[string]string)
+	ret.locationRegions = make(map[int64]string)
+	ret.twoWay = make(map[[2]int64]bool)
+	ret.archived = make(map[string]bool)
+	ret.closed = make(map[[2]int64]*closedEdge)
+	ret.coordinates = make(map[int64]Coordinates)
+	ret.heuristicScale = 1
+	ret.watched = make(map[Pair]*watch)
+	ret.watchSignal = make(chan struct{}, 1)
+	ret.critical = make(map[Pair]*critical)
+	ret.layouts = make(map[string]*Layout)
+	ret.historyLength = DefaultHistoryLength
+	ret.locks.stats = make(map[string]*LockStats)
+	ret.queries.pending = make(map[Pair]int64)
 	return &ret
 }
 
 func Restore(conn redis.Conn) (*RouteStore, error) {
 	ret := New(conn)
-	locations, err := redis.Strings(conn.Do("SMEMBERS", locations_set))
+	if err := ret.load(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// load reads everything in Redis into a store made with New
+func (rs *RouteStore) load() error {
+	rs.loading = true
+	defer func() { rs.loading = false }()
+	locations, err := redis.Strings(rs.redis.Do("SMEMBERS", locations_set))
 	if err != nil {
-		return ret, err
+		return err
 	}
 
+	// Archived locations are not in locations_set, but edges to them are still in the hashes of the others
+	if err := rs.restoreArchived(); err != nil {
+		return err
+	}
 	routes := make(map[string]map[string]float64)
 	for _, loc := range locations {
-		ret.AddLocation(loc, map[string]float64(nil))
-		routes[loc], err = getEdges(conn, loc)
+		rs.AddLocation(loc, nil, nil, new(bool))
+		routes[loc], err = getEdges(rs.redis, loc)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		for to := range routes[loc] {
+			if rs.archived[to] {
+				delete(routes[loc], to)
+			}
 		}
 	}
 
 	for from, connected := range routes {
-		if ret.AddRoutes(from, connected) != nil {
-			return nil, err
+		if err := rs.AddRoutes(from, givenWeights(connected), new(bool)); err != nil {
+			return err
 		}
 	}
 
-	return ret, nil
+	if err := rs.restoreClosures(); err != nil {
+		return err
+	}
+	if err := rs.restoreTrash(); err != nil {
+		return err
+	}
+	if err := rs.restoreCoordinates(); err != nil {
+		return err
+	}
+	if err := rs.restoreTags(); err != nil {
+		return err
+	}
+	if err := rs.restoreLocationTags(); err != nil {
+		return err
+	}
+	if err := rs.restoreVisitCosts(); err != nil {
+		return err
+	}
+	if err := rs.restoreAttributes(); err != nil {
+		return err
+	}
+	if err := rs.restoreModes(); err != nil {
+		return err
+	}
+	if err := rs.restoreCostFunctions(); err != nil {
+		return err
+	}
+	if err := rs.restoreTimezone(); err != nil {
+		return err
+	}
+	if err := rs.restoreSymmetric(); err != nil {
+		return err
+	}
+	if err := rs.restoreDefaultWeight(); err != nil {
+		return err
+	}
+	if err := rs.restoreRegions(); err != nil {
+		return err
+	}
+	if err := rs.restoreTwoWay(); err != nil {
+		return err
+	}
+	if err := rs.restoreHotSources(); err != nil {
+		return err
+	}
+	if err := rs.restoreWatched(); err != nil {
+		return err
+	}
+	if err := rs.restoreCritical(); err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func getEdges(conn redis.Conn, loc string) (map[string]float64, error) {
@@ -82,59 +278,224 @@ func getEdges(conn redis.Conn, loc string) (map[string]float64, error) {
 	return ret, nil
 }
 
-// POST /maps/ (with JSON name: string, routes_to: map[string]weight optional) : CREATE a location, optionally with routes
-func (rs *RouteStore) AddLocation(name string, routes map[string]float64) error {
-	rs.Lock()
-	defer rs.Unlock()
+func putEdges(conn redis.Conn, key string, edges map[string]float64) error {
+	for to, weight := range edges {
+		if _, err := conn.Do("HSET", key, to, weight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Graph edits go through these so that anything derived from the graph is kept in step.
+// They must be called with the lock held.
+
+func (rs *RouteStore) setEdge(from, to Location, weight float64) error {
+	// A closed edge stays out of the graph, with its new weight kept for when it opens
+	if c, ok := rs.closed[edgeKey(from.ID(), to.ID())]; ok {
+		old := c.weight
+		c.weight = weight
+		if old != weight {
+			return rs.recordEdge(string(from), string(to), &weight)
+		}
+		return nil
+	}
+
+	var old float64
+	edge := rs.graph.WeightedEdge(from.ID(), to.ID())
+	if edge != nil {
+		old = edge.Weight()
+		if old < 0 {
+			rs.negativeEdges--
+		}
+	}
+	if weight < 0 {
+		rs.negativeEdges++
+	}
+
+	rs.graph.SetWeightedEdge(rs.graph.NewWeightedEdge(from, to, weight))
+	rs.updateHot(func(tree *shortestPathTree) { tree.edgeSet(rs.graph, from.ID(), to.ID(), edge != nil, old, weight) })
+
+	if edge == nil || old != weight {
+		return rs.recordEdge(string(from), string(to), &weight)
+	}
+	return nil
+}
+
+func (rs *RouteStore) removeEdge(from, to Location) error {
+	if rs.isClosed(from, to) {
+		if err := rs.removeClosure(from, to); err != nil {
+			return err
+		}
+	} else {
+		edge := rs.graph.WeightedEdge(from.ID(), to.ID())
+		if edge == nil {
+			return nil
+		}
+		if edge.Weight() < 0 {
+			rs.negativeEdges--
+		}
+
+		rs.graph.RemoveEdge(from.ID(), to.ID())
+		rs.updateHot(func(tree *shortestPathTree) { tree.edgeRemoved(rs.graph, from.ID(), to.ID()) })
+	}
+	if err := rs.removeTags(from, to); err != nil {
+		return err
+	}
+	if err := rs.removeAttributes(from, to); err != nil {
+		return err
+	}
+	if err := rs.removeModes(from, to); err != nil {
+		return err
+	}
+	if err := rs.removeTwoWay(from, to); err != nil {
+		return err
+	}
+
+	return rs.recordEdge(string(from), string(to), nil)
+}
+
+func (rs *RouteStore) removeNode(id int64) error {
+	name := nodeName(rs.graph.Node(id))
+
+	// Closed edges are not in the graph, so go on their own
+	for key, c := range rs.closed {
+		if key[0] == id || key[1] == id {
+			if err := rs.removeEdge(c.from, c.to); err != nil {
+				return err
+			}
+		}
+	}
+
+	to := rs.graph.From(id)
+	for to.Next() {
+		if w, _ := rs.graph.Weight(id, to.Node().ID()); w < 0 {
+			rs.negativeEdges--
+		}
+		if err := rs.recordEdge(name, nodeName(to.Node()), nil); err != nil {
+			return err
+		}
+		if err := rs.removeTags(Location(name), Location(nodeName(to.Node()))); err != nil {
+			return err
+		}
+		if err := rs.removeAttributes(Location(name), Location(nodeName(to.Node()))); err != nil {
+			return err
+		}
+		if err := rs.removeModes(Location(name), Location(nodeName(to.Node()))); err != nil {
+			return err
+		}
+		if err := rs.removeTwoWay(Location(name), Location(nodeName(to.Node()))); err != nil {
+			return err
+		}
+	}
+	from := rs.graph.To(id)
+	for from.Next() {
+		if w, _ := rs.graph.Weight(from.Node().ID(), id); w < 0 {
+			rs.negativeEdges--
+		}
+		if err := rs.recordEdge(nodeName(from.Node()), name, nil); err != nil {
+			return err
+		}
+		if err := rs.removeTags(Location(nodeName(from.Node())), Location(name)); err != nil {
+			return err
+		}
+		if err := rs.removeAttributes(Location(nodeName(from.Node())), Location(name)); err != nil {
+			return err
+		}
+		if err := rs.removeModes(Location(nodeName(from.Node())), Location(name)); err != nil {
+			return err
+		}
+		if err := rs.removeTwoWay(Location(nodeName(from.Node())), Location(name)); err != nil {
+			return err
+		}
+	}
+
+	rs.graph.RemoveNode(id)
+	rs.updateHot(func(tree *shortestPathTree) { tree.nodeRemoved(rs.graph, id) })
+	return nil
+}
+
+// POST /maps/ (with JSON name: string, routes_to: map[string]weight|{weight, both}, bidirectional: bool, lat: float, lon: float optional) : CREATE
+// a location, optionally with routes and coordinates. With bidirectional, each route is also added back to <name> with the same weight, and
+// the two stay in step from then on; a nil bidirectional is the map's default, see SetSymmetric. A route's own both overrides either. A null
+// weight is the distance to the location routed to, in distance mode.
+func (rs *RouteStore) AddLocation(name string, at *Coordinates, routes map[string]RouteSpec, bidirectional *bool) error {
+	if at != nil {
+		if err := at.validate(); err != nil {
+			return err
+		}
+	}
+
+	defer rs.lock("AddLocation")()
 
 	loc := Location(name)
+	if err := rs.validateNewNames(name); err != nil {
+		return err
+	}
 	if rs.graph.Node(loc.ID()) != nil {
 		return fmt.Errorf("%s already exists", loc)
 	}
+	if err := rs.checkNotArchived(name); err != nil {
+		return err
+	}
+	for to := range routes {
+		if err := rs.validateNewNames(to); err != nil {
+			return err
+		}
+		if err := rs.checkNotArchived(to); err != nil {
+			return err
+		}
+	}
+	if at != nil {
+		// Only for resolveWeights until the location is added, so that a bad weight leaves nothing behind
+		rs.coordinates[loc.ID()] = *at
+	}
+	weights, err := rs.resolveWeights(name, routes)
+	if err == nil {
+		err = rs.checkWeights(name, weights)
+	}
+	if err != nil {
+		if at != nil {
+			delete(rs.coordinates, loc.ID())
+		}
+		return err
+	}
 
+	rs.changed()
 	rs.graph.AddNode(loc)
 	if _, err := rs.redis.Do("SADD", locations_set, name); err != nil {
 		return err
 	}
-
-	for to, weight := range routes {
-		if name != to {
-			rs.graph.SetWeightedEdge(rs.graph.NewWeightedEdge(loc, Location(to), weight))
-			if _, err := rs.redis.Do("HSET", name, to, weight); err != nil {
-				return err
-			}
+	if at != nil {
+		if _, err := rs.redis.Do("HSET", coordinates_hash, name, at.String()); err != nil {
+			return err
 		}
 	}
-	return nil
+
+	return rs.addEdges(name, weights, rs.twoWayRoutes(routes, bidirectional))
 }
 
-// GET  /maps/ : READ a list of all known locations
+// GET  /maps/ (?limit=&cursor=&total=true optional) : READ a list of all known locations, in name order
 func (rs *RouteStore) GetLocations() []string {
-	rs.Lock()
-	defer rs.Unlock()
+	defer rs.rlock("GetLocations")()
 
 	nodes := rs.graph.Nodes()
 	var ret []string
 
 	for nodes.Next() {
-		node := nodes.Node()
-		if loc, ok := node.(Location); ok {
-			ret = append(ret, string(loc))
-		} else {
-			ret = append(ret, strconv.FormatInt(node.ID(), 10))
-		}
+		ret = append(ret, nodeName(nodes.Node()))
 	}
+	sort.Strings(ret)
 
 	return ret
 }
 
-// GET  /maps/<location> : READ list of places <location> has direct connections to
+// GET  /maps/<location> (?limit=&cursor=&total=true optional) : READ list of places <location> has direct connections to, in name order
 func (rs *RouteStore) RoutesFrom(name string) ([]string, error) {
 	loc := Location(name)
 	var ret []string
 
-	rs.Lock()
-	defer rs.Unlock()
+	defer rs.rlock("RoutesFrom")()
 
 	if rs.graph.Node(loc.ID()) == nil {
 		return ret, fmt.Errorf("%s does not exist", loc)
@@ -143,87 +504,277 @@ func (rs *RouteStore) RoutesFrom(name string) ([]string, error) {
 	nodes := rs.graph.From(loc.ID())
 
 	for nodes.Next() {
-		node := nodes.Node()
-		if loc, ok := node.(Location); ok {
-			ret = append(ret, string(loc))
-		} else {
-			ret = append(ret, strconv.FormatInt(node.ID(), 10))
-		}
+		ret = append(ret, nodeName(nodes.Node()))
 	}
+	sort.Strings(ret)
+
+	return ret, nil
+}
+
+// GET  /maps/<location>/incoming (?limit=&cursor=&total=true optional) : READ list of places with direct connections to <location>, in name order
+func (rs *RouteStore) RoutesTo(name string) ([]string, error) {
+	loc := Location(name)
+	var ret []string
+
+	defer rs.rlock("RoutesTo")()
+
+	if rs.graph.Node(loc.ID()) == nil {
+		return ret, fmt.Errorf("%s does not exist", loc)
+	}
+
+	nodes := rs.graph.To(loc.ID())
+
+	for nodes.Next() {
+		ret = append(ret, nodeName(nodes.Node()))
+	}
+	sort.Strings(ret)
 
 	return ret, nil
 }
 
 // GET  /maps/<from>/<to> : READ list of shortest routes from <from> to <to>
-func (rs *RouteStore) RoutesBetween(fromStr, toStr string) ([]Route, error) {
-	rs.Lock()
-	defer rs.Unlock()
+// With opts.Disjoint, the routes share no edges or no locations, cheapest first, rather than all tying.
+// With opts.MaxWeight, routes heavier than it are left out, and ErrNoRouteWithinBudget given if that leaves none.
+// With opts.DepartAt, each route says when it reaches the end of each edge, in the map's time zone.
+// While there are negative weights, queries that do not choose an algorithm use Bellman-Ford; a negative
+// cycle reachable from <from> gives ErrNegativeCycle.
+// POST /maps/route/ (with JSON from: string, to: string, avoid: []string optional) : READ as GET /maps/<from>/<to>, around the avoided locations and edges
+func (rs *RouteStore) RoutesBetween(fromStr, toStr string, opts RouteOptions) ([]Route, error) {
+	defer rs.rlock("RoutesBetween")()
 
 	from, to := Location(fromStr), Location(toStr)
-	var ret []Route
 
 	if rs.graph.Node(from.ID()) == nil {
-		return ret, fmt.Errorf("%s does not exist", from)
+		return nil, fmt.Errorf("%s does not exist", from)
 	}
 	if rs.graph.Node(to.ID()) == nil {
-		return ret, fmt.Errorf("%s does not exist", to)
+		return nil, fmt.Errorf("%s does not exist", to)
 	}
 
-	paths, weight := path.DijkstraAllFrom(from, rs.graph).AllTo(to.ID())
+	opts, err := rs.resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	opts = rs.targetOptions(to, opts)
+	var departAt time.Time
+	if opts.DepartAt != "" {
+		if departAt, err = rs.parseDepartAt(opts.DepartAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, avoid := range opts.Avoid {
+		if avoid == fromStr || avoid == toStr {
+			return nil, fmt.Errorf("cannot avoid %s, where the route starts or ends", avoid)
+		}
+	}
+
+	rs.countQuery(fromStr, toStr)
+	routes, err := rs.cachedRoutesBetween(fromStr, toStr, opts)
+	if err == nil && opts.Disjoint != "" {
+		routes, err = rs.disjointRoutes(fromStr, toStr, routes, opts)
+	}
+	if err == nil && opts.MaxWeight != nil {
+		routes, err = withinBudget(routes, *opts.MaxWeight, tieTolerance(rs.precision))
+	}
+	if err == nil && opts.DepartAt != "" {
+		routes, err = rs.withArrivals(routes, departAt)
+	}
+	return routes, err
+}
+
+// withinBudget trims routes to those weighing no more than maxWeight, give or take tolerance, failing with
+// ErrNoRouteWithinBudget if none are left. routes may be cached, so is not changed.
+func withinBudget(routes []Route, maxWeight, tolerance float64) ([]Route, error) {
+	var ret []Route
+	for _, route := range routes {
+		if route.Weight <= maxWeight+tolerance {
+			ret = append(ret, route)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, ErrNoRouteWithinBudget
+	}
+	return ret, nil
+}
+
+// Must be called with the lock held, after checking both locations exist; always uses Dijkstra
+func (rs *RouteStore) routesBetween(from, to Location) []Route {
+	if tree, ok := rs.hot[string(from)]; ok && !tree.dropped {
+		return tree.routes(rs.graph, to.ID())
+	}
+	return shortestRoutes(rs.graph, from, to, rs.precision)
+}
+
+// shortestRoutes lists every shortest route by Dijkstra's algorithm, with totals that agree
+// to precision decimal places tying when precision is not negative
+func shortestRoutes(g graph.WeightedDirected, from, to Location, precision int) []Route {
+	var ret []Route
+
+	if precision >= 0 {
+		eachShortestPath(g, from.ID(), to.ID(), tieTolerance(precision), func(nodes []graph.Node, weight float64) error {
+			ret = append(ret, pathsToRoutes([][]graph.Node{nodes}, weight)...)
+			return nil
+		})
+		return ret
+	}
+
+	paths, weight := path.DijkstraAllFrom(from, g).AllTo(to.ID())
 	for _, path := range paths {
 		route := Route{Weight: weight}
 		for _, node := range path {
-			if loc, ok := node.(Location); ok {
-				route.Route = append(route.Route, string(loc))
-			} else {
-				route.Route = append(route.Route, strconv.FormatInt(node.ID(), 10))
-			}
+			route.Route = append(route.Route, nodeName(node))
 		}
 		ret = append(ret, route)
 	}
 
-	return ret, nil
+	return ret
 }
 
-// PUT  /maps/add/<location> (with JSON routes_to: map[string]weight) : UPDATE add the given connections to <location>
-func (rs *RouteStore) AddRoutes(name string, routes map[string]float64) error {
-	rs.Lock()
-	defer rs.Unlock()
+// PUT  /maps/add/<location> (with JSON to: map[string]weight|{weight, both}, bidirectional: bool optional) : UPDATE add the given connections to
+// <location>. With bidirectional, or a route's own both, or where the edges were added as two-way before, each connection back to <location>
+// gets the same weight; a nil bidirectional is the map's default, see SetSymmetric. A null weight is the distance between the two, in distance mode.
+func (rs *RouteStore) AddRoutes(name string, routes map[string]RouteSpec, bidirectional *bool) error {
+	names := []string{name}
+	for to := range routes {
+		names = append(names, to)
+	}
+	defer rs.lockLocations("AddRoutes", names...)()
+
+	rs.RLock()
+	weights, twoWay, err := rs.checkRoutes(name, routes, bidirectional)
+	rs.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	conn, release := rs.writeConn()
+	err = rs.writeEdges(conn, name, weights, twoWay)
+	release()
+	if err != nil {
+		return err
+	}
+
+	defer rs.apply()()
+	rs.changed()
+	return rs.setEdges(name, weights, twoWay)
+}
 
+// Must be called with the lock held, if only for reading; the weights of the edges AddRoutes adds, and which of them
+// go back again
+func (rs *RouteStore) checkRoutes(name string, routes map[string]RouteSpec, bidirectional *bool) (map[string]float64, map[string]bool, error) {
 	loc := Location(name)
 
 	if rs.graph.Node(loc.ID()) == nil {
-		return fmt.Errorf("%s does not exist", loc)
+		return nil, nil, fmt.Errorf("%s does not exist", loc)
 	}
-
-	for to, weight := range routes {
-		if name != to {
-			rs.graph.SetWeightedEdge(rs.graph.NewWeightedEdge(loc, Location(to), weight))
-			if _, err := rs.redis.Do("HSET", name, to, weight); err != nil {
-				return err
-			}
+	for to := range routes {
+		if err := rs.validateNewNames(to); err != nil {
+			return nil, nil, err
+		}
+		if err := rs.checkNotArchived(to); err != nil {
+			return nil, nil, err
 		}
 	}
-	return nil
+	weights, err := rs.resolveWeights(name, routes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := rs.checkWeights(name, weights); err != nil {
+		return nil, nil, err
+	}
+	return weights, rs.twoWayTargets(name, weights, rs.twoWayRoutes(routes, bidirectional)), nil
 }
 
-// PUT  /maps/delete/<location> (with JSON from: []string) : UPDATE remove the given connections from <location>
+// PUT  /maps/delete/<location> (with JSON from: []string) : UPDATE remove the given connections from <location>.
+// Removing either direction of a two-way edge removes both.
 func (rs *RouteStore) RemoveRoutes(name string, routes []string) error {
-	rs.Lock()
-	defer rs.Unlock()
+	defer rs.lockLocations("RemoveRoutes", append([]string{name}, routes...)...)()
 
 	loc := Location(name)
 
-	if rs.graph.Node(loc.ID()) == nil {
+	rs.RLock()
+	exists := rs.graph.Node(loc.ID()) != nil
+	twoWay := rs.twoWayOf(name, routes)
+	rs.RUnlock()
+	if !exists {
 		return fmt.Errorf("%s does not exist", loc)
 	}
 
+	conn, release := rs.writeConn()
+	err := rs.deleteEdges(conn, name, routes, twoWay)
+	release()
+	if err != nil {
+		return err
+	}
+
+	defer rs.apply()()
+	rs.changed()
+	return rs.removeEdges(name, routes, twoWay)
+}
+
+// Must be called with the lock held, after checking name exists
+func (rs *RouteStore) removeRoutes(name string, routes []string) error {
+	twoWay := rs.twoWayOf(name, routes)
+	if err := rs.deleteEdges(rs.redis, name, routes, twoWay); err != nil {
+		return err
+	}
+	return rs.removeEdges(name, routes, twoWay)
+}
+
+// Must be called with the lock held, if only for reading; the locations in routes whose edges with name are two-way
+func (rs *RouteStore) twoWayOf(name string, routes []string) map[string]bool {
+	ret := make(map[string]bool)
 	for _, to := range routes {
-		if name != to {
-			if _, err := rs.redis.Do("HDEL", name, to); err != nil {
+		if name != to && rs.isTwoWay(Location(name), Location(to)) {
+			ret[to] = true
+		}
+	}
+	return ret
+}
+
+// The Redis half of removeRoutes, on conn, which needs no store lock if it is not the store's own connection
+func (rs *RouteStore) deleteEdges(conn redis.Conn, name string, routes []string, twoWay map[string]bool) error {
+	if _, err := conn.Do("MULTI"); err != nil {
+		return err
+	}
+	queue := func() error {
+		for _, to := range routes {
+			if name == to {
+				continue
+			}
+			if twoWay[to] {
+				if _, err := conn.Do("HDEL", to, name); err != nil {
+					return err
+				}
+			}
+			if _, err := conn.Do("HDEL", name, to); err != nil {
 				return err
 			}
-			rs.graph.RemoveEdge(loc.ID(), Location(to).ID())
+		}
+		return nil
+	}
+	if err := queue(); err != nil {
+		conn.Do("DISCARD")
+		return err
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Must be called with the lock held; the in-memory half of removeRoutes, with what else Redis keeps on each edge
+func (rs *RouteStore) removeEdges(name string, routes []string, twoWay map[string]bool) error {
+	loc := Location(name)
+	for _, to := range routes {
+		if name == to {
+			continue
+		}
+		if twoWay[to] {
+			if err := rs.removeEdge(Location(to), loc); err != nil {
+				return err
+			}
+		}
+		if err := rs.removeEdge(loc, Location(to)); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -231,14 +782,35 @@ func (rs *RouteStore) RemoveRoutes(name string, routes []string) error {
 
 // DELETE /maps/<location> : DELETE the given location (and all edges from/to it) (and error if no such location)
 func (rs *RouteStore) DeleteLocation(name string) error {
-	rs.Lock()
-	defer rs.Unlock()
+	defer rs.lock("DeleteLocation")()
 
 	loc := Location(name)
 
 	if rs.graph.Node(loc.ID()) == nil {
 		return fmt.Errorf("%s does not exist", loc)
 	}
+	rs.changed()
+
+	return rs.deleteLocation(name)
+}
+
+// Must be called with the lock held, after checking name exists
+func (rs *RouteStore) deleteLocation(name string) error {
+	if err := rs.trashLocation(name); err != nil {
+		return err
+	}
+	if err := rs.removeCoordinates(name); err != nil {
+		return err
+	}
+	if err := rs.removeFromRegion(name); err != nil {
+		return err
+	}
+	if err := rs.removeLocationTags(name); err != nil {
+		return err
+	}
+	if err := rs.removeVisitCost(name); err != nil {
+		return err
+	}
 
 	if _, err := rs.redis.Do("SREM", locations_set, name); err != nil {
 		return err
@@ -253,8 +825,9 @@ func (rs *RouteStore) DeleteLocation(name string) error {
 			return err
 		}
 	}
+	if _, err := rs.redis.Do("DEL", name); err != nil {
+		return err
+	}
 
-	rs.graph.RemoveNode(loc.ID())
-
-	return nil
+	return rs.removeNode(Location(name).ID())
 }
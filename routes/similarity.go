@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// How alike two locations are in what they link to, ignoring weights and which way edges go
+type Similarity struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	// Whether there is an edge between them, either way
+	Linked bool `json:"linked"`
+	// The locations both are linked to, in name order
+	SharedNeighbours []string `json:"shared_neighbours"`
+	// Shared neighbours over all the neighbours of either, from 0 to 1; 1 means each could stand in for the other
+	Jaccard float64 `json:"jaccard"`
+	// The sum over shared neighbours of one over the log of their own neighbour counts, so that sharing a
+	// sparsely linked location counts for more than sharing a hub
+	AdamicAdar float64 `json:"adamic_adar"`
+}
+
+// GET  /maps/<a>/similar/<b> : READ how many neighbours <a> and <b> share, their Jaccard coefficient and their Adamic-Adar index.
+// Neighbours are linked by an edge either way, as for weak connectivity, and neither location counts as its own neighbour.
+// Locations with a high Jaccard coefficient may be redundant, and a high Adamic-Adar index suggests an edge between them.
+func (rs *RouteStore) Similarity(aStr, bStr string) (Similarity, error) {
+	defer rs.rlock("Similarity")()
+
+	a, b := Location(aStr), Location(bStr)
+	for _, loc := range []Location{a, b} {
+		if rs.graph.Node(loc.ID()) == nil {
+			return Similarity{}, fmt.Errorf("%s does not exist", loc)
+		}
+	}
+
+	ret := Similarity{A: aStr, B: bStr, SharedNeighbours: []string{}}
+	aNeighbours, bNeighbours := rs.neighbours(a.ID()), rs.neighbours(b.ID())
+	ret.Linked = aNeighbours[b.ID()]
+	union := len(aNeighbours)
+	for id := range bNeighbours {
+		if !aNeighbours[id] {
+			union++
+			continue
+		}
+		ret.SharedNeighbours = append(ret.SharedNeighbours, nodeName(rs.graph.Node(id)))
+		// A shared neighbour is linked to both, so has at least two neighbours and a positive log
+		ret.AdamicAdar += 1 / math.Log(float64(len(rs.neighbours(id))))
+	}
+	if union > 0 {
+		ret.Jaccard = float64(len(ret.SharedNeighbours)) / float64(union)
+	}
+	sort.Strings(ret.SharedNeighbours)
+	return ret, nil
+}
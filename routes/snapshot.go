@@ -0,0 +1,223 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"sort"
+	"sync"
+)
+
+// A read-only copy of the graph as it was at one revision. Nothing changes it after it is made,
+// so it can be read from any number of goroutines without the store lock. It shares what has not
+// changed since with the store's graph and with other snapshots, so taking one costs no more than
+// the first change to each part of the graph after it.
+type Snapshot struct {
+	revision      uint64
+	graph         *adjacencyGraph
+	negativeEdges int
+	precision     int
+	visitCosts    map[int64]float64
+}
+
+var ErrRevisionNotKept = errors.New("the revision is not kept")
+
+// The snapshots of a store, which guard themselves, since Snapshot takes them holding the store
+// lock only for reading
+type snapshotHistory struct {
+	sync.Mutex
+	// The most recent, reused until the graph changes
+	latest *Snapshot
+	// The graph at the end of each of the last length changes, oldest first, for SnapshotAt
+	kept   []*Snapshot
+	length int
+}
+
+// Snapshot returns the graph as it is now. One is taken per revision, on the first call after a
+// change, and shared by every caller until the next change.
+func (rs *RouteStore) Snapshot() *Snapshot {
+	defer rs.rlock("Snapshot")()
+
+	rs.snapshots.Lock()
+	defer rs.snapshots.Unlock()
+	return rs.latestSnapshot()
+}
+
+// Must be called with the lock held, if only for reading, and the snapshots locked
+func (rs *RouteStore) latestSnapshot() *Snapshot {
+	if rs.snapshots.latest == nil || rs.snapshots.latest.revision != rs.revision {
+		rs.visitCostsShared = true
+		rs.snapshots.latest = &Snapshot{revision: rs.revision, graph: rs.graph.clone(), negativeEdges: rs.negativeEdges, precision: rs.precision,
+			visitCosts: rs.visitCosts}
+	}
+	return rs.snapshots.latest
+}
+
+// SetSnapshotHistory keeps a snapshot of the graph after each of the last length changes, for SnapshotAt to answer
+// as of any revision since. They share what does not change, but what does is kept once per snapshot. 0, the
+// default, keeps none.
+func (rs *RouteStore) SetSnapshotHistory(length int) {
+	defer rs.lock("SetSnapshotHistory")()
+
+	rs.snapshots.Lock()
+	rs.snapshots.length = length
+	if length <= 0 {
+		rs.snapshots.kept = nil
+	}
+	rs.snapshots.Unlock()
+	rs.keepSnapshot()
+}
+
+// Must be called with the lock held, once whatever changed the graph is done
+func (rs *RouteStore) keepSnapshot() {
+	rs.snapshots.Lock()
+	defer rs.snapshots.Unlock()
+
+	h := &rs.snapshots
+	if h.length <= 0 {
+		return
+	}
+	s := rs.latestSnapshot()
+	if n := len(h.kept); n > 0 && h.kept[n-1] == s {
+		return
+	}
+	h.kept = append(h.kept, s)
+	if extra := len(h.kept) - h.length; extra > 0 {
+		// Move the rest down rather than slice them off, so the oldest can be collected
+		n := copy(h.kept, h.kept[extra:])
+		for i := n; i < len(h.kept); i++ {
+			h.kept[i] = nil
+		}
+		h.kept = h.kept[:n]
+	}
+}
+
+// GET  /maps/revisions/ : READ the revisions the graph can be queried as of, oldest first and the current one last, with SNAPSHOT_HISTORY
+func (rs *RouteStore) SnapshotRevisions() []uint64 {
+	defer rs.rlock("SnapshotRevisions")()
+
+	rs.snapshots.Lock()
+	defer rs.snapshots.Unlock()
+	ret := []uint64{}
+	for _, s := range rs.snapshots.kept {
+		if s.revision != rs.revision {
+			ret = append(ret, s.revision)
+		}
+	}
+	return append(ret, rs.revision)
+}
+
+// SnapshotAt returns the graph as it was at revision, which is the current one or one kept by SetSnapshotHistory.
+// A revision between two that are kept was never seen outside the change that passed through it, so gives the
+// older one. Any other revision is ErrRevisionNotKept.
+func (rs *RouteStore) SnapshotAt(revision uint64) (*Snapshot, error) {
+	defer rs.rlock("SnapshotAt")()
+
+	rs.snapshots.Lock()
+	defer rs.snapshots.Unlock()
+	if revision == rs.revision {
+		return rs.latestSnapshot(), nil
+	}
+	if revision < rs.revision {
+		kept := rs.snapshots.kept
+		for i := len(kept) - 1; i >= 0; i-- {
+			if kept[i].revision <= revision {
+				return kept[i], nil
+			}
+		}
+	}
+	return nil, ErrRevisionNotKept
+}
+
+// The revision of the store the snapshot was taken at
+func (s *Snapshot) Revision() uint64 {
+	return s.revision
+}
+
+// As RouteStore.GetLocations
+func (s *Snapshot) Locations() []string {
+	var ret []string
+	nodes := s.graph.Nodes()
+	for nodes.Next() {
+		ret = append(ret, nodeName(nodes.Node()))
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// As RouteStore.RoutesFrom
+func (s *Snapshot) RoutesFrom(name string) ([]string, error) {
+	loc := Location(name)
+	var ret []string
+	if s.graph.Node(loc.ID()) == nil {
+		return ret, fmt.Errorf("%s does not exist", loc)
+	}
+
+	nodes := s.graph.From(loc.ID())
+	for nodes.Next() {
+		ret = append(ret, nodeName(nodes.Node()))
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// As RouteStore.RoutesTo
+func (s *Snapshot) RoutesTo(name string) ([]string, error) {
+	loc := Location(name)
+	var ret []string
+	if s.graph.Node(loc.ID()) == nil {
+		return ret, fmt.Errorf("%s does not exist", loc)
+	}
+
+	nodes := s.graph.To(loc.ID())
+	for nodes.Next() {
+		ret = append(ret, nodeName(nodes.Node()))
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// The shortest routes from one location to another by Dijkstra's algorithm, without the
+// route cache, hot trees or tokens, which all belong to the live store
+func (s *Snapshot) RoutesBetween(fromStr, toStr string) ([]Route, error) {
+	from, to := Location(fromStr), Location(toStr)
+	if s.graph.Node(from.ID()) == nil {
+		return nil, fmt.Errorf("%s does not exist", from)
+	}
+	if s.graph.Node(to.ID()) == nil {
+		return nil, fmt.Errorf("%s does not exist", to)
+	}
+
+	ret := shortestRoutes(withVisitCosts(s.graph, s.visitCosts, from.ID()), from, to, s.precision)
+	for i := range ret {
+		ret[i].Weight = roundWeight(ret[i].Weight, s.precision)
+	}
+	return ret, nil
+}
+
+// EachRoute calls yield with each shortest route from one location to another in turn, as
+// RouteStore.RoutesBetween finds with Dijkstra's algorithm, but without building the whole list
+// first: when many routes tie, the first arrives as soon as the search is done. Routes are walked
+// back from the destination over edges that lie on some shortest path. It stops at the first
+// error from yield and returns it.
+func (s *Snapshot) EachRoute(fromStr, toStr string, yield func(Route) error) error {
+	from, to := Location(fromStr), Location(toStr)
+	if s.graph.Node(from.ID()) == nil {
+		return fmt.Errorf("%s does not exist", from)
+	}
+	if s.graph.Node(to.ID()) == nil {
+		return fmt.Errorf("%s does not exist", to)
+	}
+	if s.negativeEdges > 0 {
+		return fmt.Errorf("%s cannot be used while there are negative edge weights, use %s", Dijkstra, BellmanFord)
+	}
+
+	return eachShortestPath(withVisitCosts(s.graph, s.visitCosts, from.ID()), from.ID(), to.ID(), tieTolerance(s.precision), func(nodes []graph.Node, weight float64) error {
+		route := Route{Weight: roundWeight(weight, s.precision)}
+		for _, node := range nodes {
+			route.Route = append(route.Route, nodeName(node))
+		}
+		route.Token = encodeToken(s.revision, route, RouteOptions{})
+		return yield(route)
+	})
+}
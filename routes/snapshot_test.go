@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"testing"
+)
+
+// Each kept revision answers as the graph was after its change, visit costs included, until it is too old to keep
+func TestSnapshotAtKeptRevisions(t *testing.T) {
+	rs := New(newMemoryRedis())
+	rs.SetSnapshotHistory(3)
+	for _, name := range []string{"A", "B", "C"} {
+		if err := rs.AddLocation(name, nil, nil, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.AddRoutes("A", givenWeights(map[string]float64{"B": 1, "C": 10}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.AddRoutes("B", givenWeights(map[string]float64{"C": 1}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	cheap := rs.Snapshot().Revision()
+	if err := rs.SetVisitCost("B", 20); err != nil {
+		t.Fatal(err)
+	}
+	costed := rs.Snapshot().Revision()
+	if err := rs.RemoveRoutes("A", []string{"C"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for revision, want := range map[uint64]float64{cheap: 2, costed: 10, rs.Snapshot().Revision(): 22} {
+		s, err := rs.SnapshotAt(revision)
+		if err != nil {
+			t.Fatalf("revision %d: %v", revision, err)
+		}
+		if routes, err := s.RoutesBetween("A", "C"); err != nil || len(routes) != 1 || routes[0].Weight != want {
+			t.Fatalf("revision %d: %+v, %v", revision, routes, err)
+		}
+	}
+
+	if err := rs.AddRoutes("C", givenWeights(map[string]float64{"A": 1}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.SnapshotAt(cheap); err != ErrRevisionNotKept {
+		t.Fatalf("revision %d should no longer be kept, got %v", cheap, err)
+	}
+	if revisions := rs.SnapshotRevisions(); len(revisions) != 3 || revisions[2] != rs.Snapshot().Revision() {
+		t.Fatalf("expected the last three revisions, got %v", revisions)
+	}
+}
@@ -0,0 +1,91 @@
+package routes
+
+import (
+	"gonum.org/v1/gonum/graph/path"
+	"gonum.org/v1/gonum/graph/topo"
+	"math"
+)
+
+// Summary figures for the whole graph
+type GraphStats struct {
+	Locations int `json:"locations"`
+	Edges     int `json:"edges"`
+	// Edges out of the average location, which is also the average in
+	AverageDegree float64 `json:"average_degree"`
+	// The weight of the heaviest shortest route between any two locations with a route between them, and its ends
+	Diameter     float64 `json:"diameter"`
+	DiameterFrom string  `json:"diameter_from,omitempty"`
+	DiameterTo   string  `json:"diameter_to,omitempty"`
+	// Whether every location can reach every other
+	StronglyConnected bool `json:"strongly_connected"`
+	Components        int  `json:"components"`
+	// Whether this came from the cache rather than being computed for the request
+	Cached bool `json:"cached"`
+	// The graph revision it was computed for, which the cache holds it until
+	revision uint64
+}
+
+// GET  /maps/stats/ (Cache-Control: no-cache|only-if-cached optional) : READ location and edge counts, average degree,
+// diameter and connectivity. The result is kept until the graph changes, as for Centrality; the diameter takes a shortest
+// path search from every location, on a copy of the graph.
+func (rs *RouteStore) Stats(mode CacheMode) (GraphStats, error) {
+	unlock := rs.rlock("Stats")
+	cached, revision := rs.stats, rs.revision
+	if cached != nil && cached.revision == revision && mode != CacheBypass {
+		unlock()
+		ret := *cached
+		ret.Cached = true
+		return ret, nil
+	}
+	if mode == CacheOnly {
+		unlock()
+		return GraphStats{}, ErrNotCached
+	}
+	g, negativeEdges, precision := rs.copyGraph(), rs.negativeEdges, rs.precision
+	unlock()
+
+	ret := GraphStats{Locations: g.Nodes().Len(), revision: revision}
+	ret.Edges = g.WeightedEdges().Len()
+	if ret.Locations > 0 {
+		ret.AverageDegree = float64(ret.Edges) / float64(ret.Locations)
+	}
+	ret.Components = len(topo.TarjanSCC(g))
+	ret.StronglyConnected = ret.Components == 1
+
+	var shortest path.AllShortest
+	if negativeEdges > 0 {
+		var ok bool
+		if shortest, ok = path.FloydWarshall(g); !ok {
+			return GraphStats{}, ErrNegativeCycle
+		}
+	} else {
+		shortest = path.DijkstraAllPaths(g)
+	}
+	nodes := g.Nodes()
+	for nodes.Next() {
+		targets := g.Nodes()
+		for targets.Next() {
+			u, v := nodes.Node(), targets.Node()
+			if u.ID() == v.ID() {
+				continue
+			}
+			w := shortest.Weight(u.ID(), v.ID())
+			if math.IsInf(w, 0) {
+				continue
+			}
+			// Ties go to the first pair by name, so the answer does not change from one call to the next
+			from, to := nodeName(u), nodeName(v)
+			if ret.DiameterFrom == "" || w > ret.Diameter ||
+				w == ret.Diameter && (from < ret.DiameterFrom || from == ret.DiameterFrom && to < ret.DiameterTo) {
+				ret.Diameter, ret.DiameterFrom, ret.DiameterTo = w, from, to
+			}
+		}
+	}
+	ret.Diameter = roundWeight(ret.Diameter, precision)
+
+	defer rs.lock("Stats")()
+	if rs.revision == revision {
+		rs.stats = &ret
+	}
+	return ret, nil
+}
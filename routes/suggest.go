@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The most edges one query suggests
+const MaxSuggestedEdges = 1000
+
+// A pair of locations with no edge between them that probably should have one
+type SuggestedEdge struct {
+	// In name order; the edge is suggested either way
+	From string `json:"from"`
+	To   string `json:"to"`
+	// How many locations both are linked to
+	SharedNeighbours int `json:"shared_neighbours"`
+	// As for Similarity
+	Jaccard    float64 `json:"jaccard"`
+	AdamicAdar float64 `json:"adamic_adar"`
+	// The Adamic-Adar index over that of the best suggestion, from 0 to 1
+	Confidence float64 `json:"confidence"`
+}
+
+// GET  /maps/analysis/suggest-edges/ (?n=20 optional) : READ the n pairs of locations, with no edge between them either way, most likely
+// to be missing one, by their Adamic-Adar index: the more neighbours they share, and the fewer neighbours those have, the likelier.
+// Neighbours are as for Similarity, so only pairs two links apart are suggested. Finding them takes time in the sum of the squares of
+// the locations' neighbour counts.
+func (rs *RouteStore) SuggestEdges(n int) ([]SuggestedEdge, error) {
+	if n < 1 || n > MaxSuggestedEdges {
+		return nil, fmt.Errorf("n must be between 1 and %d", MaxSuggestedEdges)
+	}
+
+	defer rs.rlock("SuggestEdges")()
+
+	neighbours := make(map[int64]map[int64]bool)
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		neighbours[nodes.Node().ID()] = rs.neighbours(nodes.Node().ID())
+	}
+
+	// Every pair of a location's neighbours shares it, so visiting each location's pairs finds every shared neighbour once
+	candidates := make(map[Pair]*SuggestedEdge)
+	for _, linked := range neighbours {
+		if len(linked) < 2 {
+			continue
+		}
+		var names []string
+		for id := range linked {
+			names = append(names, nodeName(rs.graph.Node(id)))
+		}
+		sort.Strings(names)
+		weight := 1 / math.Log(float64(len(linked)))
+		for i, a := range names {
+			for _, b := range names[i+1:] {
+				if neighbours[Location(a).ID()][Location(b).ID()] {
+					continue
+				}
+				pair := Pair{From: a, To: b}
+				if candidates[pair] == nil {
+					candidates[pair] = &SuggestedEdge{From: a, To: b}
+				}
+				candidates[pair].SharedNeighbours++
+				candidates[pair].AdamicAdar += weight
+			}
+		}
+	}
+
+	ret := make([]SuggestedEdge, 0, len(candidates))
+	for _, candidate := range candidates {
+		union := len(neighbours[Location(candidate.From).ID()]) + len(neighbours[Location(candidate.To).ID()]) - candidate.SharedNeighbours
+		candidate.Jaccard = float64(candidate.SharedNeighbours) / float64(union)
+		ret = append(ret, *candidate)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].AdamicAdar != ret[j].AdamicAdar {
+			return ret[i].AdamicAdar > ret[j].AdamicAdar
+		}
+		return Pair{From: ret[i].From, To: ret[i].To}.String() < Pair{From: ret[j].From, To: ret[j].To}.String()
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	for i := range ret {
+		ret[i].Confidence = ret[i].AdamicAdar / ret[0].AdamicAdar
+	}
+	return ret, nil
+}
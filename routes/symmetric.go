@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"github.com/gomodule/redigo/redis"
+)
+
+// "true" when edges added to the map go both ways unless asked otherwise, see SetSymmetric
+const symmetric_key = "rest_project:symmetric"
+
+func (rs *RouteStore) restoreSymmetric() error {
+	on, err := redis.Bool(rs.redis.Do("GET", symmetric_key))
+	if err == redis.ErrNil {
+		rs.symmetric = false
+		return nil
+	} else if err != nil {
+		return err
+	}
+	rs.symmetric = on
+	return nil
+}
+
+// GET  /admin/symmetric/ : READ whether edges added to the map go both ways unless bidirectional says otherwise
+func (rs *RouteStore) Symmetric() bool {
+	defer rs.rlock("Symmetric")()
+
+	return rs.symmetric
+}
+
+// PUT  /admin/symmetric/ (with JSON symmetric: bool) : UPDATE set whether edges added to the map go both ways unless bidirectional
+// says otherwise, for maps of undirected networks such as roads. Like bidirectional, each edge it mirrors stays in step with its
+// reverse from then on. Edges already in the map are left as they are, and imports add just the edges they list.
+func (rs *RouteStore) SetSymmetric(on bool) error {
+	defer rs.lock("SetSymmetric")()
+
+	var err error
+	if on {
+		_, err = rs.redis.Do("SET", symmetric_key, "true")
+	} else {
+		_, err = rs.redis.Do("DEL", symmetric_key)
+	}
+	if err != nil {
+		return err
+	}
+	rs.symmetric = on
+	return nil
+}
+
+// Must be called with the lock held; whether edges added with the given bidirectional go both ways, the map's default if nil
+func (rs *RouteStore) bidirectional(given *bool) bool {
+	if given != nil {
+		return *given
+	}
+	return rs.symmetric
+}
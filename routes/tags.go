@@ -0,0 +1,229 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/iterator"
+	"log"
+	"math"
+	"strings"
+)
+
+// Tags of every tagged edge, by "<from>/<to>", each a comma separated list
+const edge_tags_hash = "rest_project:edge_tags"
+
+// validateTag rejects tags that could not be listed in a query parameter
+func validateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("tags cannot be empty")
+	}
+	if strings.Contains(tag, ",") {
+		return fmt.Errorf("tag %q cannot contain ','", tag)
+	}
+	return nil
+}
+
+// ParseTags reads a comma separated list of tags, as given in ?exclude_tags= and ?require_tags=
+func ParseTags(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	tags := strings.Split(s, ",")
+	for _, tag := range tags {
+		if err := validateTag(tag); err != nil {
+			return nil, err
+		}
+	}
+	return tags, nil
+}
+
+func edgeKey(from, to int64) [2]int64 {
+	return [2]int64{from, to}
+}
+
+func (rs *RouteStore) restoreTags() error {
+	stringMap, err := redis.StringMap(rs.redis.Do("HGETALL", edge_tags_hash))
+	if err != nil {
+		return err
+	}
+	for s, tags := range stringMap {
+		pair, err := ParsePair(s)
+		if err != nil {
+			// Such as the edge of a location named with a '/', before names were checked
+			log.Printf("Ignoring the tags of %q in Redis: %s\n", s, err.Error())
+			continue
+		}
+		rs.tags[edgeKey(Location(pair.From).ID(), Location(pair.To).ID())] = strings.Split(tags, ",")
+	}
+	return nil
+}
+
+// Must be called with the lock held, whenever an edge goes
+func (rs *RouteStore) removeTags(from, to Location) error {
+	key := edgeKey(from.ID(), to.ID())
+	if _, ok := rs.tags[key]; !ok {
+		return nil
+	}
+	if _, err := rs.redis.Do("HDEL", edge_tags_hash, Pair{From: string(from), To: string(to)}.String()); err != nil {
+		return err
+	}
+	delete(rs.tags, key)
+	return nil
+}
+
+// GET  /maps/<from>/edge/<to>/tags : READ the tags of the edge from <from> to <to>, in name order
+func (rs *RouteStore) EdgeTags(fromStr, toStr string) ([]string, error) {
+	defer rs.rlock("EdgeTags")()
+
+	from, to := Location(fromStr), Location(toStr)
+	if !rs.hasEdge(from, to) {
+		return nil, fmt.Errorf("there is no edge from %s to %s", from, to)
+	}
+
+	ret := append([]string{}, rs.tags[edgeKey(from.ID(), to.ID())]...)
+	return ret, nil
+}
+
+// PUT  /maps/<from>/edge/<to>/tags (with JSON []string) : UPDATE replace the tags of the edge from <from> to <to>.
+// Tags go when their edge is removed, including when either end is deleted.
+func (rs *RouteStore) SetEdgeTags(fromStr, toStr string, tags []string) error {
+	sorted, err := sortedTags(tags)
+	if err != nil {
+		return err
+	}
+
+	defer rs.lock("SetEdgeTags")()
+
+	from, to := Location(fromStr), Location(toStr)
+	if !rs.hasEdge(from, to) {
+		return fmt.Errorf("there is no edge from %s to %s", from, to)
+	}
+	rs.changed()
+
+	if len(sorted) == 0 {
+		return rs.removeTags(from, to)
+	}
+	if _, err := rs.redis.Do("HSET", edge_tags_hash, Pair{From: fromStr, To: toStr}.String(), strings.Join(sorted, ",")); err != nil {
+		return err
+	}
+	rs.tags[edgeKey(from.ID(), to.ID())] = sorted
+	return nil
+}
+
+// Must be called with the lock held; whether a route may use the edge from u to v under the options' tag filters
+func (rs *RouteStore) tagsAllow(opts RouteOptions, u, v int64) bool {
+	tags := rs.tags[edgeKey(u, v)]
+	for _, tag := range opts.ExcludeTags {
+		if hasTag(tags, tag) {
+			return false
+		}
+	}
+	for _, tag := range opts.RequireTags {
+		if !hasTag(tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Must be called with the lock held; whether a route may use the edge from u to v under the options' modes
+func (rs *RouteStore) modesAllow(opts RouteOptions, u, v int64) bool {
+	if len(opts.Modes) == 0 {
+		return true
+	}
+	_, _, ok := rs.cheapestMode(u, v, opts.Modes)
+	return ok
+}
+
+// Must be called with the lock held, after resolveOptions; the graph searches for a query should use,
+// which leaves out the edges the options' tag filters rule out, those it avoids and those with none of
+// its modes, and weighs edges by the cheapest of its modes and then by its cost function. Nothing is copied, so the store is unchanged.
+func (rs *RouteStore) routingGraph(opts RouteOptions) graph.WeightedDirected {
+	var g graph.WeightedDirected = rs.graph
+	if len(opts.ExcludeTags) > 0 || len(opts.RequireTags) > 0 || len(opts.Avoid) > 0 || len(opts.Modes) > 0 {
+		avoidNodes, avoidEdges := avoidSets(opts.Avoid)
+		filtered := filteredGraph{WeightedDirected: g, allow: func(u, v int64) bool {
+			return !avoidNodes[u] && !avoidNodes[v] && !avoidEdges[edgeKey(u, v)] && rs.tagsAllow(opts, u, v) && rs.modesAllow(opts, u, v)
+		}}
+		g = filtered
+		if len(opts.Modes) > 0 {
+			g = modeGraph{filteredGraph: filtered, rs: rs, modes: opts.Modes}
+		}
+	}
+	if opts.metric != nil {
+		g = costGraph{WeightedDirected: g, cost: opts.metric, attributes: rs.attributes}
+	} else if opts.Cost != "" {
+		g = costGraph{WeightedDirected: g, cost: rs.costs[opts.Cost], attributes: rs.attributes}
+	}
+	return g
+}
+
+// avoidSets splits resolved Avoid options into the locations and the edges they name
+func avoidSets(avoid []string) (map[int64]bool, map[[2]int64]bool) {
+	nodes, edges := make(map[int64]bool), make(map[[2]int64]bool)
+	for _, s := range avoid {
+		if pair, err := ParsePair(s); err == nil {
+			edges[edgeKey(Location(pair.From).ID(), Location(pair.To).ID())] = true
+		} else {
+			nodes[Location(s).ID()] = true
+		}
+	}
+	return nodes, edges
+}
+
+// A graph without the edges allow rejects; its nodes are all still there
+type filteredGraph struct {
+	graph.WeightedDirected
+	allow func(u, v int64) bool
+}
+
+func filterNodes(nodes graph.Nodes, allow func(id int64) bool) graph.Nodes {
+	var ret []graph.Node
+	for nodes.Next() {
+		if allow(nodes.Node().ID()) {
+			ret = append(ret, nodes.Node())
+		}
+	}
+	if len(ret) == 0 {
+		return graph.Empty
+	}
+	return iterator.NewOrderedNodes(ret)
+}
+
+func (g filteredGraph) From(id int64) graph.Nodes {
+	return filterNodes(g.WeightedDirected.From(id), func(v int64) bool { return g.allow(id, v) })
+}
+
+func (g filteredGraph) To(id int64) graph.Nodes {
+	return filterNodes(g.WeightedDirected.To(id), func(u int64) bool { return g.allow(u, id) })
+}
+
+func (g filteredGraph) HasEdgeFromTo(uid, vid int64) bool {
+	return g.WeightedDirected.HasEdgeFromTo(uid, vid) && g.allow(uid, vid)
+}
+
+func (g filteredGraph) HasEdgeBetween(xid, yid int64) bool {
+	return g.HasEdgeFromTo(xid, yid) || g.HasEdgeFromTo(yid, xid)
+}
+
+func (g filteredGraph) Edge(uid, vid int64) graph.Edge {
+	if e := g.WeightedEdge(uid, vid); e != nil {
+		return e
+	}
+	return nil
+}
+
+func (g filteredGraph) WeightedEdge(uid, vid int64) graph.WeightedEdge {
+	if !g.allow(uid, vid) {
+		return nil
+	}
+	return g.WeightedDirected.WeightedEdge(uid, vid)
+}
+
+func (g filteredGraph) Weight(xid, yid int64) (float64, bool) {
+	if xid != yid && !g.allow(xid, yid) {
+		return math.Inf(1), false
+	}
+	return g.WeightedDirected.Weight(xid, yid)
+}
@@ -0,0 +1,104 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"time"
+	// So that zones load the same whether or not the host has a zone database
+	_ "time/tzdata"
+)
+
+// The IANA name of the map's time zone, when one is set
+const timezone_key = "rest_project:timezone"
+
+// The edge attribute holding how long an edge takes to travel, in seconds
+const timeAttribute = "time"
+
+// Layouts depart_at may take without an offset, read in the map's time zone
+var localLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04"}
+
+// When a route reaches the end of one of its edges
+type LegArrival struct {
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Arrival time.Time `json:"arrival"`
+}
+
+func (rs *RouteStore) restoreTimezone() error {
+	name, err := redis.String(rs.redis.Do("GET", timezone_key))
+	if err == redis.ErrNil {
+		rs.timezone = time.UTC
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if rs.timezone, err = time.LoadLocation(name); err != nil {
+		return fmt.Errorf("bad time zone %q: %s", name, err)
+	}
+	return nil
+}
+
+// GET  /admin/timezone/ : READ the map's time zone, which arrival times are given in
+func (rs *RouteStore) Timezone() string {
+	defer rs.rlock("Timezone")()
+
+	return rs.timezone.String()
+}
+
+// PUT  /admin/timezone/ (with JSON timezone: string) : UPDATE set the map's time zone by IANA name, such as Europe/London; empty for UTC
+func (rs *RouteStore) SetTimezone(name string) error {
+	if name == "Local" {
+		return fmt.Errorf("unknown time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %q", name)
+	}
+
+	defer rs.lock("SetTimezone")()
+
+	if name == "" {
+		_, err = rs.redis.Do("DEL", timezone_key)
+	} else {
+		_, err = rs.redis.Do("SET", timezone_key, name)
+	}
+	if err != nil {
+		return err
+	}
+	rs.timezone = loc
+	return nil
+}
+
+// Must be called with the lock held; parseDepartAt reads an RFC 3339 time, or one without an offset in the map's time zone
+func (rs *RouteStore) parseDepartAt(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(rs.timezone), nil
+	}
+	for _, layout := range localLayouts {
+		if t, err := time.ParseInLocation(layout, s, rs.timezone); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("depart_at must be a time such as 2006-01-02T15:04:05, with or without an offset, not %q", s)
+}
+
+// Must be called with the lock held; withArrivals copies routes, since they may be cached, adding when each of their
+// edges is done leaving at departAt, by the edges' time attributes. Every edge must have one.
+func (rs *RouteStore) withArrivals(routes []Route, departAt time.Time) ([]Route, error) {
+	ret := make([]Route, len(routes))
+	for i, route := range routes {
+		ret[i] = route
+		ret[i].Arrivals = []LegArrival{}
+		t := departAt
+		for k := 1; k < len(route.Route); k++ {
+			from, to := route.Route[k-1], route.Route[k]
+			seconds, ok := rs.attributes[edgeKey(Location(from).ID(), Location(to).ID())][timeAttribute]
+			if !ok {
+				return nil, fmt.Errorf("the edge from %s to %s has no %s attribute, so arrival times cannot be estimated", from, to, timeAttribute)
+			}
+			t = t.Add(time.Duration(seconds * float64(time.Second)))
+			ret[i].Arrivals = append(ret[i].Arrivals, LegArrival{From: from, To: to, Arrival: t})
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// What a route token carries; clients should treat the encoded form as opaque
+type routeToken struct {
+	Revision uint64   `json:"rev"`
+	Route    []string `json:"route"`
+	Weight   float64  `json:"weight"`
+	// The cost function the weight was found by, if any
+	Cost string `json:"cost,omitempty"`
+	// Or the expression of the metric it was found by
+	Metric string `json:"metric,omitempty"`
+	// Or the weight profile
+	Profile string `json:"profile,omitempty"`
+	// The modes it was found by, if any
+	Modes []string `json:"modes,omitempty"`
+}
+
+func encodeToken(revision uint64, route Route, opts RouteOptions) string {
+	token := routeToken{Revision: revision, Route: route.Route, Weight: route.Weight, Cost: opts.Cost, Modes: opts.Modes}
+	if opts.Profile != "" {
+		token.Profile = opts.Profile
+	} else if opts.metric != nil {
+		token.Metric = opts.metric.Expression
+	}
+	js, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+func decodeToken(token string) (routeToken, error) {
+	var ret routeToken
+	js, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return ret, errors.New("malformed route token")
+	}
+	if err := json.Unmarshal(js, &ret); err != nil || len(ret.Route) == 0 {
+		return ret, errors.New("malformed route token")
+	}
+	return ret, nil
+}
+
+// Must be called with the lock held, with the resolved options the routes were found by
+func (rs *RouteStore) issueTokens(routes []Route, opts RouteOptions) {
+	for i := range routes {
+		routes[i].Token = encodeToken(rs.revision, routes[i], opts)
+	}
+}
+
+// Whether a previously returned route can still be followed, and whether it is still the cheapest
+type RouteValidation struct {
+	Route []string `json:"route"`
+	// Every edge along the route still exists
+	Valid bool `json:"valid"`
+	// Valid, and no cheaper route exists now
+	Optimal bool `json:"optimal"`
+	// The graph has changed since the token was issued
+	Changed bool `json:"changed"`
+	// What the route weighed when issued and weighs now
+	IssuedWeight  float64  `json:"issued_weight"`
+	CurrentWeight *float64 `json:"current_weight,omitempty"`
+	// The cheapest weight between the route's ends now, if there is any route
+	BestWeight *float64 `json:"best_weight,omitempty"`
+}
+
+// POST /maps/routes/validate/ (with JSON token: string) : READ whether a previously returned route is still valid and optimal
+func (rs *RouteStore) ValidateRoute(token string) (RouteValidation, error) {
+	decoded, err := decodeToken(token)
+	if err != nil {
+		return RouteValidation{}, err
+	}
+
+	defer rs.rlock("ValidateRoute")()
+
+	ret := RouteValidation{
+		Route:        decoded.Route,
+		Changed:      decoded.Revision != rs.revision,
+		IssuedWeight: decoded.Weight,
+	}
+
+	// Routes found by a cost function are checked by it, as it is defined now, and those found by a metric or
+	// profile by that, and routes found by modes by the same modes
+	opts := rs.exactOptions()
+	opts.Modes = decoded.Modes
+	if decoded.Cost != "" {
+		if _, ok := rs.costs[decoded.Cost]; !ok {
+			return ret, fmt.Errorf("unknown cost function %q", decoded.Cost)
+		}
+		opts.Cost = decoded.Cost
+	}
+	if decoded.Metric != "" {
+		if opts.metric, err = ParseCostFunction("metric", decoded.Metric); err != nil {
+			return ret, errors.New("malformed route token")
+		}
+	}
+	if decoded.Profile != "" {
+		if _, err := ParseProfile(decoded.Profile); err != nil {
+			return ret, errors.New("malformed route token")
+		}
+		opts.metric = profileCostFunction(decoded.Profile)
+	}
+	g := rs.visitCostGraph(rs.routingGraph(opts), Location(decoded.Route[0]))
+
+	weight, valid := 0.0, true
+	for i := 1; i < len(decoded.Route); i++ {
+		edge := g.WeightedEdge(Location(decoded.Route[i-1]).ID(), Location(decoded.Route[i]).ID())
+		if edge == nil {
+			valid = false
+			break
+		}
+		weight += edge.Weight()
+	}
+	if valid && rs.graph.Node(Location(decoded.Route[0]).ID()) == nil {
+		valid = false
+	}
+	ret.Valid = valid
+	if valid {
+		weight = rs.roundWeight(weight)
+		ret.CurrentWeight = &weight
+	}
+
+	from, to := Location(decoded.Route[0]), Location(decoded.Route[len(decoded.Route)-1])
+	if rs.graph.Node(from.ID()) == nil || rs.graph.Node(to.ID()) == nil {
+		return ret, nil
+	}
+
+	best, err := rs.search(from, to, opts)
+	if err != nil {
+		return ret, fmt.Errorf("cannot check %s: %s", Pair{From: string(from), To: string(to)}, err)
+	}
+	if len(best) > 0 {
+		ret.BestWeight = &best[0].Weight
+		ret.Optimal = valid && best[0].Weight >= weight
+	}
+	return ret, nil
+}
@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The most origin-destination pairs one assignment may take
+const MaxDemands = 10000
+
+// The edge attribute holding how much flow an edge can carry
+const capacityAttribute = "capacity"
+
+// How much traffic wants to go from one location to another
+type Demand struct {
+	From   string  `json:"from"`
+	To     string  `json:"to"`
+	Demand float64 `json:"demand"`
+}
+
+// The traffic assigned to one edge. Capacity and utilization are only given for edges with a capacity attribute.
+type EdgeLoad struct {
+	From        string   `json:"from"`
+	To          string   `json:"to"`
+	Flow        float64  `json:"flow"`
+	Capacity    *float64 `json:"capacity,omitempty"`
+	Utilization *float64 `json:"utilization,omitempty"`
+	Overloaded  bool     `json:"overloaded"`
+}
+
+// Where a demand matrix would send its traffic
+type TrafficAssignment struct {
+	// Every edge carrying traffic, most utilized first, then heaviest flow
+	Edges []EdgeLoad `json:"edges"`
+	// The edges carrying more than their capacity
+	Overloaded int `json:"overloaded"`
+	// Demands with no route, which were not assigned
+	Unrouted []Demand `json:"unrouted"`
+}
+
+// POST /maps/analysis/traffic/ (with JSON [{from, to, demand}]) : READ the flow on each edge if every demand took its shortest
+// route, split evenly between routes that tie, and which edges that would take past their capacity attribute. This is
+// all-or-nothing assignment: routes do not get slower as they fill up.
+func (rs *RouteStore) AssignTraffic(demands []Demand) (TrafficAssignment, error) {
+	if len(demands) < 1 || len(demands) > MaxDemands {
+		return TrafficAssignment{}, fmt.Errorf("demands must number between 1 and %d", MaxDemands)
+	}
+	for _, d := range demands {
+		if math.IsNaN(d.Demand) || math.IsInf(d.Demand, 0) || d.Demand < 0 {
+			return TrafficAssignment{}, fmt.Errorf("demand from %s to %s must be a non-negative number, not %g", d.From, d.To, d.Demand)
+		}
+	}
+
+	unlock := rs.rlock("AssignTraffic")
+	g, negativeEdges, precision, visitCosts := rs.copyGraph(), rs.negativeEdges, rs.precision, rs.copyVisitCosts()
+	capacities := make(map[[2]int64]float64)
+	for key, attributes := range rs.attributes {
+		if capacity, ok := attributes[capacityAttribute]; ok {
+			capacities[key] = capacity
+		}
+	}
+	unlock()
+
+	ret := TrafficAssignment{Edges: []EdgeLoad{}, Unrouted: []Demand{}}
+	flows := make(map[Pair]float64)
+	for _, d := range demands {
+		from, to := Location(d.From), Location(d.To)
+		for _, loc := range []Location{from, to} {
+			if g.Node(loc.ID()) == nil {
+				return TrafficAssignment{}, fmt.Errorf("%s does not exist", loc)
+			}
+		}
+		if from == to || d.Demand == 0 {
+			continue
+		}
+
+		var routes []Route
+		if negativeEdges > 0 {
+			route, err := bestRoute(g, negativeEdges, visitCosts, from, to, precision)
+			if err != nil {
+				return TrafficAssignment{}, err
+			}
+			if route != nil {
+				routes = []Route{{Route: route.Route, Weight: route.Weight}}
+			}
+		} else {
+			routes = shortestRoutes(withVisitCosts(g, visitCosts, from.ID()), from, to, precision)
+		}
+		if len(routes) == 0 {
+			ret.Unrouted = append(ret.Unrouted, d)
+			continue
+		}
+		share := d.Demand / float64(len(routes))
+		for _, route := range routes {
+			for i := 1; i < len(route.Route); i++ {
+				flows[Pair{From: route.Route[i-1], To: route.Route[i]}] += share
+			}
+		}
+	}
+
+	for pair, flow := range flows {
+		load := EdgeLoad{From: pair.From, To: pair.To, Flow: roundWeight(flow, precision)}
+		if capacity, ok := capacities[edgeKey(Location(pair.From).ID(), Location(pair.To).ID())]; ok {
+			utilization := math.Inf(1)
+			if capacity > 0 {
+				utilization = flow / capacity
+			}
+			load.Capacity = &capacity
+			if !math.IsInf(utilization, 0) {
+				load.Utilization = &utilization
+			}
+			load.Overloaded = flow > capacity
+		}
+		if load.Overloaded {
+			ret.Overloaded++
+		}
+		ret.Edges = append(ret.Edges, load)
+	}
+	utilization := func(load EdgeLoad) float64 {
+		switch {
+		case load.Overloaded && load.Utilization == nil:
+			return math.Inf(1)
+		case load.Utilization == nil:
+			return -1
+		}
+		return *load.Utilization
+	}
+	sort.Slice(ret.Edges, func(i, j int) bool {
+		a, b := ret.Edges[i], ret.Edges[j]
+		if utilization(a) != utilization(b) {
+			return utilization(a) > utilization(b)
+		}
+		if a.Flow != b.Flow {
+			return a.Flow > b.Flow
+		}
+		return a.From < b.From || a.From == b.From && a.To < b.To
+	})
+	return ret, nil
+}
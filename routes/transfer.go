@@ -0,0 +1,297 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"gonum.org/v1/gonum/graph"
+	"math"
+	"sort"
+	"strings"
+)
+
+// A graph, or part of one, in the form used by import and export
+type GraphData struct {
+	Locations   []string               `json:"locations"`
+	Edges       []Edge                 `json:"edges"`
+	Coordinates map[string]Coordinates `json:"coordinates,omitempty"`
+}
+
+// What to do when an imported edge already exists with a different weight
+type ConflictStrategy string
+
+const (
+	ConflictSkip      ConflictStrategy = "skip"
+	ConflictOverwrite ConflictStrategy = "overwrite"
+	ConflictError     ConflictStrategy = "error"
+	ConflictMin       ConflictStrategy = "min"
+	ConflictMax       ConflictStrategy = "max"
+)
+
+var ErrImportConflict = errors.New("import conflicts with existing edges")
+
+// ParseConflictStrategy checks s names a strategy; the empty string means error
+func ParseConflictStrategy(s string) (ConflictStrategy, error) {
+	switch strategy := ConflictStrategy(s); strategy {
+	case "":
+		return ConflictError, nil
+	case ConflictSkip, ConflictOverwrite, ConflictError, ConflictMin, ConflictMax:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("unknown conflict strategy %q, expected one of skip, overwrite, error, min or max", s)
+}
+
+// An imported edge that already existed with a different weight, and the weight it ended up with
+type EdgeConflict struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Existing float64 `json:"existing"`
+	Incoming float64 `json:"incoming"`
+	Resolved float64 `json:"resolved"`
+}
+
+type ImportReport struct {
+	LocationsCreated int            `json:"locations_created"`
+	EdgesCreated     int            `json:"edges_created"`
+	EdgesUpdated     int            `json:"edges_updated"`
+	EdgesUnchanged   int            `json:"edges_unchanged"`
+	Conflicts        []EdgeConflict `json:"conflicts"`
+}
+
+// POST /maps/import/ (with JSON locations, edges, coordinates; ?conflict=skip|overwrite|error|min|max optional) : CREATE
+// locations and edges from an export. Locations named only by edges are created too. With the error strategy nothing
+// is imported if any edge conflicts, and ErrImportConflict is returned along with the report.
+func (rs *RouteStore) Import(data GraphData, strategy ConflictStrategy) (ImportReport, error) {
+	report := ImportReport{Conflicts: []EdgeConflict{}}
+
+	defer rs.lock("Import")()
+
+	// Plan everything first, so a bad import changes nothing
+	locations := make(map[string]bool)
+	newLocation := func(name string) error {
+		if err := validateName(name); err != nil {
+			return err
+		}
+		if err := rs.checkNotArchived(name); err != nil {
+			return err
+		}
+		if !locations[name] && rs.graph.Node(Location(name).ID()) == nil {
+			locations[name] = true
+			report.LocationsCreated++
+		}
+		return nil
+	}
+	for _, name := range data.Locations {
+		if err := newLocation(name); err != nil {
+			return report, err
+		}
+	}
+
+	edges := make(map[Pair]float64)
+	for _, edge := range data.Edges {
+		if edge.From == edge.To {
+			return report, fmt.Errorf("%s cannot have an edge to itself", edge.From)
+		}
+		if err := rs.checkWeight(edge.From, edge.To, edge.Weight); err != nil {
+			return report, err
+		}
+		edge.Weight = rs.roundWeight(edge.Weight)
+		if err := newLocation(edge.From); err != nil {
+			return report, err
+		}
+		if err := newLocation(edge.To); err != nil {
+			return report, err
+		}
+
+		pair := Pair{From: edge.From, To: edge.To}
+		// The same edge twice in one import: the last one wins
+		if _, planned := edges[pair]; planned {
+			edges[pair] = edge.Weight
+			continue
+		}
+		existing := rs.graph.WeightedEdge(Location(edge.From).ID(), Location(edge.To).ID())
+		switch {
+		case existing == nil:
+			report.EdgesCreated++
+			edges[pair] = edge.Weight
+		case existing.Weight() == edge.Weight:
+			report.EdgesUnchanged++
+		default:
+			conflict := EdgeConflict{From: edge.From, To: edge.To, Existing: existing.Weight(), Incoming: edge.Weight}
+			switch strategy {
+			case ConflictSkip, ConflictError:
+				conflict.Resolved = conflict.Existing
+			case ConflictOverwrite:
+				conflict.Resolved = conflict.Incoming
+			case ConflictMin:
+				conflict.Resolved = math.Min(conflict.Existing, conflict.Incoming)
+			case ConflictMax:
+				conflict.Resolved = math.Max(conflict.Existing, conflict.Incoming)
+			}
+			report.Conflicts = append(report.Conflicts, conflict)
+			if conflict.Resolved != conflict.Existing {
+				report.EdgesUpdated++
+				edges[pair] = conflict.Resolved
+			} else {
+				report.EdgesUnchanged++
+			}
+		}
+	}
+	for name, c := range data.Coordinates {
+		if err := c.validate(); err != nil {
+			return report, fmt.Errorf("%s: %s", name, err)
+		}
+		if !locations[name] && rs.graph.Node(Location(name).ID()) == nil {
+			return report, fmt.Errorf("coordinates given for unknown location %s", name)
+		}
+	}
+	sort.Slice(report.Conflicts, func(i, j int) bool {
+		if report.Conflicts[i].From != report.Conflicts[j].From {
+			return report.Conflicts[i].From < report.Conflicts[j].From
+		}
+		return report.Conflicts[i].To < report.Conflicts[j].To
+	})
+	if strategy == ConflictError && len(report.Conflicts) > 0 {
+		return report, ErrImportConflict
+	}
+
+	if _, err := rs.redis.Do("MULTI"); err != nil {
+		return report, err
+	}
+	if err := rs.queueImport(locations, edges, data.Coordinates); err != nil {
+		rs.redis.Do("DISCARD")
+		return report, err
+	}
+	if _, err := rs.redis.Do("EXEC"); err != nil {
+		return report, err
+	}
+
+	rs.changed()
+	for name := range locations {
+		rs.graph.AddNode(Location(name))
+	}
+	for pair, weight := range edges {
+		if err := rs.setEdge(Location(pair.From), Location(pair.To), weight); err != nil {
+			return report, err
+		}
+	}
+	for name, c := range data.Coordinates {
+		rs.coordinates[Location(name).ID()] = c
+	}
+	return report, nil
+}
+
+// Must be called with the lock held, inside MULTI
+func (rs *RouteStore) queueImport(locations map[string]bool, edges map[Pair]float64, coordinates map[string]Coordinates) error {
+	for name := range locations {
+		if _, err := rs.redis.Do("SADD", locations_set, name); err != nil {
+			return err
+		}
+	}
+	for pair, weight := range edges {
+		if _, err := rs.redis.Do("HSET", pair.From, pair.To, weight); err != nil {
+			return err
+		}
+	}
+	for name, c := range coordinates {
+		if _, err := rs.redis.Do("HSET", coordinates_hash, name, c.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restricts what Export includes; the zero value exports everything
+type ExportFilter struct {
+	// Only locations whose names start with NodePrefix
+	NodePrefix string
+	// Only these locations, if any are given
+	Nodes []string
+	// Only edges with weights in this range
+	MinWeight, MaxWeight *float64
+	// Only locations connected to this one, ignoring edge direction
+	ComponentOf string
+}
+
+// ParseNodeFilter reads "prefix:<text>" or a comma separated list of names
+func ParseNodeFilter(s string, filter *ExportFilter) {
+	if prefix := strings.TrimPrefix(s, "prefix:"); prefix != s {
+		filter.NodePrefix = prefix
+	} else if s != "" {
+		filter.Nodes = strings.Split(s, ",")
+	}
+}
+
+// Must be called with the lock held; every node reachable from id ignoring direction
+func (rs *RouteStore) weakComponent(id int64) map[int64]bool {
+	seen := map[int64]bool{id: true}
+	stack := []int64{id}
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, nodes := range []graph.Nodes{rs.graph.From(next), rs.graph.To(next)} {
+			for nodes.Next() {
+				if other := nodes.Node().ID(); !seen[other] {
+					seen[other] = true
+					stack = append(stack, other)
+				}
+			}
+		}
+	}
+	return seen
+}
+
+// GET  /maps/export/ (?nodes=prefix:<text>|<name>,...&min_weight=&max_weight=&component_of=<location> optional) : READ
+// the locations, edges and coordinates of the graph, or the slice of it matching the filters
+func (rs *RouteStore) Export(filter ExportFilter) (GraphData, error) {
+	defer rs.rlock("Export")()
+
+	return rs.export(filter)
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) export(filter ExportFilter) (GraphData, error) {
+	var component map[int64]bool
+	if filter.ComponentOf != "" {
+		loc := Location(filter.ComponentOf)
+		if rs.graph.Node(loc.ID()) == nil {
+			return GraphData{}, fmt.Errorf("%s does not exist", loc)
+		}
+		component = rs.weakComponent(loc.ID())
+	}
+	named := make(map[string]bool)
+	for _, name := range filter.Nodes {
+		named[name] = true
+	}
+	include := func(node graph.Node) bool {
+		name := nodeName(node)
+		return strings.HasPrefix(name, filter.NodePrefix) &&
+			(len(named) == 0 || named[name]) &&
+			(component == nil || component[node.ID()])
+	}
+
+	ret := GraphData{Locations: []string{}, Edges: []Edge{}, Coordinates: make(map[string]Coordinates)}
+	nodes := rs.graph.Nodes()
+	for nodes.Next() {
+		node := nodes.Node()
+		if include(node) {
+			ret.Locations = append(ret.Locations, nodeName(node))
+			if c, ok := rs.coordinates[node.ID()]; ok {
+				ret.Coordinates[nodeName(node)] = c
+			}
+		}
+	}
+	sort.Strings(ret.Locations)
+
+	for _, edge := range rs.sortedEdges() {
+		if filter.MinWeight != nil && edge.Weight < *filter.MinWeight {
+			continue
+		}
+		if filter.MaxWeight != nil && edge.Weight > *filter.MaxWeight {
+			continue
+		}
+		if include(Location(edge.From)) && include(Location(edge.To)) {
+			ret.Edges = append(ret.Edges, edge)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,269 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	trash_set    = "rest_project:trash"
+	trash_prefix = "rest_project:trash:"
+)
+
+// How long a deleted location is kept before it is purged, unless changed with SetTrashRetention
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// How often locations kept past the retention period are purged, see MonitorTrash
+const trashPurgeInterval = time.Hour
+
+// A deleted location, along with the weight of every edge it had when it was deleted. Nothing else about the edges is
+// kept, nor the location's tags, region or visit cost; archiving a location keeps them all.
+type TrashEntry struct {
+	Name       string             `json:"name"`
+	DeletedAt  time.Time          `json:"deleted_at"`
+	ExpiresAt  time.Time          `json:"expires_at"`
+	RoutesTo   map[string]float64 `json:"routes_to"`
+	RoutesFrom map[string]float64 `json:"routes_from"`
+
+	Coordinates *Coordinates `json:"coordinates,omitempty"`
+}
+
+func trashOutKey(name string) string {
+	return trash_prefix + "out:" + name
+}
+
+func trashInKey(name string) string {
+	return trash_prefix + "in:" + name
+}
+
+// SetTrashRetention changes how long deleted locations are kept; existing entries are re-dated
+func (rs *RouteStore) SetTrashRetention(retention time.Duration) {
+	defer rs.lock("SetTrashRetention")()
+
+	rs.trashRetention = retention
+	for _, entry := range rs.trash {
+		entry.ExpiresAt = entry.DeletedAt.Add(retention)
+	}
+}
+
+func (rs *RouteStore) restoreTrash() error {
+	deleted, err := redis.StringMap(rs.redis.Do("ZRANGE", trash_set, 0, -1, "WITHSCORES"))
+	if err != nil {
+		return err
+	}
+	coordinates, err := getCoordinates(rs.redis, trash_coordinates_hash)
+	if err != nil {
+		return err
+	}
+
+	for name, score := range deleted {
+		var unix int64
+		if _, err := fmt.Sscan(score, &unix); err != nil {
+			return err
+		}
+		entry := TrashEntry{Name: name, DeletedAt: time.Unix(unix, 0)}
+		entry.ExpiresAt = entry.DeletedAt.Add(rs.trashRetention)
+		if entry.RoutesTo, err = getEdges(rs.redis, trashOutKey(name)); err != nil {
+			return err
+		}
+		if entry.RoutesFrom, err = getEdges(rs.redis, trashInKey(name)); err != nil {
+			return err
+		}
+		if c, ok := coordinates[name]; ok {
+			entry.Coordinates = &c
+		}
+		rs.trash[name] = &entry
+	}
+	return nil
+}
+
+// Must be called with the lock held, before the location is removed from the graph
+func (rs *RouteStore) trashLocation(name string) error {
+	loc := Location(name)
+	entry := TrashEntry{
+		Name:       name,
+		DeletedAt:  time.Now().Truncate(time.Second),
+		RoutesTo:   make(map[string]float64),
+		RoutesFrom: make(map[string]float64),
+	}
+	entry.ExpiresAt = entry.DeletedAt.Add(rs.trashRetention)
+
+	to := rs.graph.From(loc.ID())
+	for to.Next() {
+		node := to.Node()
+		entry.RoutesTo[nodeName(node)] = rs.graph.WeightedEdge(loc.ID(), node.ID()).Weight()
+	}
+	from := rs.graph.To(loc.ID())
+	for from.Next() {
+		node := from.Node()
+		entry.RoutesFrom[nodeName(node)] = rs.graph.WeightedEdge(node.ID(), loc.ID()).Weight()
+	}
+
+	if err := rs.purgeTrashEntry(name); err != nil {
+		return err
+	}
+	if err := putEdges(rs.redis, trashOutKey(name), entry.RoutesTo); err != nil {
+		return err
+	}
+	if err := putEdges(rs.redis, trashInKey(name), entry.RoutesFrom); err != nil {
+		return err
+	}
+	if c, ok := rs.coordinates[loc.ID()]; ok {
+		entry.Coordinates = &c
+		if _, err := rs.redis.Do("HSET", trash_coordinates_hash, name, c.String()); err != nil {
+			return err
+		}
+	}
+	if _, err := rs.redis.Do("ZADD", trash_set, entry.DeletedAt.Unix(), name); err != nil {
+		return err
+	}
+
+	rs.trash[name] = &entry
+	return nil
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) purgeTrashEntry(name string) error {
+	if _, err := rs.redis.Do("DEL", trashOutKey(name), trashInKey(name)); err != nil {
+		return err
+	}
+	if _, err := rs.redis.Do("HDEL", trash_coordinates_hash, name); err != nil {
+		return err
+	}
+	if _, err := rs.redis.Do("ZREM", trash_set, name); err != nil {
+		return err
+	}
+	delete(rs.trash, name)
+	return nil
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) purgeExpiredTrash() error {
+	now := time.Now()
+	for name, entry := range rs.trash {
+		if now.After(entry.ExpiresAt) {
+			if err := rs.purgeTrashEntry(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MonitorTrash purges the locations kept past the retention period every trashPurgeInterval, so that they leave Redis
+// even if the trash is never looked at again. Failures are logged, and tried again next time. It never returns.
+func (rs *RouteStore) MonitorTrash() {
+	for range time.Tick(trashPurgeInterval) {
+		unlock := rs.lock("MonitorTrash")
+		err := rs.purgeExpiredTrash()
+		unlock()
+		if err != nil {
+			log.Printf("Could not purge expired trash: %s\n", err)
+		}
+	}
+}
+
+// GET /maps/trash/ : READ a list of deleted locations still within the retention period
+func (rs *RouteStore) GetTrash() ([]TrashEntry, error) {
+	defer rs.lock("GetTrash")()
+
+	if err := rs.purgeExpiredTrash(); err != nil {
+		return nil, err
+	}
+
+	ret := []TrashEntry{}
+	for _, entry := range rs.trash {
+		ret = append(ret, *entry)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].DeletedAt.After(ret[j].DeletedAt) })
+	return ret, nil
+}
+
+// PUT /maps/trash/restore/<location> : UPDATE bring a deleted location back with its edges to and from locations that still
+// exist, and its coordinates. The edges come back one-way and untagged, without attributes or modes, with the weights they had.
+func (rs *RouteStore) RestoreLocation(name string) error {
+	defer rs.lock("RestoreLocation")()
+
+	if err := rs.purgeExpiredTrash(); err != nil {
+		return err
+	}
+
+	entry, ok := rs.trash[name]
+	if !ok {
+		return fmt.Errorf("%s is not in the trash", name)
+	}
+	loc := Location(name)
+	if rs.graph.Node(loc.ID()) != nil {
+		return fmt.Errorf("%s already exists", loc)
+	}
+	// The precision may have changed since it was deleted
+	for _, edges := range []map[string]float64{entry.RoutesTo, entry.RoutesFrom} {
+		for other, weight := range edges {
+			edges[other] = rs.roundWeight(weight)
+		}
+	}
+
+	rs.changed()
+	rs.graph.AddNode(loc)
+	if _, err := rs.redis.Do("SADD", locations_set, name); err != nil {
+		return err
+	}
+	// Edges to and from locations deleted since are gone for good
+	routesTo := make(map[string]float64, len(entry.RoutesTo))
+	for to, weight := range entry.RoutesTo {
+		if rs.graph.Node(Location(to).ID()) == nil {
+			continue
+		}
+		if err := rs.setEdge(loc, Location(to), weight); err != nil {
+			return err
+		}
+		routesTo[to] = weight
+	}
+	if err := putEdges(rs.redis, name, routesTo); err != nil {
+		return err
+	}
+	if entry.Coordinates != nil {
+		if _, err := rs.redis.Do("HSET", coordinates_hash, name, entry.Coordinates.String()); err != nil {
+			return err
+		}
+		rs.coordinates[loc.ID()] = *entry.Coordinates
+	}
+	for from, weight := range entry.RoutesFrom {
+		if rs.graph.Node(Location(from).ID()) == nil {
+			continue
+		}
+		if err := rs.setEdge(Location(from), loc, weight); err != nil {
+			return err
+		}
+		if _, err := rs.redis.Do("HSET", from, name, weight); err != nil {
+			return err
+		}
+	}
+
+	return rs.purgeTrashEntry(name)
+}
+
+// DELETE /maps/trash/<location> : DELETE a location from the trash permanently
+func (rs *RouteStore) PurgeLocation(name string) error {
+	defer rs.lock("PurgeLocation")()
+
+	if _, ok := rs.trash[name]; !ok {
+		return fmt.Errorf("%s is not in the trash", name)
+	}
+	return rs.purgeTrashEntry(name)
+}
+
+// DELETE /maps/trash/ : DELETE every location in the trash permanently
+func (rs *RouteStore) EmptyTrash() error {
+	defer rs.lock("EmptyTrash")()
+
+	for name := range rs.trash {
+		if err := rs.purgeTrashEntry(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
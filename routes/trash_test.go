@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Restoring a location whose edge led to a location deleted since used to bring the other back as a ghost, in the
+// graph but not in Redis
+func TestRestoreLocationSkipsDeletedTargets(t *testing.T) {
+	rs := New(newMemoryRedis())
+	for _, name := range []string{"A", "B", "C"} {
+		if err := rs.AddLocation(name, nil, nil, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.AddRoutes("A", givenWeights(map[string]float64{"B": 1, "C": 2}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"A", "B"} {
+		if err := rs.DeleteLocation(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := rs.RestoreLocation("A"); err != nil {
+		t.Fatal(err)
+	}
+	if locations := rs.GetLocations(); !reflect.DeepEqual(locations, []string{"A", "C"}) {
+		t.Fatalf("expected A and C, got %v", locations)
+	}
+	if to, err := rs.RoutesFrom("A"); err != nil || !reflect.DeepEqual(to, []string{"C"}) {
+		t.Fatalf("expected A to route to C only, got %v, %v", to, err)
+	}
+}
+
+// What MonitorTrash does: locations kept past the retention period leave Redis, and the rest stay
+func TestPurgeExpiredTrash(t *testing.T) {
+	conn := newMemoryRedis()
+	rs := New(conn)
+	for _, name := range []string{"A", "B", "C"} {
+		if err := rs.AddLocation(name, &Coordinates{Lat: 1, Lon: 2}, nil, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rs.AddRoutes("A", givenWeights(map[string]float64{"B": 1, "C": 2}), new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.DeleteLocation("A"); err != nil {
+		t.Fatal(err)
+	}
+	rs.SetTrashRetention(-time.Second)
+	if err := rs.DeleteLocation("B"); err != nil {
+		t.Fatal(err)
+	}
+	rs.trash["B"].ExpiresAt = time.Now().Add(time.Hour)
+
+	if err := rs.purgeExpiredTrash(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rs.trash["A"]; ok {
+		t.Fatal("A expired, so should be purged")
+	}
+	for key := range conn.keys() {
+		if key == trashOutKey("A") || key == trashInKey("A") {
+			t.Fatalf("%s should be purged from Redis", key)
+		}
+	}
+	if _, ok := conn.hashes[trash_coordinates_hash]["A"]; ok {
+		t.Fatal("A's coordinates should be purged from Redis")
+	}
+	if _, ok := conn.zsets[trash_set]["A"]; ok {
+		t.Fatal("A should be purged from the trash in Redis")
+	}
+	if _, ok := conn.zsets[trash_set]["B"]; !ok || rs.trash["B"] == nil {
+		t.Fatal("B has not expired, so should be kept")
+	}
+}
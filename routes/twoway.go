@@ -0,0 +1,186 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"log"
+)
+
+// Edges added as two-way, each once as "<from>/<to>" with from before to in name order
+const two_way_set = "rest_project:two_way_edges"
+
+// The pair naming a two-way edge between a and b, the same whichever end comes first
+func twoWayPair(a, b string) Pair {
+	if b < a {
+		a, b = b, a
+	}
+	return Pair{From: a, To: b}
+}
+
+func twoWayKey(a, b Location) [2]int64 {
+	pair := twoWayPair(string(a), string(b))
+	return edgeKey(Location(pair.From).ID(), Location(pair.To).ID())
+}
+
+func (rs *RouteStore) restoreTwoWay() error {
+	pairs, err := redis.Strings(rs.redis.Do("SMEMBERS", two_way_set))
+	if err != nil {
+		return err
+	}
+	for _, s := range pairs {
+		pair, err := ParsePair(s)
+		if err != nil {
+			// Such as one written for a location named with a '/', before names were checked
+			log.Printf("Ignoring the two-way pair %q in Redis: %s\n", s, err.Error())
+			continue
+		}
+		rs.twoWay[twoWayKey(Location(pair.From), Location(pair.To))] = true
+	}
+	return nil
+}
+
+// RouteSpec is one route given to AddLocation or AddRoutes. In JSON it is either a bare weight, or null, or
+// {"weight": 3, "both": true} to say which way it goes, so one-way and two-way routes can be added together.
+type RouteSpec struct {
+	// nil for the distance, in distance mode, or else the map's default weight
+	Weight *float64 `json:"weight"`
+	// Whether the route also goes back; nil for the bidirectional given with it all
+	Both *bool `json:"both"`
+}
+
+// Accept RouteSpec objects with fields besides weight and both, as the server does request bodies with LENIENT_JSON
+var LenientJSON bool
+
+func (r *RouteSpec) UnmarshalJSON(data []byte) error {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		*r = RouteSpec{}
+		return json.Unmarshal(data, &r.Weight)
+	}
+	type plain RouteSpec
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if !LenientJSON {
+		dec.DisallowUnknownFields()
+	}
+	var ret plain
+	if err := dec.Decode(&ret); err != nil {
+		return fmt.Errorf("a route must be a weight, null, or an object of weight and both: %s", err)
+	}
+	*r = RouteSpec(ret)
+	return nil
+}
+
+// Must be called with the lock held; which of routes go both ways, each by its own Both if given, or else by
+// bidirectional, or without it the map's default
+func (rs *RouteStore) twoWayRoutes(routes map[string]RouteSpec, bidirectional *bool) map[string]bool {
+	ret := make(map[string]bool, len(routes))
+	for to, route := range routes {
+		if route.Both != nil {
+			ret[to] = *route.Both
+		} else {
+			ret[to] = rs.bidirectional(bidirectional)
+		}
+	}
+	return ret
+}
+
+// Must be called with the lock held; whether the edges between a and b were added as two-way, so are kept in step
+func (rs *RouteStore) isTwoWay(a, b Location) bool {
+	return rs.twoWay[twoWayKey(a, b)]
+}
+
+// Must be called with the lock held, whenever an edge in either direction goes
+func (rs *RouteStore) removeTwoWay(a, b Location) error {
+	key := twoWayKey(a, b)
+	if !rs.twoWay[key] {
+		return nil
+	}
+	if _, err := rs.redis.Do("SREM", two_way_set, twoWayPair(string(a), string(b)).String()); err != nil {
+		return err
+	}
+	delete(rs.twoWay, key)
+	return nil
+}
+
+// Must be called with the lock held, after checking name exists and the weights are usable. Edges are added
+// from name to each location in routes, and back again when bidirectional says so or the pair is already two-way,
+// with every Redis write in one transaction so that neither direction of a two-way edge is stored alone.
+func (rs *RouteStore) addEdges(name string, routes map[string]float64, bidirectional map[string]bool) error {
+	twoWay := rs.twoWayTargets(name, routes, bidirectional)
+	if err := rs.writeEdges(rs.redis, name, routes, twoWay); err != nil {
+		return err
+	}
+	return rs.setEdges(name, routes, twoWay)
+}
+
+// Must be called with the lock held, if only for reading; the locations in routes the edge from name goes back from
+func (rs *RouteStore) twoWayTargets(name string, routes map[string]float64, bidirectional map[string]bool) map[string]bool {
+	loc := Location(name)
+	ret := make(map[string]bool)
+	for to := range routes {
+		if to != name && (bidirectional[to] || rs.isTwoWay(loc, Location(to))) {
+			ret[to] = true
+		}
+	}
+	return ret
+}
+
+// The Redis half of addEdges, on conn, which needs no store lock if it is not the store's own connection
+func (rs *RouteStore) writeEdges(conn redis.Conn, name string, routes map[string]float64, twoWay map[string]bool) error {
+	if _, err := conn.Do("MULTI"); err != nil {
+		return err
+	}
+	queue := func() error {
+		for to, weight := range routes {
+			if to == name {
+				continue
+			}
+			weight = rs.roundWeight(weight)
+			if _, err := conn.Do("HSET", name, to, weight); err != nil {
+				return err
+			}
+			if !twoWay[to] {
+				continue
+			}
+			// The far end must be a location for its edge back to be restored
+			if _, err := conn.Do("SADD", locations_set, to); err != nil {
+				return err
+			}
+			if _, err := conn.Do("HSET", to, name, weight); err != nil {
+				return err
+			}
+			if _, err := conn.Do("SADD", two_way_set, twoWayPair(name, to).String()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := queue(); err != nil {
+		conn.Do("DISCARD")
+		return err
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// Must be called with the lock held; the in-memory half of addEdges, once Redis has the edges
+func (rs *RouteStore) setEdges(name string, routes map[string]float64, twoWay map[string]bool) error {
+	loc := Location(name)
+	for to, weight := range routes {
+		if to == name {
+			continue
+		}
+		weight = rs.roundWeight(weight)
+		if err := rs.setEdge(loc, Location(to), weight); err != nil {
+			return err
+		}
+		if twoWay[to] {
+			if err := rs.setEdge(Location(to), loc, weight); err != nil {
+				return err
+			}
+			rs.twoWay[twoWayKey(loc, Location(to))] = true
+		}
+	}
+	return nil
+}
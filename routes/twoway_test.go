@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"testing"
+)
+
+// A '/' in a name would be written into keys such as "<from>/<to>" that cannot be parsed back
+func TestNamesWithSlashesAreRefused(t *testing.T) {
+	rs := New(newMemoryRedis())
+	if err := rs.AddLocation("a/b", nil, nil, new(bool)); err == nil {
+		t.Fatal("a location named a/b should be refused")
+	}
+	if err := rs.AddLocation("a", nil, map[string]RouteSpec{"b/c": {}}, new(bool)); err == nil {
+		t.Fatal("a route to b/c should be refused")
+	}
+	if rs.graph.Node(Location("a").ID()) != nil {
+		t.Fatal("a refused location should not be added")
+	}
+	if err := rs.AddLocation("a", nil, nil, new(bool)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.AddRoutes("a", givenWeights(map[string]float64{"/source": 1}), new(bool)); err == nil {
+		t.Fatal("a route to /source should be refused")
+	}
+}
+
+// A two-way pair written before names were checked is skipped rather than stopping the store from loading
+func TestRestoreSkipsUnparsableTwoWayPairs(t *testing.T) {
+	conn := newMemoryRedis()
+	conn.set(locations_set)["a"] = true
+	conn.set(locations_set)["c"] = true
+	conn.hash("a")["c"] = "1"
+	conn.hash("c")["a"] = "1"
+	conn.set(two_way_set)["a/c"] = true
+	conn.set(two_way_set)["a/b/c"] = true
+
+	rs, err := Restore(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.twoWay[twoWayKey("a", "c")] {
+		t.Fatal("the pair that parses should still be restored")
+	}
+}
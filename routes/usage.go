@@ -0,0 +1,252 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Each day's API keys are a set at this and the day, and each key's counts for the day a hash at that, a
+	// colon and the key, with requests, bytes_in, bytes_out and a field per endpoint, endpoint:<endpoint>
+	usage_key_prefix = "rest_project:usage:"
+	// How days are named in usage keys and reports
+	usageDayLayout = "2006-01-02"
+	// The most days one usage report may cover
+	MaxUsageDays = 366
+)
+
+// How many requests one API key made to one endpoint
+type EndpointUsage struct {
+	Endpoint string `json:"endpoint"`
+	Requests int64  `json:"requests"`
+}
+
+// One API key's use of the server over a day
+type KeyUsage struct {
+	Key      string `json:"key"`
+	Requests int64  `json:"requests"`
+	// Bytes of request and response bodies
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+	// Its most requested endpoints, most requested first
+	TopEndpoints []EndpointUsage `json:"top_endpoints"`
+}
+
+// Every API key's use of the server over a day, by requests, most first
+type DayUsage struct {
+	Day  string     `json:"day"`
+	Keys []KeyUsage `json:"keys"`
+}
+
+type usageCounts struct {
+	requests, bytesIn, bytesOut int64
+	endpoints                   map[string]int64
+}
+
+// Usage counts requests by API key and day in daily buckets in Redis, kept for a retention period. Requests are
+// counted in memory and added to Redis by Flush, so a crash loses at most what came since the last one.
+type Usage struct {
+	mu sync.Mutex
+	// By day, then by key
+	pending map[string]map[string]*usageCounts
+
+	// Guards redis, so that only one flush or report uses it at a time
+	redisMu   sync.Mutex
+	redis     redis.Conn
+	retention time.Duration
+}
+
+func NewUsage(conn redis.Conn, retention time.Duration) *Usage {
+	return &Usage{pending: make(map[string]map[string]*usageCounts), redis: conn, retention: retention}
+}
+
+// Record counts a request by key to endpoint at the given time, with the sizes of its request and response bodies
+func (u *Usage) Record(at time.Time, key, endpoint string, bytesIn, bytesOut int64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.add(at.UTC().Format(usageDayLayout), key, usageCounts{requests: 1, bytesIn: bytesIn, bytesOut: bytesOut, endpoints: map[string]int64{endpoint: 1}})
+}
+
+// Must be called with mu held
+func (u *Usage) add(day, key string, counts usageCounts) {
+	keys, ok := u.pending[day]
+	if !ok {
+		keys = make(map[string]*usageCounts)
+		u.pending[day] = keys
+	}
+	total, ok := keys[key]
+	if !ok {
+		total = &usageCounts{endpoints: make(map[string]int64)}
+		keys[key] = total
+	}
+	total.requests += counts.requests
+	total.bytesIn += counts.bytesIn
+	total.bytesOut += counts.bytesOut
+	for endpoint, n := range counts.endpoints {
+		total.endpoints[endpoint] += n
+	}
+}
+
+// Flush adds the requests counted since the last flush to Redis, in one transaction; if it fails, they are kept
+// for the next
+func (u *Usage) Flush() error {
+	u.redisMu.Lock()
+	defer u.redisMu.Unlock()
+
+	u.mu.Lock()
+	pending := u.pending
+	u.pending = make(map[string]map[string]*usageCounts)
+	u.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	err := u.write(pending)
+	if err != nil {
+		u.mu.Lock()
+		for day, keys := range pending {
+			for key, counts := range keys {
+				u.add(day, key, *counts)
+			}
+		}
+		u.mu.Unlock()
+	}
+	return err
+}
+
+func (u *Usage) write(pending map[string]map[string]*usageCounts) error {
+	if _, err := u.redis.Do("MULTI"); err != nil {
+		return err
+	}
+	queue := func() error {
+		seconds := int64(u.retention.Seconds())
+		for day, keys := range pending {
+			daySet := usage_key_prefix + day
+			for key, counts := range keys {
+				hash := daySet + ":" + key
+				if _, err := u.redis.Do("SADD", daySet, key); err != nil {
+					return err
+				}
+				fields := map[string]int64{"requests": counts.requests, "bytes_in": counts.bytesIn, "bytes_out": counts.bytesOut}
+				for endpoint, n := range counts.endpoints {
+					fields["endpoint:"+endpoint] = n
+				}
+				for field, n := range fields {
+					if _, err := u.redis.Do("HINCRBY", hash, field, n); err != nil {
+						return err
+					}
+				}
+				if _, err := u.redis.Do("EXPIRE", hash, seconds); err != nil {
+					return err
+				}
+			}
+			if _, err := u.redis.Do("EXPIRE", daySet, seconds); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := queue(); err != nil {
+		u.redis.Do("DISCARD")
+		return err
+	}
+	_, err := u.redis.Do("EXEC")
+	return err
+}
+
+// GET  /admin/usage/ (?from=<day>&to=<day>&key=<key>&top=5 optional) : READ requests, bytes in and out and the top endpoints of
+// each API key, or just <key>, for each day from <from> to <to>, as YYYY-MM-DD in UTC, by default the last seven days. Days past
+// the retention period, and days without requests, are left out.
+func (u *Usage) Report(from, to time.Time, key string, top int) ([]DayUsage, error) {
+	from, to = from.UTC().Truncate(24*time.Hour), to.UTC().Truncate(24*time.Hour)
+	if to.Before(from) {
+		return nil, fmt.Errorf("from %s is after to %s", from.Format(usageDayLayout), to.Format(usageDayLayout))
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > MaxUsageDays {
+		return nil, fmt.Errorf("a usage report can cover at most %d days, not %d", MaxUsageDays, days)
+	}
+	if top < 0 {
+		return nil, fmt.Errorf("top must not be negative, not %d", top)
+	}
+	if err := u.Flush(); err != nil {
+		return nil, err
+	}
+
+	u.redisMu.Lock()
+	defer u.redisMu.Unlock()
+
+	ret := []DayUsage{}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		daySet := usage_key_prefix + day.Format(usageDayLayout)
+		keys, err := redis.Strings(u.redis.Do("SMEMBERS", daySet))
+		if err != nil {
+			return nil, err
+		}
+		if key != "" {
+			found := false
+			for _, k := range keys {
+				found = found || k == key
+			}
+			keys = nil
+			if found {
+				keys = []string{key}
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		dayUsage := DayUsage{Day: day.Format(usageDayLayout), Keys: []KeyUsage{}}
+		for _, k := range keys {
+			fields, err := redis.StringMap(u.redis.Do("HGETALL", daySet+":"+k))
+			if err != nil {
+				return nil, err
+			}
+			usage, err := parseKeyUsage(k, fields, top)
+			if err != nil {
+				return nil, err
+			}
+			dayUsage.Keys = append(dayUsage.Keys, usage)
+		}
+		sort.Slice(dayUsage.Keys, func(i, j int) bool {
+			a, b := dayUsage.Keys[i], dayUsage.Keys[j]
+			return a.Requests > b.Requests || a.Requests == b.Requests && a.Key < b.Key
+		})
+		ret = append(ret, dayUsage)
+	}
+	return ret, nil
+}
+
+func parseKeyUsage(key string, fields map[string]string, top int) (KeyUsage, error) {
+	ret := KeyUsage{Key: key, TopEndpoints: []EndpointUsage{}}
+	for field, s := range fields {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return ret, fmt.Errorf("bad usage count %s for %s: %s", field, key, err)
+		}
+		switch {
+		case field == "requests":
+			ret.Requests = n
+		case field == "bytes_in":
+			ret.BytesIn = n
+		case field == "bytes_out":
+			ret.BytesOut = n
+		case strings.HasPrefix(field, "endpoint:"):
+			ret.TopEndpoints = append(ret.TopEndpoints, EndpointUsage{Endpoint: strings.TrimPrefix(field, "endpoint:"), Requests: n})
+		}
+	}
+	sort.Slice(ret.TopEndpoints, func(i, j int) bool {
+		a, b := ret.TopEndpoints[i], ret.TopEndpoints[j]
+		return a.Requests > b.Requests || a.Requests == b.Requests && a.Endpoint < b.Endpoint
+	})
+	if top < len(ret.TopEndpoints) {
+		ret.TopEndpoints = ret.TopEndpoints[:top]
+	}
+	return ret, nil
+}
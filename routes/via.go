@@ -0,0 +1,53 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A route through waypoints in order, made of the shortest route between each waypoint and the next
+type ViaRoute struct {
+	Route  []string `json:"route"`
+	Weight float64  `json:"weight"`
+	Legs   []Route  `json:"legs"`
+}
+
+// GET  /maps/via/ (?points=<name>,...) : READ the shortest route visiting every waypoint in order, with each leg.
+// Where a leg has tied routes the first is used, as listed by RoutesBetween; each leg keeps its own token.
+func (rs *RouteStore) RouteVia(points []string) (ViaRoute, error) {
+	if len(points) < 2 {
+		return ViaRoute{}, errors.New("a route needs at least 2 waypoints")
+	}
+
+	defer rs.rlock("RouteVia")()
+
+	for _, name := range points {
+		if rs.graph.Node(Location(name).ID()) == nil {
+			return ViaRoute{}, fmt.Errorf("%s does not exist", name)
+		}
+	}
+	opts, err := rs.resolveOptions(RouteOptions{})
+	if err != nil {
+		return ViaRoute{}, err
+	}
+
+	ret := ViaRoute{Route: []string{points[0]}}
+	weight := 0.0
+	for i := 1; i < len(points); i++ {
+		from, to := points[i-1], points[i]
+		rs.countQuery(from, to)
+		routes, err := rs.cachedRoutesBetween(from, to, rs.targetOptions(Location(to), opts))
+		if err != nil {
+			return ViaRoute{}, err
+		}
+		if len(routes) == 0 {
+			return ViaRoute{}, fmt.Errorf("there is no route from %s to %s", from, to)
+		}
+		leg := routes[0]
+		ret.Legs = append(ret.Legs, leg)
+		ret.Route = append(ret.Route, leg.Route[1:]...)
+		weight += leg.Weight
+	}
+	ret.Weight = rs.roundWeight(weight)
+	return ret, nil
+}
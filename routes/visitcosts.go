@@ -0,0 +1,170 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/simple"
+	"math"
+	"strconv"
+)
+
+// The cost of passing through each location that has one, by name
+const visit_costs_hash = "rest_project:visit_costs"
+
+func validateVisitCost(name string, cost float64) error {
+	if math.IsNaN(cost) || math.IsInf(cost, 0) || cost < 0 {
+		return fmt.Errorf("the visit cost of %s must be a non-negative number, not %g", name, cost)
+	}
+	return nil
+}
+
+func (rs *RouteStore) restoreVisitCosts() error {
+	stringMap, err := redis.StringMap(rs.redis.Do("HGETALL", visit_costs_hash))
+	if err != nil {
+		return err
+	}
+	for name, s := range stringMap {
+		cost, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("bad visit cost for %s: %s", name, err)
+		}
+		// Archived locations keep their costs in Redis, to have them back when restored
+		if !rs.archived[name] {
+			rs.writableVisitCosts()[Location(name).ID()] = cost
+		}
+	}
+	return nil
+}
+
+// Must be called with the lock held, whenever a location goes
+func (rs *RouteStore) removeVisitCost(name string) error {
+	if _, ok := rs.visitCosts[Location(name).ID()]; !ok {
+		return nil
+	}
+	if _, err := rs.redis.Do("HDEL", visit_costs_hash, name); err != nil {
+		return err
+	}
+	delete(rs.writableVisitCosts(), Location(name).ID())
+	return nil
+}
+
+// GET  /maps/visit-costs/ : READ the visit cost of every location that has one
+func (rs *RouteStore) VisitCosts() map[string]float64 {
+	defer rs.rlock("VisitCosts")()
+
+	ret := make(map[string]float64)
+	for id, cost := range rs.visitCosts {
+		if node := rs.graph.Node(id); node != nil {
+			ret[nodeName(node)] = cost
+		}
+	}
+	return ret
+}
+
+// GET  /maps/visit-costs/<location> : READ the visit cost of <location>, 0 if it has none
+func (rs *RouteStore) VisitCost(name string) (float64, error) {
+	defer rs.rlock("VisitCost")()
+
+	loc := Location(name)
+	if rs.graph.Node(loc.ID()) == nil {
+		return 0, fmt.Errorf("%s does not exist", loc)
+	}
+	return rs.visitCosts[loc.ID()], nil
+}
+
+// PUT  /maps/visit-costs/<location> (with JSON cost: number) : UPDATE set the cost of passing through <location>, such as the
+// penalty of changing trains at a hub, which is added to the weight of every route through it, though not of those that start or end
+// there; 0 removes it. Costs go when their location is deleted, and follow it when it is renamed.
+func (rs *RouteStore) SetVisitCost(name string, cost float64) error {
+	if err := validateVisitCost(name, cost); err != nil {
+		return err
+	}
+
+	defer rs.lock("SetVisitCost")()
+
+	loc := Location(name)
+	if rs.graph.Node(loc.ID()) == nil {
+		return fmt.Errorf("%s does not exist", loc)
+	}
+	rs.changed()
+
+	cost = rs.roundWeight(cost)
+	if cost == 0 {
+		return rs.removeVisitCost(name)
+	}
+	if _, err := rs.redis.Do("HSET", visit_costs_hash, name, cost); err != nil {
+		return err
+	}
+	rs.writableVisitCosts()[loc.ID()] = cost
+	return nil
+}
+
+// The graph with the visit cost of each location added to the weight of every edge out of it, but those out of the
+// sources routes start from, whose costs are not paid. Nor is the destination's, since routes end there rather than
+// leave it, so one search from the sources costs routes to every location correctly.
+type visitCostGraph struct {
+	graph.WeightedDirected
+	costs   map[int64]float64
+	sources map[int64]bool
+}
+
+func (g visitCostGraph) Weight(xid, yid int64) (float64, bool) {
+	w, ok := g.WeightedDirected.Weight(xid, yid)
+	if !ok || xid == yid || g.sources[xid] {
+		return w, ok
+	}
+	return w + g.costs[xid], true
+}
+
+func (g visitCostGraph) Edge(uid, vid int64) graph.Edge {
+	if e := g.WeightedEdge(uid, vid); e != nil {
+		return e
+	}
+	return nil
+}
+
+func (g visitCostGraph) WeightedEdge(uid, vid int64) graph.WeightedEdge {
+	e := g.WeightedDirected.WeightedEdge(uid, vid)
+	if e == nil {
+		return nil
+	}
+	w, _ := g.Weight(uid, vid)
+	return simple.WeightedEdge{F: e.From(), T: e.To(), W: w}
+}
+
+// g with costs added for the locations routes from any of sources pass through; searches on copies of the graph
+// take a copy of the costs with them
+func withVisitCosts(g graph.WeightedDirected, costs map[int64]float64, sources ...int64) graph.WeightedDirected {
+	if len(costs) == 0 {
+		return g
+	}
+	ret := visitCostGraph{WeightedDirected: g, costs: costs, sources: make(map[int64]bool, len(sources))}
+	for _, id := range sources {
+		ret.sources[id] = true
+	}
+	return ret
+}
+
+// Must be called with the lock held; g with the visit costs of the locations routes from from pass through
+func (rs *RouteStore) visitCostGraph(g graph.WeightedDirected, from Location) graph.WeightedDirected {
+	return withVisitCosts(g, rs.visitCosts, from.ID())
+}
+
+// Must be called with the lock held; a copy of the visit costs, for searches that run after it is released
+func (rs *RouteStore) copyVisitCosts() map[int64]float64 {
+	ret := make(map[int64]float64, len(rs.visitCosts))
+	for id, cost := range rs.visitCosts {
+		ret[id] = cost
+	}
+	return ret
+}
+
+// Must be called with the lock held; the visit costs for changing, copied first if a snapshot shares them
+func (rs *RouteStore) writableVisitCosts() map[int64]float64 {
+	if rs.visitCostsShared {
+		rs.visitCosts = rs.copyVisitCosts()
+		rs.visitCostsShared = false
+	}
+	return rs.visitCosts
+}
@@ -0,0 +1,53 @@
+package routes
+
+import (
+	"testing"
+)
+
+// Every search pays the visit cost of B on A -> B -> C, so each must weigh it 1 + 5 + 1, and C's as a destination not at all
+func TestVisitCostsOnEveryRoutingPath(t *testing.T) {
+	rs := New(newMemoryRedis())
+	for _, name := range []string{"A", "B", "C"} {
+		if err := rs.AddLocation(name, nil, nil, new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for from, to := range map[string]string{"A": "B", "B": "C"} {
+		if err := rs.AddRoutes(from, givenWeights(map[string]float64{to: 1}), new(bool)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, cost := range map[string]float64{"B": 5, "C": 100} {
+		if err := rs.SetVisitCost(name, cost); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Hot trees hold the graph's own weights, so must not answer
+	if err := rs.AddHotSource("A"); err != nil {
+		t.Fatal(err)
+	}
+	const want = 7
+
+	routes, err := rs.RoutesBetween("A", "C", RouteOptions{})
+	if err != nil || len(routes) != 1 || routes[0].Weight != want {
+		t.Fatalf("RoutesBetween: %+v, %v", routes, err)
+	}
+	if routes, err := rs.Snapshot().RoutesBetween("A", "C"); err != nil || len(routes) != 1 || routes[0].Weight != want {
+		t.Fatalf("Snapshot.RoutesBetween: %+v, %v", routes, err)
+	}
+	var streamed []Route
+	if err := rs.Snapshot().EachRoute("A", "C", func(r Route) error { streamed = append(streamed, r); return nil }); err != nil || len(streamed) != 1 || streamed[0].Weight != want {
+		t.Fatalf("Snapshot.EachRoute: %+v, %v", streamed, err)
+	}
+	matrix, err := rs.DistanceMatrix([]string{"A"}, []string{"C"})
+	if err != nil || matrix.Weights[0][0] == nil || *matrix.Weights[0][0] != want {
+		t.Fatalf("DistanceMatrix: %+v, %v", matrix, err)
+	}
+	reachable, err := rs.Within("A", want-1)
+	if err != nil || len(reachable) != 1 || reachable[0].Location != "B" {
+		t.Fatalf("Within: %+v, %v", reachable, err)
+	}
+	if routes, err := rs.NearestRoutes([]string{"A"}, []string{"C"}); err != nil || len(routes) != 1 || routes[0].Weight != want {
+		t.Fatalf("NearestRoutes: %+v, %v", routes, err)
+	}
+}
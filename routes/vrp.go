@@ -0,0 +1,246 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	// The most stops one problem may have; each costs a shortest path search
+	MaxVRPStops = 500
+	MaxVehicles = 100
+)
+
+// A location to visit and how much of a vehicle's capacity its delivery takes up
+type VRPStop struct {
+	Location string  `json:"location"`
+	Demand   float64 `json:"demand"`
+}
+
+// Two stops that one vehicle must visit, the pickup first. The pickup's demand is loaded there and unloaded at the
+// delivery, rather than brought from the depot, so the delivery has no demand of its own.
+type PickupDelivery struct {
+	Pickup   string `json:"pickup"`
+	Delivery string `json:"delivery"`
+}
+
+// A capacitated vehicle routing problem: vehicles of the same capacity leave the depot, visit every stop once between
+// them, and come back
+type VRPProblem struct {
+	Depot    string           `json:"depot"`
+	Vehicles int              `json:"vehicles"`
+	Capacity float64          `json:"capacity"`
+	Stops    []VRPStop        `json:"stops"`
+	Pairs    []PickupDelivery `json:"pairs"`
+}
+
+// One vehicle's tour from the depot and back
+type VehicleRoute struct {
+	// The stops in the order visited
+	Stops []string `json:"stops"`
+	// The most the vehicle carries at once
+	Load   float64 `json:"load"`
+	Weight float64 `json:"weight"`
+	// Every location passed through, from the depot back to it
+	Route []string `json:"route"`
+}
+
+// The tours found for a VRPProblem. Stops left unserved are those that cannot be reached from the depot or
+// cannot get back, that need more than a whole vehicle, or that did not fit in the vehicles there are.
+type VRPSolution struct {
+	Vehicles []VehicleRoute `json:"vehicles"`
+	// The total weight of every tour
+	Weight   float64   `json:"weight"`
+	Unserved []VRPStop `json:"unserved"`
+}
+
+// What a tour carries: its load leaving the depot, all for stops not in a pair, and the most at any time
+type tourLoad struct {
+	initial, peak float64
+}
+
+// join is the load of the tour that does t then u. Once t is done, its vehicle is empty.
+func (t tourLoad) join(u tourLoad) tourLoad {
+	return tourLoad{initial: t.initial + u.initial, peak: math.Max(t.peak+u.initial, u.peak)}
+}
+
+// A pair of stops by index and how much cheaper one tour ending at the first then starting at the second is than two
+type saving struct {
+	from, to int
+	saving   float64
+}
+
+// POST /maps/optimize/vrp/ (with JSON depot: string, vehicles: int, capacity: number, stops: [{location, demand}], pairs: [{pickup, delivery}] optional) : READ
+// tours for up to vehicles vehicles of capacity, from the depot through every stop and back. This is the Clarke-Wright
+// savings heuristic over the shortest route weights, so tours are good rather than the best there are. Each pickup and
+// delivery pair starts out as a tour of its own, and tours are only ever joined end to start, so the pair stays together
+// in order; where one of the pair cannot be served, neither is.
+func (rs *RouteStore) SolveVRP(problem VRPProblem) (VRPSolution, error) {
+	if problem.Vehicles < 1 || problem.Vehicles > MaxVehicles {
+		return VRPSolution{}, fmt.Errorf("vehicles must be between 1 and %d", MaxVehicles)
+	}
+	if math.IsNaN(problem.Capacity) || math.IsInf(problem.Capacity, 0) || problem.Capacity <= 0 {
+		return VRPSolution{}, fmt.Errorf("capacity must be a positive number, not %g", problem.Capacity)
+	}
+	if len(problem.Stops) < 1 || len(problem.Stops) > MaxVRPStops {
+		return VRPSolution{}, fmt.Errorf("stops must number between 1 and %d", MaxVRPStops)
+	}
+	seen := map[string]bool{problem.Depot: true}
+	demands := make(map[string]float64)
+	for _, stop := range problem.Stops {
+		if stop.Location == problem.Depot {
+			return VRPSolution{}, fmt.Errorf("%s is the depot, so cannot be a stop", stop.Location)
+		}
+		if seen[stop.Location] {
+			return VRPSolution{}, fmt.Errorf("%s is a stop more than once", stop.Location)
+		}
+		seen[stop.Location] = true
+		if math.IsNaN(stop.Demand) || math.IsInf(stop.Demand, 0) || stop.Demand < 0 {
+			return VRPSolution{}, fmt.Errorf("demand at %s must be a non-negative number, not %g", stop.Location, stop.Demand)
+		}
+		demands[stop.Location] = stop.Demand
+	}
+	// Each stop's pair partner, by name
+	deliveryOf, pickupOf := make(map[string]string), make(map[string]string)
+	for _, pair := range problem.Pairs {
+		for _, name := range []string{pair.Pickup, pair.Delivery} {
+			if !seen[name] || name == problem.Depot {
+				return VRPSolution{}, fmt.Errorf("%s is in a pickup and delivery pair, so must be a stop", name)
+			}
+			if deliveryOf[name] != "" || pickupOf[name] != "" || pair.Pickup == pair.Delivery {
+				return VRPSolution{}, fmt.Errorf("%s is in more than one pickup and delivery pair", name)
+			}
+		}
+		if demands[pair.Delivery] != 0 {
+			return VRPSolution{}, fmt.Errorf("delivery %s must have no demand of its own, it unloads what %s loaded", pair.Delivery, pair.Pickup)
+		}
+		deliveryOf[pair.Pickup], pickupOf[pair.Delivery] = pair.Delivery, pair.Pickup
+	}
+
+	unlock := rs.rlock("SolveVRP")
+	g, negativeEdges, precision, visitCosts := rs.copyGraph(), rs.negativeEdges, rs.precision, rs.copyVisitCosts()
+	unlock()
+
+	// Searches from the depot and every stop, on the copy, without holding up the store
+	names := []string{problem.Depot}
+	for _, stop := range problem.Stops {
+		names = append(names, stop.Location)
+	}
+	searches, err := newStopSearches(g, negativeEdges, visitCosts, names)
+	if err != nil {
+		return VRPSolution{}, err
+	}
+	// Index 0 is the depot and stop k is index k+1
+	dist := searches.dist
+	index := make(map[string]int)
+	for i, name := range names {
+		index[name] = i
+	}
+
+	ret := VRPSolution{Vehicles: []VehicleRoute{}, Unserved: []VRPStop{}}
+	// Tours as lists of indices into names, and which tour each served stop is on
+	var tours [][]int
+	var loads []tourLoad
+	tourOf := make(map[int]int)
+	for k, stop := range problem.Stops {
+		i := k + 1
+		if pickupOf[stop.Location] != "" {
+			// Served with its pickup
+			continue
+		}
+		tour, load := []int{i}, tourLoad{initial: stop.Demand, peak: stop.Demand}
+		if delivery, ok := deliveryOf[stop.Location]; ok {
+			tour, load = []int{i, index[delivery]}, tourLoad{peak: stop.Demand}
+		}
+		served := load.peak <= problem.Capacity && !math.IsInf(dist(0, tour[0]), 1) && !math.IsInf(dist(tour[len(tour)-1], 0), 1)
+		if len(tour) == 2 && math.IsInf(dist(tour[0], tour[1]), 1) {
+			served = false
+		}
+		if !served {
+			for _, j := range tour {
+				ret.Unserved = append(ret.Unserved, problem.Stops[j-1])
+			}
+			continue
+		}
+		for _, j := range tour {
+			tourOf[j] = len(tours)
+		}
+		tours = append(tours, tour)
+		loads = append(loads, load)
+	}
+
+	// Pickups never end a tour, nor deliveries start one, so are left out, and may have no route to or from the depot
+	var savings []saving
+	for i := range tourOf {
+		for j := range tourOf {
+			if deliveryOf[names[i]] != "" || pickupOf[names[j]] != "" {
+				continue
+			}
+			if i != j && !math.IsInf(dist(i, j), 1) {
+				savings = append(savings, saving{from: i, to: j, saving: dist(i, 0) + dist(0, j) - dist(i, j)})
+			}
+		}
+	}
+	sort.Slice(savings, func(a, b int) bool {
+		x, y := savings[a], savings[b]
+		if x.saving != y.saving {
+			return x.saving > y.saving
+		}
+		return names[x.from] < names[y.from] || names[x.from] == names[y.from] && names[x.to] < names[y.to]
+	})
+
+	// Joins the tour ending at from to the one starting at to while it saves weight, then further while
+	// there are more tours than vehicles
+	remaining := len(tours)
+	for _, s := range savings {
+		if s.saving <= 0 && remaining <= problem.Vehicles {
+			break
+		}
+		a, b := tourOf[s.from], tourOf[s.to]
+		if a == b || tours[a][len(tours[a])-1] != s.from || tours[b][0] != s.to || loads[a].join(loads[b]).peak > problem.Capacity {
+			continue
+		}
+		for _, i := range tours[b] {
+			tourOf[i] = a
+		}
+		tours[a], tours[b] = append(tours[a], tours[b]...), nil
+		loads[a], loads[b] = loads[a].join(loads[b]), tourLoad{}
+		remaining--
+	}
+
+	for t, tour := range tours {
+		if tour == nil {
+			continue
+		}
+		route := VehicleRoute{Stops: []string{}, Load: loads[t].peak, Route: []string{problem.Depot}}
+		prev := 0
+		for _, i := range append(tour, 0) {
+			route.Weight += dist(prev, i)
+			route.Route = searches.leg(route.Route, prev, i)
+			if i != 0 {
+				route.Stops = append(route.Stops, names[i])
+			}
+			prev = i
+		}
+		route.Weight = roundWeight(route.Weight, precision)
+		ret.Vehicles = append(ret.Vehicles, route)
+	}
+	// Keeping the tours that deliver the most when there are too many for the vehicles
+	sort.SliceStable(ret.Vehicles, func(i, j int) bool {
+		return ret.Vehicles[i].Load > ret.Vehicles[j].Load
+	})
+	if len(ret.Vehicles) > problem.Vehicles {
+		for _, route := range ret.Vehicles[problem.Vehicles:] {
+			for _, name := range route.Stops {
+				ret.Unserved = append(ret.Unserved, VRPStop{Location: name, Demand: demands[name]})
+			}
+		}
+		ret.Vehicles = ret.Vehicles[:problem.Vehicles]
+	}
+	for _, route := range ret.Vehicles {
+		ret.Weight += route.Weight
+	}
+	ret.Weight = roundWeight(ret.Weight, precision)
+	return ret, nil
+}
@@ -0,0 +1,209 @@
+package routes
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Every location reaches every other, with weights from weight
+func completeStore(t *testing.T, names []string, weight func(from, to int) float64) *RouteStore {
+	t.Helper()
+	edges := make(map[string]map[string]float64)
+	for i, from := range names {
+		edges[from] = make(map[string]float64)
+		for j, to := range names {
+			if i != j {
+				edges[from][to] = weight(i, j)
+			}
+		}
+	}
+	return offlineStore(t, edges)
+}
+
+// checkVRP drives each vehicle through its stops, loading at the depot everything for stops not in a pair, and fails
+// if one ever carries more than its capacity or than the load it reports. Every stop must be served once or be
+// unserved, each pair by one vehicle in order, with no more vehicles than there are.
+func checkVRP(t *testing.T, problem VRPProblem, solution VRPSolution) {
+	t.Helper()
+	if len(solution.Vehicles) > problem.Vehicles {
+		t.Fatalf("%d tours for %d vehicles", len(solution.Vehicles), problem.Vehicles)
+	}
+	demands := make(map[string]float64)
+	for _, stop := range problem.Stops {
+		demands[stop.Location] = stop.Demand
+	}
+	deliveryOf, pickupOf := make(map[string]string), make(map[string]string)
+	for _, pair := range problem.Pairs {
+		deliveryOf[pair.Pickup], pickupOf[pair.Delivery] = pair.Delivery, pair.Pickup
+	}
+
+	visits := make(map[string]int)
+	for _, stop := range solution.Unserved {
+		visits[stop.Location]++
+	}
+	for v, vehicle := range solution.Vehicles {
+		var load float64
+		for _, name := range vehicle.Stops {
+			if deliveryOf[name] == "" && pickupOf[name] == "" {
+				load += demands[name]
+			}
+		}
+		peak := load
+		picked := make(map[string]bool)
+		for _, name := range vehicle.Stops {
+			visits[name]++
+			switch {
+			case deliveryOf[name] != "":
+				picked[name] = true
+				load += demands[name]
+			case pickupOf[name] != "":
+				if !picked[pickupOf[name]] {
+					t.Fatalf("vehicle %d delivers to %s before picking up at %s: %v", v, name, pickupOf[name], vehicle.Stops)
+				}
+				load -= demands[pickupOf[name]]
+			default:
+				load -= demands[name]
+			}
+			if load > peak {
+				peak = load
+			}
+		}
+		if peak > problem.Capacity {
+			t.Fatalf("vehicle %d carries %g, over its capacity of %g: %v", v, peak, problem.Capacity, vehicle.Stops)
+		}
+		if peak != vehicle.Load {
+			t.Fatalf("vehicle %d carries %g, but says %g: %v", v, peak, vehicle.Load, vehicle.Stops)
+		}
+		if len(vehicle.Route) < 2 || vehicle.Route[0] != problem.Depot || vehicle.Route[len(vehicle.Route)-1] != problem.Depot {
+			t.Fatalf("vehicle %d does not leave and return to the depot: %v", v, vehicle.Route)
+		}
+		for _, name := range vehicle.Stops {
+			if pickup := pickupOf[name]; pickup != "" && !contains(vehicle.Stops, pickup) {
+				t.Fatalf("%s and %s are served by different vehicles", pickup, name)
+			}
+		}
+	}
+	for _, stop := range problem.Stops {
+		if visits[stop.Location] != 1 {
+			t.Fatalf("%s is served or left unserved %d times", stop.Location, visits[stop.Location])
+		}
+	}
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func solveVRP(t *testing.T, rs *RouteStore, problem VRPProblem) VRPSolution {
+	t.Helper()
+	solution, err := rs.SolveVRP(problem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkVRP(t, problem, solution)
+	return solution
+}
+
+// Joining tours always saves weight here, so only capacity keeps them apart
+func TestVRPRespectsCapacity(t *testing.T) {
+	names := []string{"depot", "a", "b", "c", "d", "e", "f"}
+	rs := completeStore(t, names, func(from, to int) float64 {
+		if from == 0 || to == 0 {
+			return 10
+		}
+		return 1
+	})
+	problem := VRPProblem{
+		Depot:    "depot",
+		Vehicles: 10,
+		Capacity: 5,
+		Stops:    []VRPStop{{"a", 4}, {"b", 3}, {"c", 3}, {"d", 2}, {"e", 2}, {"f", 1}},
+	}
+	solution := solveVRP(t, rs, problem)
+	if len(solution.Unserved) != 0 {
+		t.Fatalf("with vehicles enough, every stop should be served, not %v", solution.Unserved)
+	}
+	// 15 to deliver needs three vehicles of 5
+	if len(solution.Vehicles) < 3 {
+		t.Fatalf("%d vehicles cannot carry 15", len(solution.Vehicles))
+	}
+
+	// Too much for one vehicle, or for the vehicles there are
+	problem.Stops = append(problem.Stops, VRPStop{"g", 6})
+	problem.Vehicles = 2
+	rs = completeStore(t, append(names, "g"), func(int, int) float64 { return 1 })
+	solution = solveVRP(t, rs, problem)
+	if len(solution.Unserved) == 0 || !reflect.DeepEqual(solution.Unserved[0], VRPStop{"g", 6}) {
+		t.Fatalf("a stop needing more than a vehicle should be unserved first, not %v", solution.Unserved)
+	}
+	var served float64
+	for _, vehicle := range solution.Vehicles {
+		for _, name := range vehicle.Stops {
+			for _, stop := range problem.Stops {
+				if stop.Location == name {
+					served += stop.Demand
+				}
+			}
+		}
+	}
+	if served > 10 {
+		t.Fatalf("two vehicles of 5 delivered %g", served)
+	}
+}
+
+// A vehicle still carrying what it loaded at the depot when it reaches a pickup carries both
+func TestVRPPickupsRespectCapacity(t *testing.T) {
+	names := []string{"depot", "a", "p", "q"}
+	rs := completeStore(t, names, func(from, to int) float64 {
+		if from == 0 || to == 0 {
+			return 10
+		}
+		return 1
+	})
+	problem := VRPProblem{
+		Depot:    "depot",
+		Vehicles: 2,
+		Capacity: 5,
+		Stops:    []VRPStop{{"a", 2}, {"p", 4}, {"q", 0}},
+		Pairs:    []PickupDelivery{{Pickup: "p", Delivery: "q"}},
+	}
+	solution := solveVRP(t, rs, problem)
+	// Delivering to a first empties the vehicle for the pickup; the other way round it would carry 6
+	if len(solution.Vehicles) != 1 || !reflect.DeepEqual(solution.Vehicles[0].Stops, []string{"a", "p", "q"}) {
+		t.Fatalf("expected one tour of a, p then q, got %+v", solution.Vehicles)
+	}
+}
+
+// Random problems with pairs, against the same checks
+func TestVRPRespectsCapacityRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		names := []string{"depot"}
+		for j := 0; j < 2+r.Intn(10); j++ {
+			names = append(names, fmt.Sprint("s", j))
+		}
+		rs := completeStore(t, names, func(int, int) float64 { return float64(1 + r.Intn(20)) })
+
+		problem := VRPProblem{Depot: "depot", Vehicles: 1 + r.Intn(4), Capacity: float64(3 + r.Intn(8))}
+		stops := append([]string(nil), names[1:]...)
+		r.Shuffle(len(stops), func(a, b int) { stops[a], stops[b] = stops[b], stops[a] })
+		for len(stops) >= 2 && r.Intn(3) == 0 {
+			problem.Pairs = append(problem.Pairs, PickupDelivery{Pickup: stops[0], Delivery: stops[1]})
+			problem.Stops = append(problem.Stops, VRPStop{stops[0], float64(r.Intn(6))}, VRPStop{stops[1], 0})
+			stops = stops[2:]
+		}
+		for _, name := range stops {
+			problem.Stops = append(problem.Stops, VRPStop{name, float64(r.Intn(6))})
+		}
+		sort.Slice(problem.Stops, func(a, b int) bool { return problem.Stops[a].Location < problem.Stops[b].Location })
+		solveVRP(t, rs, problem)
+	}
+}
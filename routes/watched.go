@@ -0,0 +1,203 @@
+package routes
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"math"
+	"sort"
+	"time"
+)
+
+const watched_set = "rest_project:watched"
+
+// Must be called with the lock held; options that give exact answers whatever the edge weights
+func (rs *RouteStore) exactOptions() RouteOptions {
+	if rs.negativeEdges > 0 {
+		return RouteOptions{Algorithm: BellmanFord}
+	}
+	return RouteOptions{Algorithm: Dijkstra}
+}
+
+type watch struct {
+	weight    float64
+	route     []string
+	revision  uint64
+	changed   bool
+	changedAt time.Time
+	checkedAt time.Time
+}
+
+// A watched pair's best route now, and whether its weight has changed at any point since the last check
+type WatchedRoute struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Absent when there is no route
+	Weight    *float64   `json:"weight,omitempty"`
+	Route     []string   `json:"route,omitempty"`
+	Changed   bool       `json:"changed"`
+	ChangedAt *time.Time `json:"changed_at,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+func (w *watch) report(pair Pair) WatchedRoute {
+	ret := WatchedRoute{From: pair.From, To: pair.To, Route: w.route, Changed: w.changed}
+	if !math.IsInf(w.weight, 1) {
+		weight := w.weight
+		ret.Weight = &weight
+	}
+	if !w.changedAt.IsZero() {
+		changedAt := w.changedAt
+		ret.ChangedAt = &changedAt
+	}
+	if !w.checkedAt.IsZero() {
+		checkedAt := w.checkedAt
+		ret.CheckedAt = &checkedAt
+	}
+	return ret
+}
+
+// Must be called with the lock held; brings a watch up to date with the graph
+func (rs *RouteStore) refreshWatch(pair Pair, w *watch) {
+	if w.revision == rs.revision {
+		return
+	}
+	w.revision = rs.revision
+
+	weight, route := math.Inf(1), []string(nil)
+	if rs.graph.Node(Location(pair.From).ID()) != nil && rs.graph.Node(Location(pair.To).ID()) != nil {
+		routes, err := rs.cachedRoutesBetween(pair.From, pair.To, rs.exactOptions())
+		if err == nil && len(routes) > 0 {
+			weight, route = routes[0].Weight, routes[0].Route
+		}
+	}
+
+	if weight != w.weight {
+		w.changed = true
+		w.changedAt = time.Now()
+	}
+	w.weight, w.route = weight, route
+}
+
+// Must be called with the lock held; a watch that starts out checked
+func (rs *RouteStore) newWatch(pair Pair) *watch {
+	w := &watch{revision: rs.revision - 1}
+	rs.refreshWatch(pair, w)
+	w.changed = false
+	w.changedAt = time.Time{}
+	return w
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) refreshWatched() {
+	for pair, w := range rs.watched {
+		rs.refreshWatch(pair, w)
+	}
+}
+
+func (rs *RouteStore) restoreWatched() error {
+	members, err := redis.Strings(rs.redis.Do("SMEMBERS", watched_set))
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		pair, err := ParsePair(member)
+		if err != nil {
+			return err
+		}
+		rs.watched[pair] = rs.newWatch(pair)
+	}
+	return nil
+}
+
+// MonitorWatched recomputes watched routes and checks critical pairs soon after each change to the graph, so
+// changes are noticed even if the graph changes back before anyone looks. It never returns.
+func (rs *RouteStore) MonitorWatched() {
+	for range rs.watchSignal {
+		unlock := rs.lock("MonitorWatched")
+		rs.refreshWatched()
+		rs.refreshCriticalPairs()
+		unlock()
+	}
+}
+
+// Must be called with the lock held
+func (rs *RouteStore) signalWatched() {
+	if len(rs.watched) == 0 && len(rs.critical) == 0 {
+		return
+	}
+	select {
+	case rs.watchSignal <- struct{}{}:
+	default:
+	}
+}
+
+func (rs *RouteStore) sortedWatched() []WatchedRoute {
+	ret := []WatchedRoute{}
+	for pair, w := range rs.watched {
+		rs.refreshWatch(pair, w)
+		ret = append(ret, w.report(pair))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].From != ret[j].From {
+			return ret[i].From < ret[j].From
+		}
+		return ret[i].To < ret[j].To
+	})
+	return ret
+}
+
+// GET  /maps/watched/ : READ every watched pair's current best route and whether it changed since the last check
+func (rs *RouteStore) GetWatched() []WatchedRoute {
+	defer rs.lock("GetWatched")()
+
+	return rs.sortedWatched()
+}
+
+// POST /maps/watched/check/ : UPDATE as GET /maps/watched/, then clear the changed flags
+func (rs *RouteStore) CheckWatched() []WatchedRoute {
+	defer rs.lock("CheckWatched")()
+
+	ret := rs.sortedWatched()
+	now := time.Now()
+	for _, w := range rs.watched {
+		w.changed = false
+		w.checkedAt = now
+	}
+	return ret
+}
+
+// PUT  /maps/watched/<from>/<to> : UPDATE start watching the best route from <from> to <to>
+func (rs *RouteStore) Watch(pair Pair) error {
+	defer rs.lock("Watch")()
+
+	if rs.graph.Node(Location(pair.From).ID()) == nil {
+		return fmt.Errorf("%s does not exist", pair.From)
+	}
+	if rs.graph.Node(Location(pair.To).ID()) == nil {
+		return fmt.Errorf("%s does not exist", pair.To)
+	}
+	if _, ok := rs.watched[pair]; ok {
+		return fmt.Errorf("%s is already watched", pair)
+	}
+
+	if _, err := rs.redis.Do("SADD", watched_set, pair.String()); err != nil {
+		return err
+	}
+	rs.watched[pair] = rs.newWatch(pair)
+	return nil
+}
+
+// DELETE /maps/watched/<from>/<to> : DELETE stop watching the route from <from> to <to>
+func (rs *RouteStore) Unwatch(pair Pair) error {
+	defer rs.lock("Unwatch")()
+
+	if _, ok := rs.watched[pair]; !ok {
+		return fmt.Errorf("%s is not watched", pair)
+	}
+
+	if _, err := rs.redis.Do("SREM", watched_set, pair.String()); err != nil {
+		return err
+	}
+	delete(rs.watched, pair)
+	return nil
+}
@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"fmt"
+	"math"
+)
+
+// In integer mode no weight may be larger than this in magnitude. Any route's total is then a sum of
+// whole numbers well inside the range a float64 holds exactly, so it never picks up rounding error.
+const MaxIntegerWeight = math.MaxInt32
+
+// SetIntegerWeights turns integer mode on or off. In integer mode every weight must be a whole number,
+// such as minutes or cents, no larger than MaxIntegerWeight; turning it on fails if any edge, including
+// those of locations in the trash, does not qualify.
+func (rs *RouteStore) SetIntegerWeights(on bool) error {
+	defer rs.lock("SetIntegerWeights")()
+
+	if on {
+		var err error
+		rs.graph.each(func(adj *adjacency) {
+			for i, slot := range adj.out.slots {
+				if err == nil {
+					err = checkIntegerWeight(nodeName(adj.node), nodeName(rs.graph.at(slot).node), adj.out.weights[i])
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		for name, entry := range rs.trash {
+			for to, weight := range entry.RoutesTo {
+				if err := checkIntegerWeight(name, to, weight); err != nil {
+					return err
+				}
+			}
+			for from, weight := range entry.RoutesFrom {
+				if err := checkIntegerWeight(from, name, weight); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	rs.integerWeights = on
+	return nil
+}
+
+// Whether integer mode is on
+func (rs *RouteStore) IntegerWeights() bool {
+	defer rs.rlock("IntegerWeights")()
+
+	return rs.integerWeights
+}
+
+func checkIntegerWeight(from, to string, weight float64) error {
+	if weight != math.Trunc(weight) || math.Abs(weight) > MaxIntegerWeight {
+		return fmt.Errorf("the edge from %s to %s weighs %g, which is not a whole number up to %d", from, to, weight, MaxIntegerWeight)
+	}
+	return nil
+}
+
+// Must be called with the lock held, before changing anything, so that a bad weight rejects the whole request
+func (rs *RouteStore) checkWeight(from, to string, weight float64) error {
+	if math.IsNaN(weight) || math.IsInf(weight, 0) {
+		return fmt.Errorf("the edge from %s to %s has no usable weight", from, to)
+	}
+	if rs.integerWeights {
+		return checkIntegerWeight(from, to, weight)
+	}
+	return nil
+}
+
+// As checkWeight, for every edge from one location but those to itself, which are ignored
+func (rs *RouteStore) checkWeights(from string, routes map[string]float64) error {
+	for to, weight := range routes {
+		if to == from {
+			continue
+		}
+		if err := rs.checkWeight(from, to, weight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package routes
+
+import (
+	"container/heap"
+	"fmt"
+	"gonum.org/v1/gonum/graph/path"
+	"math"
+	"sort"
+)
+
+// A location and the weight of the cheapest route to it
+type Reachable struct {
+	Location string  `json:"location"`
+	Weight   float64 `json:"weight"`
+}
+
+// GET  /maps/<location>/within/ (?weight=<budget>) : READ every other location whose cheapest route from <location> weighs no
+// more than the budget, cheapest first. Dijkstra's search stops at the budget, so a small one is cheap however big the graph;
+// while there are negative weights, Bellman-Ford has to search the whole graph instead.
+func (rs *RouteStore) Within(name string, budget float64) ([]Reachable, error) {
+	if math.IsNaN(budget) || math.IsInf(budget, 0) {
+		return nil, fmt.Errorf("weight must be a finite number, not %g", budget)
+	}
+
+	defer rs.rlock("Within")()
+
+	source := Location(name)
+	if rs.graph.Node(source.ID()) == nil {
+		return nil, fmt.Errorf("%s does not exist", source)
+	}
+
+	tolerance := tieTolerance(rs.precision)
+	dist := make(map[int64]float64)
+	g := rs.visitCostGraph(rs.graph, source)
+	// Hot trees are dropped while there are negative weights, so those come first, and hold no visit costs
+	switch tree, hot := rs.hot[name]; {
+	case rs.negativeEdges > 0:
+		shortest, ok := path.BellmanFordFrom(source, g)
+		if !ok {
+			return nil, ErrNegativeCycle
+		}
+		nodes := rs.graph.Nodes()
+		for nodes.Next() {
+			if d := shortest.WeightTo(nodes.Node().ID()); !math.IsInf(d, 1) {
+				dist[nodes.Node().ID()] = d
+			}
+		}
+	case hot && len(rs.visitCosts) == 0:
+		for id, d := range tree.dist {
+			dist[id] = d
+		}
+	default:
+		dist[source.ID()] = 0
+		queue := distQueue{{id: source.ID(), dist: 0}}
+		for queue.Len() > 0 {
+			item := heap.Pop(&queue).(queueItem)
+			if item.dist > dist[item.id] || item.dist > budget+tolerance {
+				continue
+			}
+			to := g.From(item.id)
+			for to.Next() {
+				next := to.Node().ID()
+				w, _ := g.Weight(item.id, next)
+				if current, ok := dist[next]; !ok || item.dist+w < current {
+					dist[next] = item.dist + w
+					heap.Push(&queue, queueItem{id: next, dist: item.dist + w})
+				}
+			}
+		}
+	}
+
+	ret := []Reachable{}
+	for id, d := range dist {
+		if id != source.ID() && d <= budget+tolerance {
+			ret = append(ret, Reachable{Location: nodeName(rs.graph.Node(id)), Weight: rs.roundWeight(d)})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Weight < ret[j].Weight || ret[i].Weight == ret[j].Weight && ret[i].Location < ret[j].Location
+	})
+	return ret, nil
+}
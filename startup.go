@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// What to do at startup when Redis cannot be reached
+type startupMode string
+
+const (
+	// Exit at once
+	startupFailFast startupMode = "fail-fast"
+	// Keep dialling until STARTUP_DEADLINE, then exit
+	startupWait startupMode = "wait"
+	// Serve an empty graph, which refuses changes, and restore it from Redis once reached
+	startupServeEmpty startupMode = "serve-empty"
+)
+
+func parseStartupMode(s string) (startupMode, error) {
+	switch mode := startupMode(s); mode {
+	case startupFailFast, startupWait, startupServeEmpty:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown startup mode %q, expected one of %s, %s or %s", s, startupFailFast, startupWait, startupServeEmpty)
+}
+
+// Every command given a standbyConn fails with this until Redis is reached
+var errRedisUnavailable = errors.New("Redis cannot be reached yet, so the graph is empty and cannot be changed")
+
+// How long dialling waits between attempts, doubling from the first to the last
+const (
+	minRedialInterval = 100 * time.Millisecond
+	maxRedialInterval = 5 * time.Second
+)
+
+// redial dials until it succeeds or, unless it is zero, the deadline passes
+func redial(dial func() (redis.Conn, error), deadline time.Time) (redis.Conn, error) {
+	interval := minRedialInterval
+	for {
+		conn, err := dial()
+		if err == nil {
+			return conn, nil
+		}
+		if !deadline.IsZero() && time.Now().Add(interval).After(deadline) {
+			return nil, err
+		}
+		log.Printf("Could not reach Redis, retrying in %s: %s\n", interval, err.Error())
+		time.Sleep(interval)
+		if interval *= 2; interval > maxRedialInterval {
+			interval = maxRedialInterval
+		}
+	}
+}
+
+// standbyConn stands in for a connection to Redis that could not be dialled while serving empty, failing every
+// command with errRedisUnavailable until redial connects it
+type standbyConn struct {
+	mu   sync.RWMutex
+	conn redis.Conn
+	// Closed once conn is set
+	connected chan struct{}
+}
+
+func newStandbyConn() *standbyConn {
+	return &standbyConn{connected: make(chan struct{})}
+}
+
+// redial dials in the background until Redis is reached, then passes every command on to it
+func (c *standbyConn) redial(dial func() (redis.Conn, error)) {
+	go func() {
+		conn, _ := redial(dial, time.Time{})
+		c.mu.Lock()
+		c.conn = conn
+		c.mu.Unlock()
+		close(c.connected)
+	}()
+}
+
+func (c *standbyConn) current() redis.Conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.conn
+}
+
+func (c *standbyConn) Close() error {
+	if conn := c.current(); conn != nil {
+		return conn.Close()
+	}
+	return nil
+}
+
+func (c *standbyConn) Err() error {
+	if conn := c.current(); conn != nil {
+		return conn.Err()
+	}
+	return errRedisUnavailable
+}
+
+func (c *standbyConn) Do(command string, args ...interface{}) (interface{}, error) {
+	if conn := c.current(); conn != nil {
+		return conn.Do(command, args...)
+	}
+	return nil, errRedisUnavailable
+}
+
+func (c *standbyConn) Send(command string, args ...interface{}) error {
+	if conn := c.current(); conn != nil {
+		return conn.Send(command, args...)
+	}
+	return errRedisUnavailable
+}
+
+func (c *standbyConn) Flush() error {
+	if conn := c.current(); conn != nil {
+		return conn.Flush()
+	}
+	return errRedisUnavailable
+}
+
+func (c *standbyConn) Receive() (interface{}, error) {
+	if conn := c.current(); conn != nil {
+		return conn.Receive()
+	}
+	return nil, errRedisUnavailable
+}
+
+// waitForRedis blocks until conn reaches Redis, which it already has unless it is a standbyConn
+func waitForRedis(conn redis.Conn) {
+	if standby, ok := conn.(*standbyConn); ok {
+		<-standby.connected
+	}
+}
+
+// How the server started and whether it has reached Redis, for GET /admin/startup/
+type StartupStatus struct {
+	Mode      startupMode `json:"mode"`
+	StartedAt time.Time   `json:"started_at"`
+	// When the store was restored from Redis, or null while serving empty
+	RestoredAt *time.Time `json:"restored_at"`
+	// What reconciling the empty store with Redis found, for a server that started serving empty
+	Reconciled *routes.ResyncReport `json:"reconciled,omitempty"`
+	// Why reconciling failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+type startupState struct {
+	sync.Mutex
+	status StartupStatus
+}
+
+// The server's startup, set at the start of main
+var startup = &startupState{status: StartupStatus{Mode: startupFailFast, StartedAt: time.Now()}}
+
+func (s *startupState) mode() startupMode {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.status.Mode
+}
+
+func (s *startupState) restored(report *routes.ResyncReport, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if err != nil {
+		s.status.Error = err.Error()
+		return
+	}
+	now := time.Now()
+	s.status.RestoredAt, s.status.Reconciled = &now, report
+}
+
+// connectRedis dials the default map's connection as the startup mode says; serving empty, one that cannot be
+// dialled yet is a standbyConn
+func connectRedis(mode startupMode, deadline time.Duration) (redis.Conn, error) {
+	conn, err := dialConn()
+	if err == nil {
+		return conn, nil
+	}
+	switch mode {
+	case startupWait:
+		log.Printf("Could not reach Redis, waiting up to %s: %s\n", deadline, err.Error())
+		return redial(dialConn, time.Now().Add(deadline))
+	case startupServeEmpty:
+		log.Printf("Could not reach Redis, serving an empty graph until it can be: %s\n", err.Error())
+		standby := newStandbyConn()
+		standby.redial(dialConn)
+		return standby, nil
+	}
+	return nil, err
+}
+
+// reconcileWhenReached waits for the standby the store was made with to reach Redis, then loads the store from it
+// and configures it, as Restore and configureStore would have at startup
+func reconcileWhenReached(store *routes.RouteStore, standby *standbyConn, check evictionCheck) {
+	<-standby.connected
+	log.Printf("Reached Redis, reconciling the graph served empty until now\n")
+
+	report, err := store.Reconcile()
+	if err == nil {
+		err = configureStore(store)
+	}
+	if err != nil {
+		log.Printf("Reconciling the graph with Redis failed: %s\n", err.Error())
+		startup.restored(nil, err)
+		return
+	}
+	log.Printf("Reconciled the graph with Redis: %s\n", report.String())
+	startup.restored(&report, nil)
+
+	// Too late to refuse to start, so refusing stops the server
+	if err := checkEvictionPolicy(store, check); err != nil {
+		log.Fatalf("Stopping: %s\n", err.Error())
+	}
+}
+
+// GET  /admin/startup/ : READ the startup mode and when the store was restored from Redis, with 503 while serving an empty graph until Redis is reached
+func startupHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting startup status at %s\n", req.URL.Path)
+
+	startup.Lock()
+	status := startup.status
+	startup.Unlock()
+
+	if status.RestoredAt == nil {
+		renderJSONStatus(w, http.StatusServiceUnavailable, status)
+		return
+	}
+	renderJSON(w, status)
+}
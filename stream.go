@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// wantsNDJSON is whether the client asked for routes one per line, with ?stream=ndjson or by Accept
+func wantsNDJSON(req *http.Request) bool {
+	if req.URL.Query().Get("stream") == "ndjson" {
+		return true
+	}
+	for _, accepted := range strings.Split(req.Header.Get("Accept"), ",") {
+		if mediatype, _, err := mime.ParseMediaType(accepted); err == nil && mediatype == "application/x-ndjson" {
+			return true
+		}
+	}
+	return false
+}
+
+// streamRoutes writes each shortest route as a line of JSON as soon as it is found, from a snapshot
+// of the graph so that a slow client does not hold up the store. Only Dijkstra's algorithm enumerates
+// routes, so other algorithms are refused.
+func (rs *routeServer) streamRoutes(w http.ResponseWriter, req *http.Request, from, to string, alg routes.Algorithm) {
+	if alg != "" && alg != routes.Dijkstra {
+		httpError(w, req, "only dijkstra can stream routes", http.StatusBadRequest)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	started := false
+	err := rs.store.Snapshot().EachRoute(from, to, func(route routes.Route) error {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+		if err := enc.Encode(route); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && !started {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		// Too late for an error response; the client sees the stream end early
+		log.Printf("Streaming routes failed: %s\n", err.Error())
+		return
+	}
+	if !started {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+}
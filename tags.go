@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// GET  /maps/<from>/edge/<to>/tags : READ the tags of the edge from <from> to <to>
+func (rs *routeServer) edgeTagsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting edge tags at %s\n", req.URL.Path)
+
+	vars := mux.Vars(req)
+	tags, err := rs.store.EdgeTags(vars["from"], vars["to"])
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, tags)
+}
+
+// PUT  /maps/<from>/edge/<to>/tags (with JSON []string) : UPDATE replace the tags of the edge from <from> to <to>
+func (rs *routeServer) setEdgeTagsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting edge tags at %s\n", req.URL.Path)
+
+	var tags []string
+	if !decodeJSON(w, req, &tags) {
+		return
+	}
+
+	vars := mux.Vars(req)
+	if err := rs.store.SetEdgeTags(vars["from"], vars["to"], tags); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+// GET  /maps/location-tags/ : READ the tags of every tagged location
+func (rs *routeServer) allLocationTagsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting location tags at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.AllLocationTags())
+}
+
+// GET  /maps/location-tags/<location> : READ the tags of <location>
+func (rs *routeServer) locationTagsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting location tags at %s\n", req.URL.Path)
+
+	tags, err := rs.store.LocationTags(mux.Vars(req)["location"])
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, tags)
+}
+
+// PUT  /maps/location-tags/<location> (with JSON []string) : UPDATE replace the tags of <location>
+func (rs *routeServer) setLocationTagsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting location tags at %s\n", req.URL.Path)
+
+	var tags []string
+	if !decodeJSON(w, req, &tags) {
+		return
+	}
+
+	if err := rs.store.SetLocationTags(mux.Vars(req)["location"], tags); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/patterson-a/rest_project/routes"
+	"log"
+	"net/http"
+)
+
+// GET  /maps/export/ (?nodes=prefix:<text>|<name>,...&min_weight=&max_weight=&component_of=<location> optional) : READ the graph or a slice of it
+func (rs *routeServer) exportHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Exporting at %s\n", req.URL.Path)
+
+	query := req.URL.Query()
+	filter := routes.ExportFilter{ComponentOf: query.Get("component_of")}
+	routes.ParseNodeFilter(query.Get("nodes"), &filter)
+	for name, bound := range map[string]**float64{"min_weight": &filter.MinWeight, "max_weight": &filter.MaxWeight} {
+		if query.Get(name) == "" {
+			continue
+		}
+		weight, err := floatParam(req, name, 0)
+		if err != nil {
+			httpError(w, req, err.Error(), http.StatusBadRequest)
+			return
+		}
+		*bound = &weight
+	}
+
+	data, err := rs.store.Export(filter)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, data)
+}
+
+// POST /maps/import/ (with JSON locations, edges, coordinates; ?conflict=skip|overwrite|error|min|max optional) : CREATE locations and edges in bulk
+func (rs *routeServer) importHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Importing at %s\n", req.URL.Path)
+
+	strategy, err := routes.ParseConflictStrategy(req.URL.Query().Get("conflict"))
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var data routes.GraphData
+	if !decodeJSON(w, req, &data) {
+		return
+	}
+
+	report, err := rs.store.Import(data, strategy)
+	if err == routes.ErrImportConflict {
+		w.Header().Set("X-Error-Code", errorCode(err.Error(), http.StatusConflict))
+		renderJSONStatus(w, http.StatusConflict, report)
+		return
+	}
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, report)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/patterson-a/rest_project/routes"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// How often usage counts are added to Redis
+const usageFlushInterval = 10 * time.Second
+
+// Requests by API key, for GET /admin/usage/; nil to count none
+var usage *routes.Usage
+
+// Requests without an X-API-Key header count against this key
+const anonymousKey = "anonymous"
+
+type usageContextKey struct{}
+
+// The endpoint a request is counted against, which a named map's router fills in from within the server's
+type usageEndpoint struct {
+	endpoint string
+}
+
+// usageMiddleware counts each request against its X-API-Key, with the sizes of its request and response bodies.
+// Every router has it, so a request to a named map passes it twice, and the map's router names the endpoint.
+func usageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if usage == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if outer, ok := req.Context().Value(usageContextKey{}).(*usageEndpoint); ok {
+			outer.endpoint = req.Method + " /namespaces/{map}" + routeTemplate(req)
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		counted := &usageEndpoint{endpoint: req.Method + " " + routeTemplate(req)}
+		req = req.WithContext(context.WithValue(req.Context(), usageContextKey{}, counted))
+		body := &countingReader{ReadCloser: req.Body}
+		if req.Body != nil {
+			req.Body = body
+		}
+		cw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, req)
+
+		key := req.Header.Get("X-API-Key")
+		if key == "" {
+			key = anonymousKey
+		}
+		usage.Record(time.Now(), key, counted.endpoint, body.n, cw.n)
+	})
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush keeps streamed responses streaming
+func (w *countingWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// flushUsage adds the usage counts to Redis every interval, forever
+func flushUsage(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := usage.Flush(); err != nil {
+			log.Printf("Could not save usage counts: %s\n", err)
+		}
+	}
+}
+
+// GET  /admin/usage/ (?from=<day>&to=<day>&key=<key>&top=5 optional) : READ requests, bytes in and out and the top endpoints of each API key, as given in X-API-Key, or just <key>, for each day from <from> to <to>, as YYYY-MM-DD in UTC, by default the last seven days
+func usageHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Reporting usage at %s\n", req.URL.Path)
+
+	if usage == nil {
+		httpError(w, req, "usage is not counted on this server", http.StatusNotFound)
+		return
+	}
+
+	to, err := dayParam(req, "to", time.Now())
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := dayParam(req, "from", to.AddDate(0, 0, -6))
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	top, err := intParam(req, "top", 5)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := usage.Report(from, to, req.URL.Query().Get("key"), top)
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+	renderJSON(w, report)
+}
+
+// dayParam reads an optional YYYY-MM-DD query parameter, a day in UTC
+func dayParam(req *http.Request, name string, def time.Time) (time.Time, error) {
+	s := req.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	day, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a day such as 2006-01-02, not %q", name, s)
+	}
+	return day, nil
+}
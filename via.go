@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// GET  /maps/via/ (?points=<name>,...) : READ the shortest route visiting every waypoint in order, with each leg
+func (rs *routeServer) routeViaHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Finding a route via waypoints at %s\n", req.URL.Path)
+
+	route, err := rs.store.RouteVia(listParam(req, "points"))
+	if err != nil {
+		httpError(w, req, err.Error(), routeErrorStatus(err))
+		return
+	}
+
+	renderJSON(w, route)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"log"
+	"net/http"
+)
+
+// GET  /maps/visit-costs/ : READ the visit cost of every location that has one
+func (rs *routeServer) visitCostsHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting visit costs at %s\n", req.URL.Path)
+
+	renderJSON(w, rs.store.VisitCosts())
+}
+
+// GET  /maps/visit-costs/<location> : READ the visit cost of <location>, 0 if it has none
+func (rs *routeServer) visitCostHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Getting a visit cost at %s\n", req.URL.Path)
+
+	cost, err := rs.store.VisitCost(mux.Vars(req)["location"])
+	if err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(w, struct {
+		Cost float64 `json:"cost"`
+	}{cost})
+}
+
+// PUT  /maps/visit-costs/<location> (with JSON cost: number) : UPDATE set the cost added to routes passing through <location>; 0 removes it
+func (rs *routeServer) setVisitCostHandler(w http.ResponseWriter, req *http.Request) {
+	log.Printf("Setting a visit cost at %s\n", req.URL.Path)
+
+	var body struct {
+		Cost *float64 `json:"cost"`
+	}
+	if !decodeJSON(w, req, &body) {
+		return
+	}
+	if body.Cost == nil {
+		httpError(w, req, "cost is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := rs.store.SetVisitCost(mux.Vars(req)["location"], *body.Cost); err != nil {
+		httpError(w, req, err.Error(), http.StatusBadRequest)
+		return
+	}
+}